@@ -0,0 +1,352 @@
+// Package bot is a small client for writing chatroom bots.
+//
+// A bot account is created with POST /api/bots, which returns an API token.
+// The bot joins a room with the room's code and secret, connects to it and
+// then receives room events and sends messages or slash commands:
+//
+//	client := bot.New("http://localhost:8080", token)
+//	client.Join(ctx, "AbCd1234", "secret")
+//	conn, err := client.Connect(ctx, "AbCd1234")
+//	conn.Run(ctx, func(conn *bot.Conn, ev bot.Event) {
+//		if ev.Type == bot.EventMessage && !ev.IsBot {
+//			conn.Send("you said: " + ev.Content)
+//		}
+//	})
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// EventType is the "type" field of a frame sent by the server
+type EventType string
+
+const (
+	EventMessage         EventType = "message"
+	EventAction          EventType = "action"
+	EventUserJoined      EventType = "user_joined"
+	EventUserLeft        EventType = "user_left"
+	EventUserKicked      EventType = "user_kicked"
+	EventUserBanned      EventType = "user_banned"
+	EventUserMuted       EventType = "user_muted"
+	EventUserRenamed     EventType = "user_renamed"
+	EventRoleChanged     EventType = "role_changed"
+	EventTopicChanged    EventType = "topic_changed"
+	EventCommandResponse EventType = "command_response"
+	EventDisconnected    EventType = "disconnected"
+	EventTypingStart     EventType = "typing_start"
+	EventTypingStop      EventType = "typing_stop"
+	EventReadReceipt     EventType = "read_receipt"
+	EventPresence        EventType = "presence"
+	EventReplayComplete  EventType = "replay_complete"
+	EventResyncRequired  EventType = "resync_required"
+	EventAck             EventType = "ack"
+	EventMessageUnfurled EventType = "message_unfurled"
+	EventMessageDeleted  EventType = "message_deleted"
+	EventMessagesPurged  EventType = "messages_purged" // retention deleted the messages in MessageIDs
+	EventError           EventType = "error"
+	EventHeld            EventType = "held" // a message is waiting for moderator approval
+)
+
+// Event is a frame received from a room
+type Event struct {
+	Type       EventType `json:"type"`
+	Content    string    `json:"content"`
+	UserID     uint      `json:"user_id"`
+	Username   string    `json:"username"`
+	ChatroomID uint      `json:"chatroom_id"`
+	Timestamp  time.Time `json:"timestamp"`
+	MessageID  uint      `json:"message_id,omitempty"`
+	MessageIDs []uint    `json:"message_ids,omitempty"`
+	Seq        uint64    `json:"seq,omitempty"` // position in the room, without gaps
+	IsBot      bool      `json:"is_bot,omitempty"`
+	ReadBy     []string  `json:"read_by,omitempty"`
+	Replayed   bool      `json:"replayed,omitempty"`
+	Nonce      string    `json:"nonce,omitempty"`
+	Error      string    `json:"error,omitempty"`       // set on a failed ack or an error event
+	Limit      int       `json:"limit,omitempty"`       // on message_too_long errors
+	RetryAfter float64   `json:"retry_after,omitempty"` // seconds to wait, on rate_limited errors
+	HTML       string    `json:"html,omitempty"`        // Content rendered from Markdown by the server
+
+	Attachments []Attachment `json:"attachments,omitempty"`
+	Previews    []Preview    `json:"previews,omitempty"`
+}
+
+// Attachment is a file posted with a message. URL is relative to the
+// server and needs the bot's token to download.
+type Attachment struct {
+	ID          uint   `json:"id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	URL         string `json:"url"`
+
+	// Images only
+	Width        int    `json:"width,omitempty"`
+	Height       int    `json:"height,omitempty"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+}
+
+// Preview describes a link in a message. Previews arrive in a
+// message_unfurled event after the message itself.
+type Preview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+	ImageURL    string `json:"image_url,omitempty"`
+}
+
+// Client talks to a chatroom server using a bot API token
+type Client struct {
+	BaseURL    string // e.g. http://localhost:8080
+	Token      string
+	HTTPClient *http.Client
+	Dialer     *websocket.Dialer
+}
+
+// New returns a Client for the server at baseURL
+func New(baseURL, token string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		Token:      token,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		Dialer:     websocket.DefaultDialer,
+	}
+}
+
+func (c *Client) authHeader() http.Header {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+c.Token)
+	return header
+}
+
+// Join makes the bot a member of a room. Joining a room it is already in is not an error.
+func (c *Client) Join(ctx context.Context, code, password string) error {
+	form := url.Values{"code": {code}, "password": {password}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/join-room", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header = c.authHeader()
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool   `json:"success"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("join %s: %s", code, resp.Status)
+	}
+	if !result.Success {
+		return fmt.Errorf("join %s: %s", code, result.Error)
+	}
+	return nil
+}
+
+// Upload posts a file to a room with an optional caption. The message
+// arrives over the room's connection like any other.
+func (c *Client) Upload(ctx context.Context, code, filename string, file io.Reader, caption string) (*Attachment, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return nil, err
+	}
+	if err := form.WriteField("content", caption); err != nil {
+		return nil, err
+	}
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/api/room/"+url.PathEscape(code)+"/attachments", &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = c.authHeader()
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Attachment *Attachment `json:"attachment"`
+		Error      string      `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || result.Attachment == nil {
+		if result.Error == "" {
+			result.Error = resp.Status
+		}
+		return nil, fmt.Errorf("upload %s: %s", filename, result.Error)
+	}
+	return result.Attachment, nil
+}
+
+// Connect opens a WebSocket connection to a room the bot is a member of
+func (c *Client) Connect(ctx context.Context, code string) (*Conn, error) {
+	return c.Resume(ctx, code, 0)
+}
+
+// Resume connects like Connect, first replaying messages after
+// lastMessageID. If too many were missed the first event is
+// EventResyncRequired instead.
+func (c *Client) Resume(ctx context.Context, code string, lastMessageID uint) (*Conn, error) {
+	wsURL, err := url.Parse(c.BaseURL + "/ws/" + url.PathEscape(code))
+	if err != nil {
+		return nil, err
+	}
+	if lastMessageID > 0 {
+		wsURL.RawQuery = url.Values{"last_message_id": {fmt.Sprint(lastMessageID)}}.Encode()
+	}
+	switch wsURL.Scheme {
+	case "https":
+		wsURL.Scheme = "wss"
+	default:
+		wsURL.Scheme = "ws"
+	}
+
+	ws, resp, err := c.Dialer.DialContext(ctx, wsURL.String(), c.authHeader())
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("connect %s: %s", code, resp.Status)
+		}
+		return nil, err
+	}
+
+	conn := &Conn{
+		Room:   code,
+		ws:     ws,
+		events: make(chan Event, 64),
+	}
+	go conn.readLoop()
+	return conn, nil
+}
+
+// Conn is a live connection to one room
+type Conn struct {
+	Room string
+
+	ws      *websocket.Conn
+	events  chan Event
+	writeMu sync.Mutex
+
+	errMu sync.Mutex
+	err   error
+}
+
+// Events returns the stream of room events. It is closed when the connection ends.
+func (c *Conn) Events() <-chan Event {
+	return c.events
+}
+
+// Err returns the error that ended the connection, if any
+func (c *Conn) Err() error {
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+	return c.err
+}
+
+// Send posts a chat message to the room
+func (c *Conn) Send(content string) error {
+	return c.write(map[string]string{"type": "message", "content": content})
+}
+
+// SendWithNonce posts a chat message tagged with a client-chosen nonce of up
+// to 64 characters. The server replies with an EventAck carrying the nonce
+// and the stored message ID, and resending the same nonce after a dropped
+// connection never creates a duplicate.
+func (c *Conn) SendWithNonce(content, nonce string) error {
+	return c.write(map[string]string{"type": "message", "content": content, "nonce": nonce})
+}
+
+// Command runs a slash command, e.g. Command("topic", "release day")
+func (c *Conn) Command(name string, args ...string) error {
+	line := "/" + name
+	if len(args) > 0 {
+		line += " " + strings.Join(args, " ")
+	}
+	return c.Send(line)
+}
+
+// Close ends the connection
+func (c *Conn) Close() error {
+	c.writeMu.Lock()
+	c.ws.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second))
+	c.writeMu.Unlock()
+	return c.ws.Close()
+}
+
+// Run calls handler for every event until the context is cancelled or the
+// connection ends. Handlers run one at a time in the order events arrive.
+func (c *Conn) Run(ctx context.Context, handler func(conn *Conn, ev Event)) error {
+	for {
+		select {
+		case <-ctx.Done():
+			c.Close()
+			return ctx.Err()
+		case ev, ok := <-c.events:
+			if !ok {
+				return c.Err()
+			}
+			handler(c, ev)
+		}
+	}
+}
+
+func (c *Conn) write(frame interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return c.ws.WriteJSON(frame)
+}
+
+func (c *Conn) readLoop() {
+	defer close(c.events)
+
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseNoStatusReceived) && !errors.Is(err, net.ErrClosed) {
+				c.errMu.Lock()
+				c.err = err
+				c.errMu.Unlock()
+			}
+			return
+		}
+
+		var ev Event
+		if err := json.Unmarshal(data, &ev); err != nil {
+			continue
+		}
+		c.events <- ev
+	}
+}
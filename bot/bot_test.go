@@ -0,0 +1,104 @@
+package bot_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jeffasante/chatroom.go/bot"
+	"github.com/jeffasante/chatroom.go/internal/chattest"
+)
+
+// awaitEvent returns the first event that matches, or fails after a timeout
+func awaitEvent(t *testing.T, conn *bot.Conn, match func(bot.Event) bool) bot.Event {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev, ok := <-conn.Events():
+			if !ok {
+				t.Fatalf("connection closed: %v", conn.Err())
+			}
+			if match(ev) {
+				return ev
+			}
+		case <-timeout:
+			t.Fatal("no matching event arrived")
+		}
+	}
+}
+
+func TestBotJoinsAndChats(t *testing.T) {
+	srv := chattest.New(t)
+	alice := srv.SignUp(t, "alice")
+	code := srv.CreateRoom(t, alice, "general", "secret")
+	token := srv.CreateBot(t, alice, "helper")
+
+	ctx := context.Background()
+	client := bot.New(srv.URL, token)
+	if err := client.Join(ctx, code, "wrong"); err == nil {
+		t.Fatal("joined with the wrong secret")
+	}
+	if err := client.Join(ctx, code, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := client.Connect(ctx, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	human := srv.Connect(t, alice, code)
+	human.Send(map[string]string{"type": "message", "content": "hello bot"})
+	ev := awaitEvent(t, conn, func(ev bot.Event) bool { return ev.Type == bot.EventMessage })
+	if ev.Content != "hello bot" || ev.Username != "alice" || ev.IsBot {
+		t.Errorf("bot received %+v", ev)
+	}
+
+	if err := conn.SendWithNonce("hello human", "n-1"); err != nil {
+		t.Fatal(err)
+	}
+	ack := awaitEvent(t, conn, func(ev bot.Event) bool { return ev.Type == bot.EventAck })
+	if ack.Nonce != "n-1" || ack.MessageID == 0 || ack.Error != "" {
+		t.Errorf("ack = %+v", ack)
+	}
+	frame := human.Await(func(f chattest.Frame) bool { return f.Type == "message" && f.Content == "hello human" })
+	if frame.Username != "helper" || frame.MessageID != ack.MessageID {
+		t.Errorf("human received %+v", frame)
+	}
+}
+
+func TestBotTokenRequired(t *testing.T) {
+	srv := chattest.New(t)
+	alice := srv.SignUp(t, "alice")
+	code := srv.CreateRoom(t, alice, "general", "secret")
+
+	client := bot.New(srv.URL, "bot_not-a-token")
+	if err := client.Join(context.Background(), code, "secret"); err == nil {
+		t.Error("joined with an unknown token")
+	}
+	if _, err := client.Connect(context.Background(), code); err == nil {
+		t.Error("connected with an unknown token")
+	}
+}
+
+func TestCreateBotValidatesName(t *testing.T) {
+	srv := chattest.New(t)
+	alice := srv.SignUp(t, "alice")
+	srv.CreateBot(t, alice, "helper")
+
+	for name, want := range map[string]int{
+		"a":            http.StatusBadRequest,
+		"has space":    http.StatusBadRequest,
+		"<script>":     http.StatusBadRequest,
+		"ALICE":        http.StatusConflict,
+		"Helper":       http.StatusConflict,
+		"other-helper": http.StatusOK,
+	} {
+		if got := srv.Do(t, alice, http.MethodPost, "/api/bots", url.Values{"name": {name}}, nil); got != want {
+			t.Errorf("creating bot %q: status %d, want %d", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Command echobot is an example bot that echoes messages and sets reminders.
+//
+//	go run ./bot/examples/echobot -token bot_... -room AbCd1234 -secret roomsecret
+//
+// In the room:
+//
+//	!echo hello        -> hello
+//	!remind 10m stretch -> "@alice reminder: stretch" ten minutes later
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/jeffasante/chatroom.go/bot"
+)
+
+func main() {
+	serverURL := flag.String("url", "http://localhost:8080", "chatroom server URL")
+	token := flag.String("token", os.Getenv("CHATROOM_BOT_TOKEN"), "bot API token")
+	room := flag.String("room", "", "room code to join")
+	secret := flag.String("secret", "", "room secret")
+	flag.Parse()
+
+	if *token == "" || *room == "" {
+		log.Fatal("-token and -room are required")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	client := bot.New(*serverURL, *token)
+	if *secret != "" {
+		if err := client.Join(ctx, *room, *secret); err != nil {
+			log.Fatal("Failed to join room:", err)
+		}
+	}
+
+	conn, err := client.Connect(ctx, *room)
+	if err != nil {
+		log.Fatal("Failed to connect:", err)
+	}
+
+	log.Printf("Connected to room %s", *room)
+	if err := conn.Run(ctx, handleEvent); err != nil && err != context.Canceled {
+		log.Fatal("Connection lost:", err)
+	}
+}
+
+func handleEvent(conn *bot.Conn, ev bot.Event) {
+	// Never respond to bots, including ourselves
+	if ev.Type != bot.EventMessage || ev.IsBot {
+		return
+	}
+
+	command, args, _ := strings.Cut(ev.Content, " ")
+	switch command {
+	case "!echo":
+		if args != "" {
+			conn.Send(args)
+		}
+
+	case "!remind":
+		delay, text, _ := strings.Cut(args, " ")
+		d, err := time.ParseDuration(delay)
+		if err != nil || text == "" {
+			conn.Send("usage: !remind <duration> <text>, e.g. !remind 10m stretch")
+			return
+		}
+
+		user := ev.Username
+		time.AfterFunc(d, func() {
+			conn.Send(fmt.Sprintf("@%s reminder: %s", user, text))
+		})
+		conn.Send(fmt.Sprintf("OK %s, I'll remind you in %s", user, d))
+	}
+}
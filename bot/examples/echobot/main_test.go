@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jeffasante/chatroom.go/bot"
+	"github.com/jeffasante/chatroom.go/internal/chattest"
+)
+
+func TestEchoAndRemind(t *testing.T) {
+	srv := chattest.New(t)
+	alice := srv.SignUp(t, "alice")
+	code := srv.CreateRoom(t, alice, "general", "secret")
+	token := srv.CreateBot(t, alice, "echobot")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := bot.New(srv.URL, token)
+	if err := client.Join(ctx, code, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := client.Connect(ctx, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go conn.Run(ctx, handleEvent)
+
+	human := srv.Connect(t, alice, code)
+	fromBot := func(content string) func(chattest.Frame) bool {
+		return func(f chattest.Frame) bool {
+			return f.Type == "message" && f.Username == "echobot" && f.Content == content
+		}
+	}
+
+	human.Send(map[string]string{"type": "message", "content": "!echo hello there"})
+	human.Await(fromBot("hello there"))
+
+	human.Send(map[string]string{"type": "message", "content": "!remind later"})
+	human.Await(fromBot("usage: !remind <duration> <text>, e.g. !remind 10m stretch"))
+
+	human.Send(map[string]string{"type": "message", "content": "!remind 50ms stretch"})
+	human.Await(fromBot("OK alice, I'll remind you in 50ms"))
+	human.Await(fromBot("@alice reminder: stretch"))
+}
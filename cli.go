@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/jeffasante/chatroom.go/export"
+	"github.com/jeffasante/chatroom.go/importer"
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+// runCommand runs an administrative command against the database and
+// returns the process exit code
+func runCommand(db *gorm.DB, name string, args []string) int {
+	var err error
+	switch name {
+	case "export":
+		err = exportCommand(db, args)
+	case "import":
+		err = importCommand(db, args)
+	case "purge":
+		err = purgeCommand(db, args)
+	case "hold":
+		err = holdCommand(db, args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q; commands: export, import, purge, hold\n", name)
+		return 2
+	}
+	if err == flag.ErrHelp {
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		return 1
+	}
+	return 0
+}
+
+// commandLogger logs database warnings and errors to stderr
+func commandLogger() logger.Interface {
+	return logger.New(log.New(os.Stderr, "", log.LstdFlags), logger.Config{
+		SlowThreshold:             time.Second,
+		LogLevel:                  logger.Warn,
+		IgnoreRecordNotFoundError: true,
+	})
+}
+
+// exportCommand writes a room's history to a file or standard output
+func exportCommand(db *gorm.DB, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	code := flags.String("room", "", "code of the room to export")
+	format := flags.String("format", export.JSON, "json, txt, html or csv")
+	output := flags.String("o", "-", "file to write, or - for standard output")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *code == "" {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+	if export.ContentType(*format) == "" {
+		return export.ErrUnknownFormat
+	}
+
+	var chatroom models.Chatroom
+	if err := db.Where("code = ?", *code).First(&chatroom).Error; err != nil {
+		return fmt.Errorf("room %s not found", *code)
+	}
+
+	if *output == "-" {
+		return export.Write(os.Stdout, db, &chatroom, *format)
+	}
+	file, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := export.Write(file, db, &chatroom, *format); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// importCommand loads a Slack or Discord export into new rooms owned by an
+// existing user
+func importCommand(db *gorm.DB, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	from := flags.String("from", "", "slack (an export zip) or discord (DiscordChatExporter JSON files)")
+	owner := flags.String("owner", "", "username of the user who will own the imported rooms")
+	password := flags.String("password", "", "join password for new rooms")
+	dryRun := flags.Bool("dry-run", false, "report what would be imported without saving anything")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: import -from slack|discord -owner <username> -password <password> [-dry-run] <file>...")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *owner == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+
+	var user models.User
+	if err := db.Where("username = ?", *owner).First(&user).Error; err != nil {
+		return fmt.Errorf("no user named %s", *owner)
+	}
+
+	var history *importer.Export
+	var err error
+	switch *from {
+	case importer.Slack:
+		if flags.NArg() != 1 {
+			return errors.New("a Slack import takes one export zip")
+		}
+		history, err = importer.ReadSlack(flags.Arg(0))
+	case importer.Discord:
+		history, err = importer.ReadDiscord(flags.Args()...)
+	default:
+		return errors.New("-from must be slack or discord")
+	}
+	if err != nil {
+		return err
+	}
+
+	report, err := importer.Run(db, history, importer.Options{Owner: &user, Password: *password, DryRun: *dryRun})
+	if err != nil {
+		return err
+	}
+	report.Write(os.Stdout)
+	return nil
+}
+
+// purgeCommand applies retention policies now instead of waiting for the
+// server's next run
+func purgeCommand(db *gorm.DB, args []string) error {
+	flags := flag.NewFlagSet("purge", flag.ContinueOnError)
+	code := flags.String("room", "", "code of a single room to purge")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	blobs, err := blobStoreFromEnv()
+	if err != nil {
+		return err
+	}
+	purger := purgerFromEnv(db, blobs)
+
+	var n int
+	if *code == "" {
+		n, err = purger.PurgeAll(context.Background())
+	} else {
+		var chatroom models.Chatroom
+		if err := db.Where("code = ?", *code).First(&chatroom).Error; err != nil {
+			return fmt.Errorf("room %s not found", *code)
+		}
+		if chatroom.LegalHold {
+			return fmt.Errorf("room %s is under legal hold", *code)
+		}
+		n, err = purger.PurgeRoom(context.Background(), &chatroom)
+	}
+	fmt.Printf("%d messages purged\n", n)
+	return err
+}
+
+// holdCommand places a room under legal hold, or releases it. The change
+// is recorded in the room's audit log under the administrator's name.
+func holdCommand(db *gorm.DB, args []string) error {
+	flags := flag.NewFlagSet("hold", flag.ContinueOnError)
+	code := flags.String("room", "", "code of the room")
+	by := flags.String("by", "", "username of the administrator, for the audit log")
+	release := flags.Bool("release", false, "release the hold instead of placing it")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *code == "" || *by == "" {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+
+	var actor models.User
+	if err := db.Where("username = ?", *by).First(&actor).Error; err != nil {
+		return fmt.Errorf("no user named %s", *by)
+	}
+
+	held := !*release
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var chatroom models.Chatroom
+		if err := tx.Where("code = ?", *code).First(&chatroom).Error; err != nil {
+			return fmt.Errorf("room %s not found", *code)
+		}
+		if chatroom.LegalHold == held {
+			return nil
+		}
+		if err := tx.Model(&chatroom).Update("legal_hold", held).Error; err != nil {
+			return err
+		}
+
+		before, err := json.Marshal(map[string]bool{"legal_hold": !held})
+		if err != nil {
+			return err
+		}
+		after, err := json.Marshal(map[string]bool{"legal_hold": held})
+		if err != nil {
+			return err
+		}
+		return tx.Create(&models.AuditEvent{
+			ChatroomID: chatroom.ID,
+			ActorID:    actor.ID,
+			Action:     models.AuditRoomLegalHold,
+			Before:     string(before),
+			After:      string(after),
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+	if *release {
+		fmt.Printf("Room %s released from legal hold\n", *code)
+	} else {
+		fmt.Printf("Room %s is under legal hold\n", *code)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jeffasante/chatroom.go/internal/chattest"
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+func TestHoldIsAudited(t *testing.T) {
+	srv := chattest.New(t)
+	alice := srv.SignUp(t, "alice")
+	code := srv.CreateRoom(t, alice, "general", "secret")
+
+	for _, args := range [][]string{
+		{"-room", code, "-by", "alice"},
+		{"-room", code, "-by", "alice"}, // already held, nothing changes
+		{"-room", code, "-by", "alice", "-release"},
+	} {
+		if err := holdCommand(srv.DB, args); err != nil {
+			t.Fatalf("hold %v: %v", args, err)
+		}
+	}
+	if err := holdCommand(srv.DB, []string{"-room", code, "-by", "nobody"}); err == nil {
+		t.Error("hold by an unknown user succeeded")
+	}
+
+	var events []models.AuditEvent
+	srv.DB.Where("action = ?", models.AuditRoomLegalHold).Order("id").Find(&events)
+	if len(events) != 2 || events[0].ActorID != alice.ID ||
+		events[0].After != `{"legal_hold":true}` || events[1].After != `{"legal_hold":false}` {
+		t.Errorf("audit events %+v, want a hold and a release by alice", events)
+	}
+}
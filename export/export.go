@@ -0,0 +1,166 @@
+// Package export writes a room's message history as JSON, plain text, HTML
+// or CSV. Messages are read in batches, so rooms of any size can be
+// exported without holding them in memory.
+package export
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+// Export formats, which double as file extensions
+const (
+	JSON = "json"
+	Text = "txt"
+	HTML = "html"
+	CSV  = "csv"
+)
+
+// batchSize is how many messages are loaded from the database at a time
+const batchSize = 500
+
+var ErrUnknownFormat = errors.New("format must be json, txt, html or csv")
+
+var contentTypes = map[string]string{
+	JSON: "application/json; charset=utf-8",
+	Text: "text/plain; charset=utf-8",
+	HTML: "text/html; charset=utf-8",
+	CSV:  "text/csv; charset=utf-8",
+}
+
+// ContentType returns the MIME type of an export format, or "" if the
+// format is unknown
+func ContentType(format string) string {
+	return contentTypes[format]
+}
+
+// Room describes the exported room
+type Room struct {
+	Code       string    `json:"code"`
+	Name       string    `json:"name"`
+	Topic      string    `json:"topic,omitempty"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+// Message is one exported message. Messages can't be edited, so content is
+// always as it was sent.
+type Message struct {
+	ID          uint         `json:"id"`
+	Seq         uint64       `json:"seq"`
+	Type        string       `json:"type"`
+	AuthorID    uint         `json:"author_id"`
+	Author      string       `json:"author"`
+	IsBot       bool         `json:"is_bot,omitempty"`
+	Content     string       `json:"content"`
+	CreatedAt   time.Time    `json:"created_at"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+
+	html string // rendered Markdown, for HTML exports
+}
+
+// Attachment is the metadata of an exported attachment; file contents are
+// not included
+type Attachment struct {
+	ID          uint   `json:"id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Width       int    `json:"width,omitempty"`
+	Height      int    `json:"height,omitempty"`
+}
+
+// formatter writes one export format
+type formatter interface {
+	begin(room Room) error
+	message(m *Message) error
+	end() error
+}
+
+// Write streams a room's messages to w, oldest first. If w can be flushed,
+// as an HTTP response can, it is flushed after every batch.
+func Write(w io.Writer, db *gorm.DB, chatroom *models.Chatroom, format string) error {
+	var f formatter
+	switch format {
+	case JSON:
+		f = &jsonFormatter{w: w}
+	case Text:
+		f = &textFormatter{w: w}
+	case HTML:
+		f = &htmlFormatter{w: w}
+	case CSV:
+		f = newCSVFormatter(w)
+	default:
+		return ErrUnknownFormat
+	}
+
+	err := f.begin(Room{
+		Code:       chatroom.Code,
+		Name:       chatroom.Name,
+		Topic:      chatroom.Topic,
+		ExportedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	var lastSeq uint64
+	for {
+		var batch []models.Message
+		if err := db.Where("chatroom_id = ? AND seq > ?", chatroom.ID, lastSeq).
+			Preload("User").
+			Preload("Attachments").
+			Order("seq ASC").
+			Limit(batchSize).
+			Find(&batch).Error; err != nil {
+			return err
+		}
+
+		for i := range batch {
+			if err := f.message(NewMessage(&batch[i])); err != nil {
+				return err
+			}
+		}
+		if flusher, ok := w.(interface{ Flush() }); ok {
+			flusher.Flush()
+		}
+
+		if len(batch) < batchSize {
+			break
+		}
+		lastSeq = batch[len(batch)-1].Seq
+	}
+
+	return f.end()
+}
+
+// NewMessage converts a stored message, loaded with its user and
+// attachments, for export
+func NewMessage(m *models.Message) *Message {
+	message := &Message{
+		ID:        m.ID,
+		Seq:       m.Seq,
+		Type:      m.Type,
+		AuthorID:  m.UserID,
+		Author:    m.User.Username,
+		IsBot:     m.User.IsBot,
+		Content:   m.Content,
+		CreatedAt: m.CreatedAt.UTC(),
+		html:      string(m.HTML),
+	}
+	for _, a := range m.Attachments {
+		message.Attachments = append(message.Attachments, Attachment{
+			ID:          a.ID,
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			Size:        a.Size,
+			Width:       a.Width,
+			Height:      a.Height,
+		})
+	}
+	return message
+}
@@ -0,0 +1,191 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// jsonFormatter writes {"room": ..., "messages": [...]}, one message per line
+type jsonFormatter struct {
+	w     io.Writer
+	count int
+}
+
+func (f *jsonFormatter) begin(room Room) error {
+	data, err := json.Marshal(room)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f.w, `{"room":%s,"messages":[`, data)
+	return err
+}
+
+func (f *jsonFormatter) message(m *Message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	separator := ",\n"
+	if f.count == 0 {
+		separator = "\n"
+	}
+	f.count++
+	_, err = fmt.Fprintf(f.w, "%s%s", separator, data)
+	return err
+}
+
+func (f *jsonFormatter) end() error {
+	_, err := io.WriteString(f.w, "\n]}\n")
+	return err
+}
+
+// textFormatter writes a chat log readable without any tools
+type textFormatter struct {
+	w io.Writer
+}
+
+func (f *textFormatter) begin(room Room) error {
+	header := fmt.Sprintf("%s [%s]\n", room.Name, room.Code)
+	if room.Topic != "" {
+		header += "Topic: " + room.Topic + "\n"
+	}
+	header += "Exported " + room.ExportedAt.Format(time.RFC3339) + "\n\n"
+	_, err := io.WriteString(f.w, header)
+	return err
+}
+
+func (f *textFormatter) message(m *Message) error {
+	var b strings.Builder
+	b.WriteString("[" + m.CreatedAt.Format("2006-01-02 15:04:05") + "] ")
+	if m.Type == "action" {
+		b.WriteString("* " + m.Author + " ")
+	} else {
+		b.WriteString(m.Author + ": ")
+	}
+	// Continuation lines are indented so every message starts a new line
+	b.WriteString(strings.ReplaceAll(m.Content, "\n", "\n    "))
+	b.WriteString("\n")
+	for _, a := range m.Attachments {
+		fmt.Fprintf(&b, "    attachment: %s (%s, %d bytes)\n", a.Filename, a.ContentType, a.Size)
+	}
+	_, err := io.WriteString(f.w, b.String())
+	return err
+}
+
+func (f *textFormatter) end() error {
+	return nil
+}
+
+// htmlFormatter writes a standalone page showing formatted messages
+type htmlFormatter struct {
+	w io.Writer
+}
+
+var htmlTemplates = template.Must(template.New("begin").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<title>{{.Name}} [{{.Code}}]</title>
+<style>
+body { font-family: monospace; max-width: 900px; margin: 2em auto; padding: 0 1em; }
+.message { border-bottom: 1px solid #ddd; padding: 0.5em 0; }
+.meta { color: #666; font-size: 0.9em; }
+.attachment { color: #666; font-size: 0.9em; margin-top: 0.25em; }
+pre { background: #f4f4f4; padding: 0.5em; overflow-x: auto; }
+</style>
+</head>
+<body>
+<h1>{{.Name}} [{{.Code}}]</h1>
+{{if .Topic}}<p>{{.Topic}}</p>{{end}}
+<p class="meta">Exported {{.ExportedAt.Format "2006-01-02 15:04:05 MST"}}</p>
+`))
+
+func init() {
+	template.Must(htmlTemplates.New("message").Parse(`<div class="message" id="m{{.ID}}">
+<div class="meta">{{.CreatedAt.Format "2006-01-02 15:04:05"}} <strong>{{.Author}}</strong>{{if .IsBot}} (bot){{end}}</div>
+<div class="content">{{if eq .Type "action"}}<em>* {{.Author}} {{.Content}}</em>{{else}}{{.HTML}}{{end}}</div>
+{{range .Attachments}}<div class="attachment">attachment: {{.Filename}} ({{.ContentType}}, {{.Size}} bytes)</div>
+{{end}}</div>
+`))
+}
+
+func (f *htmlFormatter) begin(room Room) error {
+	return htmlTemplates.ExecuteTemplate(f.w, "begin", room)
+}
+
+func (f *htmlFormatter) message(m *Message) error {
+	return htmlTemplates.ExecuteTemplate(f.w, "message", struct {
+		*Message
+		HTML template.HTML
+	}{m, template.HTML(m.html)}) // already sanitized by the markdown renderer
+}
+
+func (f *htmlFormatter) end() error {
+	_, err := io.WriteString(f.w, "</body>\n</html>\n")
+	return err
+}
+
+// csvFormatter writes one row per message, attachments in a single column.
+// The csv.Writer's buffer is flushed whenever it fills, and at the end.
+type csvFormatter struct {
+	w *csv.Writer
+}
+
+func newCSVFormatter(w io.Writer) *csvFormatter {
+	return &csvFormatter{w: csv.NewWriter(w)}
+}
+
+func (f *csvFormatter) begin(room Room) error {
+	return f.w.Write([]string{"id", "seq", "type", "created_at", "author_id", "author", "is_bot", "content", "attachments"})
+}
+
+func (f *csvFormatter) message(m *Message) error {
+	var attachments []string
+	for _, a := range m.Attachments {
+		attachments = append(attachments, fmt.Sprintf("%s (%s, %d bytes)", a.Filename, a.ContentType, a.Size))
+	}
+	return f.w.Write([]string{
+		strconv.FormatUint(uint64(m.ID), 10),
+		strconv.FormatUint(m.Seq, 10),
+		spreadsheetSafe(m.Type),
+		m.CreatedAt.Format(time.RFC3339),
+		strconv.FormatUint(uint64(m.AuthorID), 10),
+		spreadsheetSafe(m.Author),
+		strconv.FormatBool(m.IsBot),
+		spreadsheetSafe(m.Content),
+		spreadsheetSafe(strings.Join(attachments, "; ")),
+	})
+}
+
+func (f *csvFormatter) end() error {
+	f.w.Flush()
+	return f.w.Error()
+}
+
+// formulaStarts are the characters that make a spreadsheet program read a
+// cell as a formula, including their full-width forms
+const formulaStarts = "=+-@＝＋－＠"
+
+// spreadsheetSafe stops spreadsheet programs treating a cell as a formula.
+// Some skip leading whitespace before deciding, so it is skipped here too.
+func spreadsheetSafe(s string) string {
+	if s == "" {
+		return s
+	}
+	if strings.ContainsRune("\t\r\n", rune(s[0])) {
+		return "'" + s
+	}
+	trimmed := strings.TrimLeftFunc(s, unicode.IsSpace)
+	if first, _ := utf8.DecodeRuneInString(trimmed); trimmed != "" && strings.ContainsRune(formulaStarts, first) {
+		return "'" + s
+	}
+	return s
+}
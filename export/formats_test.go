@@ -0,0 +1,64 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+)
+
+func TestSpreadsheetSafe(t *testing.T) {
+	tests := map[string]string{
+		"hello":                "hello",
+		"":                     "",
+		"1+1=2":                "1+1=2",
+		"=HYPERLINK(\"x\")":    "'=HYPERLINK(\"x\")",
+		"+1":                   "'+1",
+		"-2+3":                 "'-2+3",
+		"@SUM(A1)":             "'@SUM(A1)",
+		"  =1+1":               "'  =1+1",
+		"\u00a0@SUM(A1)":       "'\u00a0@SUM(A1)",
+		"\tindented":           "'\tindented",
+		"\r\n=cmd":             "'\r\n=cmd",
+		"\uff1d1+1":            "'\uff1d1+1",
+		"email me@example.com": "email me@example.com",
+	}
+	for in, want := range tests {
+		if got := spreadsheetSafe(in); got != want {
+			t.Errorf("spreadsheetSafe(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestCSVEscapesFormulas(t *testing.T) {
+	var buf bytes.Buffer
+	f := newCSVFormatter(&buf)
+	f.begin(Room{})
+	f.message(&Message{
+		ID:          1,
+		Seq:         1,
+		Type:        "message",
+		Author:      "=evil",
+		Content:     " +cmd|' /C calc'!A0",
+		CreatedAt:   time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Attachments: []Attachment{{Filename: "@file.txt", ContentType: "text/plain", Size: 3}},
+	})
+	if err := f.end(); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("%d rows, want a header and one message", len(rows))
+	}
+	row := rows[1]
+	if row[5] != "'=evil" || row[7] != "' +cmd|' /C calc'!A0" || row[8] != "'@file.txt (text/plain, 3 bytes)" {
+		t.Errorf("row = %q", row)
+	}
+	if row[0] != "1" || row[3] != "2026-01-02T03:04:05Z" {
+		t.Errorf("plain cells changed: %q", row)
+	}
+}
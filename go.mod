@@ -4,6 +4,7 @@ go 1.21 // or another version
 
 require (
 	golang.org/x/crypto v0.23.0
+	golang.org/x/net v0.25.0
 	gorm.io/gorm v1.30.0
 )
 
@@ -29,7 +30,6 @@ require (
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
 	github.com/ugorji/go/codec v1.2.12 // indirect
 	golang.org/x/arch v0.8.0 // indirect
-	golang.org/x/net v0.25.0 // indirect
 	golang.org/x/sys v0.20.0 // indirect
 	google.golang.org/protobuf v1.34.1 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
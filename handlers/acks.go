@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+const maxNonceLength = 64
+
+var (
+	errNonceTooLong = errors.New("nonce too long")
+	errSaveFailed   = errors.New("failed to save message")
+)
+
+func validateNonce(nonce string) error {
+	if len(nonce) > maxNonceLength {
+		return errNonceTooLong
+	}
+	return nil
+}
+
+// findByNonce returns a message the user already sent to the room with this
+// nonce. Run handles messages one at a time, so checking before inserting
+// is enough to stop duplicates; the unique index is a backstop.
+func (h *Hub) findByNonce(chatroomID, userID uint, nonce string) (*models.Message, bool) {
+	if nonce == "" {
+		return nil, false
+	}
+
+	var existing models.Message
+	if err := h.db.Select("id", "seq").Where("chatroom_id = ? AND user_id = ? AND client_nonce = ?", chatroomID, userID, nonce).First(&existing).Error; err != nil {
+		return nil, false
+	}
+	return &existing, true
+}
+
+// isRetry reports whether the user already sent a message with this nonce
+// to the room, whether it went out or is waiting for review. Retries are
+// acknowledged again rather than checked against the limits a second time.
+func (h *Hub) isRetry(chatroomID, userID uint, nonce string) bool {
+	if _, found := h.findByNonce(chatroomID, userID, nonce); found {
+		return true
+	}
+	_, found := h.findHeld(chatroomID, userID, nonce)
+	return found
+}
+
+// ackFrame builds the reply telling a sender whether its message was saved
+func ackFrame(message *Message, err error) *Message {
+	ack := &Message{
+		Type:       "ack",
+		ChatroomID: message.ChatroomID,
+		Timestamp:  time.Now(),
+		MessageID:  message.MessageID,
+		Seq:        message.Seq,
+		Nonce:      message.Nonce,
+	}
+	if err != nil {
+		ack.Error = err.Error()
+	} else {
+		// Lets the sender show its message as everyone else sees it
+		ack.HTML = message.HTML
+	}
+	return ack
+}
+
+// ack acknowledges a message to the connection that sent it, if it asked
+// for one by supplying a nonce. Must only be called from Run.
+func (h *Hub) ack(message *Message, err error) {
+	if message.sender == nil || message.Nonce == "" {
+		return
+	}
+	if _, ok := h.clients[message.sender]; !ok {
+		return
+	}
+	h.sendOrDrop(message.sender, ackFrame(message, err))
+}
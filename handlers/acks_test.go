@@ -0,0 +1,49 @@
+package handlers_test
+
+import (
+	"testing"
+
+	"github.com/jeffasante/chatroom.go/internal/chattest"
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+func TestRetriedSendIsAcknowledgedOnce(t *testing.T) {
+	srv := chattest.New(t)
+	alice := srv.SignUp(t, "alice")
+	general := srv.CreateRoom(t, alice, "general", "secret")
+	random := srv.CreateRoom(t, alice, "random", "secret")
+
+	conn := srv.Connect(t, alice, general)
+	first := send(t, conn, "hello", "retry-1")
+	if again := send(t, conn, "hello", "retry-1"); again != first {
+		t.Errorf("retry was saved as message %d, want %d", again, first)
+	}
+
+	// Nonces only have to be unique within a room
+	other := send(t, srv.Connect(t, alice, random), "hello", "retry-1")
+	if other == first {
+		t.Error("a send to another room was taken for a retry")
+	}
+
+	var count int64
+	srv.DB.Model(&models.Message{}).Where("client_nonce = ?", "retry-1").Count(&count)
+	if count != 2 {
+		t.Errorf("%d messages saved, want 2", count)
+	}
+}
+
+func TestNonceIndex(t *testing.T) {
+	srv := chattest.New(t)
+	messages := []models.Message{
+		{Content: "a", UserID: 1, ChatroomID: 1, ClientNonce: "n"},
+		{Content: "b", UserID: 1, ChatroomID: 1},
+		{Content: "c", UserID: 1, ChatroomID: 1},
+		{Content: "d", UserID: 2, ChatroomID: 1, ClientNonce: "n"},
+	}
+	if err := srv.DB.Create(&messages).Error; err != nil {
+		t.Fatalf("saving messages without clashing nonces: %v", err)
+	}
+	if err := srv.DB.Create(&models.Message{Content: "e", UserID: 1, ChatroomID: 1, ClientNonce: "n"}).Error; err == nil {
+		t.Error("saved a second message with the same nonce")
+	}
+}
@@ -0,0 +1,411 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/jeffasante/chatroom.go/media"
+	"github.com/jeffasante/chatroom.go/models"
+	"github.com/jeffasante/chatroom.go/moderation"
+	"github.com/jeffasante/chatroom.go/storage"
+)
+
+// MaxAttachmentSize is the largest file that can be uploaded, in bytes
+var MaxAttachmentSize int64 = 10 << 20
+
+// allowedAttachmentTypes are the content types accepted for upload, judged
+// from the file's bytes rather than what the client claims. Images listed
+// here are shown inline; everything else is downloaded.
+var allowedAttachmentTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+	"application/zip": true,
+	"text/plain":      true,
+}
+
+// AttachmentInfo describes an attachment in message frames. Images also
+// carry their dimensions and, unless too large to decode, a thumbnail.
+type AttachmentInfo struct {
+	ID          uint   `json:"id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	URL         string `json:"url"`
+
+	Width           int    `json:"width,omitempty"`
+	Height          int    `json:"height,omitempty"`
+	ThumbnailURL    string `json:"thumbnail_url,omitempty"`
+	ThumbnailWidth  int    `json:"thumbnail_width,omitempty"`
+	ThumbnailHeight int    `json:"thumbnail_height,omitempty"`
+}
+
+func attachmentInfo(a models.Attachment) AttachmentInfo {
+	info := AttachmentInfo{
+		ID:          a.ID,
+		Filename:    a.Filename,
+		ContentType: a.ContentType,
+		Size:        a.Size,
+		URL:         "/api/attachments/" + strconv.FormatUint(uint64(a.ID), 10),
+		Width:       a.Width,
+		Height:      a.Height,
+	}
+	if a.ThumbnailKey != "" {
+		info.ThumbnailURL = info.URL + "/thumbnail"
+		info.ThumbnailWidth = a.ThumbnailWidth
+		info.ThumbnailHeight = a.ThumbnailHeight
+	}
+	return info
+}
+
+func attachmentInfos(attachments []models.Attachment) []AttachmentInfo {
+	if len(attachments) == 0 {
+		return nil
+	}
+	infos := make([]AttachmentInfo, len(attachments))
+	for i, a := range attachments {
+		infos[i] = attachmentInfo(a)
+	}
+	return infos
+}
+
+// SetBlobStore sets where uploaded files are kept. Uploads are refused
+// until one is set.
+func (h *Handler) SetBlobStore(store storage.BlobStore) {
+	h.blobs = store
+}
+
+// sniffContentType returns the media type of a file judged from its first bytes
+func sniffContentType(head []byte) string {
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return mediaType
+}
+
+// cleanFilename keeps only the base name and drops characters that could
+// upset headers or file systems
+func cleanFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' || r == '/' {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "" || name == "." {
+		name = "file"
+	}
+	for len(name) > 200 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
+
+// API endpoint to upload a file and post it to a room, with an optional
+// caption in the content field
+func (h *Handler) UploadAttachment(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := h.GetCurrentUser(c)
+		if user == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		var chatroom models.Chatroom
+		if err := h.db.Where("code = ?", c.Param("code")).First(&chatroom).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+			return
+		}
+
+		if !chatroom.IsMember(h.db, user.ID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not a member"})
+			return
+		}
+
+		if h.blobs == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "attachments are not configured"})
+			return
+		}
+
+		// Leave room for the other form fields and multipart framing
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MaxAttachmentSize+64<<10)
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "file too large"})
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing file"})
+			return
+		}
+		if fileHeader.Size > MaxAttachmentSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "file too large"})
+			return
+		}
+		if fileHeader.Size == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "file is empty"})
+			return
+		}
+
+		caption := strings.TrimSpace(c.PostForm("content"))
+		if utf8.RuneCountInString(caption) > messageLimit(&chatroom) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "caption too long"})
+			return
+		}
+
+		// Checked before the file is stored, so a refused upload costs nothing
+		if refused := hub.checkRate(hub.roomSettings(chatroom.ID), user.ID, &Message{ChatroomID: chatroom.ID}, false); refused != nil {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(refused.RetryAfter))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "rate limited", "retry_after": refused.RetryAfter})
+			return
+		}
+
+		file, err := fileHeader.Open()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read upload"})
+			return
+		}
+		defer file.Close()
+
+		head := make([]byte, 512)
+		n, err := io.ReadFull(file, head)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read upload"})
+			return
+		}
+		head = head[:n]
+
+		contentType := sniffContentType(head)
+		if !allowedAttachmentTypes[contentType] {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "file type not allowed"})
+			return
+		}
+
+		key, err := storage.NewKey(chatroom.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store file"})
+			return
+		}
+
+		attachment := models.Attachment{
+			ChatroomID:  chatroom.ID,
+			UserID:      user.ID,
+			Key:         key,
+			Filename:    cleanFilename(fileHeader.Filename),
+			ContentType: contentType,
+			Size:        fileHeader.Size,
+		}
+		body := io.MultiReader(bytes.NewReader(head), file)
+
+		// Images are read whole so their metadata can be stripped before storing
+		var processed *media.Processed
+		if media.CanProcess(contentType) {
+			data, err := io.ReadAll(body)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read upload"})
+				return
+			}
+			processed, err = media.Process(data, contentType)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid image"})
+				return
+			}
+			body = bytes.NewReader(processed.Data)
+			attachment.Size = int64(len(processed.Data))
+			attachment.Width = processed.Width
+			attachment.Height = processed.Height
+		}
+
+		if err := h.blobs.Put(c.Request.Context(), key, body, attachment.Size, contentType); err != nil {
+			log.Printf("Failed to store attachment: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store file"})
+			return
+		}
+
+		// A missing thumbnail only means the full image is shown scaled down
+		if processed != nil && processed.Thumbnail != nil {
+			thumbKey := key + "-thumb"
+			if err := h.blobs.Put(c.Request.Context(), thumbKey, bytes.NewReader(processed.Thumbnail), int64(len(processed.Thumbnail)), processed.ThumbnailContentType); err != nil {
+				log.Printf("Failed to store thumbnail: %v", err)
+			} else {
+				attachment.ThumbnailKey = thumbKey
+				attachment.ThumbnailContentType = processed.ThumbnailContentType
+				attachment.ThumbnailWidth = processed.ThumbnailWidth
+				attachment.ThumbnailHeight = processed.ThumbnailHeight
+			}
+		}
+
+		if err := h.db.Create(&attachment).Error; err != nil {
+			h.deleteBlobs(attachment.BlobKeys())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save attachment"})
+			return
+		}
+
+		info := attachmentInfo(attachment)
+		decision, err := hub.submit(&Message{
+			Type:        "message",
+			Content:     caption,
+			UserID:      user.ID,
+			Username:    user.Username,
+			ChatroomID:  chatroom.ID,
+			Timestamp:   time.Now(),
+			IsBot:       user.IsBot,
+			Attachments: []AttachmentInfo{info},
+		})
+		if err != nil {
+			discardAttachments(h.db, h.blobs, []AttachmentInfo{info})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save message"})
+			return
+		}
+
+		switch decision.Action {
+		case moderation.Hold:
+			c.JSON(http.StatusAccepted, gin.H{"attachment": info, "held": true})
+		case moderation.Reject:
+			discardAttachments(h.db, h.blobs, []AttachmentInfo{info})
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "message rejected by moderation", "reason": decision.Reason})
+		default:
+			c.JSON(http.StatusCreated, gin.H{"attachment": info})
+		}
+	}
+}
+
+// API endpoint to download an attachment, for members of its room only.
+// Until its message is posted only the uploader and moderators can.
+func (h *Handler) DownloadAttachment(c *gin.Context) {
+	h.serveAttachment(c, false)
+}
+
+// API endpoint to download an image attachment's thumbnail
+func (h *Handler) DownloadThumbnail(c *gin.Context) {
+	h.serveAttachment(c, true)
+}
+
+func (h *Handler) serveAttachment(c *gin.Context, thumbnail bool) {
+	user := h.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid attachment id"})
+		return
+	}
+
+	var attachment models.Attachment
+	if err := h.db.First(&attachment, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+		return
+	}
+
+	var chatroom models.Chatroom
+	if err := h.db.Select("id", "owner_id").First(&chatroom, attachment.ChatroomID).Error; err != nil || !chatroom.IsMember(h.db, user.ID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not a member"})
+		return
+	}
+
+	// Not posted yet, e.g. its message is held for review
+	if attachment.MessageID == 0 && attachment.UserID != user.ID && !chatroom.HasRole(h.db, user.ID, models.RoleModerator) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+		return
+	}
+
+	if h.blobs == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "attachments are not configured"})
+		return
+	}
+
+	key, size, contentType := attachment.Key, attachment.Size, attachment.ContentType
+	if thumbnail {
+		if attachment.ThumbnailKey == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no thumbnail"})
+			return
+		}
+		key, size, contentType = attachment.ThumbnailKey, -1, attachment.ThumbnailContentType
+	}
+
+	blob, err := h.blobs.Get(c.Request.Context(), key)
+	if err == storage.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to read attachment %d: %v", attachment.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read attachment"})
+		return
+	}
+	defer blob.Close()
+
+	disposition := "attachment"
+	if strings.HasPrefix(contentType, "image/") {
+		disposition = "inline"
+	}
+	c.DataFromReader(http.StatusOK, size, contentType, blob, map[string]string{
+		"Content-Disposition":    mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}),
+		"X-Content-Type-Options": "nosniff",
+		"Cache-Control":          "private, max-age=86400",
+	})
+}
+
+// deleteBlobs removes stored files after their records are gone. Failures
+// only leave unreachable files behind, so they are logged and skipped.
+func (h *Handler) deleteBlobs(keys []string) {
+	if h.blobs == nil {
+		return
+	}
+	for _, key := range keys {
+		if err := h.blobs.Delete(context.Background(), key); err != nil {
+			log.Printf("Failed to delete blob %s: %v", key, err)
+		}
+	}
+}
+
+// saveMessage stores a message and links the attachments it carries, which
+// must have been uploaded by the same user to the same room
+func (h *Hub) saveMessage(message *models.Message, attachments []AttachmentInfo) error {
+	return h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(message).Error; err != nil {
+			return err
+		}
+		if len(attachments) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, len(attachments))
+		for i, a := range attachments {
+			ids[i] = a.ID
+		}
+		result := tx.Model(&models.Attachment{}).
+			Where("id IN ? AND user_id = ? AND chatroom_id = ? AND message_id = 0", ids, message.UserID, message.ChatroomID).
+			Update("message_id", message.ID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != int64(len(ids)) {
+			return errors.New("attachment not found or already used")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,170 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/jeffasante/chatroom.go/handlers"
+	"github.com/jeffasante/chatroom.go/internal/chattest"
+	"github.com/jeffasante/chatroom.go/internal/s3test"
+	"github.com/jeffasante/chatroom.go/models"
+	"github.com/jeffasante/chatroom.go/storage"
+)
+
+type uploadResult struct {
+	Attachment handlers.AttachmentInfo `json:"attachment"`
+	Held       bool                    `json:"held"`
+	Error      string                  `json:"error"`
+}
+
+// upload posts a file to a room as the user
+func upload(t *testing.T, srv *chattest.Server, user *chattest.User, code, filename string, data []byte, fields map[string]string) (int, uploadResult) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		form.WriteField(name, value)
+	}
+	part, _ := form.CreateFormFile("file", filename)
+	part.Write(data)
+	form.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/room/"+code+"/attachments", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	resp, err := user.Client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var result uploadResult
+	json.NewDecoder(resp.Body).Decode(&result)
+	return resp.StatusCode, result
+}
+
+// download fetches a URL as the user
+func download(t *testing.T, srv *chattest.Server, user *chattest.User, url string) (int, []byte) {
+	t.Helper()
+	resp, err := user.Client.Get(srv.URL + url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, data
+}
+
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		img.Set(x, 0, color.RGBA{R: 255, A: 255})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestAttachmentsInS3(t *testing.T) {
+	objects := s3test.New(t)
+	blobs, err := storage.NewS3(storage.S3Config{
+		Endpoint:  objects.URL,
+		Region:    s3test.Region,
+		Bucket:    s3test.Bucket,
+		AccessKey: s3test.AccessKey,
+		SecretKey: s3test.SecretKey,
+		PathStyle: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := chattest.New(t, chattest.WithBlobStore(blobs))
+	alice := srv.SignUp(t, "alice")
+	mallory := srv.SignUp(t, "mallory")
+	code := srv.CreateRoom(t, alice, "general", "secret")
+
+	status, notes := upload(t, srv, alice, code, "notes.txt", []byte("meeting at noon"), nil)
+	if status != http.StatusCreated {
+		t.Fatalf("upload: %d %s", status, notes.Error)
+	}
+	status, picture := upload(t, srv, alice, code, "red.png", testPNG(t, 400, 300), nil)
+	if status != http.StatusCreated || picture.Attachment.ThumbnailURL == "" {
+		t.Fatalf("image upload: %d %+v", status, picture)
+	}
+	if keys := objects.Keys(); len(keys) != 3 {
+		t.Fatalf("stored objects %v, want the file, the image and its thumbnail", keys)
+	}
+
+	// Downloads go through the server, which signs its request to the store
+	status, data := download(t, srv, alice, notes.Attachment.URL)
+	if status != http.StatusOK || string(data) != "meeting at noon" {
+		t.Errorf("download: %d %q", status, data)
+	}
+	if status, data = download(t, srv, alice, picture.Attachment.ThumbnailURL); status != http.StatusOK {
+		t.Errorf("thumbnail download: %d", status)
+	} else if thumb, err := png.Decode(bytes.NewReader(data)); err != nil || thumb.Bounds().Dx() != 320 {
+		t.Errorf("thumbnail is not a 320 pixel wide PNG: %v", err)
+	}
+	if status, _ := download(t, srv, mallory, notes.Attachment.URL); status != http.StatusForbidden {
+		t.Errorf("non-member download: %d", status)
+	}
+	gets := 0
+	for _, request := range objects.Requests() {
+		if strings.HasPrefix(request, "GET ") {
+			gets++
+		}
+	}
+	if gets != 2 {
+		t.Errorf("store requests %v, want 2 signed GETs", objects.Requests())
+	}
+
+	if status := srv.Do(t, alice, http.MethodDelete, "/api/room/"+code, nil, nil); status != http.StatusOK {
+		t.Fatalf("delete room: %d", status)
+	}
+	if keys := objects.Keys(); len(keys) != 0 {
+		t.Errorf("objects left after deleting the room: %v", keys)
+	}
+}
+
+func TestHeldAttachmentOnlyForUploaderAndModerators(t *testing.T) {
+	blobs, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := chattest.New(t, chattest.WithBlobStore(blobs))
+	alice := srv.SignUp(t, "alice")
+	bob := srv.SignUp(t, "bob")
+	carol := srv.SignUp(t, "carol")
+	code := srv.CreateRoom(t, alice, "general", "secret")
+	srv.JoinRoom(t, bob, code, "secret")
+	srv.JoinRoom(t, carol, code, "secret")
+
+	var chatroom models.Chatroom
+	srv.DB.Where("code = ?", code).First(&chatroom)
+	srv.DB.Create(&models.RoomModeration{ChatroomID: chatroom.ID, BlockedWords: "suspicious", WordAction: "hold", LinkAction: "reject"})
+
+	status, held := upload(t, srv, carol, code, "notes.txt", []byte("held file"), map[string]string{"content": "suspicious"})
+	if status != http.StatusAccepted || !held.Held {
+		t.Fatalf("upload: %d %+v", status, held)
+	}
+
+	if status, _ := download(t, srv, bob, held.Attachment.URL); status != http.StatusNotFound {
+		t.Errorf("member download before review: %d", status)
+	}
+	for _, user := range []*chattest.User{carol, alice} {
+		if status, data := download(t, srv, user, held.Attachment.URL); status != http.StatusOK || string(data) != "held file" {
+			t.Errorf("%s's download before review: %d %q", user.Username, status, data)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+// auditEntry describes an administrative action for the room's audit log.
+// before and after are snapshots of what changed, marshalled to JSON.
+type auditEntry struct {
+	chatroomID   uint
+	actorID      uint
+	action       string
+	targetUserID uint
+	targetType   string
+	targetID     uint
+	before       interface{}
+	after        interface{}
+}
+
+// recordAudit appends an entry to the audit log. Pass a transaction to
+// record it only if the action itself commits.
+func recordAudit(db *gorm.DB, entry auditEntry) error {
+	before, err := auditSnapshot(entry.before)
+	if err != nil {
+		return err
+	}
+	after, err := auditSnapshot(entry.after)
+	if err != nil {
+		return err
+	}
+	return db.Create(&models.AuditEvent{
+		ChatroomID:   entry.chatroomID,
+		ActorID:      entry.actorID,
+		Action:       entry.action,
+		TargetUserID: entry.targetUserID,
+		TargetType:   entry.targetType,
+		TargetID:     entry.targetID,
+		Before:       before,
+		After:        after,
+	}).Error
+}
+
+func auditSnapshot(value interface{}) (string, error) {
+	if value == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(value)
+	return string(data), err
+}
+
+// audit records an action that has already happened, logging any failure
+// since the action can't be undone
+func audit(db *gorm.DB, entry auditEntry) {
+	if err := recordAudit(db, entry); err != nil {
+		log.Printf("Failed to record %s audit event in chatroom %d: %v", entry.action, entry.chatroomID, err)
+	}
+}
+
+// auditView is an audit event with its snapshots as JSON values
+type auditView struct {
+	models.AuditEvent
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// List a room's audit log, newest first. Filters: action (e.g. member.ban,
+// or member for every member action), actor and target usernames, from and
+// to dates, and before_id to page back.
+func (h *Handler) ListAuditEvents(c *gin.Context) {
+	user := h.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	chatroom, ok := h.findOwnedRoom(c, user)
+	if !ok {
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit <= 0 {
+		limit = 50
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	query := h.db.Where("chatroom_id = ?", chatroom.ID)
+
+	if action := c.Query("action"); action != "" {
+		query = query.Where(`(action = ? OR action LIKE ? ESCAPE '\')`, action, escapeLike(action)+".%")
+	}
+	for param, column := range map[string]string{"actor": "actor_id", "target": "target_user_id"} {
+		username := c.Query(param)
+		if username == "" {
+			continue
+		}
+		query = query.Where(column+" IN (?)", h.db.Model(&models.User{}).Select("id").Where("username = ?", username))
+	}
+
+	from, err := parseSearchDate(c.Query("from"), false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from date"})
+		return
+	}
+	to, err := parseSearchDate(c.Query("to"), true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to date"})
+		return
+	}
+	if !from.IsZero() {
+		query = query.Where("created_at >= ?", from)
+	}
+	if !to.IsZero() {
+		query = query.Where("created_at <= ?", to)
+	}
+
+	if raw := c.Query("before_id"); raw != "" {
+		beforeID, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid before_id"})
+			return
+		}
+		query = query.Where("id < ?", beforeID)
+	}
+
+	var events []models.AuditEvent
+	if err := query.Preload("Actor").Preload("TargetUser").Order("id DESC").Limit(limit + 1).Find(&events).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get audit log"})
+		return
+	}
+	hasMore := len(events) > limit
+	if hasMore {
+		events = events[:limit]
+	}
+
+	views := make([]auditView, 0, len(events))
+	for _, event := range events {
+		view := auditView{AuditEvent: event}
+		if event.Before != "" {
+			view.Before = json.RawMessage(event.Before)
+		}
+		if event.After != "" {
+			view.After = json.RawMessage(event.After)
+		}
+		views = append(views, view)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events":   views,
+		"count":    len(views),
+		"has_more": hasMore,
+	})
+}
+
+// roomSnapshot is the part of a room recorded when its settings change
+func roomSnapshot(chatroom *models.Chatroom) gin.H {
+	return gin.H{
+		"name":               chatroom.Name,
+		"code":               chatroom.Code,
+		"topic":              chatroom.Topic,
+		"max_message_length": chatroom.MaxMessageLength,
+	}
+}
@@ -0,0 +1,158 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jeffasante/chatroom.go/internal/chattest"
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+type auditResponse struct {
+	Events  []models.AuditEvent `json:"events"`
+	Count   int                 `json:"count"`
+	HasMore bool                `json:"has_more"`
+}
+
+// auditLog creates a room owned by alice where bob is a moderator. After
+// alice creating the room the log has one event a day from January 2nd
+// 2024: bob joining, alice banning carol, bob muting carol and alice
+// changing bob's role.
+func auditLog(t *testing.T) (srv *chattest.Server, chatroom *models.Chatroom, alice, bob, carol *chattest.User) {
+	t.Helper()
+	srv = chattest.New(t)
+	alice = srv.SignUp(t, "alice")
+	bob = srv.SignUp(t, "bob")
+	carol = srv.SignUp(t, "carol")
+	chatroom = &models.Chatroom{}
+	srv.DB.Where("code = ?", srv.CreateRoom(t, alice, "general", "secret")).First(chatroom)
+	srv.DB.Create(&models.Membership{UserID: bob.ID, ChatroomID: chatroom.ID, Role: models.RoleModerator})
+
+	day := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	for i, event := range []models.AuditEvent{
+		{ActorID: bob.ID, Action: models.AuditMemberJoin},
+		{ActorID: alice.ID, Action: models.AuditMemberBan, TargetUserID: carol.ID},
+		{ActorID: bob.ID, Action: models.AuditMemberMute, TargetUserID: carol.ID},
+		{ActorID: alice.ID, Action: models.AuditMemberRole, TargetUserID: bob.ID},
+	} {
+		event.ChatroomID = chatroom.ID
+		event.CreatedAt = day.AddDate(0, 0, i)
+		if err := srv.DB.Create(&event).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return srv, chatroom, alice, bob, carol
+}
+
+// actions lists the actions of the events in the order they were returned
+func (r auditResponse) actions() []string {
+	actions := []string{}
+	for _, event := range r.Events {
+		actions = append(actions, event.Action)
+	}
+	return actions
+}
+
+func TestListAuditEventsFilters(t *testing.T) {
+	srv, chatroom, alice, _, _ := auditLog(t)
+
+	tests := []struct {
+		params url.Values
+		want   []string
+	}{
+		{url.Values{}, []string{"member.role", "member.mute", "member.ban", "member.join", "room.create"}},
+		{url.Values{"action": {"member.ban"}}, []string{"member.ban"}},
+		{url.Values{"action": {"member"}}, []string{"member.role", "member.mute", "member.ban", "member.join"}},
+		{url.Values{"action": {"mem"}}, []string{}},
+		{url.Values{"action": {"%"}}, []string{}},
+		{url.Values{"actor": {"bob"}}, []string{"member.mute", "member.join"}},
+		{url.Values{"target": {"carol"}}, []string{"member.mute", "member.ban"}},
+		{url.Values{"actor": {"alice"}, "target": {"carol"}}, []string{"member.ban"}},
+		{url.Values{"actor": {"nobody"}}, []string{}},
+		{url.Values{"from": {"2024-01-02"}, "to": {"2024-01-03"}}, []string{"member.ban", "member.join"}},
+		{url.Values{"to": {"2024-01-03T00:00:00Z"}}, []string{"member.join"}},
+		{url.Values{"from": {"2024-01-05"}}, []string{"member.role", "room.create"}},
+	}
+	for _, tt := range tests {
+		var got auditResponse
+		if status := srv.Do(t, alice, http.MethodGet, "/api/room/"+chatroom.Code+"/audit?"+tt.params.Encode(), nil, &got); status != http.StatusOK {
+			t.Errorf("%s: %d", tt.params.Encode(), status)
+			continue
+		}
+		if !reflect.DeepEqual(got.actions(), tt.want) {
+			t.Errorf("%s: %v, want %v", tt.params.Encode(), got.actions(), tt.want)
+		}
+	}
+
+	for _, params := range []string{"from=yesterday", "to=2024-13-01", "before_id=x"} {
+		if status := srv.Do(t, alice, http.MethodGet, "/api/room/"+chatroom.Code+"/audit?"+params, nil, nil); status != http.StatusBadRequest {
+			t.Errorf("%s: %d, want 400", params, status)
+		}
+	}
+}
+
+func TestListAuditEventsPaging(t *testing.T) {
+	srv, chatroom, alice, _, _ := auditLog(t)
+
+	var pages [][]string
+	params := url.Values{"limit": {"2"}}
+	for {
+		var page auditResponse
+		srv.Do(t, alice, http.MethodGet, "/api/room/"+chatroom.Code+"/audit?"+params.Encode(), nil, &page)
+		pages = append(pages, page.actions())
+		if !page.HasMore {
+			break
+		}
+		params.Set("before_id", itoa(page.Events[len(page.Events)-1].ID))
+	}
+	want := [][]string{{"member.role", "member.mute"}, {"member.ban", "member.join"}, {"room.create"}}
+	if !reflect.DeepEqual(pages, want) {
+		t.Errorf("pages %v, want %v", pages, want)
+	}
+}
+
+func TestListAuditEventsOwnerOnly(t *testing.T) {
+	srv, chatroom, _, bob, carol := auditLog(t)
+
+	for _, user := range []*chattest.User{bob, carol} {
+		if status := srv.Do(t, user, http.MethodGet, "/api/room/"+chatroom.Code+"/audit", nil, nil); status != http.StatusForbidden {
+			t.Errorf("%s reading the audit log: %d, want 403", user.Username, status)
+		}
+	}
+}
+
+func TestResolvingReportsIsAudited(t *testing.T) {
+	tests := map[string][]string{
+		models.ReportActionDismiss:       {models.AuditReportResolve},
+		models.ReportActionDeleteMessage: {models.AuditMessageDelete, models.AuditReportResolve},
+		models.ReportActionMute:          {models.AuditMemberMute, models.AuditReportResolve},
+		models.ReportActionBan:           {models.AuditMemberBan, models.AuditReportResolve},
+	}
+	for action, want := range tests {
+		t.Run(action, func(t *testing.T) {
+			f := newReportFixture(t)
+			id := f.reportMessage(t)
+			var last models.AuditEvent
+			f.srv.DB.Last(&last)
+			if status := f.resolve(t, f.bob, id, url.Values{"action": {action}}); status != http.StatusOK {
+				t.Fatalf("resolve: %d", status)
+			}
+
+			var events []models.AuditEvent
+			f.srv.DB.Where("id > ?", last.ID).Order("id").Find(&events)
+			var got []string
+			for _, event := range events {
+				got = append(got, event.Action)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("audit events %v, want %v", got, want)
+			}
+			if resolved := events[len(events)-1]; resolved.TargetType != "report" || resolved.TargetID != id || resolved.TargetUserID != f.carol.ID {
+				t.Errorf("report.resolve event %+v", resolved)
+			}
+		})
+	}
+}
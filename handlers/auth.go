@@ -4,7 +4,9 @@ import (
 	"log"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"net/http"
+	"regexp"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
@@ -12,10 +14,12 @@ import (
 
 	"github.com/jeffasante/chatroom.go/middleware"
 	"github.com/jeffasante/chatroom.go/models"
+	"github.com/jeffasante/chatroom.go/storage"
 )
 
 type Handler struct {
-	db *gorm.DB
+	db    *gorm.DB
+	blobs storage.BlobStore
 }
 
 func New(db *gorm.DB) *Handler {
@@ -51,6 +55,13 @@ func (h *Handler) HandleLogin(c *gin.Context) {
 		return
 	}
 
+	// Bots have no usable password and authenticate with their API token
+	if user.IsBot {
+		log.Printf("Password login attempted for bot account: %s", emailOrUsername)
+		c.Redirect(http.StatusFound, "/login?error=invalid")
+		return
+	}
+
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
 		log.Printf("Invalid password for user: %s", emailOrUsername)
 		c.Redirect(http.StatusFound, "/login?error=invalid")
@@ -79,10 +90,18 @@ func (h *Handler) HandleSignup(c *gin.Context) {
 		c.Redirect(http.StatusFound, "/signup?error=missing")
 		return
 	}
+	if validateUsername(username) != nil {
+		c.Redirect(http.StatusFound, "/signup?error=username")
+		return
+	}
 
 	// Check if user exists
 	var existingUser models.User
-	if err := h.db.Where("email = ? OR username = ?", email, username).First(&existingUser).Error; err == nil {
+	if err := h.db.Where("email = ?", email).First(&existingUser).Error; err == nil {
+		c.Redirect(http.StatusFound, "/signup?error=exists")
+		return
+	}
+	if taken, err := usernameTaken(h.db, username, 0); err != nil || taken {
 		c.Redirect(http.StatusFound, "/signup?error=exists")
 		return
 	}
@@ -109,6 +128,27 @@ func (h *Handler) HandleSignup(c *gin.Context) {
 	c.Redirect(http.StatusFound, "/login?success=created")
 }
 
+var usernamePattern = regexp.MustCompile(`^[\p{L}\p{N}_.-]{2,32}$`)
+
+var errInvalidUsername = errors.New("usernames are 2 to 32 letters, digits, dots, dashes or underscores")
+
+// validateUsername checks a new username, whether chosen at signup, with
+// /nick or for a bot
+func validateUsername(name string) error {
+	if !usernamePattern.MatchString(name) {
+		return errInvalidUsername
+	}
+	return nil
+}
+
+// usernameTaken reports whether an account other than exceptID has the
+// name. Names differing only in case count as the same.
+func usernameTaken(db *gorm.DB, name string, exceptID uint) (bool, error) {
+	var count int64
+	err := db.Model(&models.User{}).Where("LOWER(username) = LOWER(?) AND id <> ?", name, exceptID).Count(&count).Error
+	return count > 0, err
+}
+
 func (h *Handler) HandleLogout(c *gin.Context) {
 	token, _ := c.Cookie("token")
 	middleware.DeleteSession(token)
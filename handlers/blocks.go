@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+var errBlockSelf = errors.New("you cannot block yourself")
+
+// blockEvent tells the hub that a user blocked or unblocked someone, so
+// their open connections start or stop filtering that user's messages
+type blockEvent struct {
+	userID    uint
+	blockedID uint
+	blocked   bool
+}
+
+// blockedUsers returns the set of users a user has blocked
+func blockedUsers(db *gorm.DB, userID uint) (map[uint]bool, error) {
+	var ids []uint
+	if err := db.Model(&models.UserBlock{}).Where("user_id = ?", userID).Pluck("blocked_id", &ids).Error; err != nil {
+		return nil, err
+	}
+	blocked := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		blocked[id] = true
+	}
+	return blocked, nil
+}
+
+// withoutBlocked narrows message queries to exclude users the viewer has
+// blocked. The result can be reused for several queries.
+func withoutBlocked(db *gorm.DB, viewerID uint) *gorm.DB {
+	blocked := db.Session(&gorm.Session{NewDB: true}).Model(&models.UserBlock{}).Select("blocked_id").Where("user_id = ?", viewerID)
+	return db.Where("messages.user_id NOT IN (?)", blocked).Session(&gorm.Session{})
+}
+
+// hides reports whether a frame shouldn't reach the client because it
+// comes from someone its user blocked. Must only be called from Run.
+func (c *Client) hides(message *Message) bool {
+	if message.UserID == 0 || !c.blocked[message.UserID] {
+		return false
+	}
+	switch message.Type {
+	case "message", "action", "typing_start", "typing_stop", "message_unfurled":
+		return true
+	}
+	return false
+}
+
+// applyBlock updates the block list of a user's open connections. Must only
+// be called from Run.
+func (h *Hub) applyBlock(ev *blockEvent) {
+	for client := range h.userConns[ev.userID] {
+		if ev.blocked {
+			if client.blocked == nil {
+				client.blocked = make(map[uint]bool)
+			}
+			client.blocked[ev.blockedID] = true
+		} else {
+			delete(client.blocked, ev.blockedID)
+		}
+	}
+}
+
+// blockUser hides target's messages from user. Blocking someone twice is
+// not an error.
+func (h *Hub) blockUser(user, target *models.User) error {
+	if user.ID == target.ID {
+		return errBlockSelf
+	}
+	block := models.UserBlock{UserID: user.ID, BlockedID: target.ID}
+	if err := h.db.Where(&block).FirstOrCreate(&block).Error; err != nil {
+		return err
+	}
+	h.blocks <- &blockEvent{userID: user.ID, blockedID: target.ID, blocked: true}
+	return nil
+}
+
+// unblockUser shows target's messages to user again
+func (h *Hub) unblockUser(user, target *models.User) (bool, error) {
+	result := h.db.Where("user_id = ? AND blocked_id = ?", user.ID, target.ID).Delete(&models.UserBlock{})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		h.blocks <- &blockEvent{userID: user.ID, blockedID: target.ID, blocked: false}
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// List the users the current user has blocked
+func (h *Handler) ListBlocks(c *gin.Context) {
+	user := h.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var blocks []models.UserBlock
+	if err := h.db.Where("user_id = ?", user.ID).Preload("Blocked").Order("id ASC").Find(&blocks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get blocked users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"blocks": blocks,
+		"count":  len(blocks),
+	})
+}
+
+// Block a user by username, hiding their messages from the current user
+func (h *Handler) BlockUser(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := h.GetCurrentUser(c)
+		if user == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		var target models.User
+		if err := h.db.Where("username = ?", c.PostForm("username")).First(&target).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+
+		err := hub.blockUser(user, &target)
+		if err == errBlockSelf {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to block user %d for user %d: %v", target.ID, user.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to block user"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"blocked": target,
+		})
+	}
+}
+
+// Unblock a user by username
+func (h *Handler) UnblockUser(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := h.GetCurrentUser(c)
+		if user == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		var target models.User
+		if err := h.db.Where("username = ?", c.Param("username")).First(&target).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+
+		unblocked, err := hub.unblockUser(user, &target)
+		if err != nil {
+			log.Printf("Failed to unblock user %d for user %d: %v", target.ID, user.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unblock user"})
+			return
+		}
+		if !unblocked {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user is not blocked"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true})
+	}
+}
+
+func cmdBlock(ctx *CommandContext) error {
+	fields := ctx.Fields()
+	if len(fields) != 1 {
+		return errors.New("usage: /block <username>")
+	}
+
+	var target models.User
+	if err := ctx.DB.Where("username = ?", fields[0]).First(&target).Error; err != nil {
+		return fmt.Errorf("no user named %s", fields[0])
+	}
+
+	err := ctx.Hub.blockUser(ctx.User, &target)
+	if err == errBlockSelf {
+		return err
+	}
+	if err != nil {
+		log.Printf("Failed to block user %d for user %d: %v", target.ID, ctx.User.ID, err)
+		return errors.New("failed to block user")
+	}
+	ctx.Reply("You will no longer see messages from %s. Reload to hide earlier ones.", target.Username)
+	return nil
+}
+
+func cmdUnblock(ctx *CommandContext) error {
+	fields := ctx.Fields()
+	if len(fields) != 1 {
+		return errors.New("usage: /unblock <username>")
+	}
+
+	var target models.User
+	if err := ctx.DB.Where("username = ?", fields[0]).First(&target).Error; err != nil {
+		return fmt.Errorf("no user named %s", fields[0])
+	}
+
+	unblocked, err := ctx.Hub.unblockUser(ctx.User, &target)
+	if err != nil {
+		log.Printf("Failed to unblock user %d for user %d: %v", target.ID, ctx.User.ID, err)
+		return errors.New("failed to unblock user")
+	}
+	if !unblocked {
+		return fmt.Errorf("%s is not blocked", target.Username)
+	}
+	ctx.Reply("You will see messages from %s again", target.Username)
+	return nil
+}
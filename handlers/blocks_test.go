@@ -0,0 +1,72 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/jeffasante/chatroom.go/handlers"
+	"github.com/jeffasante/chatroom.go/internal/chattest"
+)
+
+// block makes user block the named account
+func block(t *testing.T, srv *chattest.Server, user *chattest.User, username string) {
+	t.Helper()
+	if status := srv.Do(t, user, http.MethodPost, "/api/blocks", url.Values{"username": {username}}, nil); status != http.StatusOK && status != http.StatusCreated {
+		t.Fatalf("block %s: %d", username, status)
+	}
+}
+
+func TestSearchLeavesOutBlockedUsers(t *testing.T) {
+	srv := chattest.New(t)
+	alice := srv.SignUp(t, "alice")
+	bob := srv.SignUp(t, "bob")
+	code := srv.CreateRoom(t, alice, "general", "secret")
+	srv.JoinRoom(t, bob, code, "secret")
+
+	send(t, srv.Connect(t, alice, code), "lunch at noon?", "a-1")
+	send(t, srv.Connect(t, bob, code), "lunch sounds good", "b-1")
+	block(t, srv, bob, "alice")
+
+	for _, path := range []string{"/api/room/" + code + "/search?q=lunch", "/api/search?q=lunch"} {
+		var found struct{ Results []handlers.SearchResult }
+		if status := srv.Do(t, bob, http.MethodGet, path, nil, &found); status != http.StatusOK {
+			t.Fatalf("%s: %d", path, status)
+		}
+		if len(found.Results) != 1 || found.Results[0].Username != "bob" {
+			t.Errorf("%s found %+v, want only bob's message", path, found.Results)
+		}
+
+		if srv.Do(t, alice, http.MethodGet, path, nil, &found); len(found.Results) != 2 {
+			t.Errorf("%s found %d messages for alice, want 2", path, len(found.Results))
+		}
+	}
+}
+
+func TestBlockedAuthorsPreviewsHidden(t *testing.T) {
+	page := previewPage(t)
+	srv := unfurlingServer(t)
+	alice := srv.SignUp(t, "alice")
+	bob := srv.SignUp(t, "bob")
+	code := srv.CreateRoom(t, alice, "general", "secret")
+	srv.JoinRoom(t, bob, code, "secret")
+	block(t, srv, bob, "alice")
+
+	aliceConn := srv.Connect(t, alice, code)
+	bobConn := srv.Connect(t, bob, code)
+	send(t, aliceConn, "look "+page.URL+"/post", "a-1")
+	aliceConn.Await(chattest.OfType("message_unfurled"))
+
+	// Frames reach bob in the order the hub sent them, so the preview would
+	// have arrived before the ack for anything bob sends now
+	bobConn.Send(map[string]string{"type": "message", "content": "hi", "nonce": "b-1"})
+	for {
+		frame := bobConn.Next()
+		if frame.Type == "message_unfurled" || frame.Type == "message" && frame.UserID == alice.ID {
+			t.Fatalf("bob received %s from alice", frame.Type)
+		}
+		if frame.Type == "ack" && frame.Nonce == "b-1" {
+			break
+		}
+	}
+}
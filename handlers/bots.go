@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/jeffasante/chatroom.go/middleware"
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+// Create a bot account owned by the current user. The API token is only
+// returned here and cannot be recovered later, only regenerated.
+func (h *Handler) CreateBot(c *gin.Context) {
+	user := h.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	if user.IsBot {
+		c.JSON(http.StatusForbidden, gin.H{"error": "bots cannot create bots"})
+		return
+	}
+
+	name := c.PostForm("name")
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bot name is required"})
+		return
+	}
+
+	if err := validateUsername(name); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	taken, err := usernameTaken(h.db, name, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server error"})
+		return
+	}
+	if taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "username already taken"})
+		return
+	}
+
+	// Bots never log in with a password, so store a hash of random bytes
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(h.generateToken()), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server error"})
+		return
+	}
+
+	apiToken := h.generateAPIToken()
+	bot := models.User{
+		Username:     name,
+		Email:        name + "@bots.invalid",
+		Password:     string(hashedPassword),
+		IsBot:        true,
+		BotOwnerID:   user.ID,
+		APITokenHash: middleware.HashAPIToken(apiToken),
+	}
+
+	if err := h.db.Create(&bot).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create bot"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"bot":     bot,
+		"token":   apiToken,
+	})
+}
+
+// List the bots owned by the current user
+func (h *Handler) ListBots(c *gin.Context) {
+	user := h.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var bots []models.User
+	if err := h.db.Where("is_bot = ? AND bot_owner_id = ?", true, user.ID).Order("id ASC").Find(&bots).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get bots"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"bots":  bots,
+		"count": len(bots),
+	})
+}
+
+// Replace a bot's API token, invalidating the old one
+func (h *Handler) RegenerateBotToken(c *gin.Context) {
+	user := h.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	botID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid bot id"})
+		return
+	}
+
+	var bot models.User
+	if err := h.db.Where("id = ? AND is_bot = ?", botID, true).First(&bot).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "bot not found"})
+		return
+	}
+
+	if bot.BotOwnerID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the bot owner can regenerate its token"})
+		return
+	}
+
+	apiToken := h.generateAPIToken()
+	if err := h.db.Model(&bot).Update("api_token_hash", middleware.HashAPIToken(apiToken)).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to regenerate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"token":   apiToken,
+	})
+}
+
+func (h *Handler) generateAPIToken() string {
+	return "bot_" + h.generateToken() + h.generateToken()
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"math/rand"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -23,9 +24,15 @@ func (h *Handler) ShowDashboard(c *gin.Context) {
 		chatrooms = []models.Chatroom{}
 	}
 
+	unread, err := user.UnreadCounts(h.db)
+	if err != nil {
+		unread = map[uint]int64{}
+	}
+
 	c.HTML(http.StatusOK, "dashboard.html", gin.H{
 		"user":      user,
 		"chatrooms": chatrooms,
+		"unread":    unread,
 	})
 }
 
@@ -78,10 +85,18 @@ func (h *Handler) CreateRoom(c *gin.Context) {
 	membership := models.Membership{
 		UserID:     user.ID,
 		ChatroomID: chatroom.ID,
+		Role:       models.RoleOwner,
 		JoinedAt:   time.Now(),
 	}
 	h.db.Create(&membership)
 
+	audit(h.db, auditEntry{
+		chatroomID: chatroom.ID,
+		actorID:    user.ID,
+		action:     models.AuditRoomCreate,
+		after:      roomSnapshot(&chatroom),
+	})
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"code":    code,
@@ -110,6 +125,11 @@ func (h *Handler) JoinRoom(c *gin.Context) {
 		return
 	}
 
+	if isBanned(h.db, chatroom.ID, user.ID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "you are banned from this room"})
+		return
+	}
+
 	// Check if already a member
 	if chatroom.IsMember(h.db, user.ID) {
 		c.JSON(http.StatusOK, gin.H{
@@ -124,6 +144,7 @@ func (h *Handler) JoinRoom(c *gin.Context) {
 	membership := models.Membership{
 		UserID:     user.ID,
 		ChatroomID: chatroom.ID,
+		Role:       models.RoleMember,
 		JoinedAt:   time.Now(),
 	}
 
@@ -132,6 +153,14 @@ func (h *Handler) JoinRoom(c *gin.Context) {
 		return
 	}
 
+	audit(h.db, auditEntry{
+		chatroomID:   chatroom.ID,
+		actorID:      user.ID,
+		action:       models.AuditMemberJoin,
+		targetUserID: user.ID,
+		after:        gin.H{"role": membership.Role},
+	})
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"code":    code,
@@ -162,16 +191,50 @@ func (h *Handler) ShowRoom(c *gin.Context) {
 		return
 	}
 
-	// Get recent messages - Fixed: added h.db parameter
-	messages, err := chatroom.GetMessages(h.db, 50)
-	if err != nil {
-		messages = []models.Message{}
+	// Search results link here with ?around=ID to show a message in context,
+	// leaving out messages from users the viewer blocked
+	db := withoutBlocked(h.db, user.ID)
+	var messages []models.Message
+	var hasMore, hasNewer bool
+	focusID, _ := strconv.ParseUint(c.Query("around"), 10, 64)
+	if focusID != 0 {
+		var err error
+		messages, hasMore, hasNewer, err = messagesAround(db, chatroom.ID, uint(focusID), roomHistorySize)
+		if err != nil {
+			focusID = 0
+		}
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
 	}
 
+	if focusID == 0 {
+		// Get the newest messages; the extra one tells us if older history exists
+		var err error
+		messages, err = chatroom.GetMessages(db, roomHistorySize+1)
+		if err != nil {
+			messages = []models.Message{}
+		}
+		hasMore = len(messages) > roomHistorySize
+		if hasMore {
+			messages = messages[1:]
+		}
+	}
+	attachPreviews(h.db, messages)
+
 	c.HTML(http.StatusOK, "room.html", gin.H{
 		"user":     user,
 		"chatroom": chatroom,
 		"messages": messages,
+		"hasMore":  hasMore,
+		"hasNewer": hasNewer,
+		"focusID":  focusID,
+
+		// Long text is posted as an attachment when enabled, so the input
+		// needs no limit then
+		"maxLength": maxInputLength(&chatroom),
+
+		"isModerator": chatroom.HasRole(h.db, user.ID, models.RoleModerator),
 	})
 }
 
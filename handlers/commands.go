@@ -0,0 +1,535 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+// CommandHandler runs a slash command. A returned error is shown only to the caller.
+type CommandHandler func(ctx *CommandContext) error
+
+// Command describes a slash command such as /topic
+type Command struct {
+	Name        string
+	Usage       string
+	Description string
+	MinRole     string // least privileged room role allowed to run it
+	Handler     CommandHandler
+}
+
+// CommandContext is passed to a CommandHandler for a single invocation
+type CommandContext struct {
+	Hub      *Hub
+	DB       *gorm.DB
+	User     *models.User
+	Chatroom *models.Chatroom
+	Role     string
+	Name     string
+	Args     string
+
+	client *Client
+}
+
+// Fields splits the command arguments on whitespace
+func (ctx *CommandContext) Fields() []string {
+	return strings.Fields(ctx.Args)
+}
+
+// Reply sends a private message that only the caller's connection sees
+func (ctx *CommandContext) Reply(format string, args ...interface{}) {
+	ctx.Hub.sendDirect(ctx.client, &Message{
+		Type:       "command_response",
+		Content:    fmt.Sprintf(format, args...),
+		Username:   "System",
+		ChatroomID: ctx.Chatroom.ID,
+		Timestamp:  time.Now(),
+	})
+}
+
+// Broadcast sends a message to everyone in the caller's room
+func (ctx *CommandContext) Broadcast(message *Message) {
+	message.ChatroomID = ctx.Chatroom.ID
+	if message.Timestamp.IsZero() {
+		message.Timestamp = time.Now()
+	}
+	ctx.Hub.broadcast <- message
+}
+
+// audit records an administrative command in the room's audit log
+func (ctx *CommandContext) audit(entry auditEntry) {
+	entry.chatroomID = ctx.Chatroom.ID
+	entry.actorID = ctx.User.ID
+	audit(ctx.DB, entry)
+}
+
+// RegisterCommand adds a slash command, replacing any existing command with the same name
+func (h *Hub) RegisterCommand(cmd Command) {
+	if cmd.MinRole == "" {
+		cmd.MinRole = models.RoleMember
+	}
+	h.commandsMu.Lock()
+	defer h.commandsMu.Unlock()
+	h.commands[strings.ToLower(cmd.Name)] = &cmd
+}
+
+func (h *Hub) lookupCommand(name string) (*Command, bool) {
+	h.commandsMu.RLock()
+	defer h.commandsMu.RUnlock()
+	cmd, ok := h.commands[strings.ToLower(name)]
+	return cmd, ok
+}
+
+// Commands returns the registered commands sorted by name
+func (h *Hub) Commands() []Command {
+	h.commandsMu.RLock()
+	defer h.commandsMu.RUnlock()
+
+	commands := make([]Command, 0, len(h.commands))
+	for _, cmd := range h.commands {
+		commands = append(commands, *cmd)
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Name < commands[j].Name })
+	return commands
+}
+
+// runCommand parses and executes a "/name args" line sent by a client.
+// It runs on the client's read goroutine so slow commands never block the hub.
+func (h *Hub) runCommand(c *Client, line string) {
+	name, args, _ := strings.Cut(strings.TrimPrefix(line, "/"), " ")
+
+	var chatroom models.Chatroom
+	if err := h.db.First(&chatroom, c.chatroomID).Error; err != nil {
+		log.Printf("Command /%s: chatroom %d not found: %v", name, c.chatroomID, err)
+		return
+	}
+
+	// Commands get their own copy of the user, with the name as it is now
+	user := *c.user
+	user.Username = c.username()
+
+	ctx := &CommandContext{
+		Hub:      h,
+		DB:       h.db,
+		User:     &user,
+		Chatroom: &chatroom,
+		Role:     chatroom.RoleOf(h.db, c.user.ID),
+		Name:     name,
+		Args:     strings.TrimSpace(args),
+		client:   c,
+	}
+
+	cmd, ok := h.lookupCommand(name)
+	if !ok {
+		ctx.Reply("Unknown command /%s. Type /help for a list of commands.", name)
+		return
+	}
+
+	if models.RoleRank(ctx.Role) < models.RoleRank(cmd.MinRole) {
+		ctx.Reply("/%s requires the %s role", cmd.Name, cmd.MinRole)
+		return
+	}
+
+	if err := cmd.Handler(ctx); err != nil {
+		ctx.Reply("/%s: %v", cmd.Name, err)
+	}
+}
+
+func (h *Hub) registerBuiltinCommands() {
+	h.RegisterCommand(Command{
+		Name:        "help",
+		Usage:       "/help",
+		Description: "list available commands",
+		Handler:     cmdHelp,
+	})
+	h.RegisterCommand(Command{
+		Name:        "me",
+		Usage:       "/me <action>",
+		Description: "send an action, e.g. /me waves",
+		Handler:     cmdMe,
+	})
+	h.RegisterCommand(Command{
+		Name:        "topic",
+		Usage:       "/topic [new topic]",
+		Description: "show the room topic, or set it (moderators)",
+		Handler:     cmdTopic,
+	})
+	h.RegisterCommand(Command{
+		Name:        "nick",
+		Usage:       "/nick <username>",
+		Description: "change your username",
+		Handler:     cmdNick,
+	})
+	h.RegisterCommand(Command{
+		Name:        "block",
+		Usage:       "/block <username>",
+		Description: "hide someone's messages from you",
+		Handler:     cmdBlock,
+	})
+	h.RegisterCommand(Command{
+		Name:        "unblock",
+		Usage:       "/unblock <username>",
+		Description: "see someone's messages again",
+		Handler:     cmdUnblock,
+	})
+	h.RegisterCommand(Command{
+		Name:        "kick",
+		Usage:       "/kick <username>",
+		Description: "remove a member from the room",
+		MinRole:     models.RoleModerator,
+		Handler:     cmdKick,
+	})
+	h.RegisterCommand(Command{
+		Name:        "ban",
+		Usage:       "/ban <username> [reason]",
+		Description: "remove a member from the room and stop them rejoining",
+		MinRole:     models.RoleModerator,
+		Handler:     cmdBan,
+	})
+	h.RegisterCommand(Command{
+		Name:        "unban",
+		Usage:       "/unban <username>",
+		Description: "let a banned user join the room again",
+		MinRole:     models.RoleModerator,
+		Handler:     cmdUnban,
+	})
+	h.RegisterCommand(Command{
+		Name:        "mute",
+		Usage:       "/mute <username> [minutes|off]",
+		Description: "stop a member posting, for an hour by default",
+		MinRole:     models.RoleModerator,
+		Handler:     cmdMute,
+	})
+	h.RegisterCommand(Command{
+		Name:        "slowmode",
+		Usage:       "/slowmode [seconds|off]",
+		Description: "show slow mode, or let members post only once every so many seconds",
+		MinRole:     models.RoleModerator,
+		Handler:     cmdSlowMode,
+	})
+	h.RegisterCommand(Command{
+		Name:        "role",
+		Usage:       "/role <username> <member|moderator>",
+		Description: "change a member's role",
+		MinRole:     models.RoleOwner,
+		Handler:     cmdRole,
+	})
+}
+
+func cmdHelp(ctx *CommandContext) error {
+	var lines []string
+	for _, cmd := range ctx.Hub.Commands() {
+		if models.RoleRank(ctx.Role) < models.RoleRank(cmd.MinRole) {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s - %s", cmd.Usage, cmd.Description))
+	}
+	ctx.Reply("%s", strings.Join(lines, "\n"))
+	return nil
+}
+
+func cmdMe(ctx *CommandContext) error {
+	if ctx.Args == "" {
+		return errors.New("usage: /me <action>")
+	}
+	// Actions are chat messages, so they go through moderation
+	ctx.Hub.submit(&Message{
+		Type:       "action",
+		Content:    ctx.Args,
+		UserID:     ctx.User.ID,
+		Username:   ctx.User.Username,
+		ChatroomID: ctx.Chatroom.ID,
+		Timestamp:  time.Now(),
+		IsBot:      ctx.User.IsBot,
+		sender:     ctx.client,
+	})
+	return nil
+}
+
+func cmdTopic(ctx *CommandContext) error {
+	if ctx.Args == "" {
+		if ctx.Chatroom.Topic == "" {
+			ctx.Reply("No topic is set")
+		} else {
+			ctx.Reply("Topic: %s", ctx.Chatroom.Topic)
+		}
+		return nil
+	}
+
+	if models.RoleRank(ctx.Role) < models.RoleRank(models.RoleModerator) {
+		return errors.New("only moderators can change the topic")
+	}
+
+	before := ctx.Chatroom.Topic
+	if err := ctx.DB.Model(ctx.Chatroom).Update("topic", ctx.Args).Error; err != nil {
+		log.Printf("Failed to update topic: %v", err)
+		return errors.New("failed to update topic")
+	}
+	ctx.audit(auditEntry{
+		action: models.AuditRoomTopic,
+		before: gin.H{"topic": before},
+		after:  gin.H{"topic": ctx.Args},
+	})
+
+	ctx.Broadcast(&Message{
+		Type:     "topic_changed",
+		Content:  ctx.Args,
+		UserID:   ctx.User.ID,
+		Username: ctx.User.Username,
+	})
+	return nil
+}
+
+func cmdNick(ctx *CommandContext) error {
+	fields := ctx.Fields()
+	if len(fields) != 1 {
+		return errors.New("usage: /nick <username>")
+	}
+	newName := fields[0]
+	oldName := ctx.User.Username
+	if newName == oldName {
+		return nil
+	}
+	if err := validateUsername(newName); err != nil {
+		return err
+	}
+
+	taken, err := usernameTaken(ctx.DB, newName, ctx.User.ID)
+	if err != nil {
+		log.Printf("Failed to check username %s: %v", newName, err)
+		return errors.New("failed to change username")
+	}
+	if taken {
+		return fmt.Errorf("username %s is already taken", newName)
+	}
+
+	if err := ctx.DB.Model(&models.User{}).Where("id = ?", ctx.User.ID).Update("username", newName).Error; err != nil {
+		log.Printf("Failed to rename user %d: %v", ctx.User.ID, err)
+		return errors.New("failed to change username")
+	}
+
+	// This connection's next message must carry the new name; the hub
+	// updates the user's other connections
+	ctx.client.setUsername(newName)
+	ctx.Hub.renames <- &renameEvent{userID: ctx.User.ID, username: newName}
+
+	ctx.Broadcast(&Message{
+		Type:     "user_renamed",
+		Content:  oldName + " is now known as " + newName,
+		Username: "System",
+	})
+	return nil
+}
+
+type renameEvent struct {
+	userID   uint
+	username string
+}
+
+// applyRename updates the name shown for every connection of a user.
+// Must only be called from Run.
+func (h *Hub) applyRename(ev *renameEvent) {
+	for client := range h.userConns[ev.userID] {
+		client.setUsername(ev.username)
+	}
+	if _, ok := h.usernames[ev.userID]; ok {
+		h.usernames[ev.userID] = ev.username
+	}
+}
+
+func cmdKick(ctx *CommandContext) error {
+	return removeWithCommand(ctx, false)
+}
+
+func cmdBan(ctx *CommandContext) error {
+	return removeWithCommand(ctx, true)
+}
+
+func removeWithCommand(ctx *CommandContext, ban bool) error {
+	username, reason, _ := strings.Cut(ctx.Args, " ")
+	target, _, err := findMember(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	verb := "kick"
+	if ban {
+		verb = "ban"
+	}
+	if !outranks(ctx.DB, ctx.Chatroom, ctx.User.ID, target.ID) {
+		return fmt.Errorf("you cannot %s %s", verb, target.Username)
+	}
+
+	if err := ctx.Hub.removeMember(ctx.Chatroom, target, ctx.User, ban, strings.TrimSpace(reason)); err != nil {
+		log.Printf("Failed to %s user %d from chatroom %d: %v", verb, target.ID, ctx.Chatroom.ID, err)
+		return fmt.Errorf("failed to %s member", verb)
+	}
+	return nil
+}
+
+func cmdUnban(ctx *CommandContext) error {
+	fields := ctx.Fields()
+	if len(fields) != 1 {
+		return errors.New("usage: /unban <username>")
+	}
+
+	var target models.User
+	if err := ctx.DB.Where("username = ?", fields[0]).First(&target).Error; err != nil {
+		return fmt.Errorf("no user named %s", fields[0])
+	}
+
+	unbanned, err := ctx.Hub.unbanUser(ctx.Chatroom, &target, ctx.User)
+	if err != nil {
+		log.Printf("Failed to unban user %d from chatroom %d: %v", target.ID, ctx.Chatroom.ID, err)
+		return errors.New("failed to unban user")
+	}
+	if !unbanned {
+		return fmt.Errorf("%s is not banned from this room", target.Username)
+	}
+	ctx.Reply("%s can join the room again", target.Username)
+	return nil
+}
+
+func cmdMute(ctx *CommandContext) error {
+	fields := ctx.Fields()
+	if len(fields) < 1 || len(fields) > 2 {
+		return errors.New("usage: /mute <username> [minutes|off]")
+	}
+
+	target, _, err := findMember(ctx, fields[0])
+	if err != nil {
+		return err
+	}
+	if !outranks(ctx.DB, ctx.Chatroom, ctx.User.ID, target.ID) {
+		return fmt.Errorf("you cannot mute %s", target.Username)
+	}
+
+	var until *time.Time
+	if len(fields) == 1 || fields[1] != "off" {
+		duration := time.Hour
+		if len(fields) == 2 {
+			minutes, err := strconv.Atoi(fields[1])
+			if err != nil || minutes <= 0 || time.Duration(minutes)*time.Minute > maxMute {
+				return fmt.Errorf("usage: /mute <username> [1-%d minutes|off]", int(maxMute/time.Minute))
+			}
+			duration = time.Duration(minutes) * time.Minute
+		}
+		t := time.Now().Add(duration)
+		until = &t
+	}
+
+	if err := ctx.Hub.muteMember(ctx.Chatroom, target, ctx.User, until); err != nil {
+		log.Printf("Failed to mute user %d in chatroom %d: %v", target.ID, ctx.Chatroom.ID, err)
+		return errors.New("failed to mute member")
+	}
+	return nil
+}
+
+func cmdRole(ctx *CommandContext) error {
+	fields := ctx.Fields()
+	if len(fields) != 2 {
+		return errors.New("usage: /role <username> <member|moderator>")
+	}
+
+	role := strings.ToLower(fields[1])
+	if role != models.RoleMember && role != models.RoleModerator {
+		return errors.New("role must be member or moderator")
+	}
+
+	target, membership, err := findMember(ctx, fields[0])
+	if err != nil {
+		return err
+	}
+	if target.ID == ctx.Chatroom.OwnerID {
+		return errors.New("the owner's role cannot be changed")
+	}
+
+	before := membership.Role
+	if err := ctx.DB.Model(membership).Update("role", role).Error; err != nil {
+		log.Printf("Failed to update role for membership %d: %v", membership.ID, err)
+		return errors.New("failed to change role")
+	}
+	ctx.audit(auditEntry{
+		action:       models.AuditMemberRole,
+		targetUserID: target.ID,
+		before:       gin.H{"role": before},
+		after:        gin.H{"role": role},
+	})
+
+	ctx.Broadcast(&Message{
+		Type:     "role_changed",
+		Content:  target.Username + " is now a " + role,
+		Username: "System",
+	})
+	return nil
+}
+
+func cmdSlowMode(ctx *CommandContext) error {
+	if ctx.Args == "" {
+		if ctx.Chatroom.SlowModeSeconds == 0 {
+			ctx.Reply("Slow mode is off")
+		} else {
+			ctx.Reply("Slow mode is on: one message every %d seconds", ctx.Chatroom.SlowModeSeconds)
+		}
+		return nil
+	}
+
+	seconds := 0
+	if ctx.Args != "off" {
+		n, err := strconv.Atoi(ctx.Args)
+		if err != nil || n < 0 || n > int(maxSlowMode/time.Second) {
+			return fmt.Errorf("usage: /slowmode <0-%d seconds|off>", int(maxSlowMode/time.Second))
+		}
+		seconds = n
+	}
+
+	before := ctx.Chatroom.SlowModeSeconds
+	if err := ctx.DB.Model(ctx.Chatroom).Update("slow_mode_seconds", seconds).Error; err != nil {
+		log.Printf("Failed to update slow mode: %v", err)
+		return errors.New("failed to change slow mode")
+	}
+	ctx.audit(auditEntry{
+		action: models.AuditRoomSlowMode,
+		before: gin.H{"slow_mode_seconds": before},
+		after:  gin.H{"slow_mode_seconds": seconds},
+	})
+
+	content := fmt.Sprintf("%s turned on slow mode: one message every %d seconds", ctx.User.Username, seconds)
+	if seconds == 0 {
+		content = ctx.User.Username + " turned off slow mode"
+	}
+	ctx.Broadcast(&Message{
+		Type:     "slow_mode_changed",
+		Content:  content,
+		Username: "System",
+	})
+	return nil
+}
+
+// findMember looks up a room member by username
+func findMember(ctx *CommandContext, username string) (*models.User, *models.Membership, error) {
+	if username == "" || strings.ContainsAny(username, " \t") {
+		return nil, nil, errors.New("a single username is required")
+	}
+
+	var user models.User
+	if err := ctx.DB.Where("username = ?", username).First(&user).Error; err != nil {
+		return nil, nil, fmt.Errorf("no user named %s", username)
+	}
+
+	var membership models.Membership
+	if err := ctx.DB.Where("user_id = ? AND chatroom_id = ?", user.ID, ctx.Chatroom.ID).First(&membership).Error; err != nil {
+		return nil, nil, fmt.Errorf("%s is not a member of this room", username)
+	}
+
+	return &user, &membership, nil
+}
@@ -0,0 +1,212 @@
+package handlers_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jeffasante/chatroom.go/internal/chattest"
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+// command runs a slash command and returns the next frame of the given type
+func command(t *testing.T, conn *chattest.Conn, line, frameType string) chattest.Frame {
+	t.Helper()
+	conn.Send(map[string]string{"type": "message", "content": line})
+	return conn.Await(chattest.OfType(frameType))
+}
+
+// reply runs a slash command and returns the private response
+func reply(t *testing.T, conn *chattest.Conn, line string) string {
+	t.Helper()
+	return command(t, conn, line, "command_response").Content
+}
+
+// memberRoom creates a room owned by alice that bob has joined, with both
+// connected
+func memberRoom(t *testing.T) (srv *chattest.Server, code string, alice, bob *chattest.User, aliceConn, bobConn *chattest.Conn) {
+	t.Helper()
+	srv = chattest.New(t)
+	alice = srv.SignUp(t, "alice")
+	bob = srv.SignUp(t, "bob")
+	code = srv.CreateRoom(t, alice, "general", "secret")
+	srv.JoinRoom(t, bob, code, "secret")
+	return srv, code, alice, bob, srv.Connect(t, alice, code), srv.Connect(t, bob, code)
+}
+
+func TestUnknownCommand(t *testing.T) {
+	_, _, _, _, aliceConn, _ := memberRoom(t)
+
+	want := "Unknown command /frobnicate. Type /help for a list of commands."
+	if got := reply(t, aliceConn, "/frobnicate now"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCommandRoles(t *testing.T) {
+	_, _, _, _, aliceConn, bobConn := memberRoom(t)
+
+	for line, want := range map[string]string{
+		"/kick alice":        "/kick requires the moderator role",
+		"/role alice member": "/role requires the owner role",
+		"/topic Lunch plans": "/topic: only moderators can change the topic",
+	} {
+		if got := reply(t, bobConn, line); got != want {
+			t.Errorf("bob %s: got %q, want %q", line, got, want)
+		}
+	}
+
+	// /help only lists what the caller may run
+	help := reply(t, bobConn, "/help")
+	if !strings.Contains(help, "/nick <username>") || strings.Contains(help, "/kick") || strings.Contains(help, "/role") {
+		t.Errorf("member help:\n%s", help)
+	}
+	if help := reply(t, aliceConn, "/help"); !strings.Contains(help, "/kick <username>") || !strings.Contains(help, "/role <username>") {
+		t.Errorf("owner help:\n%s", help)
+	}
+}
+
+func TestRoleCommand(t *testing.T) {
+	srv, code, alice, bob, aliceConn, bobConn := memberRoom(t)
+
+	if frame := command(t, aliceConn, "/role bob moderator", "role_changed"); frame.Content != "bob is now a moderator" {
+		t.Errorf("role_changed %q", frame.Content)
+	}
+	var chatroom models.Chatroom
+	srv.DB.Where("code = ?", code).First(&chatroom)
+	if role := chatroom.RoleOf(srv.DB, bob.ID); role != models.RoleModerator {
+		t.Fatalf("bob's role is %q", role)
+	}
+
+	// A moderator can now use moderator commands, but not against the owner
+	if got := reply(t, bobConn, "/kick alice"); got != "/kick: you cannot kick alice" {
+		t.Errorf("moderator kicking the owner: %q", got)
+	}
+	for line, want := range map[string]string{
+		"/role alice member": "/role: the owner's role cannot be changed",
+		"/role bob owner":    "/role: role must be member or moderator",
+		"/role carol member": "/role: no user named carol",
+	} {
+		if got := reply(t, aliceConn, line); got != want {
+			t.Errorf("alice %s: got %q, want %q", line, got, want)
+		}
+	}
+
+	command(t, aliceConn, "/role bob member", "role_changed")
+	if role := chatroom.RoleOf(srv.DB, bob.ID); role != models.RoleMember {
+		t.Errorf("bob's role is %q after demotion", role)
+	}
+	if role := chatroom.RoleOf(srv.DB, alice.ID); role != models.RoleOwner {
+		t.Errorf("alice's role is %q", role)
+	}
+}
+
+func TestNickCommand(t *testing.T) {
+	srv, _, alice, _, aliceConn, bobConn := memberRoom(t)
+
+	for line, want := range map[string]string{
+		"/nick BOB":      "/nick: username BOB is already taken",
+		"/nick bad<name": "/nick: usernames are 2 to 32 letters, digits, dots, dashes or underscores",
+	} {
+		if got := reply(t, aliceConn, line); got != want {
+			t.Errorf("%s: got %q, want %q", line, got, want)
+		}
+	}
+
+	frame := command(t, aliceConn, "/nick Alicia", "user_renamed")
+	if frame.Content != "alice is now known as Alicia" {
+		t.Errorf("user_renamed %q", frame.Content)
+	}
+	var user models.User
+	srv.DB.First(&user, alice.ID)
+	if user.Username != "Alicia" {
+		t.Errorf("stored username %q", user.Username)
+	}
+
+	// Messages sent afterwards carry the new name
+	aliceConn.Send(map[string]string{"type": "message", "content": "hello"})
+	isHello := func(f chattest.Frame) bool { return f.Type == "message" && f.Content == "hello" }
+	if frame := bobConn.Await(isHello); frame.Username != "Alicia" {
+		t.Errorf("message sent as %q", frame.Username)
+	}
+
+	// Changing only the case of your own name is allowed
+	if frame := command(t, aliceConn, "/nick alicia", "user_renamed"); frame.Content != "Alicia is now known as alicia" {
+		t.Errorf("user_renamed %q", frame.Content)
+	}
+}
+
+func TestKickCommand(t *testing.T) {
+	srv, code, _, bob, aliceConn, bobConn := memberRoom(t)
+
+	if got := reply(t, aliceConn, "/kick carol"); got != "/kick: no user named carol" {
+		t.Errorf("kicking a stranger: %q", got)
+	}
+
+	if frame := command(t, aliceConn, "/kick bob", "user_kicked"); frame.Content != "bob was kicked by alice" {
+		t.Errorf("user_kicked %q", frame.Content)
+	}
+	if frame := bobConn.Await(chattest.OfType("disconnected")); frame.Content != "You were kicked from the room by alice" {
+		t.Errorf("bob was told %q", frame.Content)
+	}
+
+	var chatroom models.Chatroom
+	srv.DB.Where("code = ?", code).First(&chatroom)
+	if chatroom.IsMember(srv.DB, bob.ID) {
+		t.Error("bob is still a member")
+	}
+	// A kick doesn't stop bob joining again
+	srv.JoinRoom(t, bob, code, "secret")
+}
+
+func TestTopicCommand(t *testing.T) {
+	srv, code, _, _, aliceConn, bobConn := memberRoom(t)
+
+	if got := reply(t, bobConn, "/topic"); got != "No topic is set" {
+		t.Errorf("empty topic: %q", got)
+	}
+
+	frame := command(t, aliceConn, "/topic Lunch plans", "topic_changed")
+	if frame.Content != "Lunch plans" || frame.Username != "alice" {
+		t.Errorf("topic_changed %+v", frame)
+	}
+	if frame := bobConn.Await(chattest.OfType("topic_changed")); frame.Content != "Lunch plans" {
+		t.Errorf("bob saw topic %q", frame.Content)
+	}
+
+	var chatroom models.Chatroom
+	srv.DB.Where("code = ?", code).First(&chatroom)
+	if chatroom.Topic != "Lunch plans" {
+		t.Errorf("stored topic %q", chatroom.Topic)
+	}
+	if got := reply(t, bobConn, "/topic"); got != "Topic: Lunch plans" {
+		t.Errorf("topic: %q", got)
+	}
+}
+
+func TestMuteCommand(t *testing.T) {
+	_, _, _, _, aliceConn, bobConn := memberRoom(t)
+
+	for line, want := range map[string]string{
+		"/mute":       "/mute: usage: /mute <username> [minutes|off]",
+		"/mute bob 0": "/mute: usage: /mute <username> [1-43200 minutes|off]",
+		"/mute alice": "/mute: you cannot mute alice",
+	} {
+		if got := reply(t, aliceConn, line); got != want {
+			t.Errorf("alice %s: got %q, want %q", line, got, want)
+		}
+	}
+
+	if frame := command(t, aliceConn, "/mute bob 5", "user_muted"); !strings.HasPrefix(frame.Content, "bob was muted by alice until ") {
+		t.Errorf("user_muted %q", frame.Content)
+	}
+	bobConn.Send(map[string]string{"type": "message", "content": "hello", "nonce": "muted"})
+	frame := bobConn.Await(func(f chattest.Frame) bool { return f.Nonce == "muted" })
+	if frame.Type != "error" || frame.Error != "muted" {
+		t.Errorf("message while muted got %s %q", frame.Type, frame.Error)
+	}
+
+	if frame := command(t, aliceConn, "/mute bob off", "user_muted"); frame.Content != "bob was unmuted by alice" {
+		t.Errorf("user_muted %q", frame.Content)
+	}
+	send(t, bobConn, "hello again", "unmuted")
+}
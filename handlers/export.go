@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jeffasante/chatroom.go/export"
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+// Download a room's whole history as json, txt, html or csv (moderators
+// only). The file is streamed as it is read from the database.
+func (h *Handler) ExportRoom(c *gin.Context) {
+	user := h.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var chatroom models.Chatroom
+	if err := h.db.Where("code = ?", c.Param("code")).First(&chatroom).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+		return
+	}
+	if !chatroom.HasRole(h.db, user.ID, models.RoleModerator) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only moderators can export this room"})
+		return
+	}
+
+	format := c.DefaultQuery("format", export.JSON)
+	contentType := export.ContentType(format)
+	if contentType == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": export.ErrUnknownFormat.Error()})
+		return
+	}
+
+	audit(h.db, auditEntry{
+		chatroomID: chatroom.ID,
+		actorID:    user.ID,
+		action:     models.AuditRoomExport,
+		after:      gin.H{"format": format},
+	})
+
+	filename := chatroom.Code + "-" + time.Now().UTC().Format("20060102") + "." + format
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Status(http.StatusOK)
+
+	// Headers are already sent, so a failure part way can only be logged
+	if err := export.Write(c.Writer, h.db, &chatroom, format); err != nil {
+		log.Printf("Failed to export chatroom %d: %v", chatroom.ID, err)
+	}
+}
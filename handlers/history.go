@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+// roomHistorySize is how many messages the room page shows before the
+// client scrolls up for more
+const roomHistorySize = 50
+
+// olderMessages returns up to limit messages before beforeSeq, newest first,
+// and whether even older ones exist. A beforeSeq of 0 starts from the
+// newest. Pages follow seq rather than id, since imported messages are
+// numbered in the order they were sent, not the order they were saved.
+func olderMessages(db *gorm.DB, chatroomID uint, beforeSeq uint64, limit int) ([]models.Message, bool, error) {
+	query := db.Where("chatroom_id = ?", chatroomID)
+	if beforeSeq != 0 {
+		query = query.Where("seq < ?", beforeSeq)
+	}
+
+	var messages []models.Message
+	if err := query.Preload("User").Preload("Attachments").Order("seq DESC").Limit(limit + 1).Find(&messages).Error; err != nil {
+		return nil, false, err
+	}
+	if len(messages) > limit {
+		return messages[:limit], true, nil
+	}
+	return messages, false, nil
+}
+
+// newerMessages returns up to limit messages after afterSeq, oldest first,
+// and whether more follow
+func newerMessages(db *gorm.DB, chatroomID uint, afterSeq uint64, limit int) ([]models.Message, bool, error) {
+	var messages []models.Message
+	if err := db.Where("chatroom_id = ? AND seq > ?", chatroomID, afterSeq).
+		Preload("User").
+		Preload("Attachments").
+		Order("seq ASC").
+		Limit(limit + 1).
+		Find(&messages).Error; err != nil {
+		return nil, false, err
+	}
+	if len(messages) > limit {
+		return messages[:limit], true, nil
+	}
+	return messages, false, nil
+}
+
+// messageSeq returns the sequence number of a message in a room, for
+// turning a message ID cursor into a position
+func messageSeq(db *gorm.DB, chatroomID, messageID uint) (uint64, error) {
+	var message models.Message
+	if err := db.Select("seq").Where("chatroom_id = ? AND id = ?", chatroomID, messageID).First(&message).Error; err != nil {
+		return 0, errMessageNotInRoom
+	}
+	return message.Seq, nil
+}
+
+// messagesAround returns a newest-first page centred on messageID, whether
+// older messages exist, and whether newer ones do
+func messagesAround(db *gorm.DB, chatroomID, messageID uint, limit int) ([]models.Message, bool, bool, error) {
+	var target models.Message
+	if err := db.Where("chatroom_id = ? AND id = ?", chatroomID, messageID).First(&target).Error; err != nil {
+		return nil, false, false, errMessageNotInRoom
+	}
+
+	newer, hasNewer, err := newerMessages(db, chatroomID, target.Seq, limit/2)
+	if err != nil {
+		return nil, false, false, err
+	}
+	older, hasOlder, err := olderMessages(db, chatroomID, target.Seq+1, limit-len(newer))
+	if err != nil {
+		return nil, false, false, err
+	}
+
+	page := make([]models.Message, 0, len(newer)+len(older))
+	for i := len(newer) - 1; i >= 0; i-- {
+		page = append(page, newer[i])
+	}
+	return append(page, older...), hasOlder, hasNewer, nil
+}
@@ -0,0 +1,89 @@
+package handlers_test
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/jeffasante/chatroom.go/internal/chattest"
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+type historyPage struct {
+	Messages []models.Message `json:"messages"`
+	HasMore  bool             `json:"has_more"`
+}
+
+// importedRoom returns a room whose messages were saved out of order, as
+// an import into a room with newer messages leaves them
+func importedRoom(t *testing.T) (*chattest.Server, *chattest.User, string) {
+	t.Helper()
+	srv := chattest.New(t)
+	alice := srv.SignUp(t, "alice")
+	code := srv.CreateRoom(t, alice, "general", "secret")
+
+	var chatroom models.Chatroom
+	srv.DB.Where("code = ?", code).First(&chatroom)
+	for _, seq := range []uint64{3, 1, 4, 2, 5} {
+		message := models.Message{Content: fmt.Sprint(seq), UserID: alice.ID, ChatroomID: chatroom.ID, Seq: seq}
+		if err := srv.DB.Create(&message).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return srv, alice, code
+}
+
+func seqsOf(messages []models.Message) []uint64 {
+	seqs := make([]uint64, len(messages))
+	for i, m := range messages {
+		seqs[i] = m.Seq
+	}
+	return seqs
+}
+
+func TestHistoryPagesBySeq(t *testing.T) {
+	srv, alice, code := importedRoom(t)
+
+	for _, cursor := range []string{"before_seq", "before_id"} {
+		var seqs []uint64
+		query := ""
+		for {
+			var page historyPage
+			srv.Do(t, alice, http.MethodGet, "/api/messages/"+code+"?limit=2"+query, nil, &page)
+			seqs = append(seqs, seqsOf(page.Messages)...)
+			if !page.HasMore {
+				break
+			}
+			last := page.Messages[len(page.Messages)-1]
+			if cursor == "before_seq" {
+				query = fmt.Sprintf("&before_seq=%d", last.Seq)
+			} else {
+				query = fmt.Sprintf("&before_id=%d", last.ID)
+			}
+		}
+		if want := []uint64{5, 4, 3, 2, 1}; !reflect.DeepEqual(seqs, want) {
+			t.Errorf("paging with %s gave seqs %v, want %v", cursor, seqs, want)
+		}
+	}
+}
+
+func TestHistoryAfterAndAround(t *testing.T) {
+	srv, alice, code := importedRoom(t)
+
+	// Message 1 has seq 3
+	var page historyPage
+	srv.Do(t, alice, http.MethodGet, "/api/messages/"+code+"?after_id=1", nil, &page)
+	if got, want := seqsOf(page.Messages), []uint64{4, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after_id gave seqs %v, want %v", got, want)
+	}
+
+	srv.Do(t, alice, http.MethodGet, "/api/messages/"+code+"?around_id=1&limit=3", nil, &page)
+	if got, want := seqsOf(page.Messages), []uint64{4, 3, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("around_id gave seqs %v, want %v", got, want)
+	}
+
+	if status := srv.Do(t, alice, http.MethodGet, "/api/messages/"+code+"?before_id=999", nil, nil); status != http.StatusNotFound {
+		t.Errorf("unknown before_id gave status %d", status)
+	}
+}
@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/jeffasante/chatroom.go/middleware"
+	"github.com/jeffasante/chatroom.go/models"
+	"github.com/jeffasante/chatroom.go/moderation"
+)
+
+const maxIncomingWebhookBody = 64 << 10
+
+// incomingPayload accepts both Slack ({"text": ...}) and Discord
+// ({"content": ...}) style webhook bodies. A username in the payload is
+// ignored: posts always show the integration's own name.
+type incomingPayload struct {
+	Text    string `json:"text"`
+	Content string `json:"content"`
+
+	// Slack attachments and Discord embeds are flattened to text
+	Attachments []struct {
+		Fallback string `json:"fallback"`
+		Text     string `json:"text"`
+	} `json:"attachments"`
+	Embeds []struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+	} `json:"embeds"`
+}
+
+func (p *incomingPayload) text() string {
+	parts := []string{}
+	if p.Text != "" {
+		parts = append(parts, p.Text)
+	}
+	if p.Content != "" {
+		parts = append(parts, p.Content)
+	}
+	for _, a := range p.Attachments {
+		if a.Text != "" {
+			parts = append(parts, a.Text)
+		} else if a.Fallback != "" {
+			parts = append(parts, a.Fallback)
+		}
+	}
+	for _, e := range p.Embeds {
+		if e.Title != "" && e.Description != "" {
+			parts = append(parts, e.Title+": "+e.Description)
+		} else if e.Title != "" || e.Description != "" {
+			parts = append(parts, e.Title+e.Description)
+		}
+	}
+	return strings.TrimSpace(strings.Join(parts, "\n"))
+}
+
+// Create an incoming webhook for a room. The URL contains the secret token
+// and is only returned here.
+func (h *Handler) CreateIncomingWebhook(c *gin.Context) {
+	user := h.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	chatroom, ok := h.findOwnedRoom(c, user)
+	if !ok {
+		return
+	}
+
+	name := strings.TrimSpace(c.PostForm("name"))
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "integration name is required"})
+		return
+	}
+
+	// The integration posts as a user, so its name follows the same rules
+	if err := validateUsername(name); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	taken, err := usernameTaken(h.db, name, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server error"})
+		return
+	}
+	if taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "username already taken"})
+		return
+	}
+
+	// Messages are posted as an integration user that cannot log in
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(h.generateToken()), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server error"})
+		return
+	}
+
+	token := h.generateToken() + h.generateToken()
+	webhook := models.IncomingWebhook{
+		ChatroomID:  chatroom.ID,
+		Name:        name,
+		TokenHash:   middleware.HashAPIToken(token),
+		CreatedByID: user.ID,
+	}
+
+	tx := h.db.Begin()
+	integration := models.User{
+		Username:   name,
+		Email:      name + "@integrations.invalid",
+		Password:   string(hashedPassword),
+		IsBot:      true,
+		BotOwnerID: user.ID,
+	}
+	if err := tx.Create(&integration).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create integration user"})
+		return
+	}
+
+	webhook.UserID = integration.ID
+	if err := tx.Create(&webhook).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create webhook"})
+		return
+	}
+	if err := recordAudit(tx, auditEntry{
+		chatroomID:   chatroom.ID,
+		actorID:      user.ID,
+		action:       models.AuditWebhookCreate,
+		targetUserID: integration.ID,
+		targetType:   "incoming_webhook",
+		targetID:     webhook.ID,
+		after:        gin.H{"name": webhook.Name},
+	}); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create webhook"})
+		return
+	}
+	tx.Commit()
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"webhook": webhook,
+		"url":     "/hooks/" + token,
+	})
+}
+
+// List a room's incoming webhooks
+func (h *Handler) ListIncomingWebhooks(c *gin.Context) {
+	user := h.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	chatroom, ok := h.findOwnedRoom(c, user)
+	if !ok {
+		return
+	}
+
+	var hooks []models.IncomingWebhook
+	if err := h.db.Where("chatroom_id = ?", chatroom.ID).Order("id ASC").Find(&hooks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get webhooks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"webhooks": hooks,
+		"count":    len(hooks),
+	})
+}
+
+// Delete an incoming webhook. Its integration user is kept so past
+// messages still show who posted them.
+func (h *Handler) DeleteIncomingWebhook(c *gin.Context) {
+	user := h.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	chatroom, ok := h.findOwnedRoom(c, user)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook id"})
+		return
+	}
+
+	var webhook models.IncomingWebhook
+	if err := h.db.Where("id = ? AND chatroom_id = ?", id, chatroom.ID).First(&webhook).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+		return
+	}
+	if err := h.db.Delete(&webhook).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete webhook"})
+		return
+	}
+
+	audit(h.db, auditEntry{
+		chatroomID:   chatroom.ID,
+		actorID:      user.ID,
+		action:       models.AuditWebhookDelete,
+		targetUserID: webhook.UserID,
+		targetType:   "incoming_webhook",
+		targetID:     webhook.ID,
+		before:       gin.H{"name": webhook.Name},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Webhook deleted successfully",
+	})
+}
+
+// Receive a Slack or Discord style payload and post it to the webhook's room
+func (h *Handler) HandleIncomingWebhook(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var webhook models.IncomingWebhook
+		if err := h.db.Where("token_hash = ?", middleware.HashAPIToken(c.Param("token"))).
+			Preload("User").
+			First(&webhook).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "unknown webhook"})
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxIncomingWebhookBody+1))
+		if err != nil || len(body) > maxIncomingWebhookBody {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "payload too large"})
+			return
+		}
+
+		// Slack also accepts the JSON as a "payload" form field
+		if strings.HasPrefix(c.ContentType(), "application/x-www-form-urlencoded") {
+			c.Request.Body = io.NopCloser(strings.NewReader(string(body)))
+			body = []byte(c.PostForm("payload"))
+		}
+
+		var payload incomingPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json payload"})
+			return
+		}
+
+		text := payload.text()
+		if text == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "text or content is required"})
+			return
+		}
+		// Integrations can't answer an error frame, so long text is cut short
+		chatroom := hub.roomSettings(webhook.ChatroomID)
+		message := &Message{
+			Type:       "message",
+			Content:    truncateText(text, messageLimit(chatroom)),
+			UserID:     webhook.UserID,
+			Username:   webhook.User.Username,
+			ChatroomID: webhook.ChatroomID,
+			Timestamp:  time.Now(),
+			IsBot:      true,
+		}
+
+		// Integrations share the rate limits and slow mode of WebSocket clients
+		if refused := hub.checkRate(chatroom, webhook.UserID, message, false); refused != nil {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(refused.RetryAfter))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "rate limited", "retry_after": refused.RetryAfter})
+			return
+		}
+
+		// The hub screens, saves and fans out the message like any other
+		decision, err := hub.submit(message)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save message"})
+			return
+		}
+
+		switch decision.Action {
+		case moderation.Hold:
+			c.JSON(http.StatusAccepted, gin.H{"ok": true, "held": true})
+		case moderation.Reject:
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "message rejected by moderation", "reason": decision.Reason})
+		default:
+			c.JSON(http.StatusOK, gin.H{"ok": true})
+		}
+	}
+}
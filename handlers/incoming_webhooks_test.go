@@ -0,0 +1,155 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jeffasante/chatroom.go/internal/chattest"
+)
+
+// incomingHook creates an incoming webhook in the room and returns its URL path
+func incomingHook(t *testing.T, srv *chattest.Server, owner *chattest.User, code, name string) string {
+	t.Helper()
+	var result struct {
+		URL   string `json:"url"`
+		Error string `json:"error"`
+	}
+	srv.Do(t, owner, http.MethodPost, "/api/room/"+code+"/incoming-webhooks", url.Values{"name": {name}}, &result)
+	if result.URL == "" {
+		t.Fatalf("create incoming webhook %s: %s", name, result.Error)
+	}
+	return result.URL
+}
+
+// postHook sends body to an incoming webhook without any session
+func postHook(t *testing.T, srv *chattest.Server, path, contentType, body string) int {
+	t.Helper()
+	resp, err := http.Post(srv.URL+path, contentType, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestIncomingWebhookPayloads(t *testing.T) {
+	srv := chattest.New(t)
+	alice := srv.SignUp(t, "alice")
+	code := srv.CreateRoom(t, alice, "general", "secret")
+	hook := incomingHook(t, srv, alice, code, "CI")
+	conn := srv.Connect(t, alice, code)
+
+	// The username in the first payload is ignored
+	tests := []struct {
+		name, contentType, body, want string
+	}{
+		{"slack", "application/json", `{"text": "build passed", "username": "alice"}`, "build passed"},
+		{"discord", "application/json", `{"content": "deploy finished"}`, "deploy finished"},
+		{"slack form", "application/x-www-form-urlencoded", url.Values{"payload": {`{"text": "from a form"}`}}.Encode(), "from a form"},
+		{"slack attachments", "application/json", `{"text": "alert", "attachments": [{"fallback": "disk full"}]}`, "alert\ndisk full"},
+		{"discord embeds", "application/json", `{"embeds": [{"title": "v1.2", "description": "released"}]}`, "v1.2: released"},
+	}
+	for _, tt := range tests {
+		if status := postHook(t, srv, hook, tt.contentType, tt.body); status != http.StatusOK {
+			t.Errorf("%s: status %d", tt.name, status)
+			continue
+		}
+		frame := conn.Await(chattest.OfType("message"))
+		var extra struct {
+			IsBot bool `json:"is_bot"`
+		}
+		json.Unmarshal(frame.Raw, &extra)
+		if frame.Content != tt.want || frame.Username != "CI" || !extra.IsBot {
+			t.Errorf("%s: posted %q as %q (bot %v), want %q as CI", tt.name, frame.Content, frame.Username, extra.IsBot, tt.want)
+		}
+	}
+}
+
+func TestIncomingWebhookRefusals(t *testing.T) {
+	srv := chattest.New(t)
+	alice := srv.SignUp(t, "alice")
+	code := srv.CreateRoom(t, alice, "general", "secret")
+	hook := incomingHook(t, srv, alice, code, "CI")
+
+	tests := []struct {
+		name, path, body string
+		want             int
+	}{
+		{"bad token", "/hooks/not-a-token", `{"text": "hi"}`, http.StatusNotFound},
+		{"empty body", hook, "", http.StatusBadRequest},
+		{"no text", hook, `{"username": "CI"}`, http.StatusBadRequest},
+		{"not json", hook, "hello", http.StatusBadRequest},
+		{"oversized body", hook, `{"text": "` + strings.Repeat("x", 64<<10) + `"}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		if status := postHook(t, srv, tt.path, "application/json", tt.body); status != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, status, tt.want)
+		}
+	}
+}
+
+func TestIncomingWebhookNames(t *testing.T) {
+	srv := chattest.New(t)
+	alice := srv.SignUp(t, "alice")
+	bob := srv.SignUp(t, "bob")
+	code := srv.CreateRoom(t, alice, "general", "secret")
+	srv.JoinRoom(t, bob, code, "secret")
+
+	path := "/api/room/" + code + "/incoming-webhooks"
+	for _, tt := range []struct {
+		name string
+		want int
+	}{
+		{"", http.StatusBadRequest},
+		{"CI bot", http.StatusBadRequest},
+		{"<script>", http.StatusBadRequest},
+		{"x", http.StatusBadRequest},
+		{"alice", http.StatusConflict},
+		{"BOB", http.StatusConflict},
+		{"deploys", http.StatusOK},
+		{"Deploys", http.StatusConflict},
+	} {
+		if status := srv.Do(t, alice, http.MethodPost, path, url.Values{"name": {tt.name}}, nil); status != tt.want {
+			t.Errorf("name %q: status %d, want %d", tt.name, status, tt.want)
+		}
+	}
+
+	// Only the owner can add integrations
+	if status := srv.Do(t, bob, http.MethodPost, path, url.Values{"name": {"bobs-ci"}}, nil); status != http.StatusForbidden {
+		t.Errorf("member created a webhook: %d", status)
+	}
+}
+
+func TestIncomingWebhookRateLimits(t *testing.T) {
+	srv := chattest.New(t)
+	alice, _, code := slowRoom(t, srv)
+	hook := incomingHook(t, srv, alice, code, "CI")
+
+	if status := postHook(t, srv, hook, "application/json", `{"text": "first"}`); status != http.StatusOK {
+		t.Fatalf("first post: %d", status)
+	}
+	resp, err := http.Post(srv.URL+hook, "application/json", strings.NewReader(`{"text": "second"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "60" {
+		t.Errorf("second post in slow mode: %d, Retry-After %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	// Without slow mode the integration gets the usual burst
+	other := srv.CreateRoom(t, alice, "other", "secret")
+	hook = incomingHook(t, srv, alice, other, "Deploys")
+	statuses := []int{}
+	for i := 0; i < 6; i++ {
+		statuses = append(statuses, postHook(t, srv, hook, "application/json", `{"text": "build passed"}`))
+	}
+	want := []int{200, 200, 200, 200, 200, 429}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("statuses %v, want %v", statuses, want)
+	}
+}
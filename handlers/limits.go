@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jeffasante/chatroom.go/models"
+	"github.com/jeffasante/chatroom.go/storage"
+)
+
+// Message size limits, set from the environment in main
+var (
+	// MaxMessageLength is the longest chat message, in characters. Rooms
+	// can set a lower limit of their own.
+	MaxMessageLength = 4000
+
+	// MaxFrameSize is the largest WebSocket frame read from a client, in
+	// bytes. Bigger frames can't be recovered from, so the connection is
+	// closed with status 1009 (message too big).
+	MaxFrameSize int64 = 64 << 10
+
+	// LongMessagesAsAttachments posts text over the limit as a .txt
+	// attachment with a short preview instead of rejecting it
+	LongMessagesAsAttachments = false
+)
+
+// Codes for the error field of error frames
+const (
+	errorMessageTooLong = "message_too_long"
+	errorSaveFailed     = "save_failed"
+)
+
+// longMessagePreview is how much of a converted long message stays inline
+const longMessagePreview = 280
+
+// messageLimit returns the longest message allowed in a room
+func messageLimit(chatroom *models.Chatroom) int {
+	if chatroom.MaxMessageLength > 0 && chatroom.MaxMessageLength < MaxMessageLength {
+		return chatroom.MaxMessageLength
+	}
+	return MaxMessageLength
+}
+
+// maxInputLength is the limit for the message box on the room page, or 0
+// when long messages are accepted as attachments
+func maxInputLength(chatroom *models.Chatroom) int {
+	if LongMessagesAsAttachments {
+		return 0
+	}
+	return messageLimit(chatroom)
+}
+
+// roomSettings loads the room fields that limit what members can send.
+// They are looked up for every message so changes apply to connections
+// that are already open.
+func (h *Hub) roomSettings(chatroomID uint) *models.Chatroom {
+	chatroom := &models.Chatroom{ID: chatroomID}
+	if err := h.db.Select("id", "owner_id", "max_message_length", "slow_mode_seconds").First(chatroom, chatroomID).Error; err != nil {
+		log.Printf("Failed to load settings for chatroom %d: %v", chatroomID, err)
+	}
+	return chatroom
+}
+
+// checkMessage applies the room's size limit, the user's rate limit and
+// slow mode to a chat message or command. If the message is refused it
+// returns the error frame to send back. Long text may be moved into an
+// attachment, changing the message.
+func (c *Client) checkMessage(message *Message, command bool) *Message {
+	chatroom := c.hub.roomSettings(c.chatroomID)
+	limit := messageLimit(chatroom)
+	tooLong := utf8.RuneCountInString(message.Content) > limit
+	if tooLong && (command || !LongMessagesAsAttachments || c.blobs == nil) {
+		return tooLongFrame(message, limit)
+	}
+
+	if refused := c.hub.checkRate(chatroom, c.user.ID, message, command); refused != nil {
+		return refused
+	}
+
+	if tooLong {
+		if err := c.attachLongText(message); err != nil {
+			log.Printf("Failed to store long message as an attachment: %v", err)
+			return errorFrame(message, errorSaveFailed, "Your message could not be saved")
+		}
+	}
+	return nil
+}
+
+// checkRate applies the user's rate limit and the room's slow mode to
+// anything they post, returning the error frame to send back if it must wait
+func (h *Hub) checkRate(chatroom *models.Chatroom, userID uint, message *Message, command bool) *Message {
+	now := time.Now()
+	if ok, wait := h.limits.allowUser(userID, now); !ok {
+		return rateLimitedFrame(message, throttledUser, wait)
+	}
+
+	// Moderators aren't held back by slow mode, and commands don't count
+	if !command && chatroom.SlowModeSeconds > 0 && !chatroom.HasRole(h.db, userID, models.RoleModerator) {
+		interval := time.Duration(chatroom.SlowModeSeconds) * time.Second
+		if ok, wait := h.limits.allowSlowMode(chatroom.ID, userID, interval, now); !ok {
+			return rateLimitedFrame(message, throttledSlowMode, wait)
+		}
+	}
+	return nil
+}
+
+// errorFrame tells one client why something it sent was refused. Nonce is
+// echoed so the client can tell which message failed.
+func errorFrame(message *Message, code, text string) *Message {
+	return &Message{
+		Type:       "error",
+		Content:    text,
+		ChatroomID: message.ChatroomID,
+		Timestamp:  time.Now(),
+		Nonce:      message.Nonce,
+		Error:      code,
+	}
+}
+
+func tooLongFrame(message *Message, limit int) *Message {
+	frame := errorFrame(message, errorMessageTooLong,
+		fmt.Sprintf("Messages in this room can be at most %d characters", limit))
+	frame.Limit = limit
+	return frame
+}
+
+// attachLongText moves a message's text into a text file attachment and
+// leaves the start of it as the message content
+func (c *Client) attachLongText(message *Message) error {
+	key, err := storage.NewKey(c.chatroomID)
+	if err != nil {
+		return err
+	}
+
+	data := []byte(message.Content)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := c.blobs.Put(ctx, key, bytes.NewReader(data), int64(len(data)), "text/plain"); err != nil {
+		return err
+	}
+
+	attachment := models.Attachment{
+		ChatroomID:  c.chatroomID,
+		UserID:      c.user.ID,
+		Key:         key,
+		Filename:    "message.txt",
+		ContentType: "text/plain",
+		Size:        int64(len(data)),
+	}
+	if err := c.hub.db.Create(&attachment).Error; err != nil {
+		c.blobs.Delete(ctx, key)
+		return err
+	}
+
+	message.Content = truncateText(message.Content, longMessagePreview)
+	message.Attachments = []AttachmentInfo{attachmentInfo(attachment)}
+	return nil
+}
+
+// truncateText shortens text to at most limit characters, ending with an
+// ellipsis if anything was cut
+func truncateText(text string, limit int) string {
+	if utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+	runes := []rune(text)
+	return strings.TrimRight(string(runes[:limit-1]), " \n") + "…"
+}
@@ -0,0 +1,157 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/jeffasante/chatroom.go/handlers"
+	"github.com/jeffasante/chatroom.go/internal/chattest"
+	"github.com/jeffasante/chatroom.go/models"
+	"github.com/jeffasante/chatroom.go/storage"
+)
+
+// setLimits changes the message size limits for one test
+func setLimits(t *testing.T, length int, attachments bool) {
+	t.Helper()
+	oldLength, oldAttachments := handlers.MaxMessageLength, handlers.LongMessagesAsAttachments
+	t.Cleanup(func() { handlers.MaxMessageLength, handlers.LongMessagesAsAttachments = oldLength, oldAttachments })
+	handlers.MaxMessageLength, handlers.LongMessagesAsAttachments = length, attachments
+}
+
+// limitOf decodes the limit field of a message_too_long frame
+func limitOf(frame chattest.Frame) int {
+	var refused struct {
+		Limit int `json:"limit"`
+	}
+	json.Unmarshal(frame.Raw, &refused)
+	return refused.Limit
+}
+
+func TestMessageTooLong(t *testing.T) {
+	setLimits(t, 100, false)
+	srv := chattest.New(t)
+	alice := srv.SignUp(t, "alice")
+	code := srv.CreateRoom(t, alice, "general", "secret")
+	conn := srv.Connect(t, alice, code)
+
+	conn.Send(map[string]string{"type": "message", "content": strings.Repeat("é", 101), "nonce": "long"})
+	frame := conn.Await(func(f chattest.Frame) bool { return f.Nonce == "long" })
+	if frame.Type != "error" || frame.Error != "message_too_long" || limitOf(frame) != 100 {
+		t.Errorf("oversized message got %s %q, limit %d", frame.Type, frame.Error, limitOf(frame))
+	}
+
+	// The connection is still open and the limit counts characters, not bytes
+	send(t, conn, strings.Repeat("é", 100), "at-limit")
+
+	// Rooms can set a lower limit of their own
+	srv.DB.Model(&models.Chatroom{}).Where("code = ?", code).Update("max_message_length", 10)
+	conn.Send(map[string]string{"type": "message", "content": strings.Repeat("x", 11), "nonce": "room-limit"})
+	frame = conn.Await(func(f chattest.Frame) bool { return f.Nonce == "room-limit" })
+	if frame.Error != "message_too_long" || limitOf(frame) != 10 {
+		t.Errorf("message over the room limit got %s %q, limit %d", frame.Type, frame.Error, limitOf(frame))
+	}
+	send(t, conn, strings.Repeat("x", 10), "room-at-limit")
+
+	var count int64
+	srv.DB.Model(&models.Message{}).Count(&count)
+	if count != 2 {
+		t.Errorf("%d messages saved, want 2", count)
+	}
+}
+
+func TestLongMessageAsAttachment(t *testing.T) {
+	setLimits(t, 300, true)
+	blobs, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := chattest.New(t, chattest.WithBlobStore(blobs))
+	alice := srv.SignUp(t, "alice")
+	code := srv.CreateRoom(t, alice, "general", "secret")
+	conn := srv.Connect(t, alice, code)
+
+	text := strings.Repeat("word ", 100)
+	conn.Send(map[string]string{"type": "message", "content": text, "nonce": "long"})
+	frame := conn.Await(func(f chattest.Frame) bool { return f.Type == "message" && f.Nonce == "long" })
+	var message struct {
+		Attachments []handlers.AttachmentInfo `json:"attachments"`
+	}
+	json.Unmarshal(frame.Raw, &message)
+	if len(message.Attachments) != 1 || message.Attachments[0].Filename != "message.txt" || message.Attachments[0].Size != int64(len(text)) {
+		t.Fatalf("attachments %+v", message.Attachments)
+	}
+	if want := strings.Repeat("word ", 55) + "word…"; frame.Content != want {
+		t.Errorf("preview %q, want %q", frame.Content, want)
+	}
+
+	var stored models.Attachment
+	srv.DB.First(&stored, message.Attachments[0].ID)
+	if stored.ContentType != "text/plain" || stored.Size != int64(len(text)) {
+		t.Errorf("stored attachment %+v", stored)
+	}
+}
+
+// slowRoom creates a room with slow mode on that bob is a member of
+func slowRoom(t *testing.T, srv *chattest.Server) (owner, member *chattest.User, code string) {
+	t.Helper()
+	owner = srv.SignUp(t, "alice")
+	member = srv.SignUp(t, "bob")
+	code = srv.CreateRoom(t, owner, "general", "secret")
+	srv.JoinRoom(t, member, code, "secret")
+	if err := srv.DB.Model(&models.Chatroom{}).Where("code = ?", code).Update("slow_mode_seconds", 60).Error; err != nil {
+		t.Fatal(err)
+	}
+	return owner, member, code
+}
+
+func TestSlowModeLimitsUploads(t *testing.T) {
+	blobs, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := chattest.New(t, chattest.WithBlobStore(blobs))
+	_, bob, code := slowRoom(t, srv)
+
+	if status, result := upload(t, srv, bob, code, "a.txt", []byte("first"), nil); status != http.StatusCreated {
+		t.Fatalf("first upload: %d %s", status, result.Error)
+	}
+	if status, result := upload(t, srv, bob, code, "b.txt", []byte("second"), nil); status != http.StatusTooManyRequests {
+		t.Errorf("second upload in slow mode: %d %s", status, result.Error)
+	}
+
+	conn := srv.Connect(t, bob, code)
+	conn.Send(map[string]string{"type": "message", "content": "hello", "nonce": "after-upload"})
+	frame := conn.Await(func(f chattest.Frame) bool { return f.Nonce == "after-upload" })
+	if frame.Type != "error" || frame.Error != "rate_limited" {
+		t.Errorf("message after an upload got %s %q", frame.Type, frame.Error)
+	}
+
+	var count int64
+	srv.DB.Model(&models.Attachment{}).Count(&count)
+	if count != 1 {
+		t.Errorf("%d attachments saved, want 1", count)
+	}
+}
+
+func TestRetryIsAcknowledgedInSlowMode(t *testing.T) {
+	srv := chattest.New(t)
+	_, bob, code := slowRoom(t, srv)
+
+	conn := srv.Connect(t, bob, code)
+	first := send(t, conn, "hello", "retry-1")
+
+	// The ack was lost, so the client sends the same message again
+	conn.Send(map[string]string{"type": "message", "content": "hello", "nonce": "retry-1"})
+	frame := conn.Await(func(f chattest.Frame) bool { return f.Nonce == "retry-1" && f.Type != "message" })
+	if frame.Type != "ack" || frame.MessageID != first {
+		t.Errorf("retry got %s %q for message %d, want an ack for %d", frame.Type, frame.Error, frame.MessageID, first)
+	}
+
+	conn.Send(map[string]string{"type": "message", "content": "again", "nonce": "retry-2"})
+	frame = conn.Await(func(f chattest.Frame) bool { return f.Nonce == "retry-2" })
+	if frame.Error != "rate_limited" {
+		t.Errorf("new message in slow mode got %s %q", frame.Type, frame.Error)
+	}
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+const errorMuted = "muted"
+
+// maxMute caps how long a moderator can mute someone for
+const maxMute = 30 * 24 * time.Hour
+
+// outranks reports whether actor may act on target in the room: only
+// against members less privileged than themselves
+func outranks(db *gorm.DB, chatroom *models.Chatroom, actorID, targetID uint) bool {
+	return models.RoleRank(chatroom.RoleOf(db, actorID)) > models.RoleRank(chatroom.RoleOf(db, targetID))
+}
+
+// removeMember takes a user out of a room and closes their connections.
+// Banning also stops them joining again.
+func (h *Hub) removeMember(chatroom *models.Chatroom, target, actor *models.User, ban bool, reason string) error {
+	entry := auditEntry{
+		chatroomID:   chatroom.ID,
+		actorID:      actor.ID,
+		action:       models.AuditMemberKick,
+		targetUserID: target.ID,
+		before:       gin.H{"role": chatroom.RoleOf(h.db, target.ID)},
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND chatroom_id = ?", target.ID, chatroom.ID).Delete(&models.Membership{}).Error; err != nil {
+			return err
+		}
+		if ban {
+			entry.action = models.AuditMemberBan
+			entry.after = gin.H{"reason": reason}
+			if err := tx.Create(&models.RoomBan{
+				ChatroomID: chatroom.ID,
+				UserID:     target.ID,
+				BannedByID: actor.ID,
+				Reason:     reason,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return recordAudit(tx, entry)
+	})
+	if err != nil {
+		return err
+	}
+
+	verb := "kicked"
+	if ban {
+		verb = "banned"
+	}
+	h.disconnectUser(chatroom.ID, target.ID, "You were "+verb+" from the room by "+actor.Username)
+	h.broadcast <- &Message{
+		Type:       "user_" + verb,
+		Content:    target.Username + " was " + verb + " by " + actor.Username,
+		Username:   "System",
+		ChatroomID: chatroom.ID,
+		Timestamp:  time.Now(),
+	}
+	return nil
+}
+
+// unbanUser lets a banned user join the room again
+func (h *Hub) unbanUser(chatroom *models.Chatroom, target, actor *models.User) (bool, error) {
+	var ban models.RoomBan
+	if err := h.db.Where("chatroom_id = ? AND user_id = ?", chatroom.ID, target.ID).First(&ban).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := h.db.Delete(&ban).Error; err != nil {
+		return false, err
+	}
+
+	audit(h.db, auditEntry{
+		chatroomID:   chatroom.ID,
+		actorID:      actor.ID,
+		action:       models.AuditMemberUnban,
+		targetUserID: target.ID,
+		before:       gin.H{"banned_by_id": ban.BannedByID, "reason": ban.Reason, "banned_at": ban.CreatedAt},
+	})
+	return true, nil
+}
+
+// isBanned reports whether the user is banned from the room
+func isBanned(db *gorm.DB, chatroomID, userID uint) bool {
+	var count int64
+	db.Model(&models.RoomBan{}).Where("chatroom_id = ? AND user_id = ?", chatroomID, userID).Count(&count)
+	return count > 0
+}
+
+// muteMember stops a member posting until the given time, or lifts their
+// mute when until is nil
+func (h *Hub) muteMember(chatroom *models.Chatroom, target, actor *models.User, until *time.Time) error {
+	var before models.Membership
+	h.db.Select("muted_until").Where("user_id = ? AND chatroom_id = ?", target.ID, chatroom.ID).First(&before)
+
+	result := h.db.Model(&models.Membership{}).
+		Where("user_id = ? AND chatroom_id = ?", target.ID, chatroom.ID).
+		Update("muted_until", until)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New(target.Username + " is not a member of this room")
+	}
+
+	audit(h.db, auditEntry{
+		chatroomID:   chatroom.ID,
+		actorID:      actor.ID,
+		action:       models.AuditMemberMute,
+		targetUserID: target.ID,
+		before:       gin.H{"muted_until": before.MutedUntil},
+		after:        gin.H{"muted_until": until},
+	})
+
+	content := target.Username + " was unmuted by " + actor.Username
+	if until != nil {
+		content = target.Username + " was muted by " + actor.Username + " until " + until.Format("Jan 2 15:04 MST")
+	}
+	h.broadcast <- &Message{
+		Type:       "user_muted",
+		Content:    content,
+		Username:   "System",
+		ChatroomID: chatroom.ID,
+		Timestamp:  time.Now(),
+	}
+	return nil
+}
+
+// mutedUntil returns when a user's mute in a room ends, if they are muted
+func (h *Hub) mutedUntil(chatroomID, userID uint) (time.Time, bool) {
+	var membership models.Membership
+	err := h.db.Select("muted_until").Where("user_id = ? AND chatroom_id = ?", userID, chatroomID).First(&membership).Error
+	if err != nil || membership.MutedUntil == nil || !membership.MutedUntil.After(time.Now()) {
+		return time.Time{}, false
+	}
+	return *membership.MutedUntil, true
+}
+
+func mutedFrame(message *Message, until time.Time) *Message {
+	frame := errorFrame(message, errorMuted, "You are muted in this room until "+until.Format("Jan 2 15:04 MST"))
+	frame.RetryAfter = time.Until(until).Round(time.Second).Seconds()
+	return frame
+}
+
+// deleteMessage removes a message with its attachments and tells the room
+func (h *Handler) deleteMessage(hub *Hub, message *models.Message, actor *models.User) error {
+	var attachments []models.Attachment
+	if err := h.db.Where("message_id = ?", message.ID).Find(&attachments).Error; err != nil {
+		return err
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("message_id = ?", message.ID).Delete(&models.Attachment{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(message).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, auditEntry{
+			chatroomID:   message.ChatroomID,
+			actorID:      actor.ID,
+			action:       models.AuditMessageDelete,
+			targetUserID: message.UserID,
+			targetType:   "message",
+			targetID:     message.ID,
+			before:       gin.H{"content": message.Content, "seq": message.Seq, "attachments": len(attachments), "created_at": message.CreatedAt},
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	var blobKeys []string
+	for _, attachment := range attachments {
+		blobKeys = append(blobKeys, attachment.BlobKeys()...)
+	}
+	h.deleteBlobs(blobKeys)
+
+	hub.broadcast <- &Message{
+		Type:       "message_deleted",
+		ChatroomID: message.ChatroomID,
+		Timestamp:  time.Now(),
+		MessageID:  message.ID,
+	}
+	return nil
+}
@@ -0,0 +1,614 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/jeffasante/chatroom.go/models"
+	"github.com/jeffasante/chatroom.go/moderation"
+	"github.com/jeffasante/chatroom.go/storage"
+)
+
+// Limits on a room's moderation settings
+const (
+	maxBlockedWords    = 500
+	maxBlockedDomains  = 500
+	maxModerationRules = 50
+	maxRulePattern     = 500
+)
+
+const errorMessageRejected = "message_rejected"
+
+// moderationTimeout bounds how long a message waits for its room's filters
+const moderationTimeout = 3 * time.Second
+
+// moderated counts messages filters did not simply allow, by action,
+// published at /debug/vars
+var moderated = expvar.NewMap("messages_moderated")
+
+// moderationRule is a regex rule as stored and shown by the API
+type moderationRule struct {
+	Pattern string            `json:"pattern"`
+	Action  moderation.Action `json:"action"`
+	Reason  string            `json:"reason,omitempty"`
+}
+
+// moderationSettings is a room's filter configuration as the API shows it
+type moderationSettings struct {
+	BlockedWords   []string          `json:"blocked_words"`
+	WordAction     moderation.Action `json:"word_action"`
+	Rules          []moderationRule  `json:"rules"`
+	BlockedDomains []string          `json:"blocked_domains"`
+	LinkAction     moderation.Action `json:"link_action"`
+	UseClassifier  bool              `json:"use_classifier"`
+}
+
+// EnableClassifier sets the external classifier rooms can opt in to
+func (h *Hub) EnableClassifier(classifier moderation.Filter) {
+	h.classifier = classifier
+}
+
+// roomModeration loads a room's moderation settings, or the defaults for a
+// room that has never set any
+func roomModeration(db *gorm.DB, chatroomID uint) models.RoomModeration {
+	config := models.RoomModeration{
+		ChatroomID: chatroomID,
+		WordAction: string(moderation.Mask),
+		LinkAction: string(moderation.Reject),
+	}
+	if err := db.Where("chatroom_id = ?", chatroomID).First(&config).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("Failed to load moderation settings for chatroom %d: %v", chatroomID, err)
+	}
+	return config
+}
+
+// moderationChain builds the filters for a room. Settings are loaded for
+// every message so changes apply straight away.
+func (h *Hub) moderationChain(chatroomID uint) moderation.Chain {
+	config := roomModeration(h.db, chatroomID)
+
+	var chain moderation.Chain
+	if words := moderation.NewWordList(splitLines(config.BlockedWords), moderation.Action(config.WordAction)); words != nil {
+		chain = append(chain, words)
+	}
+	if rules := compileRules(config.Rules); len(rules) > 0 {
+		chain = append(chain, rules)
+	}
+	if links := moderation.NewLinkBlocklist(splitLines(config.BlockedDomains), moderation.Action(config.LinkAction)); links != nil {
+		chain = append(chain, links)
+	}
+	if config.UseClassifier && h.classifier != nil {
+		chain = append(chain, h.classifier)
+	}
+	return chain
+}
+
+// compileRules turns stored rules into filters. They were validated when
+// saved, so a rule that no longer compiles is only logged and skipped.
+func compileRules(stored string) moderation.Rules {
+	if stored == "" {
+		return nil
+	}
+	var rules []moderationRule
+	if err := json.Unmarshal([]byte(stored), &rules); err != nil {
+		log.Printf("Invalid stored moderation rules: %v", err)
+		return nil
+	}
+
+	compiled := make(moderation.Rules, 0, len(rules))
+	for _, rule := range rules {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			log.Printf("Skipping moderation rule %q: %v", rule.Pattern, err)
+			continue
+		}
+		compiled = append(compiled, moderation.Rule{Pattern: pattern, Action: rule.Action, Reason: rule.Reason})
+	}
+	return compiled
+}
+
+func splitLines(s string) []string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// submit screens a chat message with its room's filters and hands it to the
+// hub to save and broadcast, unless its sender is muted or a filter held or
+// rejected it. Filters
+// may call out over HTTP, so this runs on the sender's goroutine before the
+// message reaches Run. A connected sender is told when its message doesn't
+// go out; the error is only for failing to hold a message.
+func (h *Hub) submit(message *Message) (moderation.Decision, error) {
+	allowed := moderation.Decision{Action: moderation.Allow, Content: message.Content}
+
+	// A retried send is only screened the first time
+	if _, found := h.findByNonce(message.ChatroomID, message.UserID, message.Nonce); found {
+		h.broadcast <- message
+		return allowed, nil
+	}
+	if held, found := h.findHeld(message.ChatroomID, message.UserID, message.Nonce); found {
+		h.notifySender(message, heldFrame(message))
+		return moderation.Decision{Action: moderation.Hold, Content: held.Content, Reason: held.Reason, Filter: held.Filter}, nil
+	}
+
+	if until, muted := h.mutedUntil(message.ChatroomID, message.UserID); muted {
+		h.notifySender(message, mutedFrame(message, until))
+		return moderation.Decision{Action: moderation.Reject, Content: message.Content, Reason: errorMuted}, nil
+	}
+
+	chain := h.moderationChain(message.ChatroomID)
+	if len(chain) == 0 {
+		h.broadcast <- message
+		return allowed, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), moderationTimeout)
+	defer cancel()
+	decision := chain.Run(ctx, moderation.Message{
+		ChatroomID: message.ChatroomID,
+		UserID:     message.UserID,
+		Content:    message.Content,
+	})
+	if decision.Action != moderation.Allow {
+		moderated.Add(string(decision.Action), 1)
+	}
+
+	switch decision.Action {
+	case moderation.Hold:
+		if err := h.holdMessage(message, decision); err != nil {
+			log.Printf("Failed to hold message for review: %v", err)
+			h.notifySender(message, errorFrame(message, errorSaveFailed, "Your message could not be saved"))
+			return decision, err
+		}
+		h.notifySender(message, heldFrame(message))
+	case moderation.Reject:
+		h.notifySender(message, rejectedFrame(message, decision.Reason))
+	default:
+		message.Content = decision.Content
+		h.broadcast <- message
+	}
+	return decision, nil
+}
+
+func (h *Hub) notifySender(message *Message, frame *Message) {
+	if message.sender != nil {
+		h.sendDirect(message.sender, frame)
+	}
+}
+
+// findHeld looks up a message from the user that is still waiting for review
+func (h *Hub) findHeld(chatroomID, userID uint, nonce string) (*models.HeldMessage, bool) {
+	if nonce == "" {
+		return nil, false
+	}
+
+	var held models.HeldMessage
+	if err := h.db.Where("chatroom_id = ? AND user_id = ? AND client_nonce = ? AND status = ?", chatroomID, userID, nonce, models.HeldPending).First(&held).Error; err != nil {
+		return nil, false
+	}
+	return &held, true
+}
+
+// holdMessage adds a message to its room's review queue. Its attachments
+// stay unlinked until it is approved.
+func (h *Hub) holdMessage(message *Message, decision moderation.Decision) error {
+	held := models.HeldMessage{
+		ChatroomID:  message.ChatroomID,
+		UserID:      message.UserID,
+		Type:        message.Type,
+		Content:     decision.Content,
+		ClientNonce: message.Nonce,
+		Reason:      decision.Reason,
+		Filter:      decision.Filter,
+		Status:      models.HeldPending,
+		CreatedAt:   message.Timestamp,
+	}
+	if len(message.Attachments) > 0 {
+		data, err := json.Marshal(message.Attachments)
+		if err != nil {
+			return err
+		}
+		held.Attachments = string(data)
+	}
+	return h.db.Create(&held).Error
+}
+
+// heldFrame tells a sender its message is waiting for a moderator
+func heldFrame(message *Message) *Message {
+	return &Message{
+		Type:       "held",
+		Content:    "Your message is waiting for a moderator to approve it",
+		ChatroomID: message.ChatroomID,
+		Timestamp:  time.Now(),
+		Nonce:      message.Nonce,
+	}
+}
+
+func rejectedFrame(message *Message, reason string) *Message {
+	text := "Your message was blocked by this room's moderation rules"
+	if reason != "" {
+		text = fmt.Sprintf("Your message was blocked (%s)", reason)
+	}
+	return errorFrame(message, errorMessageRejected, text)
+}
+
+// discardAttachments removes uploads that belonged to a message that will
+// never be posted
+func discardAttachments(db *gorm.DB, blobs storage.BlobStore, infos []AttachmentInfo) {
+	if len(infos) == 0 {
+		return
+	}
+	ids := make([]uint, len(infos))
+	for i, info := range infos {
+		ids[i] = info.ID
+	}
+
+	var attachments []models.Attachment
+	if err := db.Where("id IN ? AND message_id = 0", ids).Find(&attachments).Error; err != nil {
+		log.Printf("Failed to find discarded attachments: %v", err)
+		return
+	}
+	if err := db.Where("id IN ? AND message_id = 0", ids).Delete(&models.Attachment{}).Error; err != nil {
+		log.Printf("Failed to delete discarded attachments: %v", err)
+		return
+	}
+	if blobs == nil {
+		return
+	}
+	for _, attachment := range attachments {
+		for _, key := range attachment.BlobKeys() {
+			if err := blobs.Delete(context.Background(), key); err != nil {
+				log.Printf("Failed to delete blob %s: %v", key, err)
+			}
+		}
+	}
+}
+
+// API endpoint to get a room's moderation settings (room owners only)
+func (h *Handler) GetModeration(c *gin.Context) {
+	user := h.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	chatroom, ok := h.findOwnedRoom(c, user)
+	if !ok {
+		return
+	}
+
+	settings, err := toModerationSettings(roomModeration(h.db, chatroom.ID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get moderation settings"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"moderation": settings})
+}
+
+// API endpoint to replace a room's moderation settings with a JSON body
+// (room owners only)
+func (h *Handler) UpdateModeration(c *gin.Context) {
+	user := h.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	chatroom, ok := h.findOwnedRoom(c, user)
+	if !ok {
+		return
+	}
+
+	var settings moderationSettings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json body"})
+		return
+	}
+	if err := settings.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rules, err := json.Marshal(settings.Rules)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save moderation settings"})
+		return
+	}
+	config := roomModeration(h.db, chatroom.ID)
+	before, _ := toModerationSettings(config)
+	config.BlockedWords = strings.Join(settings.BlockedWords, "\n")
+	config.WordAction = string(settings.WordAction)
+	config.Rules = string(rules)
+	config.BlockedDomains = strings.Join(settings.BlockedDomains, "\n")
+	config.LinkAction = string(settings.LinkAction)
+	config.UseClassifier = settings.UseClassifier
+	if err := h.db.Save(&config).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save moderation settings"})
+		return
+	}
+
+	audit(h.db, auditEntry{
+		chatroomID: chatroom.ID,
+		actorID:    user.ID,
+		action:     models.AuditModerationSettings,
+		before:     before,
+		after:      settings,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"moderation": settings,
+	})
+}
+
+func toModerationSettings(config models.RoomModeration) (moderationSettings, error) {
+	settings := moderationSettings{
+		BlockedWords:   splitLines(config.BlockedWords),
+		WordAction:     moderation.Action(config.WordAction),
+		Rules:          []moderationRule{},
+		BlockedDomains: splitLines(config.BlockedDomains),
+		LinkAction:     moderation.Action(config.LinkAction),
+		UseClassifier:  config.UseClassifier,
+	}
+	if config.Rules != "" {
+		if err := json.Unmarshal([]byte(config.Rules), &settings.Rules); err != nil {
+			return settings, err
+		}
+	}
+	return settings, nil
+}
+
+// validate checks settings sent by a room owner and fills in default actions
+func (s *moderationSettings) validate() error {
+	if len(s.BlockedWords) > maxBlockedWords {
+		return fmt.Errorf("at most %d blocked words are allowed", maxBlockedWords)
+	}
+	if len(s.BlockedDomains) > maxBlockedDomains {
+		return fmt.Errorf("at most %d blocked domains are allowed", maxBlockedDomains)
+	}
+	if len(s.Rules) > maxModerationRules {
+		return fmt.Errorf("at most %d rules are allowed", maxModerationRules)
+	}
+
+	s.BlockedWords = cleanList(s.BlockedWords)
+	s.BlockedDomains = cleanList(s.BlockedDomains)
+	if s.Rules == nil {
+		s.Rules = []moderationRule{}
+	}
+
+	var err error
+	if s.WordAction, err = parseModerationAction(s.WordAction, moderation.Mask); err != nil {
+		return err
+	}
+	if s.LinkAction, err = parseModerationAction(s.LinkAction, moderation.Reject); err != nil {
+		return err
+	}
+	for i := range s.Rules {
+		rule := &s.Rules[i]
+		if len(rule.Pattern) > maxRulePattern {
+			return fmt.Errorf("rule patterns can be at most %d characters", maxRulePattern)
+		}
+		if _, err := regexp.Compile(rule.Pattern); err != nil || rule.Pattern == "" {
+			return fmt.Errorf("invalid rule pattern %q", rule.Pattern)
+		}
+		if rule.Action, err = parseModerationAction(rule.Action, moderation.Hold); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseModerationAction(action, fallback moderation.Action) (moderation.Action, error) {
+	if action == "" {
+		return fallback, nil
+	}
+	return moderation.ParseAction(string(action))
+}
+
+// cleanList trims entries, dropping empty ones and any that span lines
+func cleanList(list []string) []string {
+	cleaned := []string{}
+	for _, entry := range list {
+		if entry = strings.TrimSpace(entry); entry != "" && !strings.ContainsAny(entry, "\r\n") {
+			cleaned = append(cleaned, entry)
+		}
+	}
+	return cleaned
+}
+
+// API endpoint to list messages waiting for review, oldest first
+// (moderators only). ?status= shows approved or rejected ones instead.
+func (h *Handler) ListHeldMessages(c *gin.Context) {
+	user := h.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	chatroom, ok := h.findModeratedRoom(c, user)
+	if !ok {
+		return
+	}
+
+	status := c.DefaultQuery("status", models.HeldPending)
+	if status != models.HeldPending && status != models.HeldApproved && status != models.HeldRejected {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, approved or rejected"})
+		return
+	}
+
+	var held []models.HeldMessage
+	if err := h.db.Where("chatroom_id = ? AND status = ?", chatroom.ID, status).
+		Preload("User").
+		Order("id ASC").
+		Limit(200).
+		Find(&held).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get held messages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"held_messages": held,
+		"count":         len(held),
+	})
+}
+
+// API endpoint to approve a held message, posting it to the room
+// (moderators only)
+func (h *Handler) ApproveHeldMessage(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := h.GetCurrentUser(c)
+		if user == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		chatroom, ok := h.findModeratedRoom(c, user)
+		if !ok {
+			return
+		}
+
+		held, ok := h.reviewHeldMessage(c, chatroom, user, models.HeldApproved)
+		if !ok {
+			return
+		}
+
+		// The nonce lets the author's client confirm its waiting message
+		hub.broadcast <- &Message{
+			Type:        held.Type,
+			Content:     held.Content,
+			UserID:      held.UserID,
+			Username:    held.User.Username,
+			ChatroomID:  held.ChatroomID,
+			Timestamp:   time.Now(),
+			IsBot:       held.User.IsBot,
+			Attachments: heldAttachments(held),
+			Nonce:       held.ClientNonce,
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":      true,
+			"held_message": held,
+		})
+	}
+}
+
+// API endpoint to reject a held message, telling its author if they are
+// still connected (moderators only)
+func (h *Handler) RejectHeldMessage(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := h.GetCurrentUser(c)
+		if user == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		chatroom, ok := h.findModeratedRoom(c, user)
+		if !ok {
+			return
+		}
+
+		held, ok := h.reviewHeldMessage(c, chatroom, user, models.HeldRejected)
+		if !ok {
+			return
+		}
+
+		discardAttachments(h.db, h.blobs, heldAttachments(held))
+		frame := &Message{ChatroomID: held.ChatroomID, Nonce: held.ClientNonce}
+		hub.sendToUser(held.ChatroomID, held.UserID, rejectedFrame(frame, "rejected by a moderator"))
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":      true,
+			"held_message": held,
+		})
+	}
+}
+
+// reviewHeldMessage moves a pending held message to status. Only one
+// moderator's review succeeds if several act at once.
+func (h *Handler) reviewHeldMessage(c *gin.Context, chatroom *models.Chatroom, user *models.User, status string) (*models.HeldMessage, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid held message id"})
+		return nil, false
+	}
+
+	now := time.Now()
+	result := h.db.Model(&models.HeldMessage{}).
+		Where("id = ? AND chatroom_id = ? AND status = ?", id, chatroom.ID, models.HeldPending).
+		Updates(map[string]interface{}{"status": status, "reviewed_by_id": user.ID, "reviewed_at": now})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to review held message"})
+		return nil, false
+	}
+
+	var held models.HeldMessage
+	if err := h.db.Where("id = ? AND chatroom_id = ?", id, chatroom.ID).Preload("User").First(&held).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "held message not found"})
+		return nil, false
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "held message was already " + held.Status})
+		return nil, false
+	}
+
+	action := models.AuditHeldApprove
+	if status == models.HeldRejected {
+		action = models.AuditHeldReject
+	}
+	audit(h.db, auditEntry{
+		chatroomID:   chatroom.ID,
+		actorID:      user.ID,
+		action:       action,
+		targetUserID: held.UserID,
+		targetType:   "held_message",
+		targetID:     held.ID,
+		before:       gin.H{"status": models.HeldPending, "content": held.Content, "filter": held.Filter, "reason": held.Reason},
+		after:        gin.H{"status": status},
+	})
+	return &held, true
+}
+
+func heldAttachments(held *models.HeldMessage) []AttachmentInfo {
+	if held.Attachments == "" {
+		return nil
+	}
+	var infos []AttachmentInfo
+	if err := json.Unmarshal([]byte(held.Attachments), &infos); err != nil {
+		log.Printf("Invalid attachments on held message %d: %v", held.ID, err)
+		return nil
+	}
+	return infos
+}
+
+// findModeratedRoom loads the room in the URL if the user moderates it
+func (h *Handler) findModeratedRoom(c *gin.Context, user *models.User) (*models.Chatroom, bool) {
+	var chatroom models.Chatroom
+	if err := h.db.Where("code = ?", c.Param("code")).First(&chatroom).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+		return nil, false
+	}
+
+	if !chatroom.HasRole(h.db, user.ID, models.RoleModerator) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only moderators can moderate this room"})
+		return nil, false
+	}
+
+	return &chatroom, true
+}
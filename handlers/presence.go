@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+// Presence states. A user is online if any of their connections is active,
+// away if every connection reports away, and offline with no connections.
+const (
+	PresenceOnline  = "online"
+	PresenceAway    = "away"
+	PresenceOffline = "offline"
+)
+
+// presenceEvent is sent by a client's read goroutine when its tab becomes
+// hidden or active again
+type presenceEvent struct {
+	client   *Client
+	away     bool
+	username string
+}
+
+// Presence returns a user's current presence. Safe to call from any goroutine.
+func (h *Hub) Presence(userID uint) string {
+	h.presenceMu.RLock()
+	defer h.presenceMu.RUnlock()
+	if status, ok := h.presence[userID]; ok {
+		return status
+	}
+	return PresenceOffline
+}
+
+// userStatus aggregates a user's connections. Must only be called from Run.
+func (h *Hub) userStatus(userID uint) string {
+	conns := h.userConns[userID]
+	if len(conns) == 0 {
+		return PresenceOffline
+	}
+	for client := range conns {
+		if !client.away {
+			return PresenceOnline
+		}
+	}
+	return PresenceAway
+}
+
+// refreshPresence recomputes a user's presence and, if it changed, tells
+// every room they belong to. Must only be called from Run.
+func (h *Hub) refreshPresence(userID uint) {
+	status := h.userStatus(userID)
+
+	h.presenceMu.Lock()
+	previous, ok := h.presence[userID]
+	if !ok {
+		previous = PresenceOffline
+	}
+	if status == PresenceOffline {
+		delete(h.presence, userID)
+	} else {
+		h.presence[userID] = status
+	}
+	h.presenceMu.Unlock()
+
+	if status == previous {
+		return
+	}
+
+	username := h.usernames[userID]
+	if status == PresenceOffline {
+		delete(h.usernames, userID)
+	}
+
+	var chatroomIDs []uint
+	if err := h.db.Model(&models.Membership{}).Where("user_id = ?", userID).Pluck("chatroom_id", &chatroomIDs).Error; err != nil {
+		log.Printf("Failed to load rooms for presence of user %d: %v", userID, err)
+		return
+	}
+
+	for _, chatroomID := range chatroomIDs {
+		if _, connected := h.chatrooms[chatroomID]; !connected {
+			continue
+		}
+		h.broadcastToChatroom(chatroomID, &Message{
+			Type:       "presence",
+			Content:    status,
+			UserID:     userID,
+			Username:   username,
+			ChatroomID: chatroomID,
+			Timestamp:  time.Now(),
+		})
+	}
+}
+
+// API endpoint listing each member of a room with their presence
+func (h *Handler) GetRoomPresence(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := h.GetCurrentUser(c)
+		if user == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		var chatroom models.Chatroom
+		if err := h.db.Where("code = ?", c.Param("code")).First(&chatroom).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+			return
+		}
+
+		if !chatroom.IsMember(h.db, user.ID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not a member of this room"})
+			return
+		}
+
+		var memberships []models.Membership
+		if err := h.db.Where("chatroom_id = ?", chatroom.ID).
+			Preload("User").
+			Order("joined_at ASC").
+			Find(&memberships).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get members"})
+			return
+		}
+
+		type PresenceInfo struct {
+			ID       uint   `json:"id"`
+			Username string `json:"username"`
+			Status   string `json:"status"`
+		}
+
+		presence := []PresenceInfo{}
+		online := 0
+		for _, membership := range memberships {
+			status := hub.Presence(membership.UserID)
+			if status != PresenceOffline {
+				online++
+			}
+			presence = append(presence, PresenceInfo{
+				ID:       membership.User.ID,
+				Username: membership.User.Username,
+				Status:   status,
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"presence": presence,
+			"online":   online,
+			"count":    len(presence),
+		})
+	}
+}
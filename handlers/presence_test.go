@@ -0,0 +1,99 @@
+package handlers_test
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/jeffasante/chatroom.go/internal/chattest"
+)
+
+// settle waits until the hub has handled everything conn sent so far
+func settle(t *testing.T, conn *chattest.Conn) {
+	t.Helper()
+	conn.Send(map[string]string{"type": "message", "content": "/help"})
+	conn.Await(chattest.OfType("command_response"))
+}
+
+func TestPresenceAcrossConnections(t *testing.T) {
+	srv := chattest.New(t)
+	alice := srv.SignUp(t, "alice")
+	bob := srv.SignUp(t, "bob")
+	code := srv.CreateRoom(t, alice, "general", "secret")
+	srv.JoinRoom(t, bob, code, "secret")
+	bobConn := srv.Connect(t, bob, code)
+
+	phone := srv.Connect(t, alice, code)
+	settle(t, phone)
+	laptop := srv.Connect(t, alice, code)
+	settle(t, laptop)
+
+	// alice is away only once every connection is
+	phone.Send(map[string]string{"type": "presence", "content": "away"})
+	settle(t, phone)
+	laptop.Send(map[string]string{"type": "presence", "content": "away"})
+	settle(t, laptop)
+	phone.Send(map[string]string{"type": "presence", "content": "online"})
+	settle(t, phone)
+
+	// and offline once the last one closes
+	var changes []string
+	collect := func(until func(chattest.Frame) bool) {
+		for {
+			frame := bobConn.Next()
+			if frame.Type == "presence" && frame.UserID == alice.ID {
+				changes = append(changes, frame.Content)
+			}
+			if until(frame) {
+				return
+			}
+		}
+	}
+	laptop.Close()
+	collect(chattest.OfType("user_left"))
+	phone.Close()
+	collect(func(f chattest.Frame) bool { return f.Type == "presence" && f.Content == "offline" })
+
+	want := []string{"online", "away", "online", "offline"}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("bob saw alice go %v, want %v", changes, want)
+	}
+}
+
+func TestRoomPresence(t *testing.T) {
+	srv := chattest.New(t)
+	alice := srv.SignUp(t, "alice")
+	bob := srv.SignUp(t, "bob")
+	carol := srv.SignUp(t, "carol")
+	code := srv.CreateRoom(t, alice, "general", "secret")
+	srv.JoinRoom(t, bob, code, "secret")
+	settle(t, srv.Connect(t, alice, code))
+
+	type presence struct {
+		Presence []struct {
+			Username string `json:"username"`
+			Status   string `json:"status"`
+		} `json:"presence"`
+		Online int `json:"online"`
+		Count  int `json:"count"`
+	}
+	var got presence
+	if status := srv.Do(t, bob, http.MethodGet, "/api/room/"+code+"/presence", nil, &got); status != http.StatusOK {
+		t.Fatalf("presence: %d", status)
+	}
+	statuses := map[string]string{}
+	for _, p := range got.Presence {
+		statuses[p.Username] = p.Status
+	}
+	if got.Online != 1 || got.Count != 2 || statuses["alice"] != "online" || statuses["bob"] != "offline" {
+		t.Errorf("presence %+v", got)
+	}
+
+	// Only members can see who is around
+	if status := srv.Do(t, carol, http.MethodGet, "/api/room/"+code+"/presence", nil, nil); status != http.StatusForbidden {
+		t.Errorf("non-member: %d, want 403", status)
+	}
+	if status := srv.Do(t, carol, http.MethodGet, "/api/room/nope/presence", nil, nil); status != http.StatusNotFound {
+		t.Errorf("unknown room: %d, want 404", status)
+	}
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"expvar"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Rate limits, as a sustained rate per second and the burst allowed on top.
+// Frames are counted per connection; messages and commands per user across
+// all their connections.
+var (
+	ConnectionFrameRate  = 10.0
+	ConnectionFrameBurst = 20
+
+	UserMessageRate  = 1.0
+	UserMessageBurst = 5
+)
+
+// maxSlowMode caps the slow mode interval a moderator can set
+const maxSlowMode = time.Hour
+
+const errorRateLimited = "rate_limited"
+
+// Reasons a message was throttled, used as keys of the throttled metric
+const (
+	throttledConnection = "connection"
+	throttledUser       = "user"
+	throttledSlowMode   = "slow_mode"
+)
+
+// throttled counts refused frames by reason, published at /debug/vars
+var throttled = expvar.NewMap("messages_throttled")
+
+// tokenBucket allows burst events at once and rate events per second after
+// that. The zero value starts full.
+type tokenBucket struct {
+	tokens  float64
+	last    time.Time
+	started bool
+}
+
+// take uses up a token if there is one, otherwise it says how long until
+// there will be
+func (b *tokenBucket) take(now time.Time, rate float64, burst int) (bool, time.Duration) {
+	if !b.started {
+		b.tokens, b.last, b.started = float64(burst), now, true
+	}
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// full reports whether the bucket has refilled, so forgetting it changes nothing
+func (b *tokenBucket) full(now time.Time, rate float64, burst int) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*rate >= float64(burst)
+}
+
+type slowModeKey struct {
+	chatroomID uint
+	userID     uint
+}
+
+// rateLimiter holds the limits shared between a user's connections
+type rateLimiter struct {
+	mu        sync.Mutex
+	users     map[uint]*tokenBucket
+	lastPost  map[slowModeKey]time.Time
+	lastSweep time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		users:    make(map[uint]*tokenBucket),
+		lastPost: make(map[slowModeKey]time.Time),
+	}
+}
+
+// allowUser takes a token from the user's message bucket
+func (l *rateLimiter) allowUser(userID uint, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	bucket, ok := l.users[userID]
+	if !ok {
+		bucket = &tokenBucket{}
+		l.users[userID] = bucket
+	}
+	return bucket.take(now, UserMessageRate, UserMessageBurst)
+}
+
+// allowSlowMode lets a user post in a slow mode room if interval has passed
+// since their last message there
+func (l *rateLimiter) allowSlowMode(chatroomID, userID uint, interval time.Duration, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := slowModeKey{chatroomID: chatroomID, userID: userID}
+	if wait := interval - now.Sub(l.lastPost[key]); wait > 0 {
+		return false, wait
+	}
+	l.lastPost[key] = now
+	return true, 0
+}
+
+// sweep forgets state that no longer limits anyone, at most once a minute.
+// Must be called with mu held.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	for userID, bucket := range l.users {
+		if bucket.full(now, UserMessageRate, UserMessageBurst) {
+			delete(l.users, userID)
+		}
+	}
+	for key, last := range l.lastPost {
+		if now.Sub(last) > maxSlowMode {
+			delete(l.lastPost, key)
+		}
+	}
+}
+
+// rateLimitedFrame tells a client to wait before sending again
+func rateLimitedFrame(message *Message, reason string, wait time.Duration) *Message {
+	throttled.Add(reason, 1)
+
+	text := "You're sending messages too fast"
+	if reason == throttledSlowMode {
+		text = "Slow mode is on in this room"
+	}
+	frame := errorFrame(message, errorRateLimited, fmt.Sprintf("%s, try again in %gs", text, math.Ceil(wait.Seconds()*10)/10))
+	// Rounded up to the millisecond so retrying on time always succeeds
+	frame.RetryAfter = math.Ceil(wait.Seconds()*1000) / 1000
+	return frame
+}
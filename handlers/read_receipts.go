@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+// readReceiptMaxMembers is the largest room that gets read-by lists
+// broadcast; in bigger rooms they would be noisy and expensive
+const readReceiptMaxMembers = 20
+
+var errMessageNotInRoom = errors.New("message not found in this room")
+
+// markRead moves the user's read position in a room forward to messageID,
+// or to the newest message if messageID is 0, and tells small rooms who has
+// now read it. Read positions never move backwards.
+func (h *Hub) markRead(chatroomID, userID uint, username string, messageID uint) (uint, error) {
+	var message models.Message
+	query := h.db.Where("chatroom_id = ?", chatroomID)
+	if messageID != 0 {
+		query = query.Where("id = ?", messageID)
+	} else {
+		query = query.Order("id DESC")
+	}
+	if err := query.First(&message).Error; err != nil {
+		return 0, errMessageNotInRoom
+	}
+
+	result := h.db.Model(&models.Membership{}).
+		Where("user_id = ? AND chatroom_id = ? AND last_read_message_id < ?", userID, chatroomID, message.ID).
+		Update("last_read_message_id", message.ID)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	// Nothing changed, e.g. a duplicate or stale read frame
+	if result.RowsAffected == 0 {
+		var membership models.Membership
+		if err := h.db.Where("user_id = ? AND chatroom_id = ?", userID, chatroomID).First(&membership).Error; err != nil {
+			return 0, err
+		}
+		return membership.LastReadMessageID, nil
+	}
+
+	var memberCount int64
+	h.db.Model(&models.Membership{}).Where("chatroom_id = ?", chatroomID).Count(&memberCount)
+	if memberCount > readReceiptMaxMembers {
+		return message.ID, nil
+	}
+
+	readBy, err := h.readBy(chatroomID, message.ID)
+	if err != nil {
+		return message.ID, nil
+	}
+
+	h.broadcast <- &Message{
+		Type:       "read_receipt",
+		UserID:     userID,
+		Username:   username,
+		ChatroomID: chatroomID,
+		Timestamp:  time.Now(),
+		MessageID:  message.ID,
+		ReadBy:     readBy,
+	}
+	return message.ID, nil
+}
+
+// readBy lists the usernames of members who have read up to messageID
+func (h *Hub) readBy(chatroomID, messageID uint) ([]string, error) {
+	var usernames []string
+	err := h.db.Table("memberships").
+		Select("users.username").
+		Joins("JOIN users ON users.id = memberships.user_id").
+		Where("memberships.chatroom_id = ? AND memberships.last_read_message_id >= ?", chatroomID, messageID).
+		Order("users.username ASC").
+		Pluck("users.username", &usernames).Error
+	return usernames, err
+}
+
+// API endpoint to mark messages in a room as read
+func (h *Handler) MarkRead(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := h.GetCurrentUser(c)
+		if user == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		var chatroom models.Chatroom
+		if err := h.db.Where("code = ?", c.Param("code")).First(&chatroom).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+			return
+		}
+
+		if !chatroom.IsMember(h.db, user.ID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not a member"})
+			return
+		}
+
+		var messageID uint64
+		if raw := c.PostForm("message_id"); raw != "" {
+			var err error
+			messageID, err = strconv.ParseUint(raw, 10, 32)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message_id"})
+				return
+			}
+		}
+
+		lastRead, err := hub.markRead(chatroom.ID, user.ID, user.Username, uint(messageID))
+		if err == errMessageNotInRoom {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to mark as read"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":              true,
+			"last_read_message_id": lastRead,
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jeffasante/chatroom.go/internal/chattest"
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+// readRoom creates a room owned by alice that bob has joined, holding two
+// messages from alice
+func readRoom(t *testing.T) (srv *chattest.Server, chatroom *models.Chatroom, alice, bob *chattest.User, first, second uint) {
+	t.Helper()
+	srv = chattest.New(t)
+	alice = srv.SignUp(t, "alice")
+	bob = srv.SignUp(t, "bob")
+	code := srv.CreateRoom(t, alice, "general", "secret")
+	srv.JoinRoom(t, bob, code, "secret")
+
+	chatroom = &models.Chatroom{}
+	srv.DB.Where("code = ?", code).First(chatroom)
+	for i, content := range []string{"first", "second"} {
+		message := models.Message{Content: content, UserID: alice.ID, ChatroomID: chatroom.ID}
+		if err := srv.DB.Create(&message).Error; err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			first = message.ID
+		} else {
+			second = message.ID
+		}
+	}
+	return srv, chatroom, alice, bob, first, second
+}
+
+// markRead posts to the read endpoint, with no message_id if id is 0
+func markRead(t *testing.T, srv *chattest.Server, user *chattest.User, code string, id uint) (int, uint) {
+	t.Helper()
+	form := url.Values{}
+	if id != 0 {
+		form.Set("message_id", strconv.FormatUint(uint64(id), 10))
+	}
+	var result struct {
+		LastRead uint `json:"last_read_message_id"`
+	}
+	status := srv.Do(t, user, http.MethodPost, "/api/room/"+code+"/read", form, &result)
+	return status, result.LastRead
+}
+
+// readBy decodes the read_by list of a read_receipt frame
+func readBy(frame chattest.Frame) []string {
+	var receipt struct {
+		ReadBy []string `json:"read_by"`
+	}
+	json.Unmarshal(frame.Raw, &receipt)
+	return receipt.ReadBy
+}
+
+func TestReadPositionNeverMovesBack(t *testing.T) {
+	srv, chatroom, _, bob, first, second := readRoom(t)
+
+	if status, last := markRead(t, srv, bob, chatroom.Code, second); status != http.StatusOK || last != second {
+		t.Fatalf("marking the second message read: %d, %d", status, last)
+	}
+	if status, last := markRead(t, srv, bob, chatroom.Code, first); status != http.StatusOK || last != second {
+		t.Errorf("marking an older message read: %d, moved to %d", status, last)
+	}
+
+	var membership models.Membership
+	srv.DB.Where("user_id = ? AND chatroom_id = ?", bob.ID, chatroom.ID).First(&membership)
+	if membership.LastReadMessageID != second {
+		t.Errorf("stored position %d, want %d", membership.LastReadMessageID, second)
+	}
+}
+
+func TestMarkReadEndpoint(t *testing.T) {
+	srv, chatroom, _, bob, _, second := readRoom(t)
+
+	// Without a message_id everything up to the newest message is read
+	if status, last := markRead(t, srv, bob, chatroom.Code, 0); status != http.StatusOK || last != second {
+		t.Errorf("marking the room read: %d, %d", status, last)
+	}
+
+	// A message from another room doesn't count
+	carol := srv.SignUp(t, "carol")
+	otherCode := srv.CreateRoom(t, carol, "other", "secret")
+	var other models.Chatroom
+	srv.DB.Where("code = ?", otherCode).First(&other)
+	elsewhere := models.Message{Content: "elsewhere", UserID: carol.ID, ChatroomID: other.ID}
+	srv.DB.Create(&elsewhere)
+	if status, _ := markRead(t, srv, bob, chatroom.Code, elsewhere.ID); status != http.StatusNotFound {
+		t.Errorf("message from another room: %d, want 404", status)
+	}
+
+	if status, _ := markRead(t, srv, carol, chatroom.Code, 0); status != http.StatusForbidden {
+		t.Errorf("non-member: %d, want 403", status)
+	}
+	if status := srv.Do(t, bob, http.MethodPost, "/api/room/"+chatroom.Code+"/read", url.Values{"message_id": {"x"}}, nil); status != http.StatusBadRequest {
+		t.Errorf("invalid message_id: %d, want 400", status)
+	}
+}
+
+func TestReadReceipts(t *testing.T) {
+	srv, chatroom, alice, bob, first, second := readRoom(t)
+	aliceConn := srv.Connect(t, alice, chatroom.Code)
+	bobConn := srv.Connect(t, bob, chatroom.Code)
+
+	// Receipts carry the reader's current name
+	bobConn.Send(map[string]string{"type": "message", "content": "/nick bobby"})
+	aliceConn.Await(chattest.OfType("user_renamed"))
+
+	bobConn.Send(map[string]interface{}{"type": "read", "message_id": first})
+	frame := aliceConn.Await(chattest.OfType("read_receipt"))
+	if frame.MessageID != first || frame.UserID != bob.ID || frame.Username != "bobby" {
+		t.Errorf("read_receipt %+v", frame)
+	}
+	if got := readBy(frame); len(got) != 1 || got[0] != "bobby" {
+		t.Errorf("read_by %v, want [bobby]", got)
+	}
+
+	// A message from another room is ignored
+	bobConn.Send(map[string]interface{}{"type": "read", "message_id": second + 100})
+	bobConn.Send(map[string]interface{}{"type": "read", "message_id": second})
+	if frame := aliceConn.Await(chattest.OfType("read_receipt")); frame.MessageID != second {
+		t.Errorf("read_receipt for %d, want %d", frame.MessageID, second)
+	}
+}
+
+func TestNoReceiptsInLargeRooms(t *testing.T) {
+	srv, chatroom, alice, bob, _, second := readRoom(t)
+	aliceConn := srv.Connect(t, alice, chatroom.Code)
+
+	// Fill the room past the receipt limit of 20 members
+	for i := 0; i < 19; i++ {
+		member := models.User{Username: fmt.Sprintf("member%d", i), Email: fmt.Sprintf("member%d@example.com", i), Password: "x"}
+		srv.DB.Create(&member)
+		srv.DB.Create(&models.Membership{UserID: member.ID, ChatroomID: chatroom.ID, Role: models.RoleMember})
+	}
+
+	if status, _ := markRead(t, srv, bob, chatroom.Code, second); status != http.StatusOK {
+		t.Fatalf("mark read: %d", status)
+	}
+	if !aliceConn.Quiet(500*time.Millisecond, chattest.OfType("read_receipt")) {
+		t.Error("read receipt sent in a room of 21 members")
+	}
+}
+
+func TestUnreadCounts(t *testing.T) {
+	srv, chatroom, alice, bob, first, _ := readRoom(t)
+
+	unread := func(user *chattest.User) int64 {
+		t.Helper()
+		account := models.User{ID: user.ID}
+		counts, err := account.UnreadCounts(srv.DB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return counts[chatroom.ID]
+	}
+
+	if n := unread(bob); n != 2 {
+		t.Errorf("bob has %d unread, want 2", n)
+	}
+	// Your own messages are never unread
+	if n := unread(alice); n != 0 {
+		t.Errorf("alice has %d unread, want 0", n)
+	}
+
+	markRead(t, srv, bob, chatroom.Code, first)
+	if n := unread(bob); n != 1 {
+		t.Errorf("bob has %d unread after reading the first message, want 1", n)
+	}
+	markRead(t, srv, bob, chatroom.Code, 0)
+	if n := unread(bob); n != 0 {
+		t.Errorf("bob has %d unread after reading everything, want 0", n)
+	}
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+// replayLimit caps how many missed messages are replayed to a reconnecting
+// client. It is below the send buffer size so a replay never overflows it.
+const replayLimit = 200
+
+// resumeRequest is sent when a connected client asks for messages it missed
+type resumeRequest struct {
+	client        *Client
+	lastMessageID uint
+}
+
+// replayBatch carries the missed messages loaded for a client back to Run
+type replayBatch struct {
+	client        *Client
+	lastMessageID uint
+	messages      []models.Message
+	resync        bool // more than replayLimit were missed
+	err           error
+}
+
+// startReplay loads the persisted messages after lastMessageID off the Run
+// goroutine. Until they arrive, live frames for the client are held back
+// so none can be interleaved with the replay. Must only be called from Run.
+func (h *Hub) startReplay(client *Client, lastMessageID uint) {
+	if client.replaying {
+		return
+	}
+	client.replaying = true
+
+	go func() {
+		batch := &replayBatch{client: client, lastMessageID: lastMessageID}
+		batch.messages, batch.resync, batch.err = loadMissed(h.db, client.chatroomID, lastMessageID)
+		h.replays <- batch
+	}()
+}
+
+// loadMissed returns the messages in a room after lastMessageID, or
+// resync if there are more than replayLimit of them
+func loadMissed(db *gorm.DB, chatroomID, lastMessageID uint) ([]models.Message, bool, error) {
+	var missed int64
+	if err := db.Model(&models.Message{}).
+		Where("chatroom_id = ? AND id > ?", chatroomID, lastMessageID).
+		Count(&missed).Error; err != nil {
+		return nil, false, err
+	}
+	if missed > replayLimit {
+		return nil, true, nil
+	}
+
+	var messages []models.Message
+	if err := db.Where("chatroom_id = ? AND id > ?", chatroomID, lastMessageID).
+		Preload("User").
+		Preload("Attachments").
+		Order("id ASC").
+		Find(&messages).Error; err != nil {
+		return nil, false, err
+	}
+	attachPreviews(db, messages)
+	return messages, false, nil
+}
+
+// finishReplay sends a client its missed messages, or a resync_required
+// frame if it missed more than replayLimit and should reload instead, then
+// the live frames held back while they were loading. Must only be called
+// from Run.
+func (h *Hub) finishReplay(batch *replayBatch) {
+	client := batch.client
+	if _, ok := h.clients[client]; !ok {
+		return
+	}
+	client.replaying = false
+	held := client.held
+	client.held = nil
+
+	lastID := batch.lastMessageID
+	switch {
+	case batch.err != nil:
+		log.Printf("Failed to load missed messages: %v", batch.err)
+
+	case batch.resync:
+		if !h.sendOrDrop(client, &Message{
+			Type:       "resync_required",
+			Content:    "Too many messages were missed while disconnected, reload to catch up",
+			Username:   "System",
+			ChatroomID: client.chatroomID,
+			Timestamp:  time.Now(),
+		}) {
+			return
+		}
+
+	default:
+		for _, m := range batch.messages {
+			lastID = m.ID
+			if client.blocked[m.UserID] {
+				continue
+			}
+			frame := &Message{
+				Type:       m.Type,
+				Content:    m.Content,
+				UserID:     m.UserID,
+				Username:   m.User.Username,
+				ChatroomID: m.ChatroomID,
+				Timestamp:  m.CreatedAt,
+				MessageID:  m.ID,
+				IsBot:      m.User.IsBot,
+				Seq:        m.Seq,
+				Replayed:   true,
+
+				Attachments: attachmentInfos(m.Attachments),
+				Previews:    m.Previews,
+				HTML:        string(m.HTML),
+				Nonce:       m.ClientNonce,
+			}
+			if !h.sendOrDrop(client, client.frameFor(frame)) {
+				return
+			}
+		}
+		if !h.sendOrDrop(client, &Message{
+			Type:       "replay_complete",
+			ChatroomID: client.chatroomID,
+			Timestamp:  time.Now(),
+			MessageID:  lastID,
+		}) {
+			return
+		}
+	}
+
+	for _, message := range held {
+		// Saved while the replay was loading, so it has already been sent
+		if (message.Type == "message" || message.Type == "action") && message.MessageID <= lastID {
+			continue
+		}
+		if !h.sendOrDrop(client, message) {
+			return
+		}
+	}
+}
+
+// frameFor returns message as client should see it. A message's nonce is
+// only sent back to its author.
+func (c *Client) frameFor(message *Message) *Message {
+	if message.Nonce == "" || message.UserID == c.user.ID {
+		return message
+	}
+	stripped := *message
+	stripped.Nonce = ""
+	return &stripped
+}
+
+// sendOrDrop queues a message for one client, disconnecting it if its
+// buffer is full. Must only be called from Run.
+func (h *Hub) sendOrDrop(client *Client, message *Message) bool {
+	if client.queue(message) {
+		return true
+	}
+	h.dropClient(client)
+	h.refreshPresence(client.user.ID)
+	return false
+}
+
+// queue adds a message to the client's send buffer, or holds it back while
+// a replay is loading. It returns false if the buffer is full. Must only be
+// called from Run.
+func (c *Client) queue(message *Message) bool {
+	if c.replaying {
+		if len(c.held) >= cap(c.send) {
+			return false
+		}
+		c.held = append(c.held, message)
+		return true
+	}
+	select {
+	case c.send <- message:
+		return true
+	default:
+		return false
+	}
+}
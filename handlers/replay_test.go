@@ -0,0 +1,106 @@
+package handlers_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeffasante/chatroom.go/internal/chattest"
+)
+
+// send posts a message with a nonce and waits for it to be acknowledged
+func send(t *testing.T, conn *chattest.Conn, content, nonce string) uint {
+	t.Helper()
+	conn.Send(map[string]string{"type": "message", "content": content, "nonce": nonce})
+	ack := conn.Await(func(f chattest.Frame) bool { return f.Type == "ack" && f.Nonce == nonce })
+	if ack.Error != "" {
+		t.Fatalf("sending %q: %s", content, ack.Error)
+	}
+	return ack.MessageID
+}
+
+// replayed collects the replayed messages up to replay_complete
+func replayed(t *testing.T, conn *chattest.Conn) []chattest.Frame {
+	t.Helper()
+	var frames []chattest.Frame
+	for {
+		frame := conn.Next()
+		switch frame.Type {
+		case "replay_complete":
+			return frames
+		case "message":
+			frames = append(frames, frame)
+		}
+	}
+}
+
+func TestNonceOnlyReachesAuthor(t *testing.T) {
+	srv := chattest.New(t)
+	alice := srv.SignUp(t, "alice")
+	bob := srv.SignUp(t, "bob")
+	code := srv.CreateRoom(t, alice, "general", "secret")
+	srv.JoinRoom(t, bob, code, "secret")
+
+	aliceConn := srv.Connect(t, alice, code)
+	bobConn := srv.Connect(t, bob, code)
+	aliceConn.Send(map[string]string{"type": "message", "content": "first", "nonce": "nonce-1"})
+
+	isFirst := func(f chattest.Frame) bool { return f.Type == "message" && f.Content == "first" }
+	frame := aliceConn.Await(isFirst)
+	if frame.Nonce != "nonce-1" {
+		t.Errorf("author got nonce %q", frame.Nonce)
+	}
+	firstID := frame.MessageID
+	if frame := bobConn.Await(isFirst); frame.Nonce != "" {
+		t.Errorf("other member got nonce %q", frame.Nonce)
+	}
+
+	bobConn.Close()
+	send(t, aliceConn, "second", "nonce-2")
+	lastID := send(t, aliceConn, "third", "nonce-3")
+
+	frames := replayed(t, srv.Resume(t, bob, code, firstID))
+	if len(frames) != 2 || frames[0].Content != "second" || frames[1].Content != "third" {
+		t.Fatalf("bob's replay = %+v", frames)
+	}
+	for _, frame := range frames {
+		if frame.Nonce != "" {
+			t.Errorf("replay of %q to another member has nonce %q", frame.Content, frame.Nonce)
+		}
+	}
+
+	frames = replayed(t, srv.Resume(t, alice, code, firstID))
+	if len(frames) != 2 || frames[0].Nonce != "nonce-2" || frames[1].Nonce != "nonce-3" || frames[1].MessageID != lastID {
+		t.Errorf("alice's replay = %+v", frames)
+	}
+}
+
+func TestReplayBeforeLiveMessages(t *testing.T) {
+	srv := chattest.New(t)
+	alice := srv.SignUp(t, "alice")
+	bob := srv.SignUp(t, "bob")
+	code := srv.CreateRoom(t, alice, "general", "secret")
+	srv.JoinRoom(t, bob, code, "secret")
+
+	aliceConn := srv.Connect(t, alice, code)
+	seenID := send(t, aliceConn, "seen", "nonce-1")
+	send(t, aliceConn, "missed", "nonce-2")
+
+	bobConn := srv.Resume(t, bob, code, seenID)
+	send(t, aliceConn, "live", "nonce-3")
+
+	// The live message may have been saved before or after the replay was
+	// loaded, but it must arrive after the replay, and only once
+	var contents []string
+	for len(contents) < 2 {
+		frame := bobConn.Next()
+		if frame.Type == "message" {
+			contents = append(contents, frame.Content)
+		}
+	}
+	if contents[0] != "missed" || contents[1] != "live" {
+		t.Errorf("bob received %v", contents)
+	}
+	if !bobConn.Quiet(200*time.Millisecond, func(f chattest.Frame) bool { return f.Type == "message" }) {
+		t.Error("a message was delivered twice")
+	}
+}
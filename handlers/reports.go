@@ -0,0 +1,393 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+const (
+	maxReportDetails = 1000
+
+	// reportContextSize is how many messages are shown either side of a
+	// reported message, or from a reported user
+	reportContextSize = 3
+)
+
+// reportView is a report with the messages a moderator needs to judge it
+type reportView struct {
+	models.Report
+	Context []models.Message `json:"context"`
+}
+
+// API endpoint to report a message (message_id) or a user (username) to
+// the room's moderators
+func (h *Handler) CreateReport(c *gin.Context) {
+	user := h.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var chatroom models.Chatroom
+	if err := h.db.Where("code = ?", c.Param("code")).First(&chatroom).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+		return
+	}
+	if !chatroom.IsMember(h.db, user.ID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not a member of this room"})
+		return
+	}
+
+	reason := c.PostForm("reason")
+	if !validReportReason(reason) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason must be one of spam, harassment, hate, sexual, violence or other"})
+		return
+	}
+	details := c.PostForm("details")
+	if utf8.RuneCountInString(details) > maxReportDetails {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "details can be at most 1000 characters"})
+		return
+	}
+
+	report := models.Report{
+		ChatroomID: chatroom.ID,
+		ReporterID: user.ID,
+		Reason:     reason,
+		Details:    details,
+		Status:     models.ReportOpen,
+	}
+
+	if id := c.PostForm("message_id"); id != "" {
+		messageID, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+			return
+		}
+		var message models.Message
+		if err := h.db.Where("id = ? AND chatroom_id = ?", messageID, chatroom.ID).First(&message).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+			return
+		}
+		report.MessageID = message.ID
+		report.TargetUserID = message.UserID
+		report.MessageContent = message.Content
+	} else {
+		var target models.User
+		if err := h.db.Where("username = ?", c.PostForm("username")).First(&target).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "message_id or the username of a member is required"})
+			return
+		}
+		if !chatroom.IsMember(h.db, target.ID) {
+			c.JSON(http.StatusNotFound, gin.H{"error": target.Username + " is not a member of this room"})
+			return
+		}
+		report.TargetUserID = target.ID
+	}
+
+	if report.TargetUserID == user.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "you cannot report yourself"})
+		return
+	}
+
+	var existing int64
+	h.db.Model(&models.Report{}).
+		Where("reporter_id = ? AND chatroom_id = ? AND target_user_id = ? AND message_id = ? AND status = ?",
+			user.ID, chatroom.ID, report.TargetUserID, report.MessageID, models.ReportOpen).
+		Count(&existing)
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "you have already reported this"})
+		return
+	}
+
+	if err := h.db.Create(&report).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save report"})
+		return
+	}
+	h.db.Preload("Reporter").Preload("TargetUser").First(&report, report.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"report":  report,
+	})
+}
+
+func validReportReason(reason string) bool {
+	for _, r := range models.ReportReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// API endpoint to list a room's reports with context, oldest first
+// (moderators only). ?status= is open (the default), resolved or dismissed.
+func (h *Handler) ListReports(c *gin.Context) {
+	user := h.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	chatroom, ok := h.findModeratedRoom(c, user)
+	if !ok {
+		return
+	}
+
+	status := c.DefaultQuery("status", models.ReportOpen)
+	if status != models.ReportOpen && status != models.ReportResolved && status != models.ReportDismissed {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be open, resolved or dismissed"})
+		return
+	}
+
+	reports, err := h.roomReports(chatroom.ID, status)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get reports"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reports": reports,
+		"count":   len(reports),
+	})
+}
+
+func (h *Handler) roomReports(chatroomID uint, status string) ([]reportView, error) {
+	var reports []models.Report
+	query := h.db.Where("chatroom_id = ? AND status = ?", chatroomID, status).
+		Preload("Reporter").
+		Preload("TargetUser").
+		Preload("ResolvedBy").
+		Limit(200)
+	// Open reports are worked through oldest first; past ones newest first
+	if status == models.ReportOpen {
+		query = query.Order("id ASC")
+	} else {
+		query = query.Order("resolved_at DESC")
+	}
+	if err := query.Find(&reports).Error; err != nil {
+		return nil, err
+	}
+
+	views := make([]reportView, len(reports))
+	for i, report := range reports {
+		views[i] = reportView{Report: report, Context: h.reportContext(&report)}
+	}
+	return views, nil
+}
+
+// reportContext returns the messages around a reported message, or the
+// reported user's latest messages in the room
+func (h *Handler) reportContext(report *models.Report) []models.Message {
+	var messages []models.Message
+	if report.MessageID != 0 {
+		var reported models.Message
+		if err := h.db.Select("id", "seq").First(&reported, report.MessageID).Error; err != nil {
+			return []models.Message{}
+		}
+		from := uint64(1)
+		if reported.Seq > reportContextSize {
+			from = reported.Seq - reportContextSize
+		}
+		h.db.Where("chatroom_id = ? AND seq BETWEEN ? AND ?", report.ChatroomID, from, reported.Seq+reportContextSize).
+			Preload("User").
+			Order("seq ASC").
+			Find(&messages)
+		return messages
+	}
+
+	h.db.Where("chatroom_id = ? AND user_id = ?", report.ChatroomID, report.TargetUserID).
+		Preload("User").
+		Order("seq DESC").
+		Limit(reportContextSize * 2).
+		Find(&messages)
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages
+}
+
+// API endpoint to act on a report (moderators only). action is dismiss,
+// delete_message, mute (for minutes, default 60) or ban; note is optional.
+// Acting on a message or user also resolves the other open reports about it.
+func (h *Handler) ResolveReport(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := h.GetCurrentUser(c)
+		if user == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		chatroom, ok := h.findModeratedRoom(c, user)
+		if !ok {
+			return
+		}
+
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid report id"})
+			return
+		}
+		var report models.Report
+		if err := h.db.Where("id = ? AND chatroom_id = ?", id, chatroom.ID).Preload("TargetUser").First(&report).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "report not found"})
+			return
+		}
+		if report.Status != models.ReportOpen {
+			c.JSON(http.StatusConflict, gin.H{"error": "report was already " + report.Status})
+			return
+		}
+
+		action := c.PostForm("action")
+		target := &report.TargetUser
+		// Reports about a moderator are left to the others
+		if target.ID == user.ID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "you cannot resolve a report about yourself"})
+			return
+		}
+		if action != models.ReportActionDismiss && !outranks(h.db, chatroom, user.ID, target.ID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "you cannot act against " + target.Username})
+			return
+		}
+
+		// Which open reports this action settles
+		related := h.db.Where("chatroom_id = ? AND status = ?", chatroom.ID, models.ReportOpen)
+		switch action {
+		case models.ReportActionDismiss:
+			related = related.Where("id = ?", report.ID)
+
+		case models.ReportActionDeleteMessage:
+			if report.MessageID == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "this report is not about a message"})
+				return
+			}
+			var message models.Message
+			err := h.db.Where("id = ? AND chatroom_id = ?", report.MessageID, chatroom.ID).First(&message).Error
+			if err == nil {
+				err = h.deleteMessage(hub, &message, user)
+			} else if errors.Is(err, gorm.ErrRecordNotFound) {
+				err = nil // already gone
+			}
+			if err != nil {
+				log.Printf("Failed to delete reported message %d: %v", report.MessageID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete message"})
+				return
+			}
+			related = related.Where("message_id = ?", report.MessageID)
+
+		case models.ReportActionMute:
+			minutes := 60
+			if m := c.PostForm("minutes"); m != "" {
+				minutes, err = strconv.Atoi(m)
+				if err != nil || minutes <= 0 || time.Duration(minutes)*time.Minute > maxMute {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "minutes must be between 1 and 43200"})
+					return
+				}
+			}
+			until := time.Now().Add(time.Duration(minutes) * time.Minute)
+			if err := hub.muteMember(chatroom, target, user, &until); err != nil {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+			related = related.Where("target_user_id = ?", target.ID)
+
+		case models.ReportActionBan:
+			if !isBanned(h.db, chatroom.ID, target.ID) {
+				if err := hub.removeMember(chatroom, target, user, true, report.Reason); err != nil {
+					log.Printf("Failed to ban user %d from chatroom %d: %v", target.ID, chatroom.ID, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to ban user"})
+					return
+				}
+			}
+			related = related.Where("target_user_id = ?", target.ID)
+
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "action must be dismiss, delete_message, mute or ban"})
+			return
+		}
+
+		status := models.ReportResolved
+		if action == models.ReportActionDismiss {
+			status = models.ReportDismissed
+		}
+		now := time.Now()
+		result := related.Model(&models.Report{}).Updates(map[string]interface{}{
+			"status":          status,
+			"action":          action,
+			"resolution_note": c.PostForm("note"),
+			"resolved_by_id":  user.ID,
+			"resolved_at":     now,
+		})
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to resolve report"})
+			return
+		}
+
+		audit(h.db, auditEntry{
+			chatroomID:   chatroom.ID,
+			actorID:      user.ID,
+			action:       models.AuditReportResolve,
+			targetUserID: target.ID,
+			targetType:   "report",
+			targetID:     report.ID,
+			before:       gin.H{"status": report.Status, "reason": report.Reason},
+			after:        gin.H{"status": status, "action": action, "note": c.PostForm("note"), "resolved": result.RowsAffected},
+		})
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":  true,
+			"action":   action,
+			"resolved": result.RowsAffected,
+		})
+	}
+}
+
+// Page listing a room's open reports and held messages (moderators only)
+func (h *Handler) ShowModerationQueue(c *gin.Context) {
+	user := h.GetCurrentUser(c)
+	if user == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	var chatroom models.Chatroom
+	if err := h.db.Where("code = ?", c.Param("code")).First(&chatroom).Error; err != nil {
+		c.HTML(http.StatusNotFound, "error.html", gin.H{
+			"error": "Room not found",
+		})
+		return
+	}
+	if !chatroom.HasRole(h.db, user.ID, models.RoleModerator) {
+		c.HTML(http.StatusForbidden, "error.html", gin.H{
+			"error": "Only moderators can see the moderation queue",
+		})
+		return
+	}
+
+	reports, err := h.roomReports(chatroom.ID, models.ReportOpen)
+	if err != nil {
+		reports = []reportView{}
+	}
+	var held []models.HeldMessage
+	h.db.Where("chatroom_id = ? AND status = ?", chatroom.ID, models.HeldPending).
+		Preload("User").
+		Order("id ASC").
+		Limit(200).
+		Find(&held)
+
+	c.HTML(http.StatusOK, "moderation.html", gin.H{
+		"user":     user,
+		"chatroom": chatroom,
+		"reports":  reports,
+		"held":     held,
+	})
+}
@@ -0,0 +1,243 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jeffasante/chatroom.go/internal/chattest"
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+// reportFixture is a room owned by alice where bob is a moderator and
+// carol and dave are members, with a message from carol
+type reportFixture struct {
+	srv                     *chattest.Server
+	chatroom                models.Chatroom
+	alice, bob, carol, dave *chattest.User
+	message                 models.Message
+}
+
+func newReportFixture(t *testing.T) *reportFixture {
+	t.Helper()
+	srv := chattest.New(t)
+	f := &reportFixture{
+		srv:   srv,
+		alice: srv.SignUp(t, "alice"),
+		bob:   srv.SignUp(t, "bob"),
+		carol: srv.SignUp(t, "carol"),
+		dave:  srv.SignUp(t, "dave"),
+	}
+	code := srv.CreateRoom(t, f.alice, "general", "secret")
+	for _, user := range []*chattest.User{f.bob, f.carol, f.dave} {
+		srv.JoinRoom(t, user, code, "secret")
+	}
+	srv.DB.Where("code = ?", code).First(&f.chatroom)
+	srv.DB.Model(&models.Membership{}).Where("user_id = ? AND chatroom_id = ?", f.bob.ID, f.chatroom.ID).Update("role", models.RoleModerator)
+
+	f.message = models.Message{Content: "buy cheap watches", Type: "message", UserID: f.carol.ID, ChatroomID: f.chatroom.ID, Seq: 1}
+	if err := srv.DB.Create(&f.message).Error; err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// report files a report as user and returns the status and the report's ID
+func (f *reportFixture) report(t *testing.T, user *chattest.User, form url.Values) (int, uint) {
+	t.Helper()
+	var result struct {
+		Report models.Report `json:"report"`
+	}
+	status := f.srv.Do(t, user, http.MethodPost, "/api/room/"+f.chatroom.Code+"/reports", form, &result)
+	return status, result.Report.ID
+}
+
+// reportMessage has dave report carol's message as spam
+func (f *reportFixture) reportMessage(t *testing.T) uint {
+	t.Helper()
+	status, id := f.report(t, f.dave, url.Values{"reason": {"spam"}, "message_id": {itoa(f.message.ID)}})
+	if status != http.StatusOK {
+		t.Fatalf("report message: %d", status)
+	}
+	return id
+}
+
+// resolve acts on a report as user
+func (f *reportFixture) resolve(t *testing.T, user *chattest.User, id uint, form url.Values) int {
+	t.Helper()
+	return f.srv.Do(t, user, http.MethodPost, "/api/room/"+f.chatroom.Code+"/reports/"+itoa(id)+"/resolve", form, nil)
+}
+
+func (f *reportFixture) stored(t *testing.T, id uint) models.Report {
+	t.Helper()
+	var report models.Report
+	if err := f.srv.DB.First(&report, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestCreateReport(t *testing.T) {
+	f := newReportFixture(t)
+
+	report := f.stored(t, f.reportMessage(t))
+	if report.ReporterID != f.dave.ID || report.TargetUserID != f.carol.ID || report.MessageID != f.message.ID ||
+		report.MessageContent != "buy cheap watches" || report.Status != models.ReportOpen {
+		t.Errorf("message report %+v", report)
+	}
+
+	status, id := f.report(t, f.dave, url.Values{"reason": {"harassment"}, "username": {"carol"}, "details": {"keeps messaging me"}})
+	if status != http.StatusOK {
+		t.Fatalf("report user: %d", status)
+	}
+	if report := f.stored(t, id); report.TargetUserID != f.carol.ID || report.MessageID != 0 || report.Details != "keeps messaging me" {
+		t.Errorf("user report %+v", report)
+	}
+
+	tests := []struct {
+		name string
+		user *chattest.User
+		form url.Values
+		want int
+	}{
+		{"invalid reason", f.dave, url.Values{"reason": {"rude"}, "username": {"carol"}}, http.StatusBadRequest},
+		{"no target", f.dave, url.Values{"reason": {"spam"}}, http.StatusBadRequest},
+		{"unknown message", f.dave, url.Values{"reason": {"spam"}, "message_id": {"999"}}, http.StatusNotFound},
+		{"yourself", f.carol, url.Values{"reason": {"spam"}, "message_id": {itoa(f.message.ID)}}, http.StatusBadRequest},
+		{"twice", f.dave, url.Values{"reason": {"other"}, "message_id": {itoa(f.message.ID)}}, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		if status, _ := f.report(t, tt.user, tt.form); status != tt.want {
+			t.Errorf("%s: %d, want %d", tt.name, status, tt.want)
+		}
+	}
+}
+
+func TestListReports(t *testing.T) {
+	f := newReportFixture(t)
+	f.reportMessage(t)
+
+	path := "/api/room/" + f.chatroom.Code + "/reports"
+	if status := f.srv.Do(t, f.dave, http.MethodGet, path, nil, nil); status != http.StatusForbidden {
+		t.Errorf("member listing reports: %d, want 403", status)
+	}
+
+	var queue struct {
+		Reports []struct {
+			models.Report
+			Context []models.Message `json:"context"`
+		} `json:"reports"`
+	}
+	if status := f.srv.Do(t, f.bob, http.MethodGet, path, nil, &queue); status != http.StatusOK {
+		t.Fatalf("moderator listing reports: %d", status)
+	}
+	if len(queue.Reports) != 1 || len(queue.Reports[0].Context) != 1 || queue.Reports[0].Context[0].ID != f.message.ID {
+		t.Errorf("queue %+v", queue.Reports)
+	}
+	if status := f.srv.Do(t, f.bob, http.MethodGet, path+"?status=closed", nil, nil); status != http.StatusBadRequest {
+		t.Errorf("unknown status: %d, want 400", status)
+	}
+}
+
+func TestResolveReport(t *testing.T) {
+	tests := []struct {
+		action string
+		form   url.Values
+		want   string
+		check  func(t *testing.T, f *reportFixture)
+	}{
+		{models.ReportActionDismiss, url.Values{"note": {"not spam"}}, models.ReportDismissed, func(t *testing.T, f *reportFixture) {
+			var count int64
+			f.srv.DB.Model(&models.Message{}).Where("id = ?", f.message.ID).Count(&count)
+			if count != 1 {
+				t.Error("dismissing deleted the message")
+			}
+		}},
+		{models.ReportActionDeleteMessage, nil, models.ReportResolved, func(t *testing.T, f *reportFixture) {
+			var count int64
+			f.srv.DB.Model(&models.Message{}).Where("id = ?", f.message.ID).Count(&count)
+			if count != 0 {
+				t.Error("message not deleted")
+			}
+		}},
+		{models.ReportActionMute, url.Values{"minutes": {"30"}}, models.ReportResolved, func(t *testing.T, f *reportFixture) {
+			var membership models.Membership
+			f.srv.DB.Where("user_id = ? AND chatroom_id = ?", f.carol.ID, f.chatroom.ID).First(&membership)
+			if membership.MutedUntil == nil || time.Until(*membership.MutedUntil).Round(time.Minute) != 30*time.Minute {
+				t.Errorf("carol muted until %v", membership.MutedUntil)
+			}
+		}},
+		{models.ReportActionBan, nil, models.ReportResolved, func(t *testing.T, f *reportFixture) {
+			var bans, memberships int64
+			f.srv.DB.Model(&models.RoomBan{}).Where("chatroom_id = ? AND user_id = ?", f.chatroom.ID, f.carol.ID).Count(&bans)
+			f.srv.DB.Model(&models.Membership{}).Where("chatroom_id = ? AND user_id = ?", f.chatroom.ID, f.carol.ID).Count(&memberships)
+			if bans != 1 || memberships != 0 {
+				t.Errorf("%d bans and %d memberships for carol", bans, memberships)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			f := newReportFixture(t)
+			id := f.reportMessage(t)
+
+			form := url.Values{"action": {tt.action}}
+			for k, v := range tt.form {
+				form[k] = v
+			}
+			if status := f.resolve(t, f.bob, id, form); status != http.StatusOK {
+				t.Fatalf("resolve: %d", status)
+			}
+			report := f.stored(t, id)
+			if report.Status != tt.want || report.Action != tt.action || report.ResolvedByID != f.bob.ID ||
+				report.ResolvedAt == nil || report.ResolutionNote != form.Get("note") {
+				t.Errorf("report %+v", report)
+			}
+			tt.check(t, f)
+
+			if status := f.resolve(t, f.bob, id, form); status != http.StatusConflict {
+				t.Errorf("resolving twice: %d, want 409", status)
+			}
+		})
+	}
+}
+
+func TestResolveReportRefusals(t *testing.T) {
+	f := newReportFixture(t)
+	id := f.reportMessage(t)
+
+	if status := f.resolve(t, f.dave, id, url.Values{"action": {"dismiss"}}); status != http.StatusForbidden {
+		t.Errorf("member resolving: %d, want 403", status)
+	}
+	if status := f.resolve(t, f.bob, id, url.Values{"action": {"shrug"}}); status != http.StatusBadRequest {
+		t.Errorf("unknown action: %d, want 400", status)
+	}
+
+	// A moderator can't act against the owner, or resolve reports about themselves
+	status, aboutAlice := f.report(t, f.dave, url.Values{"reason": {"other"}, "username": {"alice"}})
+	if status != http.StatusOK {
+		t.Fatalf("report alice: %d", status)
+	}
+	if status := f.resolve(t, f.bob, aboutAlice, url.Values{"action": {"ban"}}); status != http.StatusForbidden {
+		t.Errorf("moderator banning the owner: %d, want 403", status)
+	}
+	_, aboutBob := f.report(t, f.dave, url.Values{"reason": {"other"}, "username": {"bob"}})
+	for _, action := range []string{"dismiss", "mute"} {
+		if status := f.resolve(t, f.bob, aboutBob, url.Values{"action": {action}}); status != http.StatusForbidden {
+			t.Errorf("bob resolving a report about bob with %s: %d, want 403", action, status)
+		}
+	}
+	if status := f.resolve(t, f.alice, aboutBob, url.Values{"action": {"dismiss"}}); status != http.StatusOK {
+		t.Errorf("owner dismissing a report about a moderator: %d", status)
+	}
+
+	var open int64
+	f.srv.DB.Model(&models.Report{}).Where("status = ?", models.ReportOpen).Count(&open)
+	if open != 2 {
+		t.Errorf("%d open reports, want 2", open)
+	}
+	if status := f.resolve(t, f.bob, 999, url.Values{"action": {"dismiss"}}); status != http.StatusNotFound {
+		t.Errorf("unknown report: %d, want 404", status)
+	}
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jeffasante/chatroom.go/models"
+	"github.com/jeffasante/chatroom.go/retention"
+)
+
+// retentionSettings limit how long messages are kept, 0 meaning no limit
+type retentionSettings struct {
+	Days     int `json:"days"`
+	Messages int `json:"messages"`
+}
+
+func retentionView(chatroom *models.Chatroom) gin.H {
+	days, messages := retention.Policy(chatroom)
+	return gin.H{
+		"room":       retentionSettings{Days: chatroom.RetentionDays, Messages: chatroom.RetentionMessages},
+		"server":     retentionSettings{Days: retention.Days, Messages: retention.Messages},
+		"effective":  retentionSettings{Days: days, Messages: messages},
+		"legal_hold": chatroom.LegalHold,
+	}
+}
+
+// API endpoint to get a room's retention settings and the limits that
+// actually apply to it (room owners only)
+func (h *Handler) GetRetention(c *gin.Context) {
+	user := h.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	chatroom, ok := h.findOwnedRoom(c, user)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"retention": retentionView(chatroom)})
+}
+
+// API endpoint to set how long a room keeps messages (room owners only).
+// Limits looser than the server's have no effect.
+func (h *Handler) UpdateRetention(c *gin.Context) {
+	user := h.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	chatroom, ok := h.findOwnedRoom(c, user)
+	if !ok {
+		return
+	}
+
+	var settings retentionSettings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json body"})
+		return
+	}
+	if settings.Days < 0 || settings.Messages < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days and messages can't be negative"})
+		return
+	}
+
+	before := retentionSettings{Days: chatroom.RetentionDays, Messages: chatroom.RetentionMessages}
+	if err := h.db.Model(chatroom).Updates(map[string]interface{}{
+		"retention_days":     settings.Days,
+		"retention_messages": settings.Messages,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save retention settings"})
+		return
+	}
+	chatroom.RetentionDays = settings.Days
+	chatroom.RetentionMessages = settings.Messages
+
+	audit(h.db, auditEntry{
+		chatroomID: chatroom.ID,
+		actorID:    user.ID,
+		action:     models.AuditRoomRetention,
+		before:     before,
+		after:      settings,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"retention": retentionView(chatroom),
+	})
+}
+
+// AnnouncePurge tells a room's connected clients that retention deleted
+// messages, so they stop showing them
+func (h *Hub) AnnouncePurge(chatroomID uint, messageIDs []uint) {
+	h.broadcast <- &Message{
+		Type:       "messages_purged",
+		ChatroomID: chatroomID,
+		Timestamp:  time.Now(),
+		MessageIDs: messageIDs,
+	}
+}
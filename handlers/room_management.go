@@ -1,13 +1,15 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jeffasante/chatroom.go/models"
 )
 
-// Update room name
+// Update room name and settings
 func (h *Handler) UpdateRoom(c *gin.Context) {
 	user := h.GetCurrentUser(c)
 	if user == nil {
@@ -36,16 +38,38 @@ func (h *Handler) UpdateRoom(c *gin.Context) {
 		return
 	}
 
-	// Update room name
-	if err := h.db.Model(&chatroom).Update("name", newName).Error; err != nil {
+	// Optional per-room message limit; 0 goes back to the server default
+	maxLength := chatroom.MaxMessageLength
+	if raw, ok := c.GetPostForm("max_message_length"); ok {
+		var err error
+		maxLength, err = strconv.Atoi(raw)
+		if err != nil || maxLength < 0 || maxLength > MaxMessageLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("max_message_length must be between 0 and %d", MaxMessageLength)})
+			return
+		}
+	}
+	updates := map[string]interface{}{"name": newName, "max_message_length": maxLength}
+	before := roomSnapshot(&chatroom)
+
+	// Update room settings
+	if err := h.db.Model(&chatroom).Updates(updates).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update room"})
 		return
 	}
 
+	audit(h.db, auditEntry{
+		chatroomID: chatroom.ID,
+		actorID:    user.ID,
+		action:     models.AuditRoomUpdate,
+		before:     before,
+		after:      roomSnapshot(&chatroom),
+	})
+
 	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "Room name updated successfully",
-		"name":    newName,
+		"success":            true,
+		"message":            "Room updated successfully",
+		"name":               newName,
+		"max_message_length": maxLength,
 	})
 }
 
@@ -72,6 +96,22 @@ func (h *Handler) DeleteRoom(c *gin.Context) {
 		return
 	}
 
+	if chatroom.LegalHold {
+		c.JSON(http.StatusConflict, gin.H{"error": "room is under legal hold"})
+		return
+	}
+
+	// Stored files can only be removed once the transaction has committed
+	var attachments []models.Attachment
+	if err := h.db.Select("key", "thumbnail_key").Where("chatroom_id = ?", chatroom.ID).Find(&attachments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete attachments"})
+		return
+	}
+	var blobKeys []string
+	for _, attachment := range attachments {
+		blobKeys = append(blobKeys, attachment.BlobKeys()...)
+	}
+
 	// Begin transaction to ensure all data is deleted together
 	tx := h.db.Begin()
 
@@ -89,6 +129,57 @@ func (h *Handler) DeleteRoom(c *gin.Context) {
 		return
 	}
 
+	// Delete webhooks and their queued deliveries
+	if err := tx.Where("webhook_id IN (?)", tx.Model(&models.Webhook{}).Select("id").Where("chatroom_id = ?", chatroom.ID)).
+		Delete(&models.WebhookDelivery{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete webhooks"})
+		return
+	}
+	if err := tx.Where("chatroom_id = ?", chatroom.ID).Delete(&models.Webhook{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete webhooks"})
+		return
+	}
+
+	if err := tx.Where("chatroom_id = ?", chatroom.ID).Delete(&models.IncomingWebhook{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete webhooks"})
+		return
+	}
+
+	if err := tx.Where("chatroom_id = ?", chatroom.ID).Delete(&models.HeldMessage{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete held messages"})
+		return
+	}
+	if err := tx.Where("chatroom_id = ?", chatroom.ID).Delete(&models.RoomModeration{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete moderation settings"})
+		return
+	}
+	if err := tx.Where("chatroom_id = ?", chatroom.ID).Delete(&models.Report{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete reports"})
+		return
+	}
+	if err := tx.Where("chatroom_id = ?", chatroom.ID).Delete(&models.RoomBan{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete bans"})
+		return
+	}
+	if err := tx.Where("chatroom_id = ?", chatroom.ID).Delete(&models.ImportedChannel{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete room"})
+		return
+	}
+
+	if err := tx.Where("chatroom_id = ?", chatroom.ID).Delete(&models.Attachment{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete attachments"})
+		return
+	}
+
 	// Delete the chatroom
 	if err := tx.Delete(&chatroom).Error; err != nil {
 		tx.Rollback()
@@ -96,8 +187,21 @@ func (h *Handler) DeleteRoom(c *gin.Context) {
 		return
 	}
 
+	// The audit log outlives the room
+	if err := recordAudit(tx, auditEntry{
+		chatroomID: chatroom.ID,
+		actorID:    user.ID,
+		action:     models.AuditRoomDelete,
+		before:     roomSnapshot(&chatroom),
+	}); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete room"})
+		return
+	}
+
 	// Commit transaction
 	tx.Commit()
+	h.deleteBlobs(blobKeys)
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -105,59 +209,69 @@ func (h *Handler) DeleteRoom(c *gin.Context) {
 	})
 }
 
-// Get room members list
-func (h *Handler) GetRoomMembers(c *gin.Context) {
-	user := h.GetCurrentUser(c)
-	if user == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-		return
-	}
+// Get room members list with each member's presence
+func (h *Handler) GetRoomMembers(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := h.GetCurrentUser(c)
+		if user == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
 
-	code := c.Param("code")
+		code := c.Param("code")
 
-	// Get chatroom
-	var chatroom models.Chatroom
-	if err := h.db.Where("code = ?", code).First(&chatroom).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
-		return
-	}
+		// Get chatroom
+		var chatroom models.Chatroom
+		if err := h.db.Where("code = ?", code).First(&chatroom).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+			return
+		}
 
-	// Check if user is a member
-	if !chatroom.IsMember(h.db, user.ID) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "not a member of this room"})
-		return
-	}
+		// Check if user is a member
+		if !chatroom.IsMember(h.db, user.ID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not a member of this room"})
+			return
+		}
 
-	// Get all memberships with user data
-	var memberships []models.Membership
-	if err := h.db.Where("chatroom_id = ?", chatroom.ID).
-		Preload("User").
-		Order("joined_at ASC").
-		Find(&memberships).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get members"})
-		return
-	}
+		// Get all memberships with user data
+		var memberships []models.Membership
+		if err := h.db.Where("chatroom_id = ?", chatroom.ID).
+			Preload("User").
+			Order("joined_at ASC").
+			Find(&memberships).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get members"})
+			return
+		}
 
-	// Format response
-	type MemberInfo struct {
-		ID       uint   `json:"id"`
-		Username string `json:"username"`
-		IsOwner  bool   `json:"is_owner"`
-		JoinedAt string `json:"joined_at"`
-	}
+		// Format response
+		type MemberInfo struct {
+			ID       uint   `json:"id"`
+			Username string `json:"username"`
+			Role     string `json:"role"`
+			Status   string `json:"status"`
+			IsOwner  bool   `json:"is_owner"`
+			JoinedAt string `json:"joined_at"`
+		}
 
-	var members []MemberInfo
-	for _, membership := range memberships {
-		members = append(members, MemberInfo{
-			ID:       membership.User.ID,
-			Username: membership.User.Username,
-			IsOwner:  membership.User.ID == chatroom.OwnerID,
-			JoinedAt: membership.JoinedAt.Format("2006-01-02 15:04"),
+		var members []MemberInfo
+		for _, membership := range memberships {
+			role := membership.Role
+			if membership.User.ID == chatroom.OwnerID {
+				role = models.RoleOwner
+			}
+			members = append(members, MemberInfo{
+				ID:       membership.User.ID,
+				Username: membership.User.Username,
+				Role:     role,
+				Status:   hub.Presence(membership.User.ID),
+				IsOwner:  membership.User.ID == chatroom.OwnerID,
+				JoinedAt: membership.JoinedAt.Format("2006-01-02 15:04"),
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"members": members,
+			"count":   len(members),
 		})
 	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"members": members,
-		"count":   len(members),
-	})
 }
\ No newline at end of file
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/jeffasante/chatroom.go/middleware"
+)
+
+// RegisterRoutes adds the application's pages and API to a router. The
+// caller serves templates, static files and "/".
+func (h *Handler) RegisterRoutes(router *gin.Engine, hub *Hub) {
+	// Auth routes
+	router.GET("/login", h.ShowLogin)
+	router.POST("/login", h.HandleLogin)
+	router.GET("/signup", h.ShowSignup)
+	router.POST("/signup", h.HandleSignup)
+	router.POST("/logout", h.HandleLogout)
+
+	// Incoming webhooks authenticate with the secret token in the URL
+	router.POST("/hooks/:token", h.HandleIncomingWebhook(hub))
+
+	// Authorized routes (require authentication)
+	authorized := router.Group("/")
+	authorized.Use(middleware.AuthMiddleware(h.db))
+	{
+		authorized.GET("/dashboard", h.ShowDashboard)
+		authorized.POST("/create-room", h.CreateRoom)
+		authorized.POST("/join-room", h.JoinRoom)
+		authorized.GET("/room/:code", h.ShowRoom)
+		authorized.GET("/room/:code/moderation", h.ShowModerationQueue)
+
+		// WebSocket and API routes
+		authorized.GET("/ws/:code", h.HandleWebSocket(hub))
+		authorized.GET("/api/messages/:code", h.GetMessages)
+		authorized.POST("/api/room/:code/read", h.MarkRead(hub))
+		authorized.GET("/api/room/:code/search", h.SearchRoom)
+		authorized.GET("/api/search", h.SearchAll)
+		authorized.POST("/api/room/:code/attachments", h.UploadAttachment(hub))
+		authorized.GET("/api/attachments/:id", h.DownloadAttachment)
+		authorized.GET("/api/attachments/:id/thumbnail", h.DownloadThumbnail)
+
+		// Room management routes
+		authorized.POST("/api/room/:code/update", h.UpdateRoom)
+		authorized.DELETE("/api/room/:code", h.DeleteRoom)
+		authorized.GET("/api/room/:code/members", h.GetRoomMembers(hub))
+		authorized.GET("/api/room/:code/presence", h.GetRoomPresence(hub))
+		authorized.GET("/api/room/:code/audit", h.ListAuditEvents)
+		authorized.GET("/api/room/:code/export", h.ExportRoom)
+		authorized.GET("/api/room/:code/retention", h.GetRetention)
+		authorized.PUT("/api/room/:code/retention", h.UpdateRetention)
+
+		// Outgoing webhook routes (room owners only)
+		authorized.POST("/api/room/:code/webhooks", h.CreateWebhook)
+		authorized.GET("/api/room/:code/webhooks", h.ListWebhooks)
+		authorized.DELETE("/api/room/:code/webhooks/:id", h.DeleteWebhook)
+		authorized.GET("/api/room/:code/webhooks/:id/deliveries", h.ListWebhookDeliveries)
+
+		// Incoming webhook routes (room owners only)
+		authorized.POST("/api/room/:code/incoming-webhooks", h.CreateIncomingWebhook)
+		authorized.GET("/api/room/:code/incoming-webhooks", h.ListIncomingWebhooks)
+		authorized.DELETE("/api/room/:code/incoming-webhooks/:id", h.DeleteIncomingWebhook)
+
+		// Moderation settings (room owners) and review queue (moderators)
+		authorized.GET("/api/room/:code/moderation", h.GetModeration)
+		authorized.PUT("/api/room/:code/moderation", h.UpdateModeration)
+		authorized.GET("/api/room/:code/moderation/queue", h.ListHeldMessages)
+		authorized.POST("/api/room/:code/moderation/queue/:id/approve", h.ApproveHeldMessage(hub))
+		authorized.POST("/api/room/:code/moderation/queue/:id/reject", h.RejectHeldMessage(hub))
+
+		// Reports: any member can file one, moderators resolve them
+		authorized.POST("/api/room/:code/reports", h.CreateReport)
+		authorized.GET("/api/room/:code/reports", h.ListReports)
+		authorized.POST("/api/room/:code/reports/:id/resolve", h.ResolveReport(hub))
+
+		// Blocked users, per account
+		authorized.GET("/api/blocks", h.ListBlocks)
+		authorized.POST("/api/blocks", h.BlockUser(hub))
+		authorized.DELETE("/api/blocks/:username", h.UnblockUser(hub))
+
+		// Bot account routes
+		authorized.POST("/api/bots", h.CreateBot)
+		authorized.GET("/api/bots", h.ListBots)
+		authorized.POST("/api/bots/:id/token", h.RegenerateBotToken)
+	}
+}
@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+// FullTextSearch is set at startup when the FTS5 index is available.
+// Without it search matches substrings, which is slower on large rooms.
+var FullTextSearch bool
+
+const (
+	searchDefaultLimit = 20
+	searchMaxLimit     = 50
+	searchMaxTerms     = 10
+	snippetRadius      = 60 // bytes of context either side of the first match
+)
+
+// Snippets wrap matched terms in these private-use characters, so clients
+// can escape the text first and then turn them into highlight markup
+const (
+	highlightStart = "\ue000"
+	highlightEnd   = "\ue001"
+)
+
+// searchFilters narrows a search; every field but terms is optional
+type searchFilters struct {
+	terms    []string
+	author   string
+	from     time.Time
+	to       time.Time
+	hasLink  bool
+	beforeID uint64
+	limit    int
+}
+
+// SearchResult is one matching message with a highlighted snippet
+type SearchResult struct {
+	MessageID  uint      `json:"message_id"`
+	Seq        uint64    `json:"seq"`
+	Type       string    `json:"type"`
+	Content    string    `json:"content"`
+	Snippet    string    `json:"snippet"`
+	UserID     uint      `json:"user_id"`
+	Username   string    `json:"username"`
+	RoomCode   string    `json:"room_code"`
+	RoomName   string    `json:"room_name"`
+	CreatedAt  time.Time `json:"created_at"`
+	ContextURL string    `json:"context_url"`
+}
+
+func parseSearchFilters(c *gin.Context) (searchFilters, error) {
+	f := searchFilters{
+		terms:  searchTerms(c.Query("q")),
+		author: strings.TrimSpace(c.Query("author")),
+		limit:  searchDefaultLimit,
+	}
+	if len(f.terms) == 0 {
+		return f, errors.New("missing search query")
+	}
+	if len(f.terms) > searchMaxTerms {
+		return f, errors.New("too many search terms")
+	}
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			return f, errors.New("invalid limit")
+		}
+		if limit > searchMaxLimit {
+			limit = searchMaxLimit
+		}
+		f.limit = limit
+	}
+
+	var err error
+	if f.from, err = parseSearchDate(c.Query("from"), false); err != nil {
+		return f, errors.New("invalid from date")
+	}
+	if f.to, err = parseSearchDate(c.Query("to"), true); err != nil {
+		return f, errors.New("invalid to date")
+	}
+
+	switch c.Query("has_link") {
+	case "", "false", "0":
+	case "true", "1":
+		f.hasLink = true
+	default:
+		return f, errors.New("invalid has_link")
+	}
+
+	if raw := c.Query("before_id"); raw != "" {
+		if f.beforeID, err = strconv.ParseUint(raw, 10, 64); err != nil {
+			return f, errors.New("invalid before_id")
+		}
+	}
+	return f, nil
+}
+
+// searchTerms splits a query into terms. Text in double quotes is a single
+// term, matched as a phrase.
+func searchTerms(q string) []string {
+	var terms []string
+	for i, part := range strings.Split(q, `"`) {
+		if i%2 == 0 {
+			terms = append(terms, strings.Fields(part)...)
+		} else if phrase := strings.Join(strings.Fields(part), " "); phrase != "" {
+			terms = append(terms, phrase)
+		}
+	}
+	return terms
+}
+
+// parseSearchDate accepts RFC 3339 or a plain date. A plain date used as
+// an upper bound covers the whole of that day.
+func parseSearchDate(raw string, endOfDay bool) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", raw)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
+
+// searchMessages finds messages in the given rooms, newest first, and
+// reports whether more results follow
+func searchMessages(db *gorm.DB, chatroomIDs []uint, f searchFilters) ([]SearchResult, bool, error) {
+	results := []SearchResult{}
+	if len(chatroomIDs) == 0 {
+		return results, false, nil
+	}
+
+	columns := "messages.id AS message_id, messages.seq, messages.type, messages.content, messages.user_id, messages.created_at, " +
+		"users.username, chatrooms.code AS room_code, chatrooms.name AS room_name"
+	query := db.Table("messages").
+		Joins("JOIN users ON users.id = messages.user_id").
+		Joins("JOIN chatrooms ON chatrooms.id = messages.chatroom_id").
+		Where("messages.chatroom_id IN ?", chatroomIDs)
+
+	if FullTextSearch {
+		query = query.Select(columns+", snippet(messages_fts, 0, ?, ?, '…', 16) AS snippet", highlightStart, highlightEnd).
+			Joins("JOIN messages_fts ON messages_fts.rowid = messages.id").
+			Where("messages_fts MATCH ?", ftsQuery(f.terms))
+	} else {
+		query = query.Select(columns)
+		for _, term := range f.terms {
+			query = query.Where(`messages.content LIKE ? ESCAPE '\'`, "%"+escapeLike(term)+"%")
+		}
+	}
+
+	if f.author != "" {
+		query = query.Where("LOWER(users.username) = LOWER(?)", f.author)
+	}
+	if !f.from.IsZero() {
+		query = query.Where("messages.created_at >= ?", f.from)
+	}
+	if !f.to.IsZero() {
+		query = query.Where("messages.created_at <= ?", f.to)
+	}
+	if f.hasLink {
+		query = query.Where("(messages.content LIKE '%http://%' OR messages.content LIKE '%https://%')")
+	}
+	if f.beforeID != 0 {
+		query = query.Where("messages.id < ?", f.beforeID)
+	}
+
+	if err := query.Order("messages.id DESC").Limit(f.limit + 1).Scan(&results).Error; err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(results) > f.limit
+	if hasMore {
+		results = results[:f.limit]
+	}
+	for i := range results {
+		if !FullTextSearch {
+			results[i].Snippet = highlightSnippet(results[i].Content, f.terms)
+		}
+		results[i].ContextURL = "/room/" + results[i].RoomCode + "?around=" + strconv.FormatUint(uint64(results[i].MessageID), 10)
+	}
+	return results, hasMore, nil
+}
+
+// ftsQuery quotes each term so user input is never parsed as FTS5 syntax
+func ftsQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+	}
+	return strings.Join(quoted, " ")
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// highlightSnippet cuts a window around the first matching term and marks
+// every term inside it, for searches without the FTS5 index
+func highlightSnippet(content string, terms []string) string {
+	lower := strings.ToLower(content)
+	if len(lower) != len(content) {
+		// Lowercasing changed byte offsets, so matches can't be mapped back
+		lower = content
+	}
+
+	first := -1
+	for _, term := range terms {
+		if i := strings.Index(lower, strings.ToLower(term)); i >= 0 && (first < 0 || i < first) {
+			first = i
+		}
+	}
+	if first < 0 {
+		first = 0
+	}
+
+	start, end := first-snippetRadius, first+2*snippetRadius
+	if start < 0 {
+		start = 0
+	}
+	if end > len(content) {
+		end = len(content)
+	}
+	for start > 0 && !utf8.RuneStart(content[start]) {
+		start--
+	}
+	for end < len(content) && !utf8.RuneStart(content[end]) {
+		end++
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	for i := start; i < end; {
+		matched := 0
+		for _, term := range terms {
+			if n := len(term); n > matched && i+n <= end && strings.EqualFold(lower[i:i+n], term) {
+				matched = n
+			}
+		}
+		if matched > 0 {
+			b.WriteString(highlightStart + content[i:i+matched] + highlightEnd)
+			i += matched
+			continue
+		}
+		b.WriteByte(content[i])
+		i++
+	}
+	if end < len(content) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+func searchResponse(c *gin.Context, results []SearchResult, hasMore bool) {
+	c.JSON(http.StatusOK, gin.H{
+		"results":   results,
+		"count":     len(results),
+		"has_more":  hasMore,
+		"highlight": []string{highlightStart, highlightEnd},
+	})
+}
+
+// API endpoint to search one room's messages
+func (h *Handler) SearchRoom(c *gin.Context) {
+	user := h.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var chatroom models.Chatroom
+	if err := h.db.Where("code = ?", c.Param("code")).First(&chatroom).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+		return
+	}
+
+	if !chatroom.IsMember(h.db, user.ID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not a member"})
+		return
+	}
+
+	filters, err := parseSearchFilters(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	results, hasMore, err := searchMessages(withoutBlocked(h.db, user.ID), []uint{chatroom.ID}, filters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "search failed"})
+		return
+	}
+	searchResponse(c, results, hasMore)
+}
+
+// API endpoint to search every room the user belongs to
+func (h *Handler) SearchAll(c *gin.Context) {
+	user := h.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	filters, err := parseSearchFilters(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var chatroomIDs []uint
+	if err := h.db.Model(&models.Membership{}).Where("user_id = ?", user.ID).Pluck("chatroom_id", &chatroomIDs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get rooms"})
+		return
+	}
+
+	results, hasMore, err := searchMessages(withoutBlocked(h.db, user.ID), chatroomIDs, filters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "search failed"})
+		return
+	}
+	searchResponse(c, results, hasMore)
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSearchTerms(t *testing.T) {
+	tests := map[string][]string{
+		"lunch":                     {"lunch"},
+		"  lunch   noon ":           {"lunch", "noon"},
+		`"lunch at noon" tomorrow`:  {"lunch at noon", "tomorrow"},
+		`say "  hello   there  "`:   {"say", "hello there"},
+		`"unclosed phrase`:          {"unclosed phrase"},
+		`""`:                        nil,
+		`it's "fine"`:               {"it's", "fine"},
+		`a"b c"d`:                   {"a", "b c", "d"},
+		`"first" "second phrase" x`: {"first", "second phrase", "x"},
+	}
+	for q, want := range tests {
+		if got := searchTerms(q); !reflect.DeepEqual(got, want) {
+			t.Errorf("searchTerms(%q) = %q, want %q", q, got, want)
+		}
+	}
+}
+
+func TestFTSQuery(t *testing.T) {
+	tests := []struct {
+		terms []string
+		want  string
+	}{
+		{[]string{"lunch"}, `"lunch"`},
+		{[]string{"lunch", "noon"}, `"lunch" "noon"`},
+		{[]string{"lunch at noon"}, `"lunch at noon"`},
+		{[]string{`say "hi"`}, `"say ""hi"""`},
+		{[]string{`"`}, `""""`},
+		{[]string{"NOT", "OR", "x*", "col:val"}, `"NOT" "OR" "x*" "col:val"`},
+	}
+	for _, tt := range tests {
+		if got := ftsQuery(tt.terms); got != tt.want {
+			t.Errorf("ftsQuery(%q) = %s, want %s", tt.terms, got, tt.want)
+		}
+	}
+}
+
+func TestHighlightSnippet(t *testing.T) {
+	mark := func(s string) string {
+		return strings.NewReplacer("[", highlightStart, "]", highlightEnd).Replace(s)
+	}
+	long := strings.Repeat("a", 100) + " lunch " + strings.Repeat("b", 200)
+
+	tests := []struct {
+		content string
+		terms   []string
+		want    string
+	}{
+		{"Lunch at noon?", []string{"lunch"}, "[Lunch] at noon?"},
+		{"lunch and more lunch", []string{"lunch"}, "[lunch] and more [lunch]"},
+		{"lunch at noon", []string{"noon", "lunch"}, "[lunch] at [noon]"},
+		{"see you at lunch time", []string{"lunch time"}, "see you at [lunch time]"},
+		{"no match here", []string{"dinner"}, "no match here"},
+		{"café lunch", []string{"lunch"}, "café [lunch]"},
+		{long, []string{"lunch"}, "…" + strings.Repeat("a", 59) + " [lunch] " + strings.Repeat("b", 114) + "…"},
+	}
+	for _, tt := range tests {
+		if got, want := highlightSnippet(tt.content, tt.terms), mark(tt.want); got != want {
+			t.Errorf("highlightSnippet(%q, %q) = %q, want %q", tt.content, tt.terms, got, want)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeffasante/chatroom.go/handlers"
+	"github.com/jeffasante/chatroom.go/internal/chattest"
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+type searchResponse struct {
+	Results   []handlers.SearchResult `json:"results"`
+	HasMore   bool                    `json:"has_more"`
+	Highlight []string                `json:"highlight"`
+}
+
+// searchFixture is a room with five messages from alice and bob, one a
+// day from January 1st 2024, and a room of carol's that bob isn't in
+type searchFixture struct {
+	srv        *chattest.Server
+	code       string
+	alice, bob *chattest.User
+	carol      *chattest.User
+	ids        []uint // the messages in the order they were sent
+}
+
+var searchMessages = []struct {
+	author  string
+	content string
+}{
+	{"alice", "Lunch at noon?"},
+	{"bob", "lunch sounds good, the menu is at https://example.com/menu"},
+	{"bob", `the "quoted" word`},
+	{"alice", "dinner later, not lunch at all"},
+	{"alice", "LUNCH tomorrow too"},
+}
+
+func newSearchFixture(t *testing.T, opts ...chattest.Option) *searchFixture {
+	t.Helper()
+	srv := chattest.New(t, opts...)
+	f := &searchFixture{srv: srv, alice: srv.SignUp(t, "alice"), bob: srv.SignUp(t, "bob"), carol: srv.SignUp(t, "carol")}
+	f.code = srv.CreateRoom(t, f.alice, "general", "secret")
+	srv.JoinRoom(t, f.bob, f.code, "secret")
+
+	var chatroom models.Chatroom
+	srv.DB.Where("code = ?", f.code).First(&chatroom)
+	authors := map[string]uint{"alice": f.alice.ID, "bob": f.bob.ID}
+	day := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, m := range searchMessages {
+		message := models.Message{
+			Content:    m.content,
+			Type:       "message",
+			UserID:     authors[m.author],
+			ChatroomID: chatroom.ID,
+			Seq:        uint64(i + 1),
+			CreatedAt:  day.AddDate(0, 0, i),
+		}
+		if err := srv.DB.Create(&message).Error; err != nil {
+			t.Fatal(err)
+		}
+		f.ids = append(f.ids, message.ID)
+	}
+
+	// Only carol's own searches should find this
+	var other models.Chatroom
+	srv.DB.Where("code = ?", srv.CreateRoom(t, f.carol, "other", "secret")).First(&other)
+	srv.DB.Create(&models.Message{Content: "lunch elsewhere", Type: "message", UserID: f.carol.ID, ChatroomID: other.ID, Seq: 1})
+	return f
+}
+
+// search runs a room search as bob with the given query parameters
+func (f *searchFixture) search(t *testing.T, params url.Values) searchResponse {
+	t.Helper()
+	var found searchResponse
+	if status := f.srv.Do(t, f.bob, http.MethodGet, "/api/room/"+f.code+"/search?"+params.Encode(), nil, &found); status != http.StatusOK {
+		t.Fatalf("search %s: %d", params.Encode(), status)
+	}
+	return found
+}
+
+// found lists the results as indexes into searchMessages
+func (f *searchFixture) found(results []handlers.SearchResult) []int {
+	indexes := []int{}
+	for _, r := range results {
+		for i, id := range f.ids {
+			if r.MessageID == id {
+				indexes = append(indexes, i)
+			}
+		}
+	}
+	return indexes
+}
+
+// searchModes runs a test against substring search and, when SQLite has
+// FTS5, the full-text index
+func searchModes(t *testing.T, test func(t *testing.T, f *searchFixture)) {
+	t.Run("substring", func(t *testing.T) { test(t, newSearchFixture(t)) })
+	t.Run("fts5", func(t *testing.T) { test(t, newSearchFixture(t, chattest.WithFullTextSearch(t))) })
+}
+
+func TestSearchMatching(t *testing.T) {
+	searchModes(t, func(t *testing.T, f *searchFixture) {
+		tests := []struct {
+			q    string
+			want []int
+		}{
+			{"lunch", []int{4, 3, 1, 0}},
+			{"lunch noon", []int{0}},
+			{"noon lunch", []int{0}},
+			{`"lunch at"`, []int{3, 0}},
+			{`"at lunch"`, []int{}},
+			{`"quoted"`, []int{2}},
+			{`"unclosed`, []int{}},
+			{"breakfast", []int{}},
+		}
+		for _, tt := range tests {
+			if got := f.found(f.search(t, url.Values{"q": {tt.q}}).Results); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("q=%s found %v, want %v", tt.q, got, tt.want)
+			}
+		}
+	})
+}
+
+func TestSearchFilters(t *testing.T) {
+	searchModes(t, func(t *testing.T, f *searchFixture) {
+		tests := []struct {
+			params url.Values
+			want   []int
+		}{
+			{url.Values{"author": {"bob"}}, []int{1}},
+			{url.Values{"author": {"ALICE"}}, []int{4, 3, 0}},
+			{url.Values{"author": {"carol"}}, []int{}},
+			{url.Values{"from": {"2024-01-02"}}, []int{4, 3, 1}},
+			{url.Values{"to": {"2024-01-02"}}, []int{1, 0}},
+			{url.Values{"from": {"2024-01-02"}, "to": {"2024-01-04T12:00:00Z"}}, []int{3, 1}},
+			{url.Values{"has_link": {"true"}}, []int{1}},
+			{url.Values{"before_id": {itoa(f.ids[3])}}, []int{1, 0}},
+		}
+		for _, tt := range tests {
+			tt.params.Set("q", "lunch")
+			if got := f.found(f.search(t, tt.params).Results); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s found %v, want %v", tt.params.Encode(), got, tt.want)
+			}
+		}
+
+		for _, params := range []url.Values{
+			{},
+			{"q": {"lunch"}, "from": {"yesterday"}},
+			{"q": {"lunch"}, "has_link": {"maybe"}},
+			{"q": {"lunch"}, "before_id": {"-1"}},
+			{"q": {"lunch"}, "limit": {"0"}},
+			{"q": {strings.Repeat("x ", 11)}},
+		} {
+			path := "/api/room/" + f.code + "/search?" + params.Encode()
+			if status := f.srv.Do(t, f.bob, http.MethodGet, path, nil, nil); status != http.StatusBadRequest {
+				t.Errorf("%s: %d, want 400", params.Encode(), status)
+			}
+		}
+	})
+}
+
+func TestSearchPaging(t *testing.T) {
+	searchModes(t, func(t *testing.T, f *searchFixture) {
+		var pages [][]int
+		params := url.Values{"q": {"lunch"}, "limit": {"3"}}
+		for {
+			page := f.search(t, params)
+			pages = append(pages, f.found(page.Results))
+			if !page.HasMore {
+				break
+			}
+			params.Set("before_id", itoa(page.Results[len(page.Results)-1].MessageID))
+		}
+		if want := [][]int{{4, 3, 1}, {0}}; !reflect.DeepEqual(pages, want) {
+			t.Errorf("pages %v, want %v", pages, want)
+		}
+	})
+}
+
+func TestSearchSnippets(t *testing.T) {
+	searchModes(t, func(t *testing.T, f *searchFixture) {
+		found := f.search(t, url.Values{"q": {"noon lunch"}})
+		if len(found.Highlight) != 2 || len(found.Results) != 1 {
+			t.Fatalf("response %+v", found)
+		}
+		start, end := found.Highlight[0], found.Highlight[1]
+		want := start + "Lunch" + end + " at " + start + "noon" + end + "?"
+		if got := found.Results[0].Snippet; got != want {
+			t.Errorf("snippet %q, want %q", got, want)
+		}
+		if got := found.Results[0].ContextURL; got != "/room/"+f.code+"?around="+itoa(f.ids[0]) {
+			t.Errorf("context_url %q", got)
+		}
+	})
+}
+
+func TestSearchOnlyMembersRooms(t *testing.T) {
+	searchModes(t, func(t *testing.T, f *searchFixture) {
+		path := "/api/room/" + f.code + "/search?q=lunch"
+		if status := f.srv.Do(t, f.carol, http.MethodGet, path, nil, nil); status != http.StatusForbidden {
+			t.Errorf("non-member searching a room: %d, want 403", status)
+		}
+
+		var found searchResponse
+		f.srv.Do(t, f.carol, http.MethodGet, "/api/search?q=lunch", nil, &found)
+		if len(found.Results) != 1 || found.Results[0].Content != "lunch elsewhere" {
+			t.Errorf("carol found %+v, want only the message in carol's room", found.Results)
+		}
+		f.srv.Do(t, f.bob, http.MethodGet, "/api/search?q=lunch", nil, &found)
+		if got := f.found(found.Results); !reflect.DeepEqual(got, []int{4, 3, 1, 0}) {
+			t.Errorf("bob found %v across bob's rooms", got)
+		}
+	})
+}
+
+func itoa(id uint) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
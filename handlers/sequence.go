@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"log"
+
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+// nextSeq returns the sequence number for a room's next message. The caller
+// records it in h.seqs once the message is saved, so a failed insert leaves
+// no gap. Must only be called from Run, which makes numbering race free.
+func (h *Hub) nextSeq(chatroomID uint) (uint64, error) {
+	last, ok := h.seqs[chatroomID]
+	if !ok {
+		var err error
+		if last, err = models.LastMessageSeq(h.db, chatroomID); err != nil {
+			return 0, err
+		}
+		h.seqs[chatroomID] = last
+	}
+	return last + 1, nil
+}
+
+// saveNumbered numbers a message and saves it. The import and purge
+// commands change rooms without going through the hub, so if the insert
+// fails the cached number is reloaded from the database, and the insert is
+// retried if the number it took was already used. Must only be called from
+// Run.
+func (h *Hub) saveNumbered(message *models.Message, attachments []AttachmentInfo) error {
+	seq, err := h.nextSeq(message.ChatroomID)
+	if err != nil {
+		return err
+	}
+	message.Seq = seq
+	err = h.saveMessage(message, attachments)
+	if err != nil {
+		delete(h.seqs, message.ChatroomID)
+		reloaded, reloadErr := h.nextSeq(message.ChatroomID)
+		if reloadErr != nil || reloaded == seq {
+			return err
+		}
+		log.Printf("Sequence numbers in chatroom %d changed outside the hub, continuing from %d", message.ChatroomID, reloaded)
+		message.ID = 0
+		message.Seq = reloaded
+		if err = h.saveMessage(message, attachments); err != nil {
+			return err
+		}
+	}
+	h.seqs[message.ChatroomID] = message.Seq
+	return nil
+}
@@ -0,0 +1,38 @@
+package handlers_test
+
+import (
+	"testing"
+
+	"github.com/jeffasante/chatroom.go/internal/chattest"
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+func TestSeqReloadsAfterOutsideWrites(t *testing.T) {
+	srv := chattest.New(t)
+	alice := srv.SignUp(t, "alice")
+	code := srv.CreateRoom(t, alice, "general", "secret")
+	conn := srv.Connect(t, alice, code)
+	send(t, conn, "first", "n-1")
+
+	// Another process, like the import command, adds a message
+	var chatroom models.Chatroom
+	srv.DB.Where("code = ?", code).First(&chatroom)
+	if err := srv.DB.Create(&models.Message{Content: "imported", UserID: alice.ID, ChatroomID: chatroom.ID, Seq: 2}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Send(map[string]string{"type": "message", "content": "second", "nonce": "n-2"})
+	ack := conn.Await(func(f chattest.Frame) bool { return f.Type == "ack" && f.Nonce == "n-2" })
+	if ack.Error != "" || ack.Seq != 3 {
+		t.Fatalf("ack = %+v, want seq 3", ack)
+	}
+	if third := send(t, conn, "third", "n-3"); third == 0 {
+		t.Fatal("third message not saved")
+	}
+
+	var seqs []uint64
+	srv.DB.Model(&models.Message{}).Where("chatroom_id = ?", chatroom.ID).Order("id").Pluck("seq", &seqs)
+	if len(seqs) != 4 || seqs[0] != 1 || seqs[1] != 2 || seqs[2] != 3 || seqs[3] != 4 {
+		t.Errorf("seqs = %v, want [1 2 3 4]", seqs)
+	}
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"time"
+)
+
+const (
+	// typingTimeout is how long an indicator lasts without a refresh, so a
+	// client that disconnects mid-sentence never leaves one behind
+	typingTimeout = 6 * time.Second
+
+	// typingMinInterval is the fastest a connection may refresh its indicator
+	typingMinInterval = time.Second
+)
+
+// typingEvent is sent by a client's read goroutine when its user starts or
+// stops typing
+type typingEvent struct {
+	chatroomID uint
+	userID     uint
+	username   string
+	typing     bool
+}
+
+// typist is a user currently shown as typing in a room
+type typist struct {
+	username string
+	expires  time.Time
+}
+
+// typingAllowed reports whether a typing frame should be passed to the hub.
+// Refreshes faster than typingMinInterval are dropped; a stop always goes
+// through and lets the next start through too.
+func (c *Client) typingAllowed(typing bool, now time.Time) bool {
+	if !typing {
+		c.lastTyping = time.Time{}
+		return true
+	}
+	if now.Sub(c.lastTyping) < typingMinInterval {
+		return false
+	}
+	c.lastTyping = now
+	return true
+}
+
+// setTyping records a typing change and tells the room when the visible
+// state changes. Refreshes of an existing indicator only extend its expiry,
+// so a busy typist never produces more than one frame per start and stop.
+// Must only be called from Run.
+func (h *Hub) setTyping(ev *typingEvent) {
+	typists := h.typists[ev.chatroomID]
+
+	if ev.typing {
+		if typists == nil {
+			typists = make(map[uint]*typist)
+			h.typists[ev.chatroomID] = typists
+		}
+
+		if t, exists := typists[ev.userID]; exists {
+			t.expires = time.Now().Add(typingTimeout)
+			return
+		}
+
+		typists[ev.userID] = &typist{username: ev.username, expires: time.Now().Add(typingTimeout)}
+		h.broadcastTyping(ev.chatroomID, ev.userID, ev.username, "typing_start")
+		return
+	}
+
+	t, exists := typists[ev.userID]
+	if !exists {
+		return
+	}
+	h.clearTypist(ev.chatroomID, ev.userID, t)
+}
+
+// stopTyping clears a user's indicator, e.g. when they send a message or leave
+func (h *Hub) stopTyping(chatroomID, userID uint) {
+	if t, exists := h.typists[chatroomID][userID]; exists {
+		h.clearTypist(chatroomID, userID, t)
+	}
+}
+
+// expireTyping clears indicators that have not been refreshed in time
+func (h *Hub) expireTyping(now time.Time) {
+	for chatroomID, typists := range h.typists {
+		for userID, t := range typists {
+			if now.After(t.expires) {
+				h.clearTypist(chatroomID, userID, t)
+			}
+		}
+	}
+}
+
+func (h *Hub) clearTypist(chatroomID, userID uint, t *typist) {
+	delete(h.typists[chatroomID], userID)
+	if len(h.typists[chatroomID]) == 0 {
+		delete(h.typists, chatroomID)
+	}
+	h.broadcastTyping(chatroomID, userID, t.username, "typing_stop")
+}
+
+func (h *Hub) broadcastTyping(chatroomID, userID uint, username, frameType string) {
+	h.broadcastToChatroom(chatroomID, &Message{
+		Type:       frameType,
+		UserID:     userID,
+		Username:   username,
+		ChatroomID: chatroomID,
+		Timestamp:  time.Now(),
+	})
+}
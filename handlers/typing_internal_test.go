@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+// typingRoom returns a hub with one client in room 1, without running it,
+// so the test can call the hub's methods as Run would
+func typingRoom() (*Hub, *Client) {
+	hub := NewHub(nil)
+	client := &Client{hub: hub, send: make(chan *Message, 16), user: &models.User{ID: 2}, chatroomID: 1}
+	hub.clients[client] = true
+	hub.chatrooms[1] = map[*Client]bool{client: true}
+	return hub, client
+}
+
+// frameTypes drains the frames queued for a client
+func frameTypes(c *Client) []string {
+	var types []string
+	for {
+		select {
+		case m := <-c.send:
+			types = append(types, m.Type+":"+m.Username)
+		default:
+			return types
+		}
+	}
+}
+
+func TestTypingExpires(t *testing.T) {
+	hub, client := typingRoom()
+
+	hub.setTyping(&typingEvent{chatroomID: 1, userID: 1, username: "alice", typing: true})
+	hub.setTyping(&typingEvent{chatroomID: 1, userID: 1, username: "alice", typing: true})
+	started := time.Now()
+
+	hub.expireTyping(started.Add(typingTimeout / 2))
+	if got := frameTypes(client); len(got) != 1 || got[0] != "typing_start:alice" {
+		t.Fatalf("frames before the timeout: %v, want one typing_start", got)
+	}
+
+	hub.expireTyping(started.Add(typingTimeout + time.Second))
+	if got := frameTypes(client); len(got) != 1 || got[0] != "typing_stop:alice" {
+		t.Fatalf("frames after the timeout: %v, want one typing_stop", got)
+	}
+	if len(hub.typists) != 0 {
+		t.Errorf("typists left behind: %v", hub.typists)
+	}
+
+	// A stop for an indicator that already expired isn't repeated
+	hub.setTyping(&typingEvent{chatroomID: 1, userID: 1, username: "alice"})
+	if got := frameTypes(client); len(got) != 0 {
+		t.Errorf("frames after a late stop: %v", got)
+	}
+}
+
+func TestTypingRefreshesExtendExpiry(t *testing.T) {
+	hub, client := typingRoom()
+
+	hub.setTyping(&typingEvent{chatroomID: 1, userID: 1, username: "alice", typing: true})
+	time.Sleep(10 * time.Millisecond)
+	hub.setTyping(&typingEvent{chatroomID: 1, userID: 1, username: "alice", typing: true})
+	refreshed := time.Now()
+	frameTypes(client)
+
+	// Only the refresh's expiry counts
+	hub.expireTyping(refreshed.Add(typingTimeout - time.Millisecond))
+	if got := frameTypes(client); len(got) != 0 {
+		t.Errorf("refreshed indicator expired early: %v", got)
+	}
+}
+
+func TestTypingAllowed(t *testing.T) {
+	client := &Client{}
+	now := time.Now()
+
+	steps := []struct {
+		after  time.Duration
+		typing bool
+		want   bool
+	}{
+		{0, true, true},
+		{typingMinInterval / 2, true, false}, // refreshed too soon
+		{typingMinInterval, true, true},
+		{typingMinInterval + 10*time.Millisecond, false, true}, // stops always go through
+		{typingMinInterval + 20*time.Millisecond, true, true},  // and let the next start through
+		{typingMinInterval + 30*time.Millisecond, true, false},
+	}
+	for i, step := range steps {
+		if got := client.typingAllowed(step.typing, now.Add(step.after)); got != step.want {
+			t.Errorf("step %d: typingAllowed(%v) = %v, want %v", i, step.typing, got, step.want)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package handlers_test
+
+import (
+	"testing"
+
+	"github.com/jeffasante/chatroom.go/internal/chattest"
+)
+
+func TestTypingIndicators(t *testing.T) {
+	srv := chattest.New(t)
+	alice := srv.SignUp(t, "alice")
+	bob := srv.SignUp(t, "bob")
+	code := srv.CreateRoom(t, alice, "general", "secret")
+	srv.JoinRoom(t, bob, code, "secret")
+	aliceConn := srv.Connect(t, alice, code)
+	bobConn := srv.Connect(t, bob, code)
+
+	aliceConn.Send(map[string]string{"type": "typing_start"})
+	if frame := bobConn.Await(chattest.OfType("typing_start")); frame.UserID != alice.ID || frame.Username != "alice" {
+		t.Errorf("typing_start %+v", frame)
+	}
+	aliceConn.Send(map[string]string{"type": "typing_stop"})
+	if frame := bobConn.Await(chattest.OfType("typing_stop")); frame.UserID != alice.ID {
+		t.Errorf("typing_stop %+v", frame)
+	}
+
+	// Closing the connection mid-sentence clears the indicator straight away
+	aliceConn.Send(map[string]string{"type": "typing_start"})
+	bobConn.Await(chattest.OfType("typing_start"))
+	aliceConn.Close()
+	if frame := bobConn.Await(chattest.OfType("typing_stop")); frame.UserID != alice.ID {
+		t.Errorf("typing_stop after disconnect %+v", frame)
+	}
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/jeffasante/chatroom.go/models"
+	"github.com/jeffasante/chatroom.go/unfurl"
+	"github.com/jeffasante/chatroom.go/webhooks"
+)
+
+// unfurlJob is a saved message whose links still need previews
+type unfurlJob struct {
+	chatroomID uint
+	messageID  uint
+	userID     uint // the message's author, so users who blocked them don't see the previews
+	links      []string
+}
+
+// linkUnfurler is an EventSink that fetches link previews for new messages
+// in the background and announces them with a message_unfurled frame
+type linkUnfurler struct {
+	hub      *Hub
+	unfurler *unfurl.Unfurler
+	jobs     chan unfurlJob
+}
+
+// EnableUnfurling starts workers that fetch previews for links in new
+// messages. Call it before Run.
+func (h *Hub) EnableUnfurling(unfurler *unfurl.Unfurler, workers int) {
+	u := &linkUnfurler{hub: h, unfurler: unfurler, jobs: make(chan unfurlJob, 256)}
+	for i := 0; i < workers; i++ {
+		go u.work()
+	}
+	h.AddEventSink(u)
+}
+
+// Publish queues the message's links. It never blocks; if the queue is full
+// the message just goes without previews.
+func (u *linkUnfurler) Publish(chatroomID uint, event string, data interface{}) {
+	message, ok := data.(*Message)
+	if event != webhooks.EventMessage || !ok {
+		return
+	}
+	links := unfurl.ExtractURLs(message.Content)
+	if len(links) == 0 {
+		return
+	}
+
+	select {
+	case u.jobs <- unfurlJob{chatroomID: chatroomID, messageID: message.MessageID, userID: message.UserID, links: links}:
+	default:
+		log.Printf("Unfurl queue full, skipping links in message %d", message.MessageID)
+	}
+}
+
+func (u *linkUnfurler) work() {
+	for job := range u.jobs {
+		var previews []models.LinkPreview
+		for _, link := range job.links {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			if preview := u.unfurler.Unfurl(ctx, link); preview != nil {
+				previews = append(previews, *preview)
+			}
+			cancel()
+		}
+		if len(previews) == 0 {
+			continue
+		}
+
+		u.hub.broadcast <- &Message{
+			Type:       "message_unfurled",
+			UserID:     job.userID,
+			ChatroomID: job.chatroomID,
+			MessageID:  job.messageID,
+			Timestamp:  time.Now(),
+			Previews:   previews,
+		}
+	}
+}
+
+// cachedPreviews returns the already fetched previews for links in a
+// message, in the order the links appear
+func cachedPreviews(db *gorm.DB, content string) []models.LinkPreview {
+	links := unfurl.ExtractURLs(content)
+	if len(links) == 0 {
+		return nil
+	}
+	return orderedPreviews(links, unfurl.Cached(db, links))
+}
+
+// attachPreviews fills in Previews on messages loaded from the database
+// using one cache lookup for the whole page
+func attachPreviews(db *gorm.DB, messages []models.Message) {
+	linksByMessage := make([][]string, len(messages))
+	var all []string
+	for i, m := range messages {
+		linksByMessage[i] = unfurl.ExtractURLs(m.Content)
+		all = append(all, linksByMessage[i]...)
+	}
+	if len(all) == 0 {
+		return
+	}
+
+	cached := unfurl.Cached(db, all)
+	for i := range messages {
+		messages[i].Previews = orderedPreviews(linksByMessage[i], cached)
+	}
+}
+
+func orderedPreviews(links []string, cached map[string]models.LinkPreview) []models.LinkPreview {
+	var previews []models.LinkPreview
+	for _, link := range links {
+		if preview, ok := cached[link]; ok {
+			previews = append(previews, preview)
+		}
+	}
+	return previews
+}
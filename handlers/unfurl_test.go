@@ -0,0 +1,45 @@
+package handlers_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jeffasante/chatroom.go/internal/chattest"
+	"github.com/jeffasante/chatroom.go/unfurl"
+)
+
+// previewPage serves a page with an OpenGraph title
+func previewPage(t *testing.T) *httptest.Server {
+	t.Helper()
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><head><meta property="og:title" content="Preview title"></head></html>`)
+	}))
+	t.Cleanup(page.Close)
+	return page
+}
+
+// unfurlingServer starts a chat server that may fetch previews from
+// httptest pages
+func unfurlingServer(t *testing.T) *chattest.Server {
+	t.Helper()
+	unfurl.AllowPrivate = true
+	t.Cleanup(func() { unfurl.AllowPrivate = false })
+	return chattest.New(t, chattest.WithUnfurling())
+}
+
+func TestUnfurledFrameCarriesAuthor(t *testing.T) {
+	page := previewPage(t)
+	srv := unfurlingServer(t)
+	alice := srv.SignUp(t, "alice")
+	code := srv.CreateRoom(t, alice, "general", "secret")
+	conn := srv.Connect(t, alice, code)
+
+	id := send(t, conn, "look "+page.URL+"/post", "n-1")
+	frame := conn.Await(chattest.OfType("message_unfurled"))
+	if frame.MessageID != id || frame.UserID != alice.ID {
+		t.Errorf("message_unfurled for message %d from user %d, want %d from %d", frame.MessageID, frame.UserID, id, alice.ID)
+	}
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jeffasante/chatroom.go/models"
+	"github.com/jeffasante/chatroom.go/webhooks"
+)
+
+// Register an outgoing webhook for a room. The signing secret is only
+// returned here.
+func (h *Handler) CreateWebhook(c *gin.Context) {
+	user := h.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	chatroom, ok := h.findOwnedRoom(c, user)
+	if !ok {
+		return
+	}
+
+	url := c.PostForm("url")
+	if err := webhooks.ValidateURL(url); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	events, err := webhooks.ParseEvents(c.DefaultPostForm("events", webhooks.EventMessage))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	secret := h.generateToken()
+	webhook := models.Webhook{
+		ChatroomID:  chatroom.ID,
+		URL:         url,
+		Secret:      secret,
+		Events:      events,
+		Active:      true,
+		CreatedByID: user.ID,
+	}
+
+	if err := h.db.Create(&webhook).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create webhook"})
+		return
+	}
+
+	audit(h.db, auditEntry{
+		chatroomID: chatroom.ID,
+		actorID:    user.ID,
+		action:     models.AuditWebhookCreate,
+		targetType: "webhook",
+		targetID:   webhook.ID,
+		after:      gin.H{"url": webhook.URL, "events": webhook.Events},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"webhook": webhook,
+		"secret":  secret,
+	})
+}
+
+// List a room's outgoing webhooks
+func (h *Handler) ListWebhooks(c *gin.Context) {
+	user := h.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	chatroom, ok := h.findOwnedRoom(c, user)
+	if !ok {
+		return
+	}
+
+	var hooks []models.Webhook
+	if err := h.db.Where("chatroom_id = ?", chatroom.ID).Order("id ASC").Find(&hooks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get webhooks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"webhooks": hooks,
+		"count":    len(hooks),
+	})
+}
+
+// Remove an outgoing webhook and its queued deliveries
+func (h *Handler) DeleteWebhook(c *gin.Context) {
+	user := h.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	chatroom, ok := h.findOwnedRoom(c, user)
+	if !ok {
+		return
+	}
+
+	webhook, ok := h.findWebhook(c, chatroom)
+	if !ok {
+		return
+	}
+
+	tx := h.db.Begin()
+	if err := tx.Where("webhook_id = ?", webhook.ID).Delete(&models.WebhookDelivery{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete deliveries"})
+		return
+	}
+	if err := tx.Delete(webhook).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete webhook"})
+		return
+	}
+	if err := recordAudit(tx, auditEntry{
+		chatroomID: chatroom.ID,
+		actorID:    user.ID,
+		action:     models.AuditWebhookDelete,
+		targetType: "webhook",
+		targetID:   webhook.ID,
+		before:     gin.H{"url": webhook.URL, "events": webhook.Events},
+	}); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete webhook"})
+		return
+	}
+	tx.Commit()
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Webhook deleted successfully",
+	})
+}
+
+// List recent delivery attempts for a webhook
+func (h *Handler) ListWebhookDeliveries(c *gin.Context) {
+	user := h.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	chatroom, ok := h.findOwnedRoom(c, user)
+	if !ok {
+		return
+	}
+
+	webhook, ok := h.findWebhook(c, chatroom)
+	if !ok {
+		return
+	}
+
+	var deliveries []models.WebhookDelivery
+	if err := h.db.Where("webhook_id = ?", webhook.ID).
+		Order("id DESC").
+		Limit(50).
+		Find(&deliveries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get deliveries"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deliveries": deliveries,
+		"count":      len(deliveries),
+	})
+}
+
+// findOwnedRoom loads the room in the :code param and checks the user owns
+// it, writing the error response if not
+func (h *Handler) findOwnedRoom(c *gin.Context, user *models.User) (*models.Chatroom, bool) {
+	var chatroom models.Chatroom
+	if err := h.db.Where("code = ?", c.Param("code")).First(&chatroom).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+		return nil, false
+	}
+
+	if chatroom.OwnerID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only room owner can manage this room"})
+		return nil, false
+	}
+
+	return &chatroom, true
+}
+
+func (h *Handler) findWebhook(c *gin.Context, chatroom *models.Chatroom) (*models.Webhook, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook id"})
+		return nil, false
+	}
+
+	var webhook models.Webhook
+	if err := h.db.Where("id = ? AND chatroom_id = ?", id, chatroom.ID).First(&webhook).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+		return nil, false
+	}
+
+	return &webhook, true
+}
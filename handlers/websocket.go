@@ -5,14 +5,20 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"gorm.io/gorm"
 
+	"github.com/jeffasante/chatroom.go/markdown"
 	"github.com/jeffasante/chatroom.go/middleware"
 	"github.com/jeffasante/chatroom.go/models"
+	"github.com/jeffasante/chatroom.go/moderation"
+	"github.com/jeffasante/chatroom.go/storage"
+	"github.com/jeffasante/chatroom.go/webhooks"
 )
 
 var upgrader = websocket.Upgrader{
@@ -26,16 +32,94 @@ type Hub struct {
 	broadcast  chan *Message
 	register   chan *Client
 	unregister chan *Client
+	direct     chan *directMessage
+	disconnect chan *disconnectRequest
+	typing     chan *typingEvent
+	resume     chan *resumeRequest
+	replays    chan *replayBatch
 	chatrooms  map[uint]map[*Client]bool // chatroom_id -> clients
-	db         *gorm.DB
+	typists    map[uint]map[uint]*typist // chatroom_id -> user_id -> typing state
+	userConns  map[uint]map[*Client]bool // user_id -> clients in any room
+	usernames  map[uint]string           // user_id -> username of connected users
+	seqs       map[uint]uint64           // chatroom_id -> last assigned sequence number
+
+	presenceUpdates chan *presenceEvent
+	presence        map[uint]string // user_id -> online or away, written only by Run
+	presenceMu      sync.RWMutex
+	db              *gorm.DB
+
+	commands   map[string]*Command
+	commandsMu sync.RWMutex
+
+	sinks []EventSink
+
+	limits *rateLimiter
+
+	classifier moderation.Filter // nil unless an external classifier is configured
+	toUser     chan *userMessage
+	blocks     chan *blockEvent
+	renames    chan *renameEvent
+}
+
+// EventSink receives room events from the hub, e.g. to deliver webhooks.
+// Publish is called from Hub.Run, so it must return quickly: a database
+// write is fine, a network request is not.
+type EventSink interface {
+	Publish(chatroomID uint, event string, data interface{})
+}
+
+// directMessage is delivered to a single connection instead of the whole room
+type directMessage struct {
+	client  *Client
+	message *Message
+}
+
+// userMessage is delivered to every connection a user has open in a chatroom
+type userMessage struct {
+	chatroomID uint
+	userID     uint
+	message    *Message
+}
+
+// disconnectRequest closes every connection a user has open in a chatroom
+type disconnectRequest struct {
+	chatroomID uint
+	userID     uint
+	reason     string
 }
 
 type Client struct {
 	hub        *Hub
 	conn       *websocket.Conn
 	send       chan *Message
-	user       *models.User
+	user       *models.User // never modified; read the current name with username()
 	chatroomID uint
+	away       bool          // only accessed from Run
+	resumeFrom uint          // last message ID the client saw before reconnecting
+	replaying  bool          // missed messages are loading, only accessed from Run
+	held       []*Message    // frames held back until the replay is sent, only accessed from Run
+	blocked    map[uint]bool // users this client's user blocked, only accessed from Run
+
+	blobs storage.BlobStore // for posting long messages as attachments, may be nil
+
+	frames     tokenBucket // only accessed from readPump
+	lastTyping time.Time   // last typing_start passed to the hub, only accessed from readPump
+
+	nameMu sync.Mutex
+	name   string // the user's current name, changed by /nick
+}
+
+// username returns the name the client's user currently goes by
+func (c *Client) username() string {
+	c.nameMu.Lock()
+	defer c.nameMu.Unlock()
+	return c.name
+}
+
+func (c *Client) setUsername(name string) {
+	c.nameMu.Lock()
+	c.name = name
+	c.nameMu.Unlock()
 }
 
 type Message struct {
@@ -46,145 +130,336 @@ type Message struct {
 	ChatroomID uint      `json:"chatroom_id"`
 	Timestamp  time.Time `json:"timestamp"`
 	MessageID  uint      `json:"message_id,omitempty"`
+	MessageIDs []uint    `json:"message_ids,omitempty"` // on messages_purged
+	IsBot      bool      `json:"is_bot,omitempty"`
+	ReadBy     []string  `json:"read_by,omitempty"`
+	Replayed   bool      `json:"replayed,omitempty"`
+	Seq        uint64    `json:"seq,omitempty"`
+
+	Attachments []AttachmentInfo     `json:"attachments,omitempty"`
+	Previews    []models.LinkPreview `json:"previews,omitempty"`
+	HTML        string               `json:"html,omitempty"`        // Content rendered from Markdown
+	Limit       int                  `json:"limit,omitempty"`       // on message_too_long errors
+	RetryAfter  float64              `json:"retry_after,omitempty"` // seconds, on rate_limited errors
+	Nonce       string               `json:"nonce,omitempty"`
+	Error       string               `json:"error,omitempty"`
+
+	sender *Client // connection that sent the message, for acknowledgements
+}
+
+// EventMessageID lets webhook deliveries record the message they carry
+func (m *Message) EventMessageID() uint {
+	return m.MessageID
 }
 
 func NewHub(db *gorm.DB) *Hub {
-	return &Hub{
+	hub := &Hub{
 		clients:    make(map[*Client]bool),
 		broadcast:  make(chan *Message),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		direct:     make(chan *directMessage),
+		disconnect: make(chan *disconnectRequest),
+		typing:     make(chan *typingEvent),
+		resume:     make(chan *resumeRequest),
+		replays:    make(chan *replayBatch),
 		chatrooms:  make(map[uint]map[*Client]bool),
-		db:         db,
+		typists:    make(map[uint]map[uint]*typist),
+		userConns:  make(map[uint]map[*Client]bool),
+		usernames:  make(map[uint]string),
+		seqs:       make(map[uint]uint64),
+
+		presenceUpdates: make(chan *presenceEvent),
+		presence:        make(map[uint]string),
+		db:              db,
+		commands:        make(map[string]*Command),
+		limits:          newRateLimiter(),
+		toUser:          make(chan *userMessage),
+		blocks:          make(chan *blockEvent),
+		renames:         make(chan *renameEvent),
 	}
+	hub.registerBuiltinCommands()
+	return hub
 }
 
 func (h *Hub) Run() {
+	typingTicker := time.NewTicker(time.Second)
+	defer typingTicker.Stop()
+
 	for {
 		select {
 		case client := <-h.register:
 			h.clients[client] = true
-			
+
 			// Add to chatroom
 			if h.chatrooms[client.chatroomID] == nil {
 				h.chatrooms[client.chatroomID] = make(map[*Client]bool)
 			}
 			h.chatrooms[client.chatroomID][client] = true
-			
-			log.Printf("User %s joined chatroom %d", client.user.Username, client.chatroomID)
-			
+
+			// Loaded here rather than before registering so no block is missed
+			if blocked, err := blockedUsers(h.db, client.user.ID); err == nil {
+				client.blocked = blocked
+			} else {
+				log.Printf("Failed to load blocked users for user %d: %v", client.user.ID, err)
+			}
+
+			// Catch a reconnecting client up before any live traffic reaches it
+			if client.resumeFrom > 0 {
+				h.startReplay(client, client.resumeFrom)
+			}
+
+			// Track connections per user for presence
+			if h.userConns[client.user.ID] == nil {
+				h.userConns[client.user.ID] = make(map[*Client]bool)
+			}
+			h.userConns[client.user.ID][client] = true
+			h.usernames[client.user.ID] = client.username()
+			h.refreshPresence(client.user.ID)
+
+			log.Printf("User %s joined chatroom %d", client.username(), client.chatroomID)
+
 			// Send user joined message to chatroom
 			joinMessage := &Message{
 				Type:       "user_joined",
-				Content:    client.user.Username + " joined the chat",
+				Content:    client.username() + " joined the chat",
 				Username:   "System",
 				ChatroomID: client.chatroomID,
 				Timestamp:  time.Now(),
 			}
 			h.broadcastToChatroom(client.chatroomID, joinMessage)
+			h.publish(client.chatroomID, webhooks.EventJoin, joinMessage)
+
+		case req := <-h.resume:
+			if _, ok := h.clients[req.client]; ok {
+				h.startReplay(req.client, req.lastMessageID)
+			}
+
+		case batch := <-h.replays:
+			h.finishReplay(batch)
 
 		case client := <-h.unregister:
 			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				
-				// Remove from chatroom
-				if clients, exists := h.chatrooms[client.chatroomID]; exists {
-					delete(clients, client)
-					if len(clients) == 0 {
-						delete(h.chatrooms, client.chatroomID)
-					}
-				}
-				
-				close(client.send)
-				
-				log.Printf("User %s left chatroom %d", client.user.Username, client.chatroomID)
-				
+				h.dropClient(client)
+				h.stopTyping(client.chatroomID, client.user.ID)
+				h.refreshPresence(client.user.ID)
+
+				log.Printf("User %s left chatroom %d", client.username(), client.chatroomID)
+
 				// Send user left message to chatroom
 				leftMessage := &Message{
 					Type:       "user_left",
-					Content:    client.user.Username + " left the chat",
+					Content:    client.username() + " left the chat",
 					Username:   "System",
 					ChatroomID: client.chatroomID,
 					Timestamp:  time.Now(),
 				}
 				h.broadcastToChatroom(client.chatroomID, leftMessage)
+				h.publish(client.chatroomID, webhooks.EventLeave, leftMessage)
 			}
 
+		case dm := <-h.direct:
+			if _, ok := h.clients[dm.client]; ok {
+				select {
+				case dm.client.send <- dm.message:
+				default:
+				}
+			}
+
+		case um := <-h.toUser:
+			for client := range h.chatrooms[um.chatroomID] {
+				if client.user.ID == um.userID {
+					h.sendOrDrop(client, um.message)
+				}
+			}
+
+		case req := <-h.disconnect:
+			if clients, exists := h.chatrooms[req.chatroomID]; exists {
+				for client := range clients {
+					if client.user.ID != req.userID {
+						continue
+					}
+
+					// Queue the reason before closing so writePump delivers it
+					select {
+					case client.send <- &Message{
+						Type:       "disconnected",
+						Content:    req.reason,
+						Username:   "System",
+						ChatroomID: req.chatroomID,
+						Timestamp:  time.Now(),
+					}:
+					default:
+					}
+
+					h.dropClient(client)
+				}
+				h.stopTyping(req.chatroomID, req.userID)
+				h.refreshPresence(req.userID)
+			}
+
+		case ev := <-h.blocks:
+			h.applyBlock(ev)
+
+		case ev := <-h.renames:
+			h.applyRename(ev)
+
+		case ev := <-h.presenceUpdates:
+			if _, ok := h.clients[ev.client]; ok {
+				ev.client.away = ev.away
+				h.usernames[ev.client.user.ID] = ev.username
+				h.refreshPresence(ev.client.user.ID)
+			}
+
+		case ev := <-h.typing:
+			h.setTyping(ev)
+
+		case now := <-typingTicker.C:
+			h.expireTyping(now)
+
 		case message := <-h.broadcast:
 			// Save message to database
-			if message.Type == "message" {
+			if message.Type == "message" || message.Type == "action" {
+				h.stopTyping(message.ChatroomID, message.UserID)
+				message.HTML = markdown.Render(message.Content)
+
+				// A retried send that was already saved is only acknowledged again
+				if existing, found := h.findByNonce(message.ChatroomID, message.UserID, message.Nonce); found {
+					message.MessageID = existing.ID
+					message.Seq = existing.Seq
+					h.ack(message, nil)
+					continue
+				}
+
 				dbMessage := models.Message{
-					Content:    message.Content,
-					UserID:     message.UserID,
-					ChatroomID: message.ChatroomID,
-					CreatedAt:  message.Timestamp,
+					Type:        message.Type,
+					Content:     message.Content,
+					UserID:      message.UserID,
+					ChatroomID:  message.ChatroomID,
+					ClientNonce: message.Nonce,
+					CreatedAt:   message.Timestamp,
 				}
-				
-				if err := h.db.Create(&dbMessage).Error; err != nil {
+
+				if err := h.saveNumbered(&dbMessage, message.Attachments); err != nil {
 					log.Printf("Failed to save message: %v", err)
-				} else {
-					message.MessageID = dbMessage.ID
+					h.ack(message, errSaveFailed)
+					continue
 				}
+				message.MessageID = dbMessage.ID
+				message.Seq = dbMessage.Seq
+				h.publish(message.ChatroomID, webhooks.EventMessage, message)
+			}
+
+			if message.Type == "message_deleted" {
+				h.publish(message.ChatroomID, webhooks.EventDelete, message)
 			}
-			
+
 			// Broadcast to chatroom
 			h.broadcastToChatroom(message.ChatroomID, message)
+			h.ack(message, nil)
 		}
 	}
 }
 
 func (h *Hub) broadcastToChatroom(chatroomID uint, message *Message) {
+	var dropped []*Client
 	if clients, exists := h.chatrooms[chatroomID]; exists {
 		for client := range clients {
-			select {
-			case client.send <- message:
-			default:
-				close(client.send)
-				delete(h.clients, client)
-				delete(clients, client)
+			if client.hides(message) {
+				continue
+			}
+			if !client.queue(client.frameFor(message)) {
+				// The client isn't keeping up, so disconnect it
+				h.dropClient(client)
+				dropped = append(dropped, client)
 			}
 		}
 	}
+	for _, client := range dropped {
+		h.refreshPresence(client.user.ID)
+	}
+}
+
+// dropClient removes a client from the hub and closes its send channel,
+// which makes writePump close the connection. Must only be called from Run.
+func (h *Hub) dropClient(client *Client) {
+	delete(h.clients, client)
+
+	if clients, exists := h.chatrooms[client.chatroomID]; exists {
+		delete(clients, client)
+		if len(clients) == 0 {
+			delete(h.chatrooms, client.chatroomID)
+		}
+	}
+
+	if conns, exists := h.userConns[client.user.ID]; exists {
+		delete(conns, client)
+		if len(conns) == 0 {
+			delete(h.userConns, client.user.ID)
+		}
+	}
+
+	close(client.send)
+}
+
+// AddEventSink registers a sink for room events. Call it before Run.
+func (h *Hub) AddEventSink(sink EventSink) {
+	h.sinks = append(h.sinks, sink)
+}
+
+func (h *Hub) publish(chatroomID uint, event string, data interface{}) {
+	for _, sink := range h.sinks {
+		sink.Publish(chatroomID, event, data)
+	}
+}
+
+// sendDirect queues a message for a single connection
+func (h *Hub) sendDirect(client *Client, message *Message) {
+	h.direct <- &directMessage{client: client, message: message}
+}
+
+// sendToUser delivers a message to a user's connections in a chatroom
+func (h *Hub) sendToUser(chatroomID, userID uint, message *Message) {
+	h.toUser <- &userMessage{chatroomID: chatroomID, userID: userID, message: message}
+}
+
+// disconnectUser closes a user's connections to a chatroom, telling them why
+func (h *Hub) disconnectUser(chatroomID, userID uint, reason string) {
+	h.disconnect <- &disconnectRequest{chatroomID: chatroomID, userID: userID, reason: reason}
 }
 
 func (h *Handler) HandleWebSocket(hub *Hub) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get chatroom code from URL
 		code := c.Param("code")
-		
+
 		// Verify user authentication
-		token, err := c.Cookie("token")
-		if err != nil || token == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-			return
-		}
-		
-		user := middleware.GetUserByToken(h.db, token)
+		user := middleware.GetRequestUser(h.db, c)
 		if user == nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid session"})
 			return
 		}
-		
+
 		// Get chatroom
 		var chatroom models.Chatroom
 		if err := h.db.Where("code = ?", code).First(&chatroom).Error; err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
 			return
 		}
-		
+
 		// Verify user is a member
 		if !chatroom.IsMember(h.db, user.ID) {
 			c.JSON(http.StatusForbidden, gin.H{"error": "not a member"})
 			return
 		}
-		
+
 		// Upgrade to WebSocket
 		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 		if err != nil {
 			log.Printf("WebSocket upgrade error: %v", err)
 			return
 		}
-		
+
 		// Create client
 		client := &Client{
 			hub:        hub,
@@ -192,11 +467,18 @@ func (h *Handler) HandleWebSocket(hub *Hub) gin.HandlerFunc {
 			send:       make(chan *Message, 256),
 			user:       user,
 			chatroomID: chatroom.ID,
+			blobs:      h.blobs,
+			name:       user.Username,
 		}
-		
+
+		// A reconnecting client passes the last message it saw to get a replay
+		if lastID, err := strconv.ParseUint(c.Query("last_message_id"), 10, 32); err == nil {
+			client.resumeFrom = uint(lastID)
+		}
+
 		// Register client
 		hub.register <- client
-		
+
 		// Start goroutines
 		go client.writePump()
 		go client.readPump()
@@ -208,44 +490,137 @@ func (c *Client) readPump() {
 		c.hub.unregister <- c
 		c.conn.Close()
 	}()
-	
-	c.conn.SetReadLimit(512)
+
+	c.conn.SetReadLimit(MaxFrameSize)
 	c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 	c.conn.SetPongHandler(func(string) error {
 		c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 		return nil
 	})
-	
+
 	for {
 		_, messageBytes, err := c.conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			if err == websocket.ErrReadLimit {
+				log.Printf("Closing connection for %s: frame larger than %d bytes", c.username(), MaxFrameSize)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket error: %v", err)
 			}
 			break
 		}
-		
+
 		var incomingMessage struct {
-			Type    string `json:"type"`
-			Content string `json:"content"`
+			Type      string `json:"type"`
+			Content   string `json:"content"`
+			MessageID uint   `json:"message_id"`
+			Nonce     string `json:"nonce"`
+
+			LastMessageID uint `json:"last_message_id"`
 		}
-		
+
 		if err := json.Unmarshal(messageBytes, &incomingMessage); err != nil {
 			log.Printf("JSON unmarshal error: %v", err)
 			continue
 		}
-		
+
+		// Flood protection for every kind of frame. Only refused chat
+		// messages are answered; anything else is just dropped.
+		if ok, wait := c.frames.take(time.Now(), ConnectionFrameRate, ConnectionFrameBurst); !ok {
+			if incomingMessage.Type == "message" {
+				c.hub.sendDirect(c, rateLimitedFrame(&Message{ChatroomID: c.chatroomID, Nonce: incomingMessage.Nonce}, throttledConnection, wait))
+			} else {
+				throttled.Add(throttledConnection, 1)
+			}
+			continue
+		}
+
+		// Slash commands are handled here rather than broadcast
+		if incomingMessage.Type == "message" && strings.HasPrefix(incomingMessage.Content, "/") {
+			if refused := c.checkMessage(&Message{Content: incomingMessage.Content, ChatroomID: c.chatroomID, Nonce: incomingMessage.Nonce}, true); refused != nil {
+				c.hub.sendDirect(c, refused)
+				continue
+			}
+			c.hub.runCommand(c, strings.TrimSpace(incomingMessage.Content))
+			continue
+		}
+
+		// Typing indicators are fanned out by the hub but never saved.
+		// Refreshes faster than typingMinInterval are dropped here.
+		if incomingMessage.Type == "typing_start" || incomingMessage.Type == "typing_stop" {
+			typing := incomingMessage.Type == "typing_start"
+			if !c.typingAllowed(typing, time.Now()) {
+				continue
+			}
+
+			c.hub.typing <- &typingEvent{
+				chatroomID: c.chatroomID,
+				userID:     c.user.ID,
+				username:   c.username(),
+				typing:     typing,
+			}
+			continue
+		}
+
+		// A hello frame is an alternative to the last_message_id query
+		// parameter; replayed messages may then follow live ones, so clients
+		// should dedupe by message_id
+		if incomingMessage.Type == "hello" {
+			if incomingMessage.LastMessageID > 0 {
+				c.hub.resume <- &resumeRequest{client: c, lastMessageID: incomingMessage.LastMessageID}
+			}
+			continue
+		}
+
+		if incomingMessage.Type == "presence" {
+			c.hub.presenceUpdates <- &presenceEvent{
+				client:   c,
+				away:     incomingMessage.Content == PresenceAway,
+				username: c.username(),
+			}
+			continue
+		}
+
+		if incomingMessage.Type == "read" {
+			if _, err := c.hub.markRead(c.chatroomID, c.user.ID, c.username(), incomingMessage.MessageID); err != nil && err != errMessageNotInRoom {
+				log.Printf("Failed to mark messages read: %v", err)
+			}
+			continue
+		}
+
+		// Everything else a client may send is a plain chat message
+		if incomingMessage.Type != "message" {
+			continue
+		}
+
 		// Create message to broadcast
 		message := &Message{
 			Type:       incomingMessage.Type,
 			Content:    incomingMessage.Content,
 			UserID:     c.user.ID,
-			Username:   c.user.Username,
+			Username:   c.username(),
 			ChatroomID: c.chatroomID,
 			Timestamp:  time.Now(),
+			IsBot:      c.user.IsBot,
+			Nonce:      incomingMessage.Nonce,
+			sender:     c,
+		}
+
+		if err := validateNonce(message.Nonce); err != nil {
+			c.hub.sendDirect(c, ackFrame(message, err))
+			continue
+		}
+
+		if !c.hub.isRetry(c.chatroomID, c.user.ID, message.Nonce) {
+			if refused := c.checkMessage(message, false); refused != nil {
+				c.hub.sendDirect(c, refused)
+				continue
+			}
+		}
+
+		// Long text already moved into an attachment goes if the message does
+		if decision, _ := c.hub.submit(message); decision.Action == moderation.Reject {
+			discardAttachments(c.hub.db, c.blobs, message.Attachments)
 		}
-		
-		c.hub.broadcast <- message
 	}
 }
 
@@ -255,7 +630,7 @@ func (c *Client) writePump() {
 		ticker.Stop()
 		c.conn.Close()
 	}()
-	
+
 	for {
 		select {
 		case message, ok := <-c.send:
@@ -264,18 +639,18 @@ func (c *Client) writePump() {
 				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
-			
+
 			messageBytes, err := json.Marshal(message)
 			if err != nil {
 				log.Printf("JSON marshal error: %v", err)
 				continue
 			}
-			
+
 			if err := c.conn.WriteMessage(websocket.TextMessage, messageBytes); err != nil {
 				log.Printf("WebSocket write error: %v", err)
 				return
 			}
-			
+
 		case <-ticker.C:
 			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
@@ -285,58 +660,99 @@ func (c *Client) writePump() {
 	}
 }
 
-// API endpoint to get recent messages
+// API endpoint to get a room's message history in seq order. With no
+// cursor, or with before_seq, before_id or around_id, pages come newest
+// first and has_more says whether older messages remain. after_id and
+// after_seq page forwards, oldest first, and has_more says whether newer
+// messages remain.
 func (h *Handler) GetMessages(c *gin.Context) {
-	token, err := c.Cookie("token")
-	if err != nil || token == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-		return
-	}
-	user := middleware.GetUserByToken(h.db, token)
+	user := middleware.GetRequestUser(h.db, c)
 	if user == nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid session"})
 		return
 	}
-	
+
 	code := c.Param("code")
 	var chatroom models.Chatroom
 	if err := h.db.Where("code = ?", code).First(&chatroom).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
 		return
 	}
-	
+
 	if !chatroom.IsMember(h.db, user.ID) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "not a member"})
 		return
 	}
-	
+
 	// Get query parameters
-	limitStr := c.DefaultQuery("limit", "50")
-	afterIDStr := c.Query("after_id")
-	
-	limit, _ := strconv.Atoi(limitStr)
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit <= 0 {
+		limit = 50
+	}
 	if limit > 100 {
 		limit = 100
 	}
-	
+
+	// Only one cursor is used; the first one present wins
+	var cursor, cursorValue string
+	for _, name := range []string{"before_seq", "before_id", "around_id", "after_id", "after_seq"} {
+		if value := c.Query(name); value != "" {
+			cursor, cursorValue = name, value
+			break
+		}
+	}
+
+	var position uint64
+	if cursor != "" {
+		var err error
+		position, err = strconv.ParseUint(cursorValue, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + cursor})
+			return
+		}
+	}
+
+	// Message ID cursors are turned into positions, since pages follow seq
+	if cursor == "before_id" || cursor == "after_id" {
+		seq, err := messageSeq(h.db, chatroom.ID, uint(position))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		position = seq
+	}
+
+	// Messages from users the caller blocked are left out
+	db := withoutBlocked(h.db, user.ID)
 	var messages []models.Message
-	query := h.db.Where("chatroom_id = ?", chatroom.ID).
-		Preload("User").
-		Order("created_at ASC").
-		Limit(limit)
-	
-	if afterIDStr != "" {
-		afterID, _ := strconv.ParseUint(afterIDStr, 10, 32)
-		query = query.Where("id > ?", afterID)
+	var hasMore, hasNewer bool
+	var err error
+	switch cursor {
+	case "after_id", "after_seq":
+		// Clients that spot a gap in sequence numbers fetch from the last one they saw
+		messages, hasMore, err = newerMessages(db, chatroom.ID, position, limit)
+	case "around_id":
+		messages, hasMore, hasNewer, err = messagesAround(db, chatroom.ID, uint(position), limit)
+	default:
+		messages, hasMore, err = olderMessages(db, chatroom.ID, position, limit)
+	}
+	if err == errMessageNotInRoom {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
 	}
-	
-	if err := query.Find(&messages).Error; err != nil {
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch messages"})
 		return
 	}
-	
-	c.JSON(http.StatusOK, gin.H{
+	attachPreviews(h.db, messages)
+
+	response := gin.H{
 		"messages": messages,
 		"count":    len(messages),
-	})
-}
\ No newline at end of file
+		"has_more": hasMore,
+	}
+	if cursor == "around_id" {
+		response["has_newer"] = hasNewer
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,92 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+type discordExport struct {
+	Channel struct {
+		ID    string `json:"id"`
+		Name  string `json:"name"`
+		Topic string `json:"topic"`
+	} `json:"channel"`
+	Messages []struct {
+		ID        string    `json:"id"`
+		Type      string    `json:"type"`
+		Timestamp time.Time `json:"timestamp"`
+		Content   string    `json:"content"`
+		Author    struct {
+			ID    string `json:"id"`
+			Name  string `json:"name"`
+			IsBot bool   `json:"isBot"`
+		} `json:"author"`
+		Attachments []struct {
+			URL      string `json:"url"`
+			FileName string `json:"fileName"`
+		} `json:"attachments"`
+	} `json:"messages"`
+}
+
+// Discord message types that are imported; the rest, such as pins and
+// member joins, are skipped
+var discordTypes = map[string]bool{"Default": true, "Reply": true}
+
+// ReadDiscord reads channel exports in the JSON format written by
+// DiscordChatExporter, one channel per file
+func ReadDiscord(filenames ...string) (*Export, error) {
+	export := &Export{Source: Discord, Authors: make(map[string]*Author)}
+	for _, filename := range filenames {
+		channel, err := readDiscordChannel(export, filename)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		export.Channels = append(export.Channels, channel)
+	}
+	return export, nil
+}
+
+func readDiscordChannel(export *Export, filename string) (*Channel, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var de discordExport
+	if err := json.NewDecoder(f).Decode(&de); err != nil {
+		return nil, err
+	}
+	if de.Channel.ID == "" {
+		return nil, errors.New("not a Discord channel export")
+	}
+
+	channel := &Channel{ExternalID: de.Channel.ID, Name: de.Channel.Name, Topic: de.Channel.Topic}
+	for _, dm := range de.Messages {
+		content := dm.Content
+		for _, a := range dm.Attachments {
+			content += "\n[" + a.FileName + "](" + a.URL + ")"
+		}
+		content = strings.TrimSpace(content)
+		if !discordTypes[dm.Type] || content == "" {
+			channel.Skipped++
+			continue
+		}
+
+		if export.Authors[dm.Author.ID] == nil {
+			export.Authors[dm.Author.ID] = &Author{ExternalID: dm.Author.ID, Name: dm.Author.Name, IsBot: dm.Author.IsBot}
+		}
+		channel.Messages = append(channel.Messages, &Message{
+			ExternalID: dm.ID,
+			AuthorID:   dm.Author.ID,
+			Type:       "message",
+			Content:    content,
+			CreatedAt:  dm.Timestamp.UTC(),
+		})
+	}
+	return channel, nil
+}
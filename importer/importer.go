@@ -0,0 +1,414 @@
+// Package importer loads message history exported from Slack or Discord.
+// Each channel becomes a room, authors without an account here become
+// placeholder users, and messages keep their original timestamps.
+// Importing the same export again only adds what is missing.
+package importer
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+// Export sources
+const (
+	Slack   = "slack"
+	Discord = "discord"
+)
+
+// batchSize is how many messages are inserted at a time
+const batchSize = 500
+
+// Export is the history read from another chat service
+type Export struct {
+	Source   string
+	Authors  map[string]*Author // by external ID
+	Channels []*Channel
+}
+
+// Author is a user of the other service
+type Author struct {
+	ExternalID string
+	Name       string
+	Email      string // matched against existing accounts when set
+	IsBot      bool
+}
+
+// Channel is a channel of the other service and its messages
+type Channel struct {
+	ExternalID string
+	Name       string
+	Topic      string
+	Messages   []*Message
+	Skipped    int // events that aren't messages, such as joins
+}
+
+// Message is a message to import. Type is "message" or "action".
+type Message struct {
+	ExternalID string
+	AuthorID   string
+	Type       string
+	Content    string
+	CreatedAt  time.Time
+}
+
+// Options control an import
+type Options struct {
+	Owner    *models.User // owns and is the only member of new rooms
+	Password string       // join password for new rooms
+	DryRun   bool         // report what would be imported without saving it
+}
+
+// Report describes what an import did, or would do in a dry run
+type Report struct {
+	Source       string
+	DryRun       bool
+	Channels     []ChannelReport
+	UsersCreated []string
+	UsersMatched int // authors mapped to existing accounts
+}
+
+// ChannelReport describes the import of one channel
+type ChannelReport struct {
+	Name     string
+	Code     string
+	NewRoom  bool
+	Found    int // messages in the export
+	Imported int
+	Existing int // imported by an earlier run
+	TooOld   int // missing, but older than the room's newest message
+	Skipped  int // events that aren't messages, such as joins
+}
+
+var errDryRun = errors.New("dry run")
+
+// Run imports an export in a single transaction, so a failed import leaves
+// nothing behind. A dry run is rolled back once the report is complete.
+func Run(db *gorm.DB, export *Export, opts Options) (*Report, error) {
+	report := &Report{Source: export.Source, DryRun: opts.DryRun}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		im := &importer{tx: tx, export: export, opts: opts, report: report, users: make(map[string]uint)}
+		for _, channel := range export.Channels {
+			if err := im.importChannel(channel); err != nil {
+				return fmt.Errorf("channel %s: %w", channel.Name, err)
+			}
+		}
+		if opts.DryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && err != errDryRun {
+		return nil, err
+	}
+	return report, nil
+}
+
+type importer struct {
+	tx     *gorm.DB
+	export *Export
+	opts   Options
+	report *Report
+	users  map[string]uint // author external ID -> user ID
+}
+
+func (im *importer) importChannel(channel *Channel) error {
+	chatroom, created, err := im.room(channel)
+	if err != nil {
+		return err
+	}
+	result := ChannelReport{Name: chatroom.Name, Code: chatroom.Code, NewRoom: created, Found: len(channel.Messages), Skipped: channel.Skipped}
+
+	// Nonces identify messages from earlier runs
+	nonces := make([]string, len(channel.Messages))
+	for i, m := range channel.Messages {
+		nonces[i] = im.export.Source + ":" + m.ExternalID
+	}
+	existing := make(map[string]bool)
+	for start := 0; start < len(nonces); start += batchSize {
+		end := min(start+batchSize, len(nonces))
+		var found []string
+		if err := im.tx.Model(&models.Message{}).
+			Where("chatroom_id = ? AND client_nonce IN ?", chatroom.ID, nonces[start:end]).
+			Pluck("client_nonce", &found).Error; err != nil {
+			return err
+		}
+		for _, nonce := range found {
+			existing[nonce] = true
+		}
+	}
+
+	seq, err := models.LastMessageSeq(im.tx, chatroom.ID)
+	if err != nil {
+		return err
+	}
+
+	// History is ordered by seq, so messages older than the room's newest
+	// can't be slotted in; they are usually ones retention already deleted
+	var newest time.Time
+	if seq > 0 {
+		var last models.Message
+		if err := im.tx.Select("created_at").Where("chatroom_id = ?", chatroom.ID).Order("seq DESC").First(&last).Error; err != nil {
+			return err
+		}
+		newest = last.CreatedAt
+	}
+
+	sort.SliceStable(channel.Messages, func(i, j int) bool {
+		return channel.Messages[i].CreatedAt.Before(channel.Messages[j].CreatedAt)
+	})
+	var batch []models.Message
+	for i, m := range channel.Messages {
+		if existing[nonces[i]] {
+			result.Existing++
+			continue
+		}
+		if m.CreatedAt.Before(newest) {
+			result.TooOld++
+			continue
+		}
+		userID, err := im.user(m.AuthorID)
+		if err != nil {
+			return err
+		}
+		seq++
+		batch = append(batch, models.Message{
+			Type:        m.Type,
+			Content:     m.Content,
+			UserID:      userID,
+			ChatroomID:  chatroom.ID,
+			Seq:         seq,
+			ClientNonce: nonces[i],
+			CreatedAt:   m.CreatedAt,
+		})
+		existing[nonces[i]] = true // repeated in the export
+	}
+	if len(batch) > 0 {
+		if err := im.tx.CreateInBatches(batch, batchSize).Error; err != nil {
+			return err
+		}
+	}
+	result.Imported = len(batch)
+
+	if result.Imported > 0 {
+		after, err := json.Marshal(map[string]interface{}{
+			"source":   im.export.Source,
+			"channel":  channel.ExternalID,
+			"imported": result.Imported,
+		})
+		if err != nil {
+			return err
+		}
+		if err := im.tx.Create(&models.AuditEvent{
+			ChatroomID: chatroom.ID,
+			ActorID:    im.opts.Owner.ID,
+			Action:     models.AuditRoomImport,
+			After:      string(after),
+		}).Error; err != nil {
+			return err
+		}
+	}
+
+	im.report.Channels = append(im.report.Channels, result)
+	return nil
+}
+
+// room finds the room a channel was imported into before, or creates one
+func (im *importer) room(channel *Channel) (*models.Chatroom, bool, error) {
+	var mapping models.ImportedChannel
+	err := im.tx.Where("source = ? AND external_id = ?", im.export.Source, channel.ExternalID).First(&mapping).Error
+	if err == nil {
+		var chatroom models.Chatroom
+		if err := im.tx.First(&chatroom, mapping.ChatroomID).Error; err != nil {
+			return nil, false, err
+		}
+		return &chatroom, false, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, err
+	}
+
+	if im.opts.Password == "" {
+		return nil, false, errors.New("a password is required to create rooms")
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(im.opts.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, false, err
+	}
+	code, err := im.roomCode()
+	if err != nil {
+		return nil, false, err
+	}
+
+	chatroom := models.Chatroom{
+		Code:     code,
+		Name:     channel.Name,
+		Password: string(hashedPassword),
+		Topic:    channel.Topic,
+		OwnerID:  im.opts.Owner.ID,
+	}
+	if err := im.tx.Create(&chatroom).Error; err != nil {
+		return nil, false, err
+	}
+	if err := im.tx.Create(&models.Membership{
+		UserID:     im.opts.Owner.ID,
+		ChatroomID: chatroom.ID,
+		Role:       models.RoleOwner,
+		JoinedAt:   time.Now(),
+	}).Error; err != nil {
+		return nil, false, err
+	}
+	if err := im.tx.Create(&models.ImportedChannel{
+		Source:     im.export.Source,
+		ExternalID: channel.ExternalID,
+		ChatroomID: chatroom.ID,
+	}).Error; err != nil {
+		return nil, false, err
+	}
+	return &chatroom, true, nil
+}
+
+const roomCodeChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// roomCode generates an unused room code like the ones rooms get when
+// created on the site
+func (im *importer) roomCode() (string, error) {
+	for {
+		code := make([]byte, 8)
+		for i := range code {
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(roomCodeChars))))
+			if err != nil {
+				return "", err
+			}
+			code[i] = roomCodeChars[n.Int64()]
+		}
+		var count int64
+		if err := im.tx.Model(&models.Chatroom{}).Where("code = ?", string(code)).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return string(code), nil
+		}
+	}
+}
+
+// user returns the account for an author: an existing user with the same
+// email, the placeholder made by an earlier run, or a new placeholder
+func (im *importer) user(externalID string) (uint, error) {
+	if id, ok := im.users[externalID]; ok {
+		return id, nil
+	}
+
+	author := im.export.Authors[externalID]
+	if author == nil {
+		author = &Author{ExternalID: externalID, Name: "unknown-" + externalID}
+	}
+	placeholderEmail := im.export.Source + "-" + safeName(author.ExternalID) + "@import.invalid"
+
+	var user models.User
+	err := im.tx.Where("email = ?", placeholderEmail).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) && author.Email != "" {
+		if err = im.tx.Where("email = ?", author.Email).First(&user).Error; err == nil {
+			im.report.UsersMatched++
+		}
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		user, err = im.createPlaceholder(author, placeholderEmail)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	im.users[externalID] = user.ID
+	return user.ID, nil
+}
+
+// createPlaceholder makes an account that can't log in, named after the
+// author. A source suffix, then a number, is added if the name is taken.
+func (im *importer) createPlaceholder(author *Author, email string) (models.User, error) {
+	base := safeName(author.Name)
+	if base == "" {
+		base = safeName(author.ExternalID)
+	}
+	username := base
+	for n := 1; ; n++ {
+		var count int64
+		if err := im.tx.Model(&models.User{}).Where("username = ?", username).Count(&count).Error; err != nil {
+			return models.User{}, err
+		}
+		if count == 0 {
+			break
+		}
+		username = base + "_" + im.export.Source
+		if n > 1 {
+			username += fmt.Sprint(n)
+		}
+	}
+
+	// Placeholders never log in, so store a hash of random bytes
+	secret := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return models.User{}, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword(secret, bcrypt.DefaultCost)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	user := models.User{
+		Username: username,
+		Email:    email,
+		Password: string(hashedPassword),
+		IsBot:    author.IsBot,
+	}
+	if err := im.tx.Create(&user).Error; err != nil {
+		return models.User{}, err
+	}
+	im.report.UsersCreated = append(im.report.UsersCreated, username)
+	return user, nil
+}
+
+var unsafeNameChars = regexp.MustCompile(`[^\p{L}\p{N}_.-]+`)
+
+// safeName turns a display name into a single-word username
+func safeName(name string) string {
+	return strings.Trim(unsafeNameChars.ReplaceAllString(strings.TrimSpace(name), "_"), "_")
+}
+
+// Write prints the report for an administrator
+func (r *Report) Write(w io.Writer) {
+	if r.DryRun {
+		fmt.Fprintf(w, "Dry run of %s import; nothing was saved\n\n", r.Source)
+	}
+	for _, c := range r.Channels {
+		room := c.Code
+		if c.NewRoom {
+			room = "new room " + room
+			if r.DryRun {
+				room = "new room"
+			}
+		}
+		fmt.Fprintf(w, "%s (%s): %d messages, %d imported, %d already imported, %d other events skipped\n",
+			c.Name, room, c.Found, c.Imported, c.Existing, c.Skipped)
+		if c.TooOld > 0 {
+			fmt.Fprintf(w, "  %d messages not imported because they are older than the room's newest message\n", c.TooOld)
+		}
+	}
+	fmt.Fprintf(w, "\n%d placeholder users created", len(r.UsersCreated))
+	if len(r.UsersCreated) > 0 {
+		fmt.Fprintf(w, ": %s", strings.Join(r.UsersCreated, ", "))
+	}
+	fmt.Fprintf(w, "\n%d authors matched to existing accounts by email\n", r.UsersMatched)
+}
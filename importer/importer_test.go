@@ -0,0 +1,54 @@
+package importer_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeffasante/chatroom.go/importer"
+	"github.com/jeffasante/chatroom.go/internal/chattest"
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+func export(messages ...*importer.Message) *importer.Export {
+	return &importer.Export{
+		Source:   importer.Slack,
+		Authors:  map[string]*importer.Author{"U1": {ExternalID: "U1", Name: "carol"}},
+		Channels: []*importer.Channel{{ExternalID: "C1", Name: "general", Messages: messages}},
+	}
+}
+
+func message(id string, at time.Time) *importer.Message {
+	return &importer.Message{ExternalID: id, AuthorID: "U1", Type: "message", Content: id, CreatedAt: at}
+}
+
+func TestReimportKeepsHistoryInOrder(t *testing.T) {
+	srv := chattest.New(t)
+	var owner models.User
+	srv.DB.First(&owner, srv.SignUp(t, "alice").ID)
+	opts := importer.Options{Owner: &owner, Password: "secret"}
+
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	if _, err := importer.Run(srv.DB, export(message("b", start.Add(time.Hour))), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// A later export with a message from before the first import's
+	report, err := importer.Run(srv.DB, export(
+		message("a", start),
+		message("b", start.Add(time.Hour)),
+		message("c", start.Add(2*time.Hour)),
+	), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := report.Channels[0]
+	if got.Imported != 1 || got.Existing != 1 || got.TooOld != 1 {
+		t.Errorf("report %+v, want 1 imported, 1 existing and 1 too old", got)
+	}
+
+	var contents []string
+	srv.DB.Model(&models.Message{}).Order("seq ASC").Pluck("content", &contents)
+	if len(contents) != 2 || contents[0] != "b" || contents[1] != "c" {
+		t.Errorf("room history %v, want [b c]", contents)
+	}
+}
@@ -0,0 +1,263 @@
+package importer
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type slackUser struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	IsBot   bool   `json:"is_bot"`
+	Profile struct {
+		Email       string `json:"email"`
+		DisplayName string `json:"display_name"`
+	} `json:"profile"`
+}
+
+type slackChannel struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Topic struct {
+		Value string `json:"value"`
+	} `json:"topic"`
+}
+
+type slackMessage struct {
+	Type     string `json:"type"`
+	Subtype  string `json:"subtype"`
+	User     string `json:"user"`
+	BotID    string `json:"bot_id"`
+	Username string `json:"username"` // name shown for bot messages
+	Text     string `json:"text"`
+	TS       string `json:"ts"`
+	Files    []struct {
+		Name       string `json:"name"`
+		Title      string `json:"title"`
+		URLPrivate string `json:"url_private"`
+	} `json:"files"`
+}
+
+// Slack message subtypes that are imported; the rest, such as joins and
+// topic changes, are skipped
+var slackSubtypes = map[string]string{
+	"":                 "message",
+	"thread_broadcast": "message",
+	"file_share":       "message",
+	"bot_message":      "message",
+	"me_message":       "action",
+}
+
+// ReadSlack reads a Slack workspace export: a zip with users.json,
+// channels.json (and groups.json for private channels) and a folder of
+// daily message files per channel. Direct messages are not imported.
+func ReadSlack(filename string) (*Export, error) {
+	archive, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	// Exports are sometimes zipped with an enclosing folder
+	files := make(map[string]*zip.File)
+	root := ""
+	for _, f := range archive.File {
+		files[f.Name] = f
+		if path.Base(f.Name) == "channels.json" {
+			root = path.Dir(f.Name)
+		}
+	}
+	if root == "" {
+		return nil, errors.New("not a Slack export: channels.json is missing")
+	}
+
+	export := &Export{Source: Slack, Authors: make(map[string]*Author)}
+
+	var users []slackUser
+	if err := readZipJSON(files, path.Join(root, "users.json"), &users); err != nil {
+		return nil, err
+	}
+	names := make(map[string]string)
+	for _, u := range users {
+		export.Authors[u.ID] = &Author{ExternalID: u.ID, Name: u.Name, Email: u.Profile.Email, IsBot: u.IsBot}
+		names[u.ID] = u.Name
+		if u.Profile.DisplayName != "" {
+			names[u.ID] = u.Profile.DisplayName
+		}
+	}
+
+	var channels []slackChannel
+	for _, list := range []string{"channels.json", "groups.json"} {
+		var more []slackChannel
+		if err := readZipJSON(files, path.Join(root, list), &more); err != nil && !errors.Is(err, errMissingFile) {
+			return nil, err
+		}
+		channels = append(channels, more...)
+	}
+
+	for _, sc := range channels {
+		channel := &Channel{ExternalID: sc.ID, Name: sc.Name, Topic: sc.Topic.Value}
+
+		// One file per day, named YYYY-MM-DD.json
+		var days []string
+		prefix := path.Join(root, sc.Name) + "/"
+		for name := range files {
+			if strings.HasPrefix(name, prefix) && strings.HasSuffix(name, ".json") {
+				days = append(days, name)
+			}
+		}
+		sort.Strings(days)
+
+		for _, day := range days {
+			var messages []slackMessage
+			if err := readZipJSON(files, day, &messages); err != nil {
+				return nil, err
+			}
+			for _, sm := range messages {
+				message, err := slackToMessage(export, sc.ID, sm, names)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", day, err)
+				}
+				if message == nil {
+					channel.Skipped++
+					continue
+				}
+				channel.Messages = append(channel.Messages, message)
+			}
+		}
+		export.Channels = append(export.Channels, channel)
+	}
+	return export, nil
+}
+
+func slackToMessage(export *Export, channelID string, sm slackMessage, names map[string]string) (*Message, error) {
+	messageType, ok := slackSubtypes[sm.Subtype]
+	if sm.Type != "message" || !ok {
+		return nil, nil
+	}
+
+	createdAt, err := parseSlackTS(sm.TS)
+	if err != nil {
+		return nil, err
+	}
+
+	content := slackText(sm.Text, names)
+	for _, f := range sm.Files {
+		title := f.Title
+		if title == "" {
+			title = f.Name
+		}
+		if f.URLPrivate != "" {
+			content += "\n[" + title + "](" + f.URLPrivate + ")"
+		}
+	}
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return nil, nil
+	}
+
+	authorID := sm.User
+	if authorID == "" || sm.Subtype == "bot_message" {
+		// Bot messages may have no user, only a bot ID and display name
+		authorID = "bot-" + sm.BotID
+		if sm.BotID == "" {
+			authorID = "bot-" + sm.Username
+		}
+		if export.Authors[authorID] == nil {
+			name := sm.Username
+			if name == "" {
+				name = authorID
+			}
+			export.Authors[authorID] = &Author{ExternalID: authorID, Name: name, IsBot: true}
+		}
+	}
+
+	return &Message{
+		ExternalID: channelID + "-" + sm.TS,
+		AuthorID:   authorID,
+		Type:       messageType,
+		Content:    content,
+		CreatedAt:  createdAt,
+	}, nil
+}
+
+// parseSlackTS converts a Slack timestamp such as "1586000000.000100",
+// seconds and microseconds since the epoch
+func parseSlackTS(ts string) (time.Time, error) {
+	seconds, micros, _ := strings.Cut(ts, ".")
+	sec, err := strconv.ParseInt(seconds, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", ts)
+	}
+	var usec int64
+	if micros != "" {
+		if usec, err = strconv.ParseInt(micros, 10, 64); err != nil {
+			return time.Time{}, fmt.Errorf("invalid timestamp %q", ts)
+		}
+	}
+	return time.Unix(sec, usec*1000).UTC(), nil
+}
+
+var slackMarkup = regexp.MustCompile(`<([^<>]+)>`)
+
+// slackText turns Slack's message markup into plain text and Markdown:
+// <@U123> mentions, <#C123|general> channels, <!here> and <url|label> links
+func slackText(text string, names map[string]string) string {
+	text = slackMarkup.ReplaceAllStringFunc(text, func(match string) string {
+		target, label, _ := strings.Cut(match[1:len(match)-1], "|")
+		switch {
+		case strings.HasPrefix(target, "@"):
+			if name, ok := names[target[1:]]; ok {
+				return "@" + name
+			}
+			if label != "" {
+				return "@" + label
+			}
+			return target
+		case strings.HasPrefix(target, "#"):
+			if label != "" {
+				return "#" + label
+			}
+			return target
+		case strings.HasPrefix(target, "!"):
+			if label != "" {
+				return label
+			}
+			special, _, _ := strings.Cut(target[1:], "^")
+			return "@" + special
+		case label != "" && label != target:
+			return "[" + label + "](" + target + ")"
+		default:
+			return strings.TrimPrefix(target, "mailto:")
+		}
+	})
+	// Slack escapes only these three characters
+	return html.UnescapeString(text)
+}
+
+var errMissingFile = errors.New("file missing from export")
+
+func readZipJSON(files map[string]*zip.File, name string, v interface{}) error {
+	f, ok := files[name]
+	if !ok {
+		return fmt.Errorf("%s: %w", name, errMissingFile)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := json.NewDecoder(r).Decode(v); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,343 @@
+// Package chattest runs the chat server on a temporary database for tests,
+// behind an httptest server with the real routes.
+package chattest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/jeffasante/chatroom.go/handlers"
+	"github.com/jeffasante/chatroom.go/models"
+	"github.com/jeffasante/chatroom.go/storage"
+	"github.com/jeffasante/chatroom.go/unfurl"
+)
+
+// Server is a running chat server
+type Server struct {
+	*httptest.Server
+	DB      *gorm.DB
+	Handler *handlers.Handler
+	Hub     *handlers.Hub
+}
+
+// Option changes how the server is set up, before it starts
+type Option func(s *Server)
+
+// WithBlobStore stores attachments in blobs instead of a temporary directory
+func WithBlobStore(blobs storage.BlobStore) Option {
+	return func(s *Server) { s.Handler.SetBlobStore(blobs) }
+}
+
+// WithUnfurling fetches link previews for new messages. Set
+// unfurl.AllowPrivate to fetch them from httptest servers.
+func WithUnfurling() Option {
+	return func(s *Server) { s.Hub.EnableUnfurling(unfurl.New(s.DB), 1) }
+}
+
+// WithFullTextSearch searches with the FTS5 index, skipping the test when
+// SQLite was built without it (build with -tags sqlite_fts5)
+func WithFullTextSearch(t testing.TB) Option {
+	return func(s *Server) {
+		if err := models.SetupSearchIndex(s.DB); err != nil {
+			t.Skipf("FTS5 unavailable: %v", err)
+		}
+		handlers.FullTextSearch = true
+		t.Cleanup(func() { handlers.FullTextSearch = false })
+	}
+}
+
+// New starts a server that is closed when the test ends
+func New(t testing.TB, opts ...Option) *Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	dir := t.TempDir()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dir, "chatroom.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := models.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	blobs, err := storage.NewLocal(filepath.Join(dir, "uploads"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{DB: db, Handler: handlers.New(db), Hub: handlers.NewHub(db)}
+	s.Handler.SetBlobStore(blobs)
+	for _, opt := range opts {
+		opt(s)
+	}
+	go s.Hub.Run()
+
+	router := gin.New()
+	s.Handler.RegisterRoutes(router, s.Hub)
+	s.Server = httptest.NewServer(router)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// User is a signed-in account. Its Client sends the session cookie.
+type User struct {
+	ID       uint
+	Username string
+	Token    string // session token, or API token for bots
+	Client   *http.Client
+}
+
+// SignUp creates an account and logs it in
+func (s *Server) SignUp(t testing.TB, username string) *User {
+	t.Helper()
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{
+		Jar:     jar,
+		Timeout: 10 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	form := url.Values{"username": {username}, "email": {username + "@example.com"}, "password": {"password"}}
+	resp, err := client.PostForm(s.URL+"/signup", form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if location := resp.Header.Get("Location"); !strings.Contains(location, "success") {
+		t.Fatalf("sign up %s: redirected to %s", username, location)
+	}
+
+	resp, err = client.PostForm(s.URL+"/login", url.Values{"email": {username}, "password": {"password"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	user := &User{Username: username, Client: client}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "token" {
+			user.Token = cookie.Value
+		}
+	}
+	if user.Token == "" {
+		t.Fatalf("log in %s: no session cookie", username)
+	}
+
+	var account models.User
+	if err := s.DB.Where("username = ?", username).First(&account).Error; err != nil {
+		t.Fatal(err)
+	}
+	user.ID = account.ID
+	return user
+}
+
+// Do sends a request as the user and decodes the JSON response into out,
+// if out isn't nil. body is sent as a form, or as JSON if it isn't
+// url.Values.
+func (s *Server) Do(t testing.TB, user *User, method, path string, body interface{}, out interface{}) int {
+	t.Helper()
+	var reader io.Reader
+	contentType := ""
+	switch b := body.(type) {
+	case nil:
+	case url.Values:
+		reader = strings.NewReader(b.Encode())
+		contentType = "application/x-www-form-urlencoded"
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = strings.NewReader(string(data))
+		contentType = "application/json"
+	}
+
+	req, err := http.NewRequest(method, s.URL+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := user.Client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decoding %s response: %v", method, path, resp.Status, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// CreateRoom creates a room owned by user and returns its code
+func (s *Server) CreateRoom(t testing.TB, user *User, name, password string) string {
+	t.Helper()
+	var result struct {
+		Code  string `json:"code"`
+		Error string `json:"error"`
+	}
+	s.Do(t, user, http.MethodPost, "/create-room", url.Values{"name": {name}, "password": {password}}, &result)
+	if result.Code == "" {
+		t.Fatalf("create room: %s", result.Error)
+	}
+	return result.Code
+}
+
+// JoinRoom makes user a member of a room
+func (s *Server) JoinRoom(t testing.TB, user *User, code, password string) {
+	t.Helper()
+	var result struct {
+		Success bool   `json:"success"`
+		Error   string `json:"error"`
+	}
+	s.Do(t, user, http.MethodPost, "/join-room", url.Values{"code": {code}, "password": {password}}, &result)
+	if !result.Success {
+		t.Fatalf("join room: %s", result.Error)
+	}
+}
+
+// Conn is a user's WebSocket connection to a room
+type Conn struct {
+	*websocket.Conn
+	t testing.TB
+}
+
+// Connect opens a WebSocket connection to a room as the user
+func (s *Server) Connect(t testing.TB, user *User, code string) *Conn {
+	t.Helper()
+	return s.dial(t, user, "/ws/"+code)
+}
+
+// Resume reconnects to a room, asking for the messages after lastMessageID
+func (s *Server) Resume(t testing.TB, user *User, code string, lastMessageID uint) *Conn {
+	t.Helper()
+	return s.dial(t, user, fmt.Sprintf("/ws/%s?last_message_id=%d", code, lastMessageID))
+}
+
+func (s *Server) dial(t testing.TB, user *User, path string) *Conn {
+	t.Helper()
+	header := http.Header{"Cookie": {"token=" + user.Token}}
+	ws, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+path, header)
+	if err != nil {
+		status := ""
+		if resp != nil {
+			status = resp.Status
+		}
+		t.Fatalf("connect to %s: %v %s", path, err, status)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return &Conn{Conn: ws, t: t}
+}
+
+// Frame is a frame received from a room. Only commonly checked fields are
+// decoded; Raw has the whole frame.
+type Frame struct {
+	Type       string `json:"type"`
+	Content    string `json:"content"`
+	UserID     uint   `json:"user_id"`
+	Username   string `json:"username"`
+	MessageID  uint   `json:"message_id"`
+	MessageIDs []uint `json:"message_ids"`
+	Seq        uint64 `json:"seq"`
+	Nonce      string `json:"nonce"`
+	Error      string `json:"error"`
+	Raw        json.RawMessage
+}
+
+// Send writes a JSON frame
+func (c *Conn) Send(frame interface{}) {
+	c.t.Helper()
+	if err := c.WriteJSON(frame); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// Next returns the next frame, or fails the test if none arrives in time
+func (c *Conn) Next() Frame {
+	c.t.Helper()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := c.ReadMessage()
+	if err != nil {
+		c.t.Fatalf("reading frame: %v", err)
+	}
+	var frame Frame
+	if err := json.Unmarshal(data, &frame); err != nil {
+		c.t.Fatal(err)
+	}
+	frame.Raw = data
+	return frame
+}
+
+// Await skips frames until one matches, or fails the test after timeout
+func (c *Conn) Await(match func(Frame) bool) Frame {
+	c.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if frame := c.Next(); match(frame) {
+			return frame
+		}
+	}
+	c.t.Fatal("no matching frame arrived")
+	return Frame{}
+}
+
+// Quiet reports whether no frame matching match arrives within wait. The
+// connection can't be read from afterwards.
+func (c *Conn) Quiet(wait time.Duration, match func(Frame) bool) bool {
+	c.t.Helper()
+	deadline := time.Now().Add(wait)
+	for {
+		c.SetReadDeadline(deadline)
+		_, data, err := c.ReadMessage()
+		if err != nil {
+			return true
+		}
+		var frame Frame
+		if json.Unmarshal(data, &frame) == nil {
+			frame.Raw = data
+			if match(frame) {
+				return false
+			}
+		}
+	}
+}
+
+// OfType matches frames of the given type
+func OfType(frameType string) func(Frame) bool {
+	return func(f Frame) bool { return f.Type == frameType }
+}
+
+// CreateBot creates a bot owned by user and returns its API token
+func (s *Server) CreateBot(t testing.TB, owner *User, name string) string {
+	t.Helper()
+	var result struct {
+		Token string `json:"token"`
+		Error string `json:"error"`
+	}
+	s.Do(t, owner, http.MethodPost, "/api/bots", url.Values{"name": {name}}, &result)
+	if result.Token == "" {
+		t.Fatalf("create bot: %s", result.Error)
+	}
+	return result.Token
+}
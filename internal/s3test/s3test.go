@@ -0,0 +1,185 @@
+// Package s3test is a stand-in for an S3-compatible object store such as
+// MinIO. It serves one bucket with path-style URLs, keeps objects in
+// memory and refuses requests whose Signature Version 4 doesn't verify.
+package s3test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Credentials the stand-in accepts
+const (
+	Region    = "us-east-1"
+	Bucket    = "chatroom"
+	AccessKey = "test-access-key"
+	SecretKey = "test-secret-key"
+)
+
+// Object is a stored object
+type Object struct {
+	Data        []byte
+	ContentType string
+}
+
+// Server is a running stand-in object store
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	objects  map[string]Object
+	requests []string // "METHOD key" for every request that verified
+}
+
+// New starts a server that is closed when the test ends
+func New(t testing.TB) *Server {
+	t.Helper()
+	s := &Server{objects: make(map[string]Object)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Object returns the object stored under key
+func (s *Server) Object(key string) (Object, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, ok := s.objects[key]
+	return object, ok
+}
+
+// Keys returns the keys of every stored object, sorted
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Requests returns "METHOD key" for every signed request served
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+Bucket+"/")
+	if !ok || key == "" {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+	if !verify(r) {
+		http.Error(w, "SignatureDoesNotMatch", http.StatusForbidden)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+key)
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil || int64(len(data)) != r.ContentLength {
+			http.Error(w, "IncompleteBody", http.StatusBadRequest)
+			return
+		}
+		s.objects[key] = Object{Data: data, ContentType: r.Header.Get("Content-Type")}
+	case http.MethodGet:
+		object, ok := s.objects[key]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", object.ContentType)
+		w.Write(object.Data)
+	case http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "MethodNotAllowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// verify checks a request's AWS Signature Version 4 Authorization header
+// against the headers it actually arrived with
+func verify(r *http.Request) bool {
+	credential, signedHeaders, signature, ok := parseAuthorization(r.Header.Get("Authorization"))
+	if !ok {
+		return false
+	}
+	amzDate := r.Header.Get("X-Amz-Date")
+	signedAt, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil || time.Since(signedAt).Abs() > 15*time.Minute {
+		return false
+	}
+	scope := signedAt.Format("20060102") + "/" + Region + "/s3/aws4_request"
+	if credential != AccessKey+"/"+scope {
+		return false
+	}
+
+	var headers []string
+	for _, name := range strings.Split(signedHeaders, ";") {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		headers = append(headers, name+":"+strings.TrimSpace(value)+"\n")
+	}
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		r.URL.RawQuery,
+		strings.Join(headers, ""),
+		signedHeaders,
+		r.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := []byte("AWS4" + SecretKey)
+	for _, part := range []string{signedAt.Format("20060102"), Region, "s3", "aws4_request"} {
+		key = sum(key, part)
+	}
+	want := hex.EncodeToString(sum(key, stringToSign))
+	return hmac.Equal([]byte(signature), []byte(want))
+}
+
+func parseAuthorization(header string) (credential, signedHeaders, signature string, ok bool) {
+	fields, ok := strings.CutPrefix(header, "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return "", "", "", false
+	}
+	for _, field := range strings.Split(fields, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		switch name {
+		case "Credential":
+			credential = value
+		case "SignedHeaders":
+			signedHeaders = value
+		case "Signature":
+			signature = value
+		}
+	}
+	return credential, signedHeaders, signature, credential != "" && signedHeaders != "" && signature != ""
+}
+
+func sum(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
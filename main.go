@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/driver/sqlite"
@@ -11,6 +15,11 @@ import (
 	"github.com/jeffasante/chatroom.go/handlers"
 	"github.com/jeffasante/chatroom.go/middleware"
 	"github.com/jeffasante/chatroom.go/models"
+	"github.com/jeffasante/chatroom.go/moderation"
+	"github.com/jeffasante/chatroom.go/retention"
+	"github.com/jeffasante/chatroom.go/storage"
+	"github.com/jeffasante/chatroom.go/unfurl"
+	"github.com/jeffasante/chatroom.go/webhooks"
 )
 
 var db *gorm.DB
@@ -18,20 +27,81 @@ var db *gorm.DB
 func main() {
 	// Initialize the database connection
 	var err error
-	db, err = gorm.Open(sqlite.Open("chatroom.db"), &gorm.Config{})
+	config := &gorm.Config{}
+	if len(os.Args) > 1 {
+		// Commands may write their output to stdout, so keep it clean
+		config.Logger = commandLogger()
+	}
+	db, err = gorm.Open(sqlite.Open("chatroom.db"), config)
 
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 
 	// Auto migrate the schema
-	db.AutoMigrate(&models.User{}, &models.Chatroom{}, &models.Message{}, &models.Membership{})
+	if err := models.Migrate(db); err != nil {
+		log.Fatal("Failed to migrate the database:", err)
+	}
+
+	// Full-text search needs SQLite built with FTS5 (make build, or go build -tags sqlite_fts5)
+	if err := models.SetupSearchIndex(db); err != nil {
+		log.Printf("Full-text search unavailable, falling back to substring search: %v", err)
+	} else {
+		handlers.FullTextSearch = true
+	}
+
+	// Server-wide message retention, which rooms can only tighten
+	if n, err := strconv.Atoi(os.Getenv("RETENTION_DAYS")); err == nil && n > 0 {
+		retention.Days = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("RETENTION_MESSAGES")); err == nil && n > 0 {
+		retention.Messages = n
+	}
+
+	// Administrative commands such as "export" run against the database
+	// instead of starting the server
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(db, os.Args[1], os.Args[2:]))
+	}
 
 	// Initialize handlers with database
 	h := handlers.New(db)
 
+	// Uploaded files go to an S3-compatible bucket if one is configured,
+	// otherwise to local disk
+	blobs, err := blobStoreFromEnv()
+	if err != nil {
+		log.Fatal("Failed to set up attachment storage:", err)
+	}
+	h.SetBlobStore(blobs)
+
+	limitsFromEnv()
+
+	// Outgoing webhooks are delivered in the background from a database outbox
+	webhooks.AllowHTTP = os.Getenv("WEBHOOKS_ALLOW_HTTP") == "1"
+	webhooks.AllowPrivate = os.Getenv("WEBHOOKS_ALLOW_PRIVATE") == "1"
+	dispatcher := webhooks.NewDispatcher(db)
+	go dispatcher.Run(context.Background())
+
 	// Initialize WebSocket hub
 	hub := handlers.NewHub(db)
+	hub.AddEventSink(dispatcher)
+
+	// Expired messages are purged in the background
+	purger := purgerFromEnv(db, blobs)
+	purger.OnPurge = hub.AnnouncePurge
+	go purger.Run(context.Background())
+
+	// Link previews are fetched in the background. UNFURL_ALLOW_PRIVATE=1
+	// lets them reach local addresses, for development only.
+	unfurl.AllowPrivate = os.Getenv("UNFURL_ALLOW_PRIVATE") == "1"
+	hub.EnableUnfurling(unfurl.New(db), 4)
+
+	// Rooms can opt in to screening by an external classifier service
+	if url := os.Getenv("MODERATION_CLASSIFIER_URL"); url != "" {
+		hub.EnableClassifier(moderation.NewClassifier(url))
+	}
+
 	go hub.Run()
 
 
@@ -44,38 +114,59 @@ func main() {
 	// Serve static files
 	router.Static("/static", "./static")
 
-	// Auth routes
-	router.GET("/", redirectToDashboard)
-	router.GET("/login", h.ShowLogin)
-	router.POST("/login", h.HandleLogin)
-	router.GET("/signup", h.ShowSignup)
-	router.POST("/signup", h.HandleSignup)
-	router.POST("/logout", h.HandleLogout)
-
-	// Authorized routes (require authentication)
-	authorized := router.Group("/")
-	authorized.Use(middleware.AuthMiddleware(db))
-	{
-		authorized.GET("/dashboard", h.ShowDashboard)
-		authorized.POST("/create-room", h.CreateRoom)
-		authorized.POST("/join-room", h.JoinRoom)
-		authorized.GET("/room/:code", h.ShowRoom)
-		
-		// WebSocket and API routes
-		authorized.GET("/ws/:code", h.HandleWebSocket(hub))
-		authorized.GET("/api/messages/:code", h.GetMessages)
-
-		// Room management routes
-		authorized.POST("/api/room/:code/update", h.UpdateRoom)
-		authorized.DELETE("/api/room/:code", h.DeleteRoom)
-		authorized.GET("/api/room/:code/members", h.GetRoomMembers)
+	// Counters such as messages_throttled, for operators
+	if os.Getenv("EXPOSE_METRICS") == "1" {
+		router.GET("/debug/vars", gin.WrapH(expvar.Handler()))
 	}
 
+	router.GET("/", redirectToDashboard)
+	h.RegisterRoutes(router, hub)
+
 	log.Println("Server started on :8080")
 	router.Run(":8080")
 
 }
 
+// limitsFromEnv sets the message size limits. A frame must fit the longest
+// message even if every character arrives as a 6 byte \uXXXX JSON escape.
+func limitsFromEnv() {
+	if n, err := strconv.Atoi(os.Getenv("MAX_MESSAGE_LENGTH")); err == nil && n > 0 {
+		handlers.MaxMessageLength = n
+	}
+	if n, err := strconv.ParseInt(os.Getenv("MAX_FRAME_SIZE"), 10, 64); err == nil && n > 0 {
+		handlers.MaxFrameSize = n
+	}
+	handlers.MaxFrameSize = max(handlers.MaxFrameSize, int64(handlers.MaxMessageLength)*6+1024)
+	handlers.LongMessagesAsAttachments = os.Getenv("LONG_MESSAGES_AS_ATTACHMENTS") == "1"
+}
+
+func blobStoreFromEnv() (storage.BlobStore, error) {
+	if bucket := os.Getenv("S3_BUCKET"); bucket != "" {
+		return storage.NewS3(storage.S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    bucket,
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			PathStyle: os.Getenv("S3_PATH_STYLE") == "1",
+		})
+	}
+
+	dir := os.Getenv("ATTACHMENTS_DIR")
+	if dir == "" {
+		dir = "uploads"
+	}
+	return storage.NewLocal(dir)
+}
+
+// purgerFromEnv sets up the retention job. RETENTION_ARCHIVE_DIR keeps a
+// copy of purged messages.
+func purgerFromEnv(db *gorm.DB, blobs storage.BlobStore) *retention.Purger {
+	purger := retention.NewPurger(db, blobs)
+	purger.ArchiveDir = os.Getenv("RETENTION_ARCHIVE_DIR")
+	return purger
+}
+
 func redirectToDashboard(c *gin.Context) {
 	token, err := c.Cookie("token")
 	if err != nil || token == "" {
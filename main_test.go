@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jeffasante/chatroom.go/handlers"
+)
+
+func TestLimitsFromEnv(t *testing.T) {
+	length, frame, attach := handlers.MaxMessageLength, handlers.MaxFrameSize, handlers.LongMessagesAsAttachments
+	t.Cleanup(func() {
+		handlers.MaxMessageLength, handlers.MaxFrameSize, handlers.LongMessagesAsAttachments = length, frame, attach
+	})
+
+	tests := []struct {
+		name                string
+		maxLength, maxFrame string
+		attachments         string
+		wantLength          int
+		wantFrame           int64
+		wantAttachments     bool
+	}{
+		{"defaults", "", "", "", 4000, 64 << 10, false},
+		{"longer messages", "20000", "", "", 20000, 20000*6 + 1024, false},
+		{"frame size", "", "1048576", "1", 4000, 1 << 20, true},
+		{"frame too small for the limit", "1000", "100", "", 1000, 1000*6 + 1024, false},
+		{"invalid", "lots", "-1", "yes", 4000, 64 << 10, false},
+	}
+	for _, tt := range tests {
+		handlers.MaxMessageLength, handlers.MaxFrameSize, handlers.LongMessagesAsAttachments = length, frame, attach
+		t.Setenv("MAX_MESSAGE_LENGTH", tt.maxLength)
+		t.Setenv("MAX_FRAME_SIZE", tt.maxFrame)
+		t.Setenv("LONG_MESSAGES_AS_ATTACHMENTS", tt.attachments)
+		limitsFromEnv()
+		if handlers.MaxMessageLength != tt.wantLength || handlers.MaxFrameSize != tt.wantFrame || handlers.LongMessagesAsAttachments != tt.wantAttachments {
+			t.Errorf("%s: got %d, %d, %v", tt.name, handlers.MaxMessageLength, handlers.MaxFrameSize, handlers.LongMessagesAsAttachments)
+		}
+	}
+}
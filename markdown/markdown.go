@@ -0,0 +1,304 @@
+// Package markdown renders the small Markdown subset used in chat messages:
+// **bold**, *italics*, `code`, fenced code blocks, [links](https://...),
+// bare URLs and > quotes.
+//
+// The output is safe to insert into a page as is. Nothing from the source
+// reaches it unescaped, raw HTML is shown as text, and links are only made
+// for http, https and mailto URLs.
+package markdown
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// maxQuoteDepth limits nested quotes; deeper ">" are shown as text
+const maxQuoteDepth = 5
+
+// Render converts message text to HTML
+func Render(src string) string {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	var b strings.Builder
+	renderBlocks(&b, strings.Split(src, "\n"), 0)
+	return b.String()
+}
+
+func renderBlocks(b *strings.Builder, lines []string, depth int) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case isFence(line):
+			lang := languageClass(strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "`")))
+			i++
+			start := i
+			for i < len(lines) && !isFence(lines[i]) {
+				i++
+			}
+			code := strings.Join(lines[start:i], "\n")
+			i++ // closing fence, if there is one
+
+			b.WriteString("<pre><code")
+			if lang != "" {
+				b.WriteString(` class="language-` + lang + `"`)
+			}
+			b.WriteString(">")
+			b.WriteString(html.EscapeString(code))
+			b.WriteString("</code></pre>")
+
+		case isQuote(line) && depth < maxQuoteDepth:
+			var quoted []string
+			for ; i < len(lines) && isQuote(lines[i]); i++ {
+				q := strings.TrimPrefix(strings.TrimLeft(lines[i], " "), ">")
+				quoted = append(quoted, strings.TrimPrefix(q, " "))
+			}
+			b.WriteString("<blockquote>")
+			renderBlocks(b, quoted, depth+1)
+			b.WriteString("</blockquote>")
+
+		case strings.TrimSpace(line) == "":
+			i++
+
+		default:
+			start := i
+			for i++; i < len(lines); i++ {
+				next := lines[i]
+				if strings.TrimSpace(next) == "" || isFence(next) || (isQuote(next) && depth < maxQuoteDepth) {
+					break
+				}
+			}
+			b.WriteString("<p>")
+			for n, text := range lines[start:i] {
+				if n > 0 {
+					b.WriteString("<br>")
+				}
+				renderInline(b, text, false)
+			}
+			b.WriteString("</p>")
+		}
+	}
+}
+
+func isFence(line string) bool {
+	return strings.HasPrefix(strings.TrimLeft(line, " "), "```")
+}
+
+func isQuote(line string) bool {
+	return strings.HasPrefix(strings.TrimLeft(line, " "), ">")
+}
+
+var languagePattern = regexp.MustCompile(`^[A-Za-z0-9_+#-]{1,20}$`)
+
+// languageClass returns the fence's language if it's safe to use in a
+// class name, otherwise ""
+func languageClass(info string) string {
+	if fields := strings.Fields(info); len(fields) > 0 && languagePattern.MatchString(fields[0]) {
+		return fields[0]
+	}
+	return ""
+}
+
+var autolinkPattern = regexp.MustCompile(`^https?://[^\s<>"'` + "`" + `]+`)
+
+// renderInline writes one line of text with code spans, emphasis and links.
+// inLink stops links nesting inside link text.
+func renderInline(b *strings.Builder, s string, inLink bool) {
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && isPunct(s[i+1]):
+			b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			continue
+
+		case c == '`':
+			n := runLength(s, i, '`')
+			if end := findRun(s, i+n, '`', n); end >= 0 {
+				b.WriteString("<code>")
+				b.WriteString(html.EscapeString(s[i+n : end]))
+				b.WriteString("</code>")
+				i = end + n
+				continue
+			}
+			b.WriteString(s[i : i+n])
+			i += n
+			continue
+
+		case c == '*' || c == '_':
+			if end, ok := closeEmphasis(s, i, 2); ok {
+				b.WriteString("<strong>")
+				renderInline(b, s[i+2:end], inLink)
+				b.WriteString("</strong>")
+				i = end + 2
+				continue
+			}
+			if end, ok := closeEmphasis(s, i, 1); ok {
+				b.WriteString("<em>")
+				renderInline(b, s[i+1:end], inLink)
+				b.WriteString("</em>")
+				i = end + 1
+				continue
+			}
+			n := runLength(s, i, c)
+			b.WriteString(s[i : i+n])
+			i += n
+			continue
+
+		case c == '[' && !inLink:
+			if text, href, end, ok := parseLink(s, i); ok {
+				writeLinkStart(b, href)
+				renderInline(b, text, true)
+				b.WriteString("</a>")
+				i = end
+				continue
+			}
+
+		case c == 'h' && !inLink && (i == 0 || !isAlnum(s[i-1])):
+			if match := autolinkPattern.FindString(s[i:]); match != "" {
+				match = strings.TrimRight(match, ".,;:!?)]}*_")
+				if href, ok := safeURL(match); ok {
+					writeLinkStart(b, href)
+					b.WriteString(html.EscapeString(match))
+					b.WriteString("</a>")
+					i += len(match)
+					continue
+				}
+			}
+		}
+
+		b.WriteString(html.EscapeString(s[i : i+1]))
+		i++
+	}
+}
+
+// closeEmphasis looks for the delimiter run of length n that closes the
+// one at s[i]. Like CommonMark, the text inside can't start or end with a
+// space, and _ only counts at word boundaries so snake_case stays as is.
+func closeEmphasis(s string, i, n int) (int, bool) {
+	c := s[i]
+	if i+n >= len(s) || strings.Count(s[i:i+n], string(c)) != n || isSpace(s[i+n]) {
+		return 0, false
+	}
+	if c == '_' && i > 0 && isAlnum(s[i-1]) {
+		return 0, false
+	}
+
+	for j := i + n + 1; j < len(s); j++ {
+		if s[j] == '`' {
+			// Skip code spans so delimiters inside them don't close anything
+			m := runLength(s, j, '`')
+			if end := findRun(s, j+m, '`', m); end >= 0 {
+				j = end + m - 1
+			} else {
+				j += m - 1
+			}
+			continue
+		}
+		if s[j] != c {
+			continue
+		}
+
+		// A run of three closes both ** and *, as in ***x***
+		m := runLength(s, j, c)
+		if (m == n || m == 3) && !isSpace(s[j-1]) {
+			end := j + m - n
+			if c != '_' || end+n == len(s) || !isAlnum(s[end+n]) {
+				return end, true
+			}
+		}
+		j += m - 1
+	}
+	return 0, false
+}
+
+// parseLink reads [text](url) at s[i], returning the index after it
+func parseLink(s string, i int) (text, href string, end int, ok bool) {
+	closeText := strings.IndexByte(s[i:], ']')
+	if closeText < 0 {
+		return "", "", 0, false
+	}
+	closeText += i
+	if closeText+1 >= len(s) || s[closeText+1] != '(' {
+		return "", "", 0, false
+	}
+	closeURL := strings.IndexByte(s[closeText+2:], ')')
+	if closeURL < 0 {
+		return "", "", 0, false
+	}
+	closeURL += closeText + 2
+
+	// In "[a [b](url)" only the inner brackets are the link
+	text = s[i+1 : closeText]
+	if strings.IndexByte(text, '[') >= 0 {
+		return "", "", 0, false
+	}
+	href, ok = safeURL(strings.TrimSpace(s[closeText+2 : closeURL]))
+	if !ok || text == "" {
+		return "", "", 0, false
+	}
+	return text, href, closeURL + 1, true
+}
+
+// safeURL accepts only absolute http, https and mailto URLs
+func safeURL(raw string) (string, bool) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		if u.Host == "" {
+			return "", false
+		}
+	case "mailto":
+		if u.Opaque == "" {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	return u.String(), true
+}
+
+func writeLinkStart(b *strings.Builder, href string) {
+	b.WriteString(`<a href="`)
+	b.WriteString(html.EscapeString(href))
+	b.WriteString(`" target="_blank" rel="nofollow noopener noreferrer">`)
+}
+
+func runLength(s string, i int, c byte) int {
+	n := 0
+	for i+n < len(s) && s[i+n] == c {
+		n++
+	}
+	return n
+}
+
+// findRun returns the start of the next run of exactly n c's at or after i
+func findRun(s string, i int, c byte, n int) int {
+	for i < len(s) {
+		if s[i] != c {
+			i++
+			continue
+		}
+		m := runLength(s, i, c)
+		if m == n {
+			return i
+		}
+		i += m
+	}
+	return -1
+}
+
+func isPunct(c byte) bool {
+	return strings.IndexByte("\\`*_{}[]()#+-.!>~|<&\"'", c) >= 0
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
+
+func isAlnum(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,79 @@
+package markdown
+
+import "testing"
+
+// rel is the attribute list every link gets after its href
+const rel = `" target="_blank" rel="nofollow noopener noreferrer">`
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		// Raw HTML is shown as text
+		{"script", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>"},
+		{"img onerror", "<img src=x onerror=alert(1)>", "<p>&lt;img src=x onerror=alert(1)&gt;</p>"},
+		{"entities", "a & b &amp; c", "<p>a &amp; b &amp;amp; c</p>"},
+
+		// Only http, https and mailto links are made
+		{"link", "[docs](https://example.com/a?b=1&c=2)", `<p><a href="https://example.com/a?b=1&amp;c=2` + rel + "docs</a></p>"},
+		{"mailto", "[mail](mailto:a@example.com)", `<p><a href="mailto:a@example.com` + rel + "mail</a></p>"},
+		{"javascript", "[x](javascript:alert(1))", "<p>[x](javascript:alert(1))</p>"},
+		{"javascript mixed case", "[x](JaVaScRiPt:alert(1))", "<p>[x](JaVaScRiPt:alert(1))</p>"},
+		{"javascript padded", "[x]( javascript:alert(1) )", "<p>[x]( javascript:alert(1) )</p>"},
+		{"data", "[x](data:text/html,<script>alert(1)</script>)", "<p>[x](data:text/html,&lt;script&gt;alert(1)&lt;/script&gt;)</p>"},
+		{"relative", "[x](/logout)", "<p>[x](/logout)</p>"},
+		{"no host", "[x](https:///x)", "<p>[x](https:///x)</p>"},
+		{"double quote in target", `[x](https://example.com/"onmouseover="alert(1))`, `<p><a href="https://example.com/%22onmouseover=%22alert%281` + rel + "x</a>)</p>"},
+		{"single quote in target", "[x](https://example.com/it's)", `<p><a href="https://example.com/it&#39;s` + rel + "x</a></p>"},
+		{"quotes in text", `[it's "here"](https://example.com)`, `<p><a href="https://example.com` + rel + "it&#39;s &#34;here&#34;</a></p>"},
+		{"nested link", "[a [b](https://example.com)](https://example.org)", `<p>[a <a href="https://example.com` + rel + `b</a>](<a href="https://example.org` + rel + "https://example.org</a>)</p>"},
+
+		// Code is escaped and never formatted
+		{"code span", "`<b>x</b>`", "<p><code>&lt;b&gt;x&lt;/b&gt;</code></p>"},
+		{"code span emphasis", "`*not* [x](https://example.com)`", "<p><code>*not* [x](https://example.com)</code></p>"},
+		{"unclosed code span", "`<b>", "<p>`&lt;b&gt;</p>"},
+		{"fence", "```\n<b>x</b>\n```", "<pre><code>&lt;b&gt;x&lt;/b&gt;</code></pre>"},
+		{"fence language", "```go\nfmt.Println(\"<hi>\")\n```", `<pre><code class="language-go">fmt.Println(&#34;&lt;hi&gt;&#34;)</code></pre>`},
+		{"fence info html", "```\"><script>alert(1)</script>\nx\n```", "<pre><code>x</code></pre>"},
+		{"fence info attribute", "```go onload=alert(1)\nx\n```", `<pre><code class="language-go">x</code></pre>`},
+		{"unclosed fence", "```\n<i>x", "<pre><code>&lt;i&gt;x</code></pre>"},
+
+		// Emphasis
+		{"bold and italics", "**bold** and *it* and _it_", "<p><strong>bold</strong> and <em>it</em> and <em>it</em></p>"},
+		{"unclosed star", "*bold", "<p>*bold</p>"},
+		{"unclosed double star", "**bold", "<p>**bold</p>"},
+		{"unclosed underscore", "_x", "<p>_x</p>"},
+		{"spaced stars", "2 * 3 * 4", "<p>2 * 3 * 4</p>"},
+		{"snake case", "snake_case_name", "<p>snake_case_name</p>"},
+		{"italics in bold", "**bold *it* bold**", "<p><strong>bold <em>it</em> bold</strong></p>"},
+		{"bold in italics", "*a **b** c*", "<p><em>a <strong>b</strong> c</em></p>"},
+		{"triple", "***x***", "<p><strong><em>x</em></strong></p>"},
+		{"unclosed inside bold", "**a *b**", "<p><strong>a *b</strong></p>"},
+		{"crossed", "_a *b_ c*", "<p><em>a *b</em> c*</p>"},
+		{"html in emphasis", "*<b>*", "<p><em>&lt;b&gt;</em></p>"},
+		{"strikethrough is text", "~~x~~", "<p>~~x~~</p>"},
+		{"unclosed strikethrough", "~~x", "<p>~~x</p>"},
+		{"escaped", `\*not\* \<b\>`, "<p>*not* &lt;b&gt;</p>"},
+
+		// Bare URLs
+		{"autolink", "see https://example.com/a", `<p>see <a href="https://example.com/a` + rel + "https://example.com/a</a></p>"},
+		{"autolink period", "see https://example.com/a.", `<p>see <a href="https://example.com/a` + rel + "https://example.com/a</a>.</p>"},
+		{"autolink parens", "(https://example.com/a)", `<p>(<a href="https://example.com/a` + rel + "https://example.com/a</a>)</p>"},
+		{"autolink punctuation", "https://example.com/a?!", `<p><a href="https://example.com/a` + rel + "https://example.com/a</a>?!</p>"},
+		{"autolink angle brackets", "<https://example.com>", `<p>&lt;<a href="https://example.com` + rel + "https://example.com</a>&gt;</p>"},
+		{"autolink before tag", "https://example.com/<script>", `<p><a href="https://example.com/` + rel + "https://example.com/</a>&lt;script&gt;</p>"},
+		{"autolink before quote", `https://example.com/a"onclick=x`, `<p><a href="https://example.com/a` + rel + "https://example.com/a</a>&#34;onclick=x</p>"},
+		{"autolink inside word", "xhttps://example.com", "<p>xhttps://example.com</p>"},
+
+		// Blocks
+		{"lines", "a\r\nb", "<p>a<br>b</p>"},
+		{"paragraphs", "a\n\nb", "<p>a</p><p>b</p>"},
+		{"quote", "> quote <b>\n> more", "<blockquote><p>quote &lt;b&gt;<br>more</p></blockquote>"},
+		{"deep quote", ">>>>>> x", "<blockquote><blockquote><blockquote><blockquote><blockquote><p>&gt; x</p></blockquote></blockquote></blockquote></blockquote></blockquote>"},
+	}
+	for _, tt := range tests {
+		if got := Render(tt.src); got != tt.want {
+			t.Errorf("%s: Render(%q)\n got %s\nwant %s", tt.name, tt.src, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// Package media prepares uploaded images: it strips identifying metadata
+// and makes thumbnails, using only the standard library decoders.
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+const (
+	// ThumbnailSize bounds the longer side of a thumbnail, in pixels
+	ThumbnailSize = 320
+
+	// maxDecodePixels stops huge images being decoded, which would take a
+	// lot of memory. Larger images are still stored, just without a thumbnail.
+	maxDecodePixels = 16 << 20
+)
+
+// ErrInvalidImage is returned when the bytes don't decode as the claimed type
+var ErrInvalidImage = errors.New("invalid image")
+
+// Processed is an image ready to store
+type Processed struct {
+	Data   []byte // the image with metadata removed
+	Width  int    // as displayed, after any EXIF rotation
+	Height int
+
+	Thumbnail            []byte // nil if the image was too large to decode
+	ThumbnailContentType string
+	ThumbnailWidth       int
+	ThumbnailHeight      int
+}
+
+// CanProcess reports whether Process supports a content type
+func CanProcess(contentType string) bool {
+	switch contentType {
+	case "image/png", "image/jpeg", "image/gif", "image/webp":
+		return true
+	}
+	return false
+}
+
+// Process strips metadata from a PNG, JPEG, GIF or WebP and makes a
+// thumbnail. JPEGs that EXIF says are rotated are re-encoded upright, since
+// stripping the EXIF block would otherwise lose the rotation; those too
+// large to decode keep an EXIF block with only the orientation. There is no
+// WebP decoder in the standard library, so WebPs get no thumbnail.
+func Process(data []byte, contentType string) (*Processed, error) {
+	if contentType == "image/webp" {
+		stripped, width, height, err := stripWebP(data)
+		if err != nil {
+			return nil, ErrInvalidImage
+		}
+		return &Processed{Data: stripped, Width: width, Height: height}, nil
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+
+	orientation := 1
+	switch contentType {
+	case "image/jpeg":
+		orientation = jpegOrientation(data)
+		data, err = stripJPEG(data)
+	case "image/png":
+		data, err = stripPNG(data)
+	case "image/gif":
+		data, err = stripGIF(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Processed{Data: data, Width: config.Width, Height: config.Height}
+	if orientation >= 5 {
+		p.Width, p.Height = p.Height, p.Width
+	}
+	if config.Width*config.Height > maxDecodePixels {
+		if orientation != 1 {
+			p.Data = withOrientation(data, orientation)
+		}
+		return p, nil
+	}
+
+	img, err := decode(data, contentType)
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	if orientation != 1 {
+		img = orient(img, orientation)
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+			return nil, err
+		}
+		p.Data = buf.Bytes()
+	}
+
+	thumb := thumbnail(img, ThumbnailSize)
+	var buf bytes.Buffer
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 80})
+		p.ThumbnailContentType = "image/jpeg"
+	} else {
+		// PNG keeps transparency
+		err = png.Encode(&buf, thumb)
+		p.ThumbnailContentType = "image/png"
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.Thumbnail = buf.Bytes()
+	p.ThumbnailWidth = thumb.Bounds().Dx()
+	p.ThumbnailHeight = thumb.Bounds().Dy()
+	return p, nil
+}
+
+// decode reads the first frame of animated GIFs
+func decode(data []byte, contentType string) (image.Image, error) {
+	r := bytes.NewReader(data)
+	switch contentType {
+	case "image/jpeg":
+		return jpeg.Decode(r)
+	case "image/png":
+		return png.Decode(r)
+	case "image/gif":
+		return gif.Decode(r)
+	}
+	return nil, ErrInvalidImage
+}
+
+// thumbnail scales an image to fit within size×size by averaging the source
+// pixels that fall into each thumbnail pixel. Smaller images keep their size.
+func thumbnail(src image.Image, size int) *image.RGBA {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	dw, dh := sw, sh
+	if sw > size || sh > size {
+		if sw >= sh {
+			dw, dh = size, max(1, sh*size/sw)
+		} else {
+			dw, dh = max(1, sw*size/sh), size
+		}
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, sw, sh))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+	if dw == sw && dh == sh {
+		return rgba
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, max((y+1)*sh/dh, y*sh/dh+1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, max((x+1)*sw/dw, x*sw/dw+1)
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[sy*rgba.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += uint64(p[0])
+					g += uint64(p[1])
+					bl += uint64(p[2])
+					a += uint64(p[3])
+					n++
+				}
+			}
+
+			d := dst.Pix[y*dst.Stride+x*4:]
+			d[0], d[1], d[2], d[3] = uint8(r/n), uint8(g/n), uint8(bl/n), uint8(a/n)
+		}
+	}
+	return dst
+}
+
+// orient applies an EXIF orientation (2-8) so the image is upright
+func orient(src image.Image, orientation int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			default:
+				dx, dy = x, y
+			}
+			dst.Set(dx, dy, src.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
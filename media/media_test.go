@@ -0,0 +1,192 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"testing"
+)
+
+// exifAPP1 returns an EXIF segment with an orientation tag followed by
+// other data, standing in for GPS coordinates and the like
+func exifAPP1(orientation int, other string) []byte {
+	tiff := []byte{
+		'M', 'M', 0, 42, 0, 0, 0, 8,
+		0, 1,
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, byte(orientation), 0, 0,
+		0, 0, 0, 0,
+	}
+	tiff = append(tiff, other...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	segment = append(segment, "Exif\x00\x00"...)
+	segment = append(segment, tiff...)
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(segment)-2))
+	return segment
+}
+
+// testJPEG encodes a width×height JPEG with an EXIF segment after the SOI
+func testJPEG(t *testing.T, width, height, orientation int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	out := append([]byte{0xFF, 0xD8}, exifAPP1(orientation, "gps-secret")...)
+	return append(out, data[2:]...)
+}
+
+func TestRotatedJPEG(t *testing.T) {
+	p, err := Process(testJPEG(t, 8, 4, 6), "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(p.Data, []byte("gps-secret")) || bytes.Contains(p.Data, []byte("Exif")) {
+		t.Error("EXIF left in the image")
+	}
+	img, err := jpeg.Decode(bytes.NewReader(p.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 4 || b.Dy() != 8 || p.Width != 4 || p.Height != 8 {
+		t.Errorf("upright image is %v, reported %dx%d", b, p.Width, p.Height)
+	}
+}
+
+func TestLargeRotatedJPEGKeepsOrientation(t *testing.T) {
+	data := testJPEG(t, 8, 4, 6)
+
+	// Claim a size too large to decode; only the header is read
+	sof := bytes.Index(data, []byte{0xFF, 0xC0})
+	binary.BigEndian.PutUint16(data[sof+5:], 4000)
+	binary.BigEndian.PutUint16(data[sof+7:], 5000)
+
+	p, err := Process(data, "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(p.Data, []byte("gps-secret")) {
+		t.Error("EXIF beyond the orientation left in the image")
+	}
+	if got := jpegOrientation(p.Data); got != 6 {
+		t.Errorf("orientation %d, want 6", got)
+	}
+	if p.Width != 4000 || p.Height != 5000 || p.Thumbnail != nil {
+		t.Errorf("reported %dx%d with a thumbnail of %d bytes", p.Width, p.Height, len(p.Thumbnail))
+	}
+	if _, _, err := image.DecodeConfig(bytes.NewReader(p.Data)); err != nil {
+		t.Errorf("result doesn't parse: %v", err)
+	}
+}
+
+func TestStripGIF(t *testing.T) {
+	frame := image.NewPaletted(image.Rect(0, 0, 10, 10), palette.Plan9)
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, &gif.GIF{
+		Image:     []*image.Paletted{frame, frame},
+		Delay:     []int{10, 10},
+		LoopCount: 0,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A comment and an XMP block, inserted before the trailer
+	data := buf.Bytes()
+	data = append(data[:len(data)-1:len(data)-1], 0x21, 0xFE, 14)
+	data = append(data, "secret comment"...)
+	data = append(data, 0, 0x21, 0xFF, 11)
+	data = append(data, "XMP DataXMP"...)
+	data = append(data, 10)
+	data = append(data, "secret xmp"...)
+	data = append(data, 0, 0x3B)
+
+	p, err := Process(data, "image/gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(p.Data, []byte("secret")) {
+		t.Error("comment or XMP left in the GIF")
+	}
+	if !bytes.Contains(p.Data, []byte("NETSCAPE2.0")) {
+		t.Error("looping block removed")
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(p.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Image) != 2 || p.Thumbnail == nil {
+		t.Errorf("%d frames, thumbnail %d bytes", len(g.Image), len(p.Thumbnail))
+	}
+}
+
+// webpChunk encodes a RIFF chunk, padded to an even length
+func webpChunk(fourCC string, data []byte) []byte {
+	chunk := append([]byte(fourCC), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(data)))
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func webpFile(chunks ...[]byte) []byte {
+	data := []byte("RIFF\x00\x00\x00\x00WEBP")
+	for _, chunk := range chunks {
+		data = append(data, chunk...)
+	}
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8))
+	return data
+}
+
+func TestStripWebP(t *testing.T) {
+	// 100×50 canvas with alpha, EXIF and XMP
+	vp8x := []byte{0x10 | webpFlagEXIF | webpFlagXMP, 0, 0, 0, 99, 0, 0, 49, 0, 0}
+	data := webpFile(
+		webpChunk("VP8X", vp8x),
+		webpChunk("VP8L", []byte{0x2F, 0, 0, 0, 0}),
+		webpChunk("EXIF", []byte("gps-secret")),
+		webpChunk("XMP ", []byte("<x:xmpmeta>secret</x:xmpmeta>")),
+	)
+
+	p, err := Process(data, "image/webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(p.Data, []byte("secret")) || bytes.Contains(p.Data, []byte("EXIF")) {
+		t.Error("metadata left in the WebP")
+	}
+	if p.Width != 100 || p.Height != 50 {
+		t.Errorf("size %dx%d, want 100x50", p.Width, p.Height)
+	}
+	if flags := p.Data[20]; flags != 0x10 {
+		t.Errorf("VP8X flags %#x, want only alpha", flags)
+	}
+	if size := binary.LittleEndian.Uint32(p.Data[4:]); int(size) != len(p.Data)-8 {
+		t.Errorf("RIFF size %d for %d bytes", size, len(p.Data))
+	}
+}
+
+func TestWebPSize(t *testing.T) {
+	// Lossless 300×200: 14-bit width-1 and height-1 after the signature
+	bits := uint32(299) | uint32(199)<<14
+	lossless := []byte{0x2F, byte(bits), byte(bits >> 8), byte(bits >> 16), byte(bits >> 24)}
+	if p, err := Process(webpFile(webpChunk("VP8L", lossless)), "image/webp"); err != nil || p.Width != 300 || p.Height != 200 {
+		t.Errorf("lossless: %+v, %v", p, err)
+	}
+
+	lossy := []byte{0, 0, 0, 0x9D, 0x01, 0x2A, 64, 1, 240, 0}
+	if p, err := Process(webpFile(webpChunk("VP8 ", lossy)), "image/webp"); err != nil || p.Width != 320 || p.Height != 240 {
+		t.Errorf("lossy: %+v, %v", p, err)
+	}
+
+	if _, err := Process([]byte("RIFF\x04\x00\x00\x00WEBP"), "image/webp"); err == nil {
+		t.Error("accepted a WebP without an image")
+	}
+}
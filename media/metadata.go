@@ -0,0 +1,307 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var errCorrupt = errors.New("corrupt image")
+
+// JPEG segments removed by stripJPEG: EXIF and XMP (APP1), Photoshop/IPTC
+// (APP13) and comments. JFIF, ICC colour profiles and Adobe colour
+// transform markers are kept because they change how the image looks.
+var strippedJPEGMarkers = map[byte]bool{
+	0xE1: true,
+	0xED: true,
+	0xFE: true,
+}
+
+// stripJPEG removes metadata segments without re-encoding the image
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errCorrupt
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, 0xD8)
+	for i := 2; ; {
+		if i+4 > len(data) || data[i] != 0xFF {
+			return nil, errCorrupt
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			// Fill byte before a marker
+			i++
+			continue
+		}
+
+		// Start of scan: the compressed image data follows and runs to the end
+		if marker == 0xDA {
+			return append(out, data[i:]...), nil
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errCorrupt
+		}
+		if !strippedJPEGMarkers[marker] {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+}
+
+// jpegOrientation returns the EXIF orientation (1-8), or 1 if there is none
+func jpegOrientation(data []byte) int {
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		if marker == 0xDA {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			break
+		}
+		if marker == 0xE1 && bytes.HasPrefix(data[i+4:end], []byte("Exif\x00\x00")) {
+			return exifOrientation(data[i+10 : end])
+		}
+		i = end
+	}
+	return 1
+}
+
+// exifOrientation reads tag 0x0112 from the first IFD of a TIFF structure
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < count; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+	return 1
+}
+
+// PNG chunks removed by stripPNG: EXIF, text of any kind and timestamps
+var strippedPNGChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// stripPNG drops metadata chunks; every chunk carries its own checksum, so
+// the rest are copied unchanged
+func stripPNG(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errCorrupt
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+	for i := len(pngSignature); i < len(data); {
+		if i+8 > len(data) {
+			return nil, errCorrupt
+		}
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, errCorrupt
+		}
+		if !strippedPNGChunks[string(data[i+4:i+8])] {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return out, nil
+}
+
+// withOrientation adds an EXIF block holding only the orientation tag to a
+// JPEG stripped of its metadata, after the JFIF segment if there is one
+func withOrientation(data []byte, orientation int) []byte {
+	exif := []byte{
+		0xFF, 0xE1, 0, 34, // APP1 and its length
+		'E', 'x', 'i', 'f', 0, 0,
+		'M', 'M', 0, 42, 0, 0, 0, 8, // big-endian TIFF header, first IFD at 8
+		0, 1, // one entry
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, byte(orientation), 0, 0, // orientation, one SHORT
+		0, 0, 0, 0, // no next IFD
+	}
+
+	at := 2
+	if len(data) >= 6 && data[2] == 0xFF && data[3] == 0xE0 {
+		at = 4 + int(binary.BigEndian.Uint16(data[4:]))
+	}
+	out := make([]byte, 0, len(data)+len(exif))
+	out = append(out, data[:at]...)
+	out = append(out, exif...)
+	return append(out, data[at:]...)
+}
+
+// stripGIF drops comment blocks and application blocks other than the
+// ones that make animations loop, which is where XMP is kept
+func stripGIF(data []byte) ([]byte, error) {
+	if len(data) < 13 || !(bytes.HasPrefix(data, []byte("GIF87a")) || bytes.HasPrefix(data, []byte("GIF89a"))) {
+		return nil, errCorrupt
+	}
+
+	// Header, logical screen descriptor and global colour table
+	i := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		i += 3 << (flags&0x07 + 1)
+	}
+	if i > len(data) {
+		return nil, errCorrupt
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:i]...)
+
+	for i < len(data) {
+		start := i
+		keep := true
+		switch data[i] {
+		case 0x3B: // trailer
+			return append(out, 0x3B), nil
+
+		case 0x21: // extension
+			if i+2 > len(data) {
+				return nil, errCorrupt
+			}
+			switch data[i+1] {
+			case 0xFE: // comment
+				keep = false
+			case 0xFF: // application
+				keep = i+14 <= len(data) && data[i+2] == 11 &&
+					(string(data[i+3:i+14]) == "NETSCAPE2.0" || string(data[i+3:i+14]) == "ANIMEXTS1.0")
+			}
+			i += 2
+
+		case 0x2C: // image descriptor, local colour table and LZW code size
+			if i+10 > len(data) {
+				return nil, errCorrupt
+			}
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1)
+			}
+			i++
+
+		default:
+			return nil, errCorrupt
+		}
+
+		// Data sub-blocks, ended by an empty one
+		for {
+			if i >= len(data) {
+				return nil, errCorrupt
+			}
+			size := int(data[i])
+			i += 1 + size
+			if size == 0 {
+				break
+			}
+		}
+		if i > len(data) {
+			return nil, errCorrupt
+		}
+		if keep {
+			out = append(out, data[start:i]...)
+		}
+	}
+	// Some encoders leave out the trailer
+	return append(out, 0x3B), nil
+}
+
+// WebP flags in the VP8X chunk saying EXIF or XMP chunks are present
+const (
+	webpFlagEXIF = 0x08
+	webpFlagXMP  = 0x04
+)
+
+// stripWebP drops EXIF and XMP chunks from a WebP and returns its size
+func stripWebP(data []byte) ([]byte, int, int, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, 0, 0, errCorrupt
+	}
+
+	var width, height int
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:12]...)
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, 0, 0, errCorrupt
+		}
+		fourCC := string(data[i : i+4])
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2 // chunks are padded to an even length
+		if size < 0 || end > len(data) {
+			return nil, 0, 0, errCorrupt
+		}
+		chunk := data[i+8 : i+8+size]
+
+		switch fourCC {
+		case "EXIF", "XMP ":
+			i = end
+			continue
+		case "VP8X":
+			if size < 10 {
+				return nil, 0, 0, errCorrupt
+			}
+			width = 1 + (int(chunk[4]) | int(chunk[5])<<8 | int(chunk[6])<<16)
+			height = 1 + (int(chunk[7]) | int(chunk[8])<<8 | int(chunk[9])<<16)
+		case "VP8L":
+			if width == 0 && size >= 5 && chunk[0] == 0x2F {
+				bits := binary.LittleEndian.Uint32(chunk[1:])
+				width, height = 1+int(bits&0x3FFF), 1+int(bits>>14&0x3FFF)
+			}
+		case "VP8 ":
+			if width == 0 && size >= 10 && chunk[3] == 0x9D && chunk[4] == 0x01 && chunk[5] == 0x2A {
+				width = int(binary.LittleEndian.Uint16(chunk[6:]) & 0x3FFF)
+				height = int(binary.LittleEndian.Uint16(chunk[8:]) & 0x3FFF)
+			}
+		}
+
+		start := len(out)
+		out = append(out, data[i:end]...)
+		if fourCC == "VP8X" {
+			out[start+8] &^= webpFlagEXIF | webpFlagXMP
+		}
+		i = end
+	}
+	if width == 0 || height == 0 {
+		return nil, 0, 0, errCorrupt
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, width, height, nil
+}
@@ -1,8 +1,11 @@
 package middleware
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -15,6 +18,18 @@ var Sessions = make(map[string]uint)
 
 func AuthMiddleware(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// Bots send their API token as a bearer token instead of a cookie
+		if apiToken := BearerToken(c); apiToken != "" {
+			bot := GetUserByAPIToken(db, apiToken)
+			if bot == nil {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid api token"})
+				return
+			}
+			c.Set("user", *bot)
+			c.Next()
+			return
+		}
+
 		token, err := c.Cookie("token")
 		if err != nil || token == "" {
 			log.Printf("No token found in cookies")
@@ -73,4 +88,41 @@ func GetUserByToken(db *gorm.DB, token string) *models.User {
 	}
 
 	return &user
-}
\ No newline at end of file
+}
+
+// GetRequestUser authenticates a request by bot API token or session cookie
+func GetRequestUser(db *gorm.DB, c *gin.Context) *models.User {
+	if apiToken := BearerToken(c); apiToken != "" {
+		return GetUserByAPIToken(db, apiToken)
+	}
+
+	token, err := c.Cookie("token")
+	if err != nil || token == "" {
+		return nil
+	}
+	return GetUserByToken(db, token)
+}
+
+// BearerToken returns the token from an "Authorization: Bearer" header, if any
+func BearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+}
+
+// HashAPIToken returns the form of a bot API token stored in the database
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func GetUserByAPIToken(db *gorm.DB, token string) *models.User {
+	var user models.User
+	if err := db.Where("is_bot = ? AND api_token_hash = ?", true, HashAPIToken(token)).First(&user).Error; err != nil {
+		return nil
+	}
+
+	return &user
+}
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Audited actions
+const (
+	AuditRoomCreate         = "room.create"
+	AuditRoomUpdate         = "room.update"
+	AuditRoomDelete         = "room.delete"
+	AuditRoomTopic          = "room.topic"
+	AuditRoomSlowMode       = "room.slow_mode"
+	AuditRoomExport         = "room.export"
+	AuditRoomImport         = "room.import"
+	AuditRoomRetention      = "room.retention"
+	AuditRoomLegalHold      = "room.legal_hold"
+	AuditMemberJoin         = "member.join"
+	AuditMemberKick         = "member.kick"
+	AuditMemberBan          = "member.ban"
+	AuditMemberUnban        = "member.unban"
+	AuditMemberMute         = "member.mute"
+	AuditMemberRole         = "member.role"
+	AuditMessageDelete      = "message.delete"
+	AuditModerationSettings = "moderation.settings"
+	AuditHeldApprove        = "moderation.approve"
+	AuditHeldReject         = "moderation.reject"
+	AuditReportResolve      = "report.resolve"
+	AuditWebhookCreate      = "webhook.create"
+	AuditWebhookDelete      = "webhook.delete"
+)
+
+// AuditEvent records an administrative action in a room. Before and After
+// hold JSON snapshots of what changed. Events are never updated or deleted,
+// even when the room is.
+type AuditEvent struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	ChatroomID   uint      `json:"chatroom_id" gorm:"not null;index"`
+	ActorID      uint      `json:"actor_id" gorm:"not null;index"`
+	Action       string    `json:"action" gorm:"not null;index"`
+	TargetUserID uint      `json:"target_user_id,omitempty" gorm:"index"`
+	TargetType   string    `json:"target_type,omitempty"` // e.g. message, report, webhook
+	TargetID     uint      `json:"target_id,omitempty"`
+	Before       string    `json:"-" gorm:"type:text"`
+	After        string    `json:"-" gorm:"type:text"`
+	CreatedAt    time.Time `json:"created_at" gorm:"index"`
+
+	// Relationships
+	Actor      User  `json:"actor" gorm:"foreignKey:ActorID"`
+	TargetUser *User `json:"target_user,omitempty" gorm:"foreignKey:TargetUserID"`
+}
+
+// Triggers that stop audit events being changed or removed, however the
+// database is written to
+var auditLogTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS audit_events_no_update BEFORE UPDATE ON audit_events BEGIN
+		SELECT RAISE(ABORT, 'audit events are append-only');
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS audit_events_no_delete BEFORE DELETE ON audit_events BEGIN
+		SELECT RAISE(ABORT, 'audit events are append-only');
+	END`,
+}
+
+// ProtectAuditLog makes the audit_events table append-only
+func ProtectAuditLog(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range auditLogTriggers {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,38 @@
+package models
+
+import "testing"
+
+func TestAuditLogIsAppendOnly(t *testing.T) {
+	db := newTestDB(t)
+	event := AuditEvent{ChatroomID: 1, ActorID: 1, Action: AuditRoomUpdate, After: `{"name":"general"}`}
+	if err := db.Create(&event).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Model(&event).Update("after", `{"name":"renamed"}`).Error; err == nil {
+		t.Error("updating an audit event succeeded")
+	}
+	if err := db.Exec("UPDATE audit_events SET actor_id = 2").Error; err == nil {
+		t.Error("updating audit events with SQL succeeded")
+	}
+	if err := db.Delete(&event).Error; err == nil {
+		t.Error("deleting an audit event succeeded")
+	}
+	if err := db.Exec("DELETE FROM audit_events").Error; err == nil {
+		t.Error("deleting audit events with SQL succeeded")
+	}
+
+	var stored AuditEvent
+	db.First(&stored, event.ID)
+	if stored.ActorID != 1 || stored.After != `{"name":"general"}` {
+		t.Errorf("stored event %+v", stored)
+	}
+
+	// Migrating again keeps the triggers
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec("DELETE FROM audit_events").Error; err == nil {
+		t.Error("deleting audit events after migrating again succeeded")
+	}
+}
@@ -1,26 +1,54 @@
 package models
 
 import (
-	"time"
+	"fmt"
 	"gorm.io/gorm"
+	"html/template"
+	"time"
+
+	"github.com/jeffasante/chatroom.go/markdown"
 )
 
 type User struct {
-	ID       uint   `json:"id" gorm:"primaryKey"`
-	Username string `json:"username" gorm:"unique;not null"`
-	Email    string `json:"email" gorm:"unique;not null"`
-	Password string `json:"-" gorm:"not null"`
+	ID        uint   `json:"id" gorm:"primaryKey"`
+	Username  string `json:"username" gorm:"unique;not null"`
+	Email     string `json:"email" gorm:"unique;not null"`
+	Password  string `json:"-" gorm:"not null"`
 	CreatedAt time.Time
+
+	// Bot accounts authenticate with an API token instead of a password
+	IsBot        bool   `json:"is_bot"`
+	BotOwnerID   uint   `json:"bot_owner_id,omitempty"`
+	APITokenHash string `json:"-" gorm:"index"`
 }
 
 type Chatroom struct {
-	ID          uint   `json:"id" gorm:"primaryKey"`
-	Code        string `json:"code" gorm:"unique;not null;size:8"`
-	Name        string `json:"name" gorm:"not null"`
-	Password    string `json:"-" gorm:"not null"`
-	OwnerID     uint   `json:"owner_id"`
-	CreatedAt   time.Time
-	
+	ID        uint   `json:"id" gorm:"primaryKey"`
+	Code      string `json:"code" gorm:"unique;not null;size:8"`
+	Name      string `json:"name" gorm:"not null"`
+	Password  string `json:"-" gorm:"not null"`
+	Topic     string `json:"topic"`
+	OwnerID   uint   `json:"owner_id"`
+	CreatedAt time.Time
+
+	// Longest message allowed, in characters. 0 uses the server default.
+	MaxMessageLength int `json:"max_message_length" gorm:"not null;default:0"`
+
+	// Slow mode: members may post once every this many seconds. 0 is off.
+	SlowModeSeconds int `json:"slow_mode_seconds" gorm:"not null;default:0"`
+
+	// Retention: messages older than RetentionDays, or older than the
+	// newest RetentionMessages, are purged. 0 uses the server default.
+	RetentionDays     int `json:"retention_days" gorm:"not null;default:0"`
+	RetentionMessages int `json:"retention_messages" gorm:"not null;default:0"`
+
+	// A room under legal hold is never purged and can't be deleted
+	LegalHold bool `json:"legal_hold" gorm:"not null;default:false"`
+
+	// Highest sequence number purged, so numbering carries on even after
+	// every message has expired
+	PurgedSeq uint64 `json:"-" gorm:"not null;default:0"`
+
 	// Relationships
 	Owner       User         `gorm:"foreignKey:OwnerID"`
 	Messages    []Message    `gorm:"foreignKey:ChatroomID"`
@@ -29,27 +57,275 @@ type Chatroom struct {
 
 type Message struct {
 	ID         uint      `json:"id" gorm:"primaryKey"`
+	Type       string    `json:"type" gorm:"not null;default:message"`
 	Content    string    `json:"content" gorm:"not null;type:text"`
-	UserID     uint      `json:"user_id"`
-	ChatroomID uint      `json:"chatroom_id"`
+	UserID     uint      `json:"user_id" gorm:"uniqueIndex:idx_messages_nonce,priority:2"`
+	ChatroomID uint      `json:"chatroom_id" gorm:"uniqueIndex:idx_messages_seq,priority:1;uniqueIndex:idx_messages_nonce,priority:1"`
 	CreatedAt  time.Time `json:"created_at"`
-	
+
+	// Position in the room, assigned by the hub. Increasing and gapless
+	// apart from deleted messages, so clients order by it and spot missed
+	// messages. 0 only until BackfillMessageSeqs numbers old messages.
+	Seq uint64 `json:"seq" gorm:"not null;default:0;uniqueIndex:idx_messages_seq,priority:2,where:seq > 0"`
+
+	// Client-supplied ID used to make retried sends idempotent, unique per
+	// sender in a room
+	ClientNonce string `json:"-" gorm:"uniqueIndex:idx_messages_nonce,priority:3,where:client_nonce <> ''"`
+
 	// Relationships
-	User     User     `gorm:"foreignKey:UserID"`
-	Chatroom Chatroom `gorm:"foreignKey:ChatroomID"`
+	User        User         `gorm:"foreignKey:UserID"`
+	Chatroom    Chatroom     `gorm:"foreignKey:ChatroomID"`
+	Attachments []Attachment `json:"attachments" gorm:"foreignKey:MessageID"`
+
+	// Cached previews of links in Content, filled in when loading history
+	Previews []LinkPreview `json:"previews,omitempty" gorm:"-"`
+
+	// Content rendered from Markdown, safe to insert into a page
+	HTML template.HTML `json:"html" gorm:"-"`
+}
+
+// AfterFind renders the content of every message loaded from the database
+func (m *Message) AfterFind(tx *gorm.DB) error {
+	m.HTML = template.HTML(markdown.Render(m.Content))
+	return nil
 }
 
+// Room roles, from least to most privileged
+const (
+	RoleMember    = "member"
+	RoleModerator = "moderator"
+	RoleOwner     = "owner"
+)
+
 type Membership struct {
-	ID         uint `gorm:"primaryKey"`
-	UserID     uint `gorm:"not null"`
-	ChatroomID uint `gorm:"not null"`
+	ID         uint   `gorm:"primaryKey"`
+	UserID     uint   `gorm:"not null"`
+	ChatroomID uint   `gorm:"not null"`
+	Role       string `gorm:"not null;default:member"`
 	JoinedAt   time.Time
-	
+
+	// Highest message ID the user has seen in this room
+	LastReadMessageID uint `gorm:"not null;default:0"`
+
+	// Set while a moderator has muted the user in this room
+	MutedUntil *time.Time
+
 	// Relationships
 	User     User     `gorm:"foreignKey:UserID"`
 	Chatroom Chatroom `gorm:"foreignKey:ChatroomID"`
 }
 
+// Webhook is an outgoing HTTP callback registered by a room owner
+type Webhook struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	ChatroomID  uint      `json:"chatroom_id" gorm:"not null;index"`
+	URL         string    `json:"url" gorm:"not null"`
+	Secret      string    `json:"-" gorm:"not null"`
+	Events      string    `json:"events" gorm:"not null"` // comma separated, e.g. "message,join"
+	Active      bool      `json:"active" gorm:"not null;default:true"`
+	CreatedByID uint      `json:"created_by_id"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Delivery states for WebhookDelivery
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is one event queued for a webhook. Rows are the durable
+// outbox the webhook dispatcher retries from.
+type WebhookDelivery struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	WebhookID     uint       `json:"webhook_id" gorm:"not null;index"`
+	Event         string     `json:"event" gorm:"not null"`
+	MessageID     uint       `json:"message_id,omitempty" gorm:"index"` // the message the event is about, if any
+	Payload       string     `json:"-" gorm:"not null;type:text"`
+	Status        string     `json:"status" gorm:"not null;default:pending;index"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" gorm:"index"`
+	LastError     string     `json:"last_error"`
+	CreatedAt     time.Time  `json:"created_at"`
+	DeliveredAt   *time.Time `json:"delivered_at"`
+}
+
+// IncomingWebhook lets an external service post into a room. Messages are
+// attributed to the webhook's integration user.
+type IncomingWebhook struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	ChatroomID  uint      `json:"chatroom_id" gorm:"not null;index"`
+	Name        string    `json:"name" gorm:"not null"`
+	TokenHash   string    `json:"-" gorm:"not null;uniqueIndex"`
+	UserID      uint      `json:"user_id"`
+	CreatedByID uint      `json:"created_by_id"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	// Relationships
+	User User `json:"-" gorm:"foreignKey:UserID"`
+}
+
+// Attachment is an uploaded file. The bytes live in a blob store under Key;
+// MessageID is set once the message carrying it has been saved.
+type Attachment struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	MessageID   uint      `json:"message_id" gorm:"index"`
+	ChatroomID  uint      `json:"chatroom_id" gorm:"not null;index"`
+	UserID      uint      `json:"user_id" gorm:"not null"`
+	Key         string    `json:"-" gorm:"not null;uniqueIndex"`
+	Filename    string    `json:"filename" gorm:"not null"`
+	ContentType string    `json:"content_type" gorm:"not null"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	// Set for images
+	Width                int    `json:"width,omitempty"`
+	Height               int    `json:"height,omitempty"`
+	ThumbnailKey         string `json:"-"`
+	ThumbnailContentType string `json:"-"`
+	ThumbnailWidth       int    `json:"thumbnail_width,omitempty"`
+	ThumbnailHeight      int    `json:"thumbnail_height,omitempty"`
+}
+
+// BlobKeys lists every stored blob belonging to the attachment
+func (a *Attachment) BlobKeys() []string {
+	if a.ThumbnailKey != "" {
+		return []string{a.Key, a.ThumbnailKey}
+	}
+	return []string{a.Key}
+}
+
+// LinkPreview caches the result of unfurling a URL. OK is false when the
+// page couldn't be fetched or had no title, so it isn't retried every time.
+type LinkPreview struct {
+	ID          uint      `json:"-" gorm:"primaryKey"`
+	URL         string    `json:"url" gorm:"not null;uniqueIndex"`
+	OK          bool      `json:"-" gorm:"not null;default:false"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty" gorm:"type:text"`
+	SiteName    string    `json:"site_name,omitempty"`
+	ImageURL    string    `json:"image_url,omitempty"`
+	FetchedAt   time.Time `json:"-"`
+}
+
+// RoomModeration is a room's content filter configuration. Actions are
+// moderation actions: allow, mask, hold or reject.
+type RoomModeration struct {
+	ID             uint      `json:"-" gorm:"primaryKey"`
+	ChatroomID     uint      `json:"-" gorm:"not null;uniqueIndex"`
+	BlockedWords   string    `json:"-" gorm:"type:text"` // one per line
+	WordAction     string    `json:"-" gorm:"not null;default:mask"`
+	Rules          string    `json:"-" gorm:"type:text"` // JSON list of {pattern, action, reason}
+	BlockedDomains string    `json:"-" gorm:"type:text"` // one per line
+	LinkAction     string    `json:"-" gorm:"not null;default:reject"`
+	UseClassifier  bool      `json:"-" gorm:"not null;default:false"`
+	UpdatedAt      time.Time `json:"-"`
+}
+
+// Review states for HeldMessage
+const (
+	HeldPending  = "pending"
+	HeldApproved = "approved"
+	HeldRejected = "rejected"
+)
+
+// HeldMessage is a message a moderation filter kept out of the room until a
+// moderator reviews it. Approved messages are posted as new messages.
+type HeldMessage struct {
+	ID           uint       `json:"id" gorm:"primaryKey"`
+	ChatroomID   uint       `json:"chatroom_id" gorm:"not null;index"`
+	UserID       uint       `json:"user_id" gorm:"not null"`
+	Type         string     `json:"type" gorm:"not null;default:message"`
+	Content      string     `json:"content" gorm:"not null;type:text"`
+	Attachments  string     `json:"-" gorm:"type:text"` // JSON list of attachment infos
+	ClientNonce  string     `json:"-" gorm:"index"`
+	Reason       string     `json:"reason"`
+	Filter       string     `json:"filter"`
+	Status       string     `json:"status" gorm:"not null;default:pending;index"`
+	ReviewedByID uint       `json:"reviewed_by_id,omitempty"`
+	ReviewedAt   *time.Time `json:"reviewed_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+
+	// Relationships
+	User User `json:"user" gorm:"foreignKey:UserID"`
+}
+
+// RoomBan keeps a user out of a room; they can't join again while it exists
+type RoomBan struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	ChatroomID uint      `json:"chatroom_id" gorm:"not null;uniqueIndex:idx_room_bans_room_user"`
+	UserID     uint      `json:"user_id" gorm:"not null;uniqueIndex:idx_room_bans_room_user"`
+	BannedByID uint      `json:"banned_by_id"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Report reasons members can choose from
+var ReportReasons = []string{"spam", "harassment", "hate", "sexual", "violence", "other"}
+
+// Report states
+const (
+	ReportOpen      = "open"
+	ReportResolved  = "resolved"
+	ReportDismissed = "dismissed"
+)
+
+// Actions a moderator can resolve a report with
+const (
+	ReportActionDismiss       = "dismiss"
+	ReportActionDeleteMessage = "delete_message"
+	ReportActionMute          = "mute"
+	ReportActionBan           = "ban"
+)
+
+// Report flags a message, or a user when MessageID is 0, for the room's
+// moderators. The resolution fields record who acted on it and how.
+type Report struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	ChatroomID   uint      `json:"chatroom_id" gorm:"not null;index"`
+	ReporterID   uint      `json:"reporter_id" gorm:"not null"`
+	TargetUserID uint      `json:"target_user_id" gorm:"not null;index"`
+	MessageID    uint      `json:"message_id,omitempty" gorm:"index"`
+	Reason       string    `json:"reason" gorm:"not null"`
+	Details      string    `json:"details" gorm:"type:text"`
+	Status       string    `json:"status" gorm:"not null;default:open;index"`
+	CreatedAt    time.Time `json:"created_at"`
+
+	// Copy of the reported message, kept if the message is deleted
+	MessageContent string `json:"message_content,omitempty" gorm:"type:text"`
+
+	Action         string     `json:"action,omitempty"`
+	ResolutionNote string     `json:"resolution_note,omitempty" gorm:"type:text"`
+	ResolvedByID   uint       `json:"resolved_by_id,omitempty"`
+	ResolvedAt     *time.Time `json:"resolved_at,omitempty"`
+
+	// Relationships
+	Reporter   User  `json:"reporter" gorm:"foreignKey:ReporterID"`
+	TargetUser User  `json:"target_user" gorm:"foreignKey:TargetUserID"`
+	ResolvedBy *User `json:"resolved_by,omitempty" gorm:"foreignKey:ResolvedByID"`
+}
+
+// UserBlock hides a user's messages from the user who blocked them
+type UserBlock struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"not null;uniqueIndex:idx_user_blocks_pair"`
+	BlockedID uint      `json:"blocked_id" gorm:"not null;uniqueIndex:idx_user_blocks_pair"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Relationships
+	Blocked User `json:"blocked" gorm:"foreignKey:BlockedID"`
+}
+
+// ImportedChannel remembers which room a channel from another chat service
+// was imported into, so importing the same export again finds it
+type ImportedChannel struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	Source     string    `json:"source" gorm:"not null;uniqueIndex:idx_imported_channels_source"` // slack or discord
+	ExternalID string    `json:"external_id" gorm:"not null;uniqueIndex:idx_imported_channels_source"`
+	ChatroomID uint      `json:"chatroom_id" gorm:"not null;index"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
 // Helper methods - all now properly accept database parameter
 func (u *User) GetChatrooms(db *gorm.DB) ([]Chatroom, error) {
 	var memberships []Membership
@@ -57,33 +333,199 @@ func (u *User) GetChatrooms(db *gorm.DB) ([]Chatroom, error) {
 	if err != nil {
 		return nil, err
 	}
-	
+
 	var chatroomIDs []uint
 	for _, membership := range memberships {
 		chatroomIDs = append(chatroomIDs, membership.ChatroomID)
 	}
-	
+
 	if len(chatroomIDs) == 0 {
 		return []Chatroom{}, nil
 	}
-	
+
 	var chatrooms []Chatroom
 	err = db.Where("id IN ?", chatroomIDs).Find(&chatrooms).Error
 	return chatrooms, err
 }
 
+// UnreadCounts returns the number of unread messages from other users in
+// each of the user's rooms, keyed by chatroom ID. Rooms with nothing unread
+// are omitted.
+func (u *User) UnreadCounts(db *gorm.DB) (map[uint]int64, error) {
+	var rows []struct {
+		ChatroomID uint
+		Unread     int64
+	}
+	err := db.Table("messages").
+		Select("messages.chatroom_id AS chatroom_id, COUNT(*) AS unread").
+		Joins("JOIN memberships ON memberships.chatroom_id = messages.chatroom_id AND memberships.user_id = ?", u.ID).
+		Where("messages.id > memberships.last_read_message_id AND messages.user_id <> ?", u.ID).
+		Group("messages.chatroom_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[uint]int64, len(rows))
+	for _, row := range rows {
+		counts[row.ChatroomID] = row.Unread
+	}
+	return counts, nil
+}
+
 func (c *Chatroom) IsMember(db *gorm.DB, userID uint) bool {
 	var count int64
 	db.Model(&Membership{}).Where("user_id = ? AND chatroom_id = ?", userID, c.ID).Count(&count)
 	return count > 0
 }
 
+// GetMessages returns the room's newest messages, oldest first
 func (c *Chatroom) GetMessages(db *gorm.DB, limit int) ([]Message, error) {
 	var messages []Message
 	err := db.Where("chatroom_id = ?", c.ID).
 		Preload("User").
-		Order("created_at ASC").
+		Preload("Attachments").
+		Order("seq DESC").
 		Limit(limit).
 		Find(&messages).Error
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
 	return messages, err
-}
\ No newline at end of file
+}
+
+// Migrate brings the database schema up to date
+func Migrate(db *gorm.DB) error {
+	if err := dedupeMessageNonces(db); err != nil {
+		return fmt.Errorf("removing duplicate message nonces: %w", err)
+	}
+	if err := dedupeMessageSeqs(db); err != nil {
+		return fmt.Errorf("removing duplicate sequence numbers: %w", err)
+	}
+	if err := db.AutoMigrate(&User{}, &Chatroom{}, &Message{}, &Membership{},
+		&Webhook{}, &WebhookDelivery{}, &IncomingWebhook{}, &Attachment{}, &LinkPreview{},
+		&RoomModeration{}, &HeldMessage{}, &RoomBan{}, &Report{}, &UserBlock{},
+		&AuditEvent{}, &ImportedChannel{}); err != nil {
+		return err
+	}
+	// Replaced by the unique idx_messages_nonce and idx_messages_seq
+	for _, index := range []string{"idx_messages_client_nonce", "idx_messages_room_seq"} {
+		if db.Migrator().HasIndex(&Message{}, index) {
+			if err := db.Migrator().DropIndex(&Message{}, index); err != nil {
+				return err
+			}
+		}
+	}
+	if err := BackfillMessageSeqs(db); err != nil {
+		return fmt.Errorf("numbering existing messages: %w", err)
+	}
+	if err := ProtectAuditLog(db); err != nil {
+		return fmt.Errorf("protecting the audit log: %w", err)
+	}
+	return nil
+}
+
+// dedupeMessageNonces clears the nonce of all but the first message a user
+// sent with it in a room, so the unique index can be created on databases
+// from before it existed
+func dedupeMessageNonces(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&Message{}, "ClientNonce") {
+		return nil
+	}
+	return db.Exec(`UPDATE messages SET client_nonce = '' WHERE client_nonce <> '' AND id NOT IN (
+		SELECT MIN(id) FROM messages WHERE client_nonce <> '' GROUP BY chatroom_id, user_id, client_nonce
+	)`).Error
+}
+
+// dedupeMessageSeqs unnumbers all but the first message to take each
+// sequence number in a room, so the unique index can be created on
+// databases from before it existed. BackfillMessageSeqs then numbers them
+// after the room's last message.
+func dedupeMessageSeqs(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&Message{}, "Seq") {
+		return nil
+	}
+	return db.Exec(`UPDATE messages SET seq = 0 WHERE seq > 0 AND id NOT IN (
+		SELECT MIN(id) FROM messages WHERE seq > 0 GROUP BY chatroom_id, seq
+	)`).Error
+}
+
+// BackfillMessageSeqs numbers messages that have no sequence number, in ID
+// order after the last number each room has used
+func BackfillMessageSeqs(db *gorm.DB) error {
+	var rooms []uint
+	if err := db.Model(&Message{}).Where("seq = 0").Distinct().Pluck("chatroom_id", &rooms).Error; err != nil {
+		return err
+	}
+
+	for _, chatroomID := range rooms {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			seq, err := LastMessageSeq(tx, chatroomID)
+			if err != nil {
+				return err
+			}
+			var ids []uint
+			if err := tx.Model(&Message{}).Where("chatroom_id = ? AND seq = 0", chatroomID).Order("id").Pluck("id", &ids).Error; err != nil {
+				return err
+			}
+			for _, id := range ids {
+				seq++
+				if err := tx.Model(&Message{}).Where("id = ?", id).Update("seq", seq).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LastMessageSeq returns the highest sequence number a room has used,
+// including messages that have since been purged
+func LastMessageSeq(db *gorm.DB, chatroomID uint) (uint64, error) {
+	var last struct{ Seq uint64 }
+	err := db.Model(&Chatroom{}).
+		Select("MAX(purged_seq, COALESCE((SELECT MAX(seq) FROM messages WHERE chatroom_id = chatrooms.id), 0)) AS seq").
+		Where("id = ?", chatroomID).
+		Scan(&last).Error
+	return last.Seq, err
+}
+
+// RoleRank orders roles so permission checks can compare them
+func RoleRank(role string) int {
+	switch role {
+	case RoleOwner:
+		return 3
+	case RoleModerator:
+		return 2
+	case RoleMember:
+		return 1
+	}
+	return 0
+}
+
+// RoleOf returns the user's role in the room, or "" if they are not a member.
+// The owner is always reported as RoleOwner, even for memberships created
+// before roles existed.
+func (c *Chatroom) RoleOf(db *gorm.DB, userID uint) string {
+	if c.OwnerID == userID {
+		return RoleOwner
+	}
+
+	var membership Membership
+	if err := db.Where("user_id = ? AND chatroom_id = ?", userID, c.ID).First(&membership).Error; err != nil {
+		return ""
+	}
+	if membership.Role == "" {
+		return RoleMember
+	}
+	return membership.Role
+}
+
+// HasRole reports whether the user holds at least the given role in the room
+func (c *Chatroom) HasRole(db *gorm.DB, userID uint, role string) bool {
+	return RoleRank(c.RoleOf(db, userID)) >= RoleRank(role)
+}
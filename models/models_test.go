@@ -0,0 +1,84 @@
+package models
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func roomSeqs(t *testing.T, db *gorm.DB, chatroomID uint) []uint64 {
+	t.Helper()
+	var seqs []uint64
+	if err := db.Model(&Message{}).Where("chatroom_id = ?", chatroomID).Order("id").Pluck("seq", &seqs).Error; err != nil {
+		t.Fatal(err)
+	}
+	return seqs
+}
+
+func TestBackfillMessageSeqs(t *testing.T) {
+	db := newTestDB(t)
+	db.Create(&Chatroom{ID: 1, Name: "a", Code: "a"})
+	db.Create(&Chatroom{ID: 2, Name: "b", Code: "b", PurgedSeq: 5})
+	for _, m := range []Message{
+		{ChatroomID: 1, Content: "1"},
+		{ChatroomID: 2, Content: "2"},
+		{ChatroomID: 1, Content: "3"},
+		{ChatroomID: 1, Content: "4", Seq: 7},
+		{ChatroomID: 1, Content: "5"},
+	} {
+		if err := db.Create(&m).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := BackfillMessageSeqs(db); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := roomSeqs(t, db, 1), []uint64{8, 9, 7, 10}; !reflect.DeepEqual(got, want) {
+		t.Errorf("room 1 seqs = %v, want %v", got, want)
+	}
+	if got, want := roomSeqs(t, db, 2), []uint64{6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("room 2 seqs = %v, want %v", got, want)
+	}
+}
+
+func TestMigrateRenumbersDuplicateSeqs(t *testing.T) {
+	db := newTestDB(t)
+	db.Create(&Chatroom{ID: 1, Name: "a", Code: "a"})
+
+	// As left by a hub with a stale sequence cache, before the unique index
+	if err := db.Migrator().DropIndex(&Message{}, "idx_messages_seq"); err != nil {
+		t.Fatal(err)
+	}
+	for i, seq := range []uint64{1, 2, 2, 3} {
+		if err := db.Create(&Message{ChatroomID: 1, Content: string(rune('a' + i)), Seq: seq}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := roomSeqs(t, db, 1), []uint64{1, 2, 4, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("seqs = %v, want %v", got, want)
+	}
+	if err := db.Create(&Message{ChatroomID: 1, Content: "dup", Seq: 4}).Error; err == nil {
+		t.Error("saved a message with a sequence number already in use")
+	}
+}
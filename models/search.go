@@ -0,0 +1,55 @@
+package models
+
+import "gorm.io/gorm"
+
+// Statements creating the FTS5 index over message content. Triggers keep it
+// in sync however messages are created, edited or deleted.
+var searchIndexSchema = []string{
+	`CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(content, content='messages', content_rowid='id')`,
+	`CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+		INSERT INTO messages_fts(rowid, content) VALUES (new.id, new.content);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+		INSERT INTO messages_fts(messages_fts, rowid, content) VALUES ('delete', old.id, old.content);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF content ON messages BEGIN
+		INSERT INTO messages_fts(messages_fts, rowid, content) VALUES ('delete', old.id, old.content);
+		INSERT INTO messages_fts(rowid, content) VALUES (new.id, new.content);
+	END`,
+}
+
+var searchIndexTriggers = []string{"messages_fts_insert", "messages_fts_delete", "messages_fts_update"}
+
+// SetupSearchIndex creates the full-text index, filling it from existing
+// messages when it is new or was left stale. It fails if SQLite was built
+// without FTS5 (build with -tags sqlite_fts5); the sync triggers are then
+// removed so saving messages keeps working, and search falls back to
+// slower substring matching.
+func SetupSearchIndex(db *gorm.DB) error {
+	var triggers int64
+	db.Raw(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name IN ?`, searchIndexTriggers).Scan(&triggers)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range searchIndexSchema {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		// The statements above succeed without FTS5 when the index already
+		// exists, but reading it does not
+		var probe []int64
+		if err := tx.Raw(`SELECT rowid FROM messages_fts LIMIT 1`).Scan(&probe).Error; err != nil {
+			return err
+		}
+		if triggers == int64(len(searchIndexTriggers)) {
+			return nil
+		}
+		return tx.Exec(`INSERT INTO messages_fts(messages_fts) VALUES ('rebuild')`).Error
+	})
+	if err != nil {
+		for _, name := range searchIndexTriggers {
+			db.Exec(`DROP TRIGGER IF EXISTS ` + name)
+		}
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Classifier asks an external HTTP service for a verdict. The service gets
+// a JSON Message in a POST and answers with ClassifierResponse.
+type Classifier struct {
+	URL    string
+	Client *http.Client
+}
+
+// ClassifierResponse is what a classifier service returns. Content is only
+// used when the action is mask.
+type ClassifierResponse struct {
+	Action  Action `json:"action"`
+	Content string `json:"content,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// NewClassifier returns a Classifier with a short timeout, since every
+// message waits for it
+func NewClassifier(url string) *Classifier {
+	return &Classifier{URL: url, Client: &http.Client{Timeout: 2 * time.Second}}
+}
+
+func (c *Classifier) Name() string { return "classifier" }
+
+func (c *Classifier) Check(ctx context.Context, msg Message) (Decision, error) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return Decision{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return Decision{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return Decision{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Decision{}, fmt.Errorf("classifier returned %s", resp.Status)
+	}
+
+	var result ClassifierResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&result); err != nil {
+		return Decision{}, fmt.Errorf("invalid classifier response: %w", err)
+	}
+	if _, err := ParseAction(string(result.Action)); err != nil {
+		return Decision{}, err
+	}
+	if result.Action != Mask || result.Content == "" {
+		result.Content = msg.Content
+	}
+	return Decision{Action: result.Action, Content: result.Content, Reason: result.Reason}, nil
+}
@@ -0,0 +1,136 @@
+package moderation
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// WordList matches whole words, ignoring case
+type WordList struct {
+	pattern *regexp.Regexp
+	action  Action
+}
+
+// NewWordList returns nil if there are no words to match
+func NewWordList(words []string, action Action) *WordList {
+	var quoted []string
+	for _, w := range words {
+		if w = strings.TrimSpace(w); w != "" {
+			quoted = append(quoted, regexp.QuoteMeta(w))
+		}
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+	return &WordList{
+		pattern: regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`),
+		action:  action,
+	}
+}
+
+func (w *WordList) Name() string { return "word_list" }
+
+func (w *WordList) Check(ctx context.Context, msg Message) (Decision, error) {
+	return applyPattern(w.pattern, w.action, "blocked word", msg.Content), nil
+}
+
+// Rule is a regular expression and what to do with messages it matches
+type Rule struct {
+	Pattern *regexp.Regexp
+	Action  Action
+	Reason  string
+}
+
+// Rules applies each rule in turn
+type Rules []Rule
+
+func (r Rules) Name() string { return "regex_rules" }
+
+func (r Rules) Check(ctx context.Context, msg Message) (Decision, error) {
+	result := Decision{Action: Allow, Content: msg.Content}
+	for _, rule := range r {
+		reason := rule.Reason
+		if reason == "" {
+			reason = "matched a room rule"
+		}
+		d := applyPattern(rule.Pattern, rule.Action, reason, result.Content)
+		switch d.Action {
+		case Mask:
+			result = d
+		case Hold, Reject:
+			return d, nil
+		}
+	}
+	return result, nil
+}
+
+// applyPattern decides on a message using one pattern. Masking replaces
+// each match with asterisks.
+func applyPattern(pattern *regexp.Regexp, action Action, reason, content string) Decision {
+	if !pattern.MatchString(content) {
+		return Decision{Action: Allow, Content: content}
+	}
+	if action == Mask {
+		content = pattern.ReplaceAllStringFunc(content, func(match string) string {
+			return strings.Repeat("*", utf8.RuneCountInString(match))
+		})
+	}
+	return Decision{Action: action, Content: content, Reason: reason}
+}
+
+// LinkBlocklist matches links to the listed domains and their subdomains
+type LinkBlocklist struct {
+	domains []string
+	action  Action
+}
+
+// NewLinkBlocklist returns nil if there are no domains to block
+func NewLinkBlocklist(domains []string, action Action) *LinkBlocklist {
+	var cleaned []string
+	for _, d := range domains {
+		d = strings.Trim(strings.ToLower(strings.TrimSpace(d)), ".")
+		if d != "" {
+			cleaned = append(cleaned, d)
+		}
+	}
+	if len(cleaned) == 0 {
+		return nil
+	}
+	return &LinkBlocklist{domains: cleaned, action: action}
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"']+`)
+
+func (l *LinkBlocklist) Name() string { return "link_blocklist" }
+
+func (l *LinkBlocklist) Check(ctx context.Context, msg Message) (Decision, error) {
+	blocked := false
+	content := linkPattern.ReplaceAllStringFunc(msg.Content, func(link string) string {
+		u, err := url.Parse(link)
+		if err != nil || !l.blocks(u.Hostname()) {
+			return link
+		}
+		blocked = true
+		return "[link removed]"
+	})
+	if !blocked {
+		return Decision{Action: Allow, Content: msg.Content}, nil
+	}
+	if l.action != Mask {
+		content = msg.Content
+	}
+	return Decision{Action: l.action, Content: content, Reason: "blocked link"}, nil
+}
+
+func (l *LinkBlocklist) blocks(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, d := range l.domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+// Package moderation screens chat messages before they are saved. Filters
+// are run in a Chain; each can allow a message, mask parts of it, hold it
+// for a moderator to review, or reject it outright.
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// Action is what a filter wants done with a message
+type Action string
+
+const (
+	Allow  Action = "allow"
+	Mask   Action = "mask"   // post it with the offending parts replaced
+	Hold   Action = "hold"   // keep it out of the room until a moderator approves it
+	Reject Action = "reject" // drop it and tell the sender
+)
+
+// ParseAction validates an action name from configuration
+func ParseAction(s string) (Action, error) {
+	switch a := Action(s); a {
+	case Allow, Mask, Hold, Reject:
+		return a, nil
+	}
+	return "", fmt.Errorf("unknown moderation action %q", s)
+}
+
+// Message is what filters see of a chat message
+type Message struct {
+	ChatroomID uint   `json:"chatroom_id"`
+	UserID     uint   `json:"user_id"`
+	Content    string `json:"content"`
+}
+
+// Decision is a filter's verdict. Content is the text to post, which only
+// differs from the original when parts were masked.
+type Decision struct {
+	Action  Action
+	Content string
+	Reason  string
+	Filter  string // name of the filter that decided, empty if all allowed it
+}
+
+// Filter checks one message. Filters that can't reach a verdict, e.g.
+// because a remote service is down, return an error and are skipped.
+type Filter interface {
+	Name() string
+	Check(ctx context.Context, msg Message) (Decision, error)
+}
+
+// Chain runs filters in order. Masks accumulate, so later filters see the
+// masked text; a rejection stops the chain, and a hold stands unless a
+// later filter rejects.
+type Chain []Filter
+
+// Run returns the combined decision of every filter
+func (c Chain) Run(ctx context.Context, msg Message) Decision {
+	result := Decision{Action: Allow, Content: msg.Content}
+	var held *Decision
+
+	for _, filter := range c {
+		msg.Content = result.Content
+		d, err := filter.Check(ctx, msg)
+		if err != nil {
+			log.Printf("Moderation filter %s failed, skipping it: %v", filter.Name(), err)
+			continue
+		}
+		d.Filter = filter.Name()
+
+		switch d.Action {
+		case Mask:
+			result.Action, result.Content = Mask, d.Content
+			result.Reason, result.Filter = d.Reason, d.Filter
+		case Hold:
+			if held == nil {
+				d.Content = result.Content
+				held = &d
+			}
+		case Reject:
+			d.Content = result.Content
+			return d
+		}
+	}
+
+	if held != nil {
+		return *held
+	}
+	return result
+}
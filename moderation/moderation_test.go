@@ -0,0 +1,114 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fixed is a filter that always gives the same verdict
+type fixed struct {
+	name   string
+	action Action
+	err    error
+	seen   *string // content the filter was given
+}
+
+func (f fixed) Name() string { return f.name }
+
+func (f fixed) Check(ctx context.Context, msg Message) (Decision, error) {
+	if f.seen != nil {
+		*f.seen = msg.Content
+	}
+	if f.err != nil {
+		return Decision{}, f.err
+	}
+	return Decision{Action: f.action, Content: msg.Content, Reason: f.name}, nil
+}
+
+func TestChainOrder(t *testing.T) {
+	masked := NewWordList([]string{"darn"}, Mask)
+	var seen string
+
+	tests := []struct {
+		name    string
+		chain   Chain
+		action  Action
+		filter  string
+		content string
+	}{
+		{"mask then hold", Chain{masked, fixed{name: "hold", action: Hold, seen: &seen}}, Hold, "hold", "well **** it"},
+		{"mask, hold, reject", Chain{masked, fixed{name: "hold", action: Hold}, fixed{name: "reject", action: Reject}}, Reject, "reject", "well **** it"},
+		{"first hold stands", Chain{fixed{name: "first", action: Hold}, fixed{name: "second", action: Hold}}, Hold, "first", "well darn it"},
+		{"reject stops the chain", Chain{fixed{name: "reject", action: Reject}, masked}, Reject, "reject", "well darn it"},
+		{"mask only", Chain{masked, fixed{name: "allow", action: Allow}}, Mask, "word_list", "well **** it"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := tt.chain.Run(context.Background(), Message{Content: "well darn it"})
+			if d.Action != tt.action || d.Filter != tt.filter {
+				t.Errorf("got %s by %q, want %s by %q", d.Action, d.Filter, tt.action, tt.filter)
+			}
+			if d.Content != tt.content {
+				t.Errorf("content %q, want %q", d.Content, tt.content)
+			}
+		})
+	}
+	if seen != "well **** it" {
+		t.Errorf("filter after the mask saw %q", seen)
+	}
+}
+
+func TestChainSkipsFailedFilters(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	chain := Chain{
+		NewClassifier(down.URL),
+		fixed{name: "broken", err: errors.New("timeout")},
+		NewWordList([]string{"darn"}, Mask),
+	}
+	d := chain.Run(context.Background(), Message{Content: "darn"})
+	if d.Action != Mask || d.Content != "****" {
+		t.Errorf("got %+v, want the word list's mask", d)
+	}
+}
+
+func TestLinkBlocklist(t *testing.T) {
+	blocklist := NewLinkBlocklist([]string{" Example.COM. "}, Mask)
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"see https://example.com/page", "see [link removed]"},
+		{"see http://cdn.images.example.com/a.png", "see [link removed]"},
+		{"see https://EXAMPLE.com./x", "see [link removed]"},
+		{"see https://notexample.com", "see https://notexample.com"},
+		{"see https://example.com.evil.net", "see https://example.com.evil.net"},
+		{"example.com without a scheme", "example.com without a scheme"},
+	}
+	for _, tt := range tests {
+		d, err := blocklist.Check(context.Background(), Message{Content: tt.content})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Content != tt.want {
+			t.Errorf("%q became %q, want %q", tt.content, d.Content, tt.want)
+		}
+		if blocked := d.Content != tt.content; blocked != (d.Action == Mask) {
+			t.Errorf("%q: action %s", tt.content, d.Action)
+		}
+	}
+
+	if NewLinkBlocklist([]string{" ", ""}, Reject) != nil {
+		t.Error("empty blocklist is not nil")
+	}
+	rejecting := NewLinkBlocklist([]string{"example.com"}, Reject)
+	if d, _ := rejecting.Check(context.Background(), Message{Content: "https://a.example.com"}); d.Action != Reject || d.Content != "https://a.example.com" {
+		t.Errorf("rejecting blocklist gave %+v", d)
+	}
+}
@@ -0,0 +1,99 @@
+// Command standin is a local stand-in for an external moderation classifier,
+// for development and testing. It speaks the same protocol as a real one.
+//
+//	go run ./moderation/standin -addr :8090 -reject spam,scam -mask darn
+//	MODERATION_CLASSIFIER_URL=http://localhost:8090/classify ./server
+//
+// Besides the word lists it holds messages that are mostly capital letters
+// or carry many links.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/jeffasante/chatroom.go/moderation"
+)
+
+const (
+	shoutingMinLetters = 20
+	maxLinks           = 5
+)
+
+var linkPattern = regexp.MustCompile(`https?://`)
+
+func main() {
+	addr := flag.String("addr", ":8090", "address to listen on")
+	reject := flag.String("reject", "", "comma separated words that get a message rejected")
+	mask := flag.String("mask", "", "comma separated words to mask")
+	flag.Parse()
+
+	rejected := moderation.NewWordList(strings.Split(*reject, ","), moderation.Reject)
+	masked := moderation.NewWordList(strings.Split(*mask, ","), moderation.Mask)
+
+	http.Handle("/classify", classifyHandler(rejected, masked))
+
+	log.Printf("Stand-in classifier listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+// classifyHandler answers classification requests using the word lists
+func classifyHandler(filters ...*moderation.WordList) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var msg moderation.Message
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+
+		result := classify(r.Context(), msg, filters...)
+		log.Printf("chatroom %d user %d: %s %s", msg.ChatroomID, msg.UserID, result.Action, result.Reason)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+func classify(ctx context.Context, msg moderation.Message, filters ...*moderation.WordList) moderation.ClassifierResponse {
+	var chain moderation.Chain
+	for _, f := range filters {
+		if f != nil {
+			chain = append(chain, f)
+		}
+	}
+	if d := chain.Run(ctx, msg); d.Action != moderation.Allow {
+		return moderation.ClassifierResponse{Action: d.Action, Content: d.Content, Reason: d.Reason}
+	}
+
+	if shouting(msg.Content) {
+		return moderation.ClassifierResponse{Action: moderation.Hold, Reason: "shouting"}
+	}
+	if len(linkPattern.FindAllString(msg.Content, -1)) > maxLinks {
+		return moderation.ClassifierResponse{Action: moderation.Hold, Reason: "too many links"}
+	}
+	return moderation.ClassifierResponse{Action: moderation.Allow}
+}
+
+// shouting reports whether a message is long enough to judge and at least
+// 80% of its letters are capitals
+func shouting(content string) bool {
+	letters, upper := 0, 0
+	for _, r := range content {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	return letters >= shoutingMinLetters && upper*5 >= letters*4
+}
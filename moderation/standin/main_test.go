@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jeffasante/chatroom.go/moderation"
+)
+
+func TestClassifier(t *testing.T) {
+	srv := httptest.NewServer(classifyHandler(
+		moderation.NewWordList([]string{"scam"}, moderation.Reject),
+		moderation.NewWordList([]string{"darn"}, moderation.Mask),
+		nil,
+	))
+	defer srv.Close()
+	classifier := moderation.NewClassifier(srv.URL)
+
+	tests := []struct {
+		content string
+		action  moderation.Action
+		want    string
+	}{
+		{"hello there", moderation.Allow, "hello there"},
+		{"darn it", moderation.Mask, "**** it"},
+		{"darn, a scam", moderation.Reject, "darn, a scam"},
+		{"WHY IS EVERYONE SHOUTING AT ME", moderation.Hold, "WHY IS EVERYONE SHOUTING AT ME"},
+		{strings.Repeat("https://example.com ", maxLinks+1), moderation.Hold, strings.Repeat("https://example.com ", maxLinks+1)},
+	}
+	for _, tt := range tests {
+		d, err := classifier.Check(context.Background(), moderation.Message{ChatroomID: 1, UserID: 2, Content: tt.content})
+		if err != nil {
+			t.Fatalf("%q: %v", tt.content, err)
+		}
+		if d.Action != tt.action || d.Content != tt.want {
+			t.Errorf("%q: got %s %q, want %s %q", tt.content, d.Action, d.Content, tt.action, tt.want)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// Package netguard makes HTTP clients for requests to URLs supplied by
+// users, such as link previews and webhooks, that can only reach public IP
+// addresses. The address is checked when connecting, which also covers
+// redirects and DNS rebinding.
+package netguard
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// ErrBlockedAddress is returned when connecting to a non-public address
+var ErrBlockedAddress = errors.New("address not allowed")
+
+// NewClient returns an HTTP client that refuses non-public addresses
+// unless allowPrivate is set, for local development and tests.
+// checkRedirect is used as the client's CheckRedirect.
+func NewClient(timeout time.Duration, allowPrivate bool, checkRedirect func(*http.Request, []*http.Request) error) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !(allowPrivate || AllowedIP(ip)) {
+				return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+			}
+			return nil
+		},
+	}
+
+	transport := &http.Transport{
+		Proxy:                 nil, // a proxy would make the address check meaningless
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+
+	return &http.Client{
+		Transport:     transport,
+		Timeout:       timeout,
+		CheckRedirect: checkRedirect,
+	}
+}
+
+// cgnat is the carrier-grade NAT range, which isn't covered by IsPrivate
+var cgnat = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// nat64 addresses can reach IPv4 hosts, private ones included
+var nat64 = &net.IPNet{IP: net.ParseIP("64:ff9b::"), Mask: net.CIDRMask(96, 128)}
+
+// AllowedIP reports whether ip is a public unicast address
+func AllowedIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4[0] != 0 && !ip4.Equal(net.IPv4bcast) && !cgnat.Contains(ip4)
+	}
+	return !nat64.Contains(ip)
+}
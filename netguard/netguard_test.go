@@ -0,0 +1,33 @@
+package netguard
+
+import (
+	"net"
+	"testing"
+)
+
+func TestAllowedIP(t *testing.T) {
+	for raw, want := range map[string]bool{
+		"93.184.216.34":        true,
+		"2606:2800:220:1::248": true,
+		"127.0.0.1":            false,
+		"10.0.0.1":             false,
+		"172.16.5.4":           false,
+		"192.168.1.1":          false,
+		"169.254.169.254":      false,
+		"100.64.0.1":           false,
+		"0.0.0.0":              false,
+		"0.1.2.3":              false,
+		"255.255.255.255":      false,
+		"224.0.0.1":            false,
+		"::1":                  false,
+		"::":                   false,
+		"fe80::1":              false,
+		"fc00::1":              false,
+		"::ffff:127.0.0.1":     false,
+		"64:ff9b::a00:1":       false,
+	} {
+		if got := AllowedIP(net.ParseIP(raw)); got != want {
+			t.Errorf("AllowedIP(%s) = %v, want %v", raw, got, want)
+		}
+	}
+}
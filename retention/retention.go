@@ -0,0 +1,276 @@
+// Package retention purges messages that have outlived their room's
+// retention policy, in batches, optionally archiving them first. Rooms under
+// legal hold are never purged.
+package retention
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/jeffasante/chatroom.go/export"
+	"github.com/jeffasante/chatroom.go/models"
+	"github.com/jeffasante/chatroom.go/storage"
+	"github.com/jeffasante/chatroom.go/webhooks"
+)
+
+// Server-wide limits, 0 meaning no limit. Rooms can tighten them but not
+// relax them.
+var (
+	Days     int // messages older than this many days expire
+	Messages int // only this many of a room's newest messages are kept
+)
+
+// Policy returns the limits that apply to a room: the stricter of its own
+// settings and the server's, 0 meaning no limit
+func Policy(chatroom *models.Chatroom) (days, messages int) {
+	return stricter(chatroom.RetentionDays, Days), stricter(chatroom.RetentionMessages, Messages)
+}
+
+func stricter(room, server int) int {
+	if room == 0 || (server > 0 && server < room) {
+		return server
+	}
+	return room
+}
+
+// errHeld stops a purge when the room is put under legal hold mid-run
+var errHeld = errors.New("room is under legal hold")
+
+// Purger deletes expired messages and their attachments
+type Purger struct {
+	db    *gorm.DB
+	blobs storage.BlobStore
+
+	Interval   time.Duration // how often rooms are checked
+	BatchSize  int           // messages deleted per transaction
+	ArchiveDir string        // if set, messages are appended to a file here before deletion
+
+	// OnPurge is called with the IDs of each batch once it is deleted, so
+	// clients showing the messages can be told
+	OnPurge func(chatroomID uint, messageIDs []uint)
+}
+
+func NewPurger(db *gorm.DB, blobs storage.BlobStore) *Purger {
+	return &Purger{
+		db:        db,
+		blobs:     blobs,
+		Interval:  time.Hour,
+		BatchSize: 500,
+	}
+}
+
+// Run purges every room now and then every Interval until ctx is cancelled
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := p.PurgeAll(ctx); err != nil {
+			log.Printf("Retention purge failed: %v", err)
+		} else if n > 0 {
+			log.Printf("Retention purge removed %d messages", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeAll applies every room's policy once and returns how many messages
+// were removed. A room that fails is logged and skipped.
+func (p *Purger) PurgeAll(ctx context.Context) (int, error) {
+	query := p.db.Where("legal_hold = ?", false)
+	if Days == 0 && Messages == 0 {
+		query = query.Where("retention_days > 0 OR retention_messages > 0")
+	}
+	var rooms []models.Chatroom
+	if err := query.Find(&rooms).Error; err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for i := range rooms {
+		n, err := p.PurgeRoom(ctx, &rooms[i])
+		total += n
+		if err != nil {
+			log.Printf("Failed to purge chatroom %d: %v", rooms[i].ID, err)
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return total, ctx.Err()
+}
+
+// PurgeRoom deletes a room's expired messages, oldest first, and returns
+// how many were removed
+func (p *Purger) PurgeRoom(ctx context.Context, chatroom *models.Chatroom) (int, error) {
+	if chatroom.LegalHold {
+		return 0, nil
+	}
+	days, messages := Policy(chatroom)
+
+	// Cutoffs are fixed at the start, so messages sent during a long purge
+	// don't move them
+	var conditions []string
+	var args []interface{}
+	if days > 0 {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, time.Now().AddDate(0, 0, -days))
+	}
+	if messages > 0 {
+		var seqs []uint64
+		if err := p.db.Model(&models.Message{}).
+			Where("chatroom_id = ?", chatroom.ID).
+			Order("seq DESC").
+			Offset(messages).
+			Limit(1).
+			Pluck("seq", &seqs).Error; err != nil {
+			return 0, err
+		}
+		if len(seqs) > 0 {
+			conditions = append(conditions, "seq <= ?")
+			args = append(args, seqs[0])
+		}
+	}
+	if len(conditions) == 0 {
+		return 0, nil
+	}
+
+	total := 0
+	for ctx.Err() == nil {
+		var batch []models.Message
+		if err := p.db.Where("chatroom_id = ?", chatroom.ID).
+			Where(strings.Join(conditions, " OR "), args...).
+			Preload("User").
+			Preload("Attachments").
+			Order("seq ASC").
+			Limit(p.BatchSize).
+			Find(&batch).Error; err != nil {
+			return total, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		if err := p.purgeBatch(chatroom, batch); err != nil {
+			if errors.Is(err, errHeld) {
+				err = nil
+			}
+			return total, err
+		}
+		total += len(batch)
+		if len(batch) < p.BatchSize {
+			break
+		}
+	}
+	return total, nil
+}
+
+// purgeBatch archives and deletes messages, which must be in seq order.
+// Stored files are removed once the deletion has committed.
+func (p *Purger) purgeBatch(chatroom *models.Chatroom, batch []models.Message) error {
+	if p.ArchiveDir != "" {
+		if err := p.archive(chatroom, batch); err != nil {
+			return err
+		}
+	}
+
+	ids := make([]uint, len(batch))
+	var blobKeys []string
+	for i, message := range batch {
+		ids[i] = message.ID
+		for _, attachment := range message.Attachments {
+			blobKeys = append(blobKeys, attachment.BlobKeys()...)
+		}
+	}
+
+	err := p.db.Transaction(func(tx *gorm.DB) error {
+		// Checking the hold in the same transaction means a hold placed
+		// during a purge takes effect before the next batch
+		result := tx.Model(&models.Chatroom{}).
+			Where("id = ? AND legal_hold = ?", chatroom.ID, false).
+			Update("purged_seq", gorm.Expr("MAX(purged_seq, ?)", batch[len(batch)-1].Seq))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errHeld
+		}
+		if err := tx.Where("message_id IN ?", ids).Delete(&models.Attachment{}).Error; err != nil {
+			return err
+		}
+
+		// Copies of the text expire with it. Held messages still waiting for
+		// review are kept until a moderator decides on them.
+		if err := tx.Model(&models.Report{}).Where("message_id IN ?", ids).Update("message_content", "").Error; err != nil {
+			return err
+		}
+		if err := webhooks.RedactMessages(tx, ids); err != nil {
+			return err
+		}
+		if err := tx.Where("chatroom_id = ? AND status <> ? AND created_at <= ?", chatroom.ID, models.HeldPending, batch[len(batch)-1].CreatedAt).
+			Delete(&models.HeldMessage{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id IN ?", ids).Delete(&models.Message{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	if p.OnPurge != nil {
+		p.OnPurge(chatroom.ID, ids)
+	}
+
+	if p.blobs != nil {
+		for _, key := range blobKeys {
+			if err := p.blobs.Delete(context.Background(), key); err != nil {
+				log.Printf("Failed to delete blob %s: %v", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// archive appends messages to the room's archive file, one JSON object
+// per line in the export format. The file is synced before anything is
+// deleted; a purge interrupted after that may archive a batch twice.
+func (p *Purger) archive(chatroom *models.Chatroom, batch []models.Message) error {
+	if err := os.MkdirAll(p.ArchiveDir, 0o750); err != nil {
+		return err
+	}
+	name := filepath.Join(p.ArchiveDir, fmt.Sprintf("%d-%s.jsonl", chatroom.ID, chatroom.Code))
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for i := range batch {
+		if err := encoder.Encode(export.NewMessage(&batch[i])); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,233 @@
+package retention_test
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeffasante/chatroom.go/handlers"
+	"github.com/jeffasante/chatroom.go/internal/chattest"
+	"github.com/jeffasante/chatroom.go/models"
+	"github.com/jeffasante/chatroom.go/retention"
+	"github.com/jeffasante/chatroom.go/webhooks"
+)
+
+// setServerLimits changes the server-wide retention limits for one test
+func setServerLimits(t *testing.T, days, messages int) {
+	t.Helper()
+	oldDays, oldMessages := retention.Days, retention.Messages
+	t.Cleanup(func() { retention.Days, retention.Messages = oldDays, oldMessages })
+	retention.Days, retention.Messages = days, messages
+}
+
+// newRoom creates a room owned by alice with messages sent the given
+// number of days ago, oldest first
+func newRoom(t *testing.T, srv *chattest.Server, alice *chattest.User, name string, ages ...int) (*models.Chatroom, []uint) {
+	t.Helper()
+	chatroom := &models.Chatroom{}
+	srv.DB.Where("code = ?", srv.CreateRoom(t, alice, name, "secret")).First(chatroom)
+
+	var ids []uint
+	for i, age := range ages {
+		message := models.Message{
+			Content:    "message",
+			UserID:     alice.ID,
+			ChatroomID: chatroom.ID,
+			Seq:        uint64(i + 1),
+			CreatedAt:  time.Now().AddDate(0, 0, -age),
+		}
+		if err := srv.DB.Create(&message).Error; err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, message.ID)
+	}
+	return chatroom, ids
+}
+
+// remaining lists the IDs of a room's messages
+func remaining(t *testing.T, srv *chattest.Server, chatroom *models.Chatroom) []uint {
+	t.Helper()
+	ids := []uint{}
+	srv.DB.Model(&models.Message{}).Where("chatroom_id = ?", chatroom.ID).Order("seq").Pluck("id", &ids)
+	return ids
+}
+
+func TestPolicy(t *testing.T) {
+	tests := []struct {
+		roomDays, roomMessages     int
+		serverDays, serverMessages int
+		wantDays, wantMessages     int
+	}{
+		{0, 0, 0, 0, 0, 0},
+		{30, 100, 0, 0, 30, 100},
+		{0, 0, 90, 1000, 90, 1000},
+		{30, 5000, 90, 1000, 30, 1000},
+		{365, 0, 90, 0, 90, 0},
+	}
+	for _, tt := range tests {
+		setServerLimits(t, tt.serverDays, tt.serverMessages)
+		days, messages := retention.Policy(&models.Chatroom{RetentionDays: tt.roomDays, RetentionMessages: tt.roomMessages})
+		if days != tt.wantDays || messages != tt.wantMessages {
+			t.Errorf("room %d days %d messages, server %d days %d messages: got %d, %d, want %d, %d",
+				tt.roomDays, tt.roomMessages, tt.serverDays, tt.serverMessages, days, messages, tt.wantDays, tt.wantMessages)
+		}
+	}
+}
+
+func TestPurgeLimits(t *testing.T) {
+	tests := []struct {
+		name                       string
+		roomDays, roomMessages     int
+		serverDays, serverMessages int
+		legalHold                  bool
+		want                       []int // indexes of the messages kept
+	}{
+		{"no limits", 0, 0, 0, 0, false, []int{0, 1, 2, 3, 4}},
+		{"room days", 7, 0, 0, 0, false, []int{2, 3, 4}},
+		{"room messages", 0, 2, 0, 0, false, []int{3, 4}},
+		{"days or messages", 7, 2, 0, 0, false, []int{3, 4}},
+		{"server default", 0, 0, 7, 0, false, []int{2, 3, 4}},
+		{"room stricter than server", 2, 0, 7, 0, false, []int{4}},
+		{"server stricter than room", 30, 4, 7, 2, false, []int{3, 4}},
+		{"legal hold", 1, 1, 1, 1, true, []int{0, 1, 2, 3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setServerLimits(t, tt.serverDays, tt.serverMessages)
+			srv := chattest.New(t)
+			alice := srv.SignUp(t, "alice")
+			chatroom, ids := newRoom(t, srv, alice, "general", 30, 10, 5, 3, 0)
+			srv.DB.Model(chatroom).Updates(map[string]interface{}{
+				"retention_days":     tt.roomDays,
+				"retention_messages": tt.roomMessages,
+				"legal_hold":         tt.legalHold,
+			})
+
+			purger := retention.NewPurger(srv.DB, nil)
+			n, err := purger.PurgeAll(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want []uint
+			for _, i := range tt.want {
+				want = append(want, ids[i])
+			}
+			if got := remaining(t, srv, chatroom); !reflect.DeepEqual(got, want) || n != len(ids)-len(want) {
+				t.Errorf("purged %d, kept %v, want %v", n, got, want)
+			}
+		})
+	}
+}
+
+func TestPurgeBatches(t *testing.T) {
+	srv := chattest.New(t)
+	alice := srv.SignUp(t, "alice")
+	chatroom, ids := newRoom(t, srv, alice, "general", 9, 8, 7, 6, 5, 0)
+	srv.DB.Model(chatroom).Update("retention_days", 1)
+	chatroom.RetentionDays = 1
+
+	var batches [][]uint
+	purger := retention.NewPurger(srv.DB, nil)
+	purger.BatchSize = 2
+	purger.OnPurge = func(chatroomID uint, messageIDs []uint) {
+		batches = append(batches, messageIDs)
+	}
+	if n, err := purger.PurgeRoom(context.Background(), chatroom); err != nil || n != 5 {
+		t.Fatalf("purged %d messages: %v", n, err)
+	}
+	if want := [][]uint{{ids[0], ids[1]}, {ids[2], ids[3]}, {ids[4]}}; !reflect.DeepEqual(batches, want) {
+		t.Errorf("batches %v, want %v", batches, want)
+	}
+}
+
+func TestPurgedSeqKeepsSequenceMonotonic(t *testing.T) {
+	srv := chattest.New(t)
+	alice := srv.SignUp(t, "alice")
+	chatroom, _ := newRoom(t, srv, alice, "general", 5, 4, 3)
+	srv.DB.Model(chatroom).Update("retention_days", 1)
+	chatroom.RetentionDays = 1
+
+	purger := retention.NewPurger(srv.DB, nil)
+	if n, err := purger.PurgeRoom(context.Background(), chatroom); err != nil || n != 3 {
+		t.Fatalf("purged %d messages: %v", n, err)
+	}
+	if last, err := models.LastMessageSeq(srv.DB, chatroom.ID); err != nil || last != 3 {
+		t.Errorf("last seq %d after purging every message, want 3 (%v)", last, err)
+	}
+
+	// The next message carries on from the purged ones
+	conn := srv.Connect(t, alice, chatroom.Code)
+	conn.Send(map[string]string{"type": "message", "content": "after the purge", "nonce": "next"})
+	if frame := conn.Await(func(f chattest.Frame) bool { return f.Type == "message" && f.Nonce == "next" }); frame.Seq != 4 {
+		t.Errorf("next message has seq %d, want 4", frame.Seq)
+	}
+
+	// A purge of older messages never moves purged_seq back
+	srv.DB.Create(&models.Message{Content: "late import", UserID: alice.ID, ChatroomID: chatroom.ID, Seq: 2, CreatedAt: time.Now().AddDate(0, 0, -5)})
+	if _, err := purger.PurgeRoom(context.Background(), chatroom); err != nil {
+		t.Fatal(err)
+	}
+	srv.DB.First(chatroom, chatroom.ID)
+	if chatroom.PurgedSeq != 3 {
+		t.Errorf("purged_seq %d, want 3", chatroom.PurgedSeq)
+	}
+}
+
+func TestPurgeRemovesCopies(t *testing.T) {
+	srv := chattest.New(t)
+	alice := srv.SignUp(t, "alice")
+	code := srv.CreateRoom(t, alice, "general", "secret")
+	conn := srv.Connect(t, alice, code)
+
+	var chatroom models.Chatroom
+	srv.DB.Where("code = ?", code).First(&chatroom)
+	srv.DB.Model(&chatroom).Update("retention_days", 1)
+
+	old := time.Now().AddDate(0, 0, -2)
+	expired := models.Message{Content: "expired", UserID: alice.ID, ChatroomID: chatroom.ID, Seq: 1, CreatedAt: old}
+	kept := models.Message{Content: "kept", UserID: alice.ID, ChatroomID: chatroom.ID, Seq: 2}
+	srv.DB.Create(&expired)
+	srv.DB.Create(&kept)
+
+	report := models.Report{ChatroomID: chatroom.ID, ReporterID: alice.ID, TargetUserID: alice.ID, MessageID: expired.ID, Reason: "spam", MessageContent: expired.Content}
+	srv.DB.Create(&report)
+	rejected := models.HeldMessage{ChatroomID: chatroom.ID, UserID: alice.ID, Content: "rejected", Status: models.HeldRejected, CreatedAt: old}
+	pending := models.HeldMessage{ChatroomID: chatroom.ID, UserID: alice.ID, Content: "pending", Status: models.HeldPending, CreatedAt: old}
+	srv.DB.Create(&rejected)
+	srv.DB.Create(&pending)
+
+	srv.DB.Create(&models.Webhook{ChatroomID: chatroom.ID, URL: "https://example.com/hook", Secret: "s3cret", Events: "message", Active: true})
+	dispatcher := webhooks.NewDispatcher(srv.DB)
+	for _, message := range []models.Message{expired, kept} {
+		dispatcher.Publish(chatroom.ID, webhooks.EventMessage, &handlers.Message{Type: "message", Content: message.Content, MessageID: message.ID})
+	}
+
+	purger := retention.NewPurger(srv.DB, nil)
+	purger.OnPurge = srv.Hub.AnnouncePurge
+	if n, err := purger.PurgeRoom(context.Background(), &chatroom); err != nil || n != 1 {
+		t.Fatalf("purged %d messages: %v", n, err)
+	}
+
+	frame := conn.Await(chattest.OfType("messages_purged"))
+	if len(frame.MessageIDs) != 1 || frame.MessageIDs[0] != expired.ID {
+		t.Errorf("messages_purged lists %v, want [%d]", frame.MessageIDs, expired.ID)
+	}
+
+	srv.DB.First(&report, report.ID)
+	if report.MessageContent != "" {
+		t.Errorf("report still has %q", report.MessageContent)
+	}
+	var contents []string
+	srv.DB.Model(&models.HeldMessage{}).Pluck("content", &contents)
+	if len(contents) != 1 || contents[0] != "pending" {
+		t.Errorf("held messages left: %v, want only the pending one", contents)
+	}
+
+	var deliveries []models.WebhookDelivery
+	srv.DB.Order("id").Find(&deliveries)
+	if len(deliveries) != 2 || strings.Contains(deliveries[0].Payload, "expired") || !strings.Contains(deliveries[1].Payload, `"content":"kept"`) {
+		t.Errorf("webhook deliveries %+v, want only the kept message's text", deliveries)
+	}
+}
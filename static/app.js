@@ -98,9 +98,19 @@ let socket = null;
 let roomCode = null;
 let reconnectAttempts = 0;
 const maxReconnectAttempts = 5;
+let serverDisconnected = false;
 let displayedMessages = new Set(); // Track displayed messages to prevent duplicates
+const pendingMessages = new Map(); // nonce -> {content, element, timer} awaiting an ack
+const ackTimeout = 10000;
+let lastSeq = 0; // highest room sequence number we have shown
+let fetchingGap = false;
+let oldestSeq = 0; // cursor for loading older history
+let hasMoreHistory = false;
+let loadingHistory = false;
 
 function initializeChat(code) {
+    // room.html also calls this on load, so only connect once
+    if (roomCode) return;
     roomCode = code;
     connectWebSocket();
     
@@ -112,12 +122,24 @@ function initializeChat(code) {
                 sendMessage();
             }
         });
+        messageInput.addEventListener('input', function() {
+            if (messageInput.value.trim() === '') {
+                stopTyping();
+            } else {
+                handleTyping();
+            }
+        });
     }
 }
 
 function connectWebSocket() {
     const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-    const wsUrl = `${protocol}//${window.location.host}/ws/${roomCode}`;
+    let wsUrl = `${protocol}//${window.location.host}/ws/${roomCode}`;
+    
+    // Ask the server to replay anything we missed while disconnected
+    if (latestMessageId > 0) {
+        wsUrl += `?last_message_id=${latestMessageId}`;
+    }
     
     console.log('Attempting to connect to:', wsUrl);
     updateConnectionStatus('Connecting...', 'warning');
@@ -128,11 +150,67 @@ function connectWebSocket() {
         console.log('Connected to chat');
         reconnectAttempts = 0;
         updateConnectionStatus('Connected', 'success');
+        scheduleMarkRead();
+        sendPresence();
+        loadPresence();
+        resendPending();
     };
     
     socket.onmessage = function(event) {
         try {
             const message = JSON.parse(event.data);
+            if (message.type === 'typing_start' || message.type === 'typing_stop') {
+                updateTyping(message);
+                return;
+            }
+            if (message.type === 'read_receipt') {
+                showReadReceipt(message);
+                return;
+            }
+            if (message.type === 'presence') {
+                updatePresence(message);
+                return;
+            }
+            if (message.type === 'replay_complete') {
+                return;
+            }
+            if (message.type === 'ack') {
+                handleAck(message);
+                return;
+            }
+            if (message.type === 'error') {
+                handleError(message);
+                return;
+            }
+            if (message.type === 'held') {
+                handleHeld(message);
+                return;
+            }
+            if (message.type === 'message_deleted') {
+                removeMessage(message.message_id);
+                return;
+            }
+            if (message.type === 'messages_purged') {
+                message.message_ids.forEach(removeMessage);
+                return;
+            }
+            if (message.type === 'message_unfurled') {
+                showPreviews(message);
+                return;
+            }
+            if (message.type === 'resync_required') {
+                // Too far behind to replay, so reload the page to catch up
+                serverDisconnected = true;
+                socket.close();
+                window.location.reload();
+                return;
+            }
+            if (message.type === 'disconnected') {
+                serverDisconnected = true;
+            }
+            if (message.seq) {
+                checkSeq(message.seq);
+            }
             displayMessage(message);
         } catch (error) {
             console.error('Error parsing message:', error);
@@ -143,6 +221,19 @@ function connectWebSocket() {
         console.log('Disconnected from chat', event);
         updateConnectionStatus('Disconnected', 'error');
         
+        // The server closed us on purpose (e.g. kicked), so don't reconnect
+        if (serverDisconnected) {
+            return;
+        }
+        
+        // 1009: a frame was over the server's size limit. Don't resend the
+        // pending messages that caused it when reconnecting.
+        if (event.code === 1009) {
+            pendingMessages.forEach(function(pending, nonce) {
+                markFailed(nonce, 'message too large');
+            });
+        }
+        
         // Attempt to reconnect
         if (reconnectAttempts < maxReconnectAttempts) {
             reconnectAttempts++;
@@ -175,28 +266,217 @@ function sendMessage() {
     const input = document.getElementById('messageInput');
     const content = input.value.trim();
     
-    if (content && socket && socket.readyState === WebSocket.OPEN) {
-        const message = {
-            type: 'message',
-            content: content
-        };
-        
-        socket.send(JSON.stringify(message));
-        input.value = '';
-        
-        // Don't display the message here - let the WebSocket broadcast handle it
-        // This prevents duplicate messages
-    } else if (!socket || socket.readyState !== WebSocket.OPEN) {
-        showConnectionStatus('Not connected', 'error');
+    if (!content) return;
+    
+    // Commands aren't stored, so they get no nonce or pending bubble
+    if (content.startsWith('/')) {
+        if (socket && socket.readyState === WebSocket.OPEN) {
+            socket.send(JSON.stringify({type: 'message', content: content}));
+            input.value = '';
+        } else {
+            showConnectionStatus('Not connected', 'error');
+        }
+        return;
+    }
+    
+    // Show the message straight away; it is confirmed when the server
+    // echoes or acknowledges it, and resent with the same nonce otherwise
+    const nonce = generateNonce();
+    pendingMessages.set(nonce, {
+        content: content,
+        element: displayPending(nonce, content),
+        timer: null
+    });
+    input.value = '';
+    
+    // The server clears our indicator when the message arrives
+    clearTimeout(typingTimer);
+    lastTypingSent = 0;
+    
+    if (!transmitPending(nonce)) {
+        showConnectionStatus('Not connected, will send when reconnected', 'warning');
+    }
+}
+
+function generateNonce() {
+    if (window.crypto && crypto.randomUUID) {
+        return crypto.randomUUID();
+    }
+    return `${Date.now().toString(36)}-${Math.random().toString(36).slice(2)}`;
+}
+
+function transmitPending(nonce) {
+    const pending = pendingMessages.get(nonce);
+    if (!pending || !socket || socket.readyState !== WebSocket.OPEN) {
+        return false;
+    }
+    
+    socket.send(JSON.stringify({
+        type: 'message',
+        content: pending.content,
+        nonce: nonce
+    }));
+    
+    clearTimeout(pending.timer);
+    pending.timer = setTimeout(function() {
+        transmitPending(nonce);
+    }, ackTimeout);
+    return true;
+}
+
+function resendPending() {
+    pendingMessages.forEach(function(pending, nonce) {
+        if (!pending.element.classList.contains('failed')) {
+            transmitPending(nonce);
+        }
+    });
+}
+
+function displayPending(nonce, content) {
+    const messagesContainer = document.getElementById('messages');
+    const messageElement = document.createElement('div');
+    messageElement.className = 'message pending';
+    messageElement.setAttribute('data-nonce', nonce);
+    messageElement.innerHTML = `
+        <div class="message-header">
+            You
+            <span class="message-time">sending...</span>
+        </div>
+        <div class="message-content">${escapeHtml(content)}</div>
+    `;
+    if (messagesContainer) {
+        messagesContainer.appendChild(messageElement);
+        messagesContainer.scrollTop = messagesContainer.scrollHeight;
+    }
+    return messageElement;
+}
+
+// confirmPending turns a pending bubble into the stored message. Returns
+// false if the nonce isn't one of ours.
+function confirmPending(message) {
+    const pending = pendingMessages.get(message.nonce);
+    if (!pending) return false;
+    
+    clearTimeout(pending.timer);
+    pendingMessages.delete(message.nonce);
+    
+    const messageId = String(message.message_id);
+    if (displayedMessages.has(messageId)) {
+        // Already shown, e.g. replayed after a reconnect
+        pending.element.remove();
+        return true;
+    }
+    displayedMessages.add(messageId);
+    noteMessageId(message.message_id);
+    noteSeq(message.seq);
+    
+    pending.element.classList.remove('pending', 'held');
+    pending.element.setAttribute('data-message-id', messageId);
+    if (message.seq) {
+        pending.element.setAttribute('data-seq', message.seq);
+    }
+    const content = pending.element.querySelector('.message-content');
+    if (content && message.html) {
+        content.innerHTML = message.html;
+    }
+    const time = pending.element.querySelector('.message-time');
+    if (time) {
+        const timestamp = new Date(message.timestamp);
+        time.textContent = timestamp.toLocaleTimeString([], {hour: '2-digit', minute:'2-digit'});
+    }
+    return true;
+}
+
+function handleAck(ack) {
+    if (!ack.error) {
+        confirmPending(ack);
+        return;
+    }
+    
+    markFailed(ack.nonce, ack.error);
+}
+
+// markFailed shows a pending message as not sent, clicking it retries.
+// Returns false if the nonce isn't one of ours.
+function markFailed(nonce, reason) {
+    const pending = pendingMessages.get(nonce);
+    if (!pending) return false;
+    
+    clearTimeout(pending.timer);
+    pending.element.classList.remove('pending', 'held');
+    pending.element.classList.add('failed');
+    pending.element.title = `Not sent: ${reason}. Click to retry.`;
+    const time = pending.element.querySelector('.message-time');
+    if (time) {
+        time.textContent = 'failed, click to retry';
+    }
+    pending.element.onclick = function() {
+        pending.element.onclick = null;
+        pending.element.classList.remove('failed');
+        pending.element.classList.add('pending');
+        if (time) {
+            time.textContent = 'sending...';
+        }
+        transmitPending(nonce);
+    };
+    return true;
+}
+
+// handleError shows why the server refused something we sent
+function handleError(frame) {
+    if (frame.error === 'message_too_long' && frame.limit) {
+        const input = document.getElementById('messageInput');
+        if (input) input.maxLength = frame.limit;
+    }
+    if (frame.error === 'rate_limited' && frame.nonce && pendingMessages.has(frame.nonce)) {
+        // Keep it pending and send it again once the server allows it
+        const pending = pendingMessages.get(frame.nonce);
+        clearTimeout(pending.timer);
+        pending.element.title = frame.content;
+        pending.timer = setTimeout(function() {
+            transmitPending(frame.nonce);
+        }, (frame.retry_after || 1) * 1000);
+        return;
+    }
+    if (frame.nonce && pendingMessages.has(frame.nonce)) {
+        // Resending the same text would fail again
+        const pending = pendingMessages.get(frame.nonce);
+        markFailed(frame.nonce, frame.content);
+        pending.element.onclick = null;
+        pending.element.title = `Not sent: ${frame.content}`;
+        const time = pending.element.querySelector('.message-time');
+        if (time) time.textContent = 'not sent';
+        return;
+    }
+    displayMessage(Object.assign({}, frame, {type: 'command_response'}));
+}
+
+// handleHeld shows that a message is waiting for a moderator. It stays
+// pending so the approved message, carrying our nonce, confirms it.
+function handleHeld(frame) {
+    const pending = frame.nonce && pendingMessages.get(frame.nonce);
+    if (!pending) {
+        displayMessage(Object.assign({}, frame, {type: 'command_response'}));
+        return;
     }
+    clearTimeout(pending.timer);
+    pending.element.classList.add('held');
+    pending.element.title = frame.content;
+    const time = pending.element.querySelector('.message-time');
+    if (time) time.textContent = 'awaiting approval';
 }
 
 function displayMessage(message) {
     const messagesContainer = document.getElementById('messages');
     if (!messagesContainer) return;
     
+    // Our own message coming back confirms its pending bubble
+    if (message.nonce && message.message_id && confirmPending(message)) {
+        return;
+    }
+    
     // Create a unique identifier for the message to prevent duplicates
-    const messageId = message.message_id || `${message.user_id}-${message.timestamp}-${message.content}`;
+    const messageId = message.message_id ? String(message.message_id) : `${message.user_id}-${message.timestamp}-${message.content}`;
     
     // Check if we've already displayed this message
     if (displayedMessages.has(messageId)) {
@@ -216,8 +496,29 @@ function displayMessage(message) {
     const timeString = timestamp.toLocaleTimeString([], {hour: '2-digit', minute:'2-digit'});
     
     // Handle different message types
-    if (message.type === 'user_joined' || message.type === 'user_left') {
+    if (message.type === 'topic_changed') {
+        const topicElement = document.getElementById('roomTopic');
+        if (topicElement) {
+            topicElement.textContent = message.content;
+        }
+        message = Object.assign({}, message, {
+            content: `${message.username} set the topic to: ${message.content}`
+        });
+    }
+    
+    if (message.type === 'action') {
+        messageElement.classList.add('action-message');
+        messageElement.innerHTML = `
+            <div class="message-header">
+                <span class="message-time">${timeString}</span>
+            </div>
+            <div class="message-content">* ${escapeHtml(message.username)} ${contentHtml(message)}</div>
+        `;
+    } else if (systemMessageTypes.has(message.type)) {
         messageElement.classList.add('system-message');
+        if (message.type === 'command_response') {
+            messageElement.classList.add('command-response');
+        }
         messageElement.innerHTML = `
             <div class="message-header">
                 <span class="system-label">System</span>
@@ -226,21 +527,64 @@ function displayMessage(message) {
             <div class="message-content system-content">${escapeHtml(message.content)}</div>
         `;
     } else {
+        const botTag = message.is_bot ? ' [bot]' : '';
+        const reportButton = message.message_id && String(message.user_id) !== getCurrentUserId()
+            ? `<button class="report-btn" title="report" onclick="reportMessage(${Number(message.message_id)})">⚑</button>`
+            : '';
         messageElement.innerHTML = `
             <div class="message-header">
-                ${escapeHtml(message.username)}
+                ${escapeHtml(message.username)}${botTag}
                 <span class="message-time">${timeString}</span>
+                ${reportButton}
             </div>
-            <div class="message-content">${escapeHtml(message.content)}</div>
+            <div class="message-content">${contentHtml(message)}</div>
+            ${attachmentsHtml(message.attachments)}
+            ${previewsHtml(message.previews)}
         `;
     }
     
-    messagesContainer.appendChild(messageElement);
-    messagesContainer.scrollTop = messagesContainer.scrollHeight;
+    // Stored messages go in sequence order, since a fetched gap arrives
+    // after the live messages that revealed it
+    const next = message.seq ? findMessageAfterSeq(messagesContainer, message.seq) : null;
+    if (next) {
+        messageElement.setAttribute('data-seq', message.seq);
+        messagesContainer.insertBefore(messageElement, next);
+    } else {
+        if (message.seq) {
+            messageElement.setAttribute('data-seq', message.seq);
+        }
+        messagesContainer.appendChild(messageElement);
+        messagesContainer.scrollTop = messagesContainer.scrollHeight;
+    }
+    
+    if (message.message_id) {
+        noteMessageId(message.message_id);
+    }
+    noteSeq(message.seq);
+    
+    // Someone who just sent a message has stopped typing
+    if (typingUsers.delete(message.user_id)) {
+        renderTypingIndicator();
+    }
     
     console.log('Displayed message:', messageId);
 }
 
+// Frames rendered as system notices rather than chat messages
+const systemMessageTypes = new Set([
+    'user_joined',
+    'user_left',
+    'user_kicked',
+    'user_renamed',
+    'role_changed',
+    'topic_changed',
+    'slow_mode_changed',
+    'user_banned',
+    'user_muted',
+    'command_response',
+    'disconnected'
+]);
+
 function showConnectionStatus(status, type) {
     // Remove existing status
     const existingStatus = document.querySelector('.connection-status');
@@ -272,6 +616,12 @@ function showConnectionStatus(status, type) {
     updateConnectionStatus(status, type);
 }
 
+// contentHtml returns a message's Markdown as rendered and sanitized by the
+// server, so every client shows the same thing
+function contentHtml(message) {
+    return message.html || escapeHtml(message.content);
+}
+
 function escapeHtml(text) {
     const div = document.createElement('div');
     div.textContent = text;
@@ -289,6 +639,7 @@ function showSettings() {
 
 async function updateRoom() {
     const newName = document.getElementById('roomNameEdit').value.trim();
+    const maxLength = document.getElementById('roomMaxLengthEdit').value.trim() || '0';
     if (!newName) {
         alert('Room name cannot be empty');
         return;
@@ -306,13 +657,13 @@ async function updateRoom() {
             headers: {
                 'Content-Type': 'application/x-www-form-urlencoded',
             },
-            body: `name=${encodeURIComponent(newName)}`
+            body: `name=${encodeURIComponent(newName)}&max_message_length=${encodeURIComponent(maxLength)}`
         });
         
         const result = await response.json();
         
         if (result.success) {
-            alert('Room name updated successfully!');
+            alert('Room updated successfully!');
             // Update the room title on the page
             const roomTitle = document.querySelector('.room-title');
             if (roomTitle) {
@@ -386,7 +737,8 @@ function updateMembersDisplay(members) {
         memberDiv.style.marginBottom = '5px';
         
         const ownerBadge = member.is_owner ? ' (Owner)' : '';
-        memberDiv.textContent = `${index + 1}: ${member.username}${ownerBadge}`;
+        const status = member.status ? ` - ${member.status}` : '';
+        memberDiv.textContent = `${index + 1}: ${member.username}${ownerBadge}${status}`;
         
         if (member.is_owner) {
             memberDiv.style.fontWeight = 'bold';
@@ -396,6 +748,78 @@ function updateMembersDisplay(members) {
     });
 }
 
+// removeMessage takes a message a moderator deleted off the page
+function removeMessage(messageId) {
+    const element = document.querySelector(`.message[data-message-id="${Number(messageId)}"]`);
+    if (element) {
+        element.remove();
+    }
+}
+
+async function reportMessage(messageId) {
+    const reason = prompt('Report this message for: spam, harassment, hate, sexual, violence or other', 'spam');
+    if (!reason) return;
+    const details = prompt('Anything the moderators should know? (optional)') || '';
+    
+    try {
+        const response = await fetch(`/api/room/${getRoomCodeFromUrl()}/reports`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/x-www-form-urlencoded',
+            },
+            body: `message_id=${messageId}&reason=${encodeURIComponent(reason.trim().toLowerCase())}&details=${encodeURIComponent(details)}`
+        });
+        const result = await response.json();
+        alert(result.success ? 'Thanks, the moderators will take a look.' : 'Error: ' + result.error);
+    } catch (error) {
+        alert('Network error occurred');
+    }
+}
+
+// resolveReport acts on a report from the moderation queue page
+async function resolveReport(reportId, action) {
+    if (action === 'ban' && !confirm('Ban this user from the room?')) {
+        return;
+    }
+    const item = document.querySelector(`.report[data-report-id="${reportId}"]`);
+    const note = item ? item.querySelector('.report-note').value : '';
+    
+    try {
+        const response = await fetch(`/api/room/${getRoomCodeFromUrl()}/reports/${reportId}/resolve`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/x-www-form-urlencoded',
+            },
+            body: `action=${action}&note=${encodeURIComponent(note)}`
+        });
+        const result = await response.json();
+        if (result.success) {
+            location.reload();
+        } else {
+            alert('Error: ' + result.error);
+        }
+    } catch (error) {
+        alert('Network error occurred');
+    }
+}
+
+// reviewHeld approves or rejects a held message from the moderation queue page
+async function reviewHeld(heldId, decision) {
+    try {
+        const response = await fetch(`/api/room/${getRoomCodeFromUrl()}/moderation/queue/${heldId}/${decision}`, {
+            method: 'POST'
+        });
+        const result = await response.json();
+        if (result.success) {
+            location.reload();
+        } else {
+            alert('Error: ' + result.error);
+        }
+    } catch (error) {
+        alert('Network error occurred');
+    }
+}
+
 function getRoomCodeFromUrl() {
     const pathParts = window.location.pathname.split('/');
     if (pathParts[1] === 'room' && pathParts[2]) {
@@ -410,16 +834,31 @@ document.addEventListener('DOMContentLoaded', function() {
     if (messagesContainer) {
         // Track existing messages on page load to prevent duplicates
         const existingMessages = messagesContainer.querySelectorAll('.message');
-        existingMessages.forEach((msgElement, index) => {
-            // Create a unique ID for existing messages based on their content and position
-            const content = msgElement.querySelector('.message-content')?.textContent || '';
-            const username = msgElement.querySelector('.message-header')?.textContent || '';
-            const uniqueId = `existing-${index}-${content.substring(0, 20)}`;
-            displayedMessages.add(uniqueId);
-            msgElement.setAttribute('data-message-id', uniqueId);
+        existingMessages.forEach((msgElement) => {
+            // Server-rendered messages carry their database ID
+            const messageId = msgElement.dataset.messageId;
+            if (messageId) {
+                displayedMessages.add(messageId);
+                noteMessageId(Number(messageId));
+            }
+            const seq = Number(msgElement.dataset.seq);
+            if (seq && (!oldestSeq || seq < oldestSeq)) {
+                oldestSeq = seq;
+            }
+            noteSeq(seq);
         });
         
-        messagesContainer.scrollTop = messagesContainer.scrollHeight;
+        // Opened from a search result: show that message instead of the end
+        if (!focusMessage(messagesContainer.dataset.focusId)) {
+            messagesContainer.scrollTop = messagesContainer.scrollHeight;
+        }
+        
+        hasMoreHistory = messagesContainer.dataset.hasMore === 'true';
+        messagesContainer.addEventListener('scroll', function() {
+            if (messagesContainer.scrollTop < 50) {
+                loadOlderMessages();
+            }
+        });
         
         // Get room code from URL and initialize chat
         const pathParts = window.location.pathname.split('/');
@@ -427,34 +866,432 @@ document.addEventListener('DOMContentLoaded', function() {
             initializeChat(pathParts[2]);
         }
     }
+    
+    const fileInput = document.getElementById('fileInput');
+    if (fileInput) {
+        fileInput.addEventListener('change', uploadFile);
+    }
+    
+    const searchInput = document.getElementById('searchInput');
+    if (searchInput) {
+        searchInput.addEventListener('input', scheduleSearch);
+        document.getElementById('searchAllRooms').addEventListener('change', scheduleSearch);
+    }
 });
 
-// Online users functionality (for future enhancement)
+// Attachments
+function attachmentsHtml(attachments) {
+    if (!attachments) return '';
+    return attachments.map(a => {
+        const url = escapeHtml(a.url);
+        if (a.thumbnail_url) {
+            // Sized up front so the message list doesn't jump as it loads
+            return `<div class="attachment"><a href="${url}" target="_blank"><img class="attachment-image" src="${escapeHtml(a.thumbnail_url)}" width="${a.thumbnail_width}" height="${a.thumbnail_height}" alt="${escapeHtml(a.filename)}" title="${escapeHtml(a.filename)} (${a.width}×${a.height})"></a></div>`;
+        }
+        if (a.content_type.startsWith('image/')) {
+            return `<div class="attachment"><a href="${url}" target="_blank"><img class="attachment-image" src="${url}" alt="${escapeHtml(a.filename)}"></a></div>`;
+        }
+        return `<div class="attachment"><a class="attachment-file" href="${url}">📎 ${escapeHtml(a.filename)} (${formatSize(a.size)})</a></div>`;
+    }).join('');
+}
+
+// Link previews. Only text is shown: loading the page's image would tell
+// the linked site who is reading the room.
+function previewsHtml(previews) {
+    if (!previews || previews.length === 0) return '';
+    return `<div class="link-previews">${previews.map(p => `
+        <a class="link-preview" href="${escapeHtml(p.url)}" target="_blank" rel="noopener noreferrer">
+            <span class="link-preview-site">${escapeHtml(p.site_name || '')}</span>
+            <span class="link-preview-title">${escapeHtml(p.title)}</span>
+            ${p.description ? `<span class="link-preview-description">${escapeHtml(p.description)}</span>` : ''}
+        </a>`).join('')}</div>`;
+}
+
+function showPreviews(message) {
+    const messageElement = document.querySelector(`#messages .message[data-message-id="${message.message_id}"]`);
+    if (!messageElement) return;
+    const existing = messageElement.querySelector('.link-previews');
+    if (existing) existing.remove();
+    messageElement.insertAdjacentHTML('beforeend', previewsHtml(message.previews));
+}
+
+function formatSize(bytes) {
+    if (bytes < 1024) return `${bytes} B`;
+    if (bytes < 1024 * 1024) return `${(bytes / 1024).toFixed(1)} KB`;
+    return `${(bytes / 1024 / 1024).toFixed(1)} MB`;
+}
+
+// uploadFile posts the chosen file with whatever is typed as its caption;
+// the message itself arrives over the WebSocket like any other
+async function uploadFile() {
+    const fileInput = document.getElementById('fileInput');
+    const input = document.getElementById('messageInput');
+    const file = fileInput.files[0];
+    if (!file) return;
+    
+    const form = new FormData();
+    form.append('file', file);
+    form.append('content', input.value.trim());
+    
+    showConnectionStatus(`Uploading ${file.name}...`, 'warning');
+    try {
+        const response = await fetch(`/api/room/${roomCode}/attachments`, {
+            method: 'POST',
+            body: form
+        });
+        const data = await response.json();
+        if (!response.ok) {
+            showConnectionStatus(data.error || 'Upload failed', 'error');
+            return;
+        }
+        input.value = '';
+        showConnectionStatus('Uploaded', 'success');
+    } catch (error) {
+        console.error('Error uploading file:', error);
+        showConnectionStatus('Upload failed', 'error');
+    } finally {
+        fileInput.value = '';
+    }
+}
+
+// Search
+let searchTimer;
+
+function scheduleSearch() {
+    clearTimeout(searchTimer);
+    searchTimer = setTimeout(runSearch, 300);
+}
+
+async function runSearch() {
+    const query = document.getElementById('searchInput').value.trim();
+    const resultsElement = document.getElementById('searchResults');
+    if (!query) {
+        resultsElement.innerHTML = '';
+        return;
+    }
+    
+    const allRooms = document.getElementById('searchAllRooms').checked;
+    const url = allRooms
+        ? `/api/search?q=${encodeURIComponent(query)}`
+        : `/api/room/${roomCode}/search?q=${encodeURIComponent(query)}`;
+    
+    try {
+        const response = await fetch(url);
+        const data = await response.json();
+        if (!response.ok) {
+            resultsElement.textContent = data.error || 'Search failed';
+            return;
+        }
+        renderSearchResults(data, allRooms);
+    } catch (error) {
+        console.error('Error searching:', error);
+    }
+}
+
+function renderSearchResults(data, allRooms) {
+    const resultsElement = document.getElementById('searchResults');
+    if (data.results.length === 0) {
+        resultsElement.textContent = 'No matches';
+        return;
+    }
+    
+    const [start, end] = data.highlight;
+    resultsElement.innerHTML = data.results.map(result => {
+        const snippet = escapeHtml(result.snippet).split(start).join('<mark>').split(end).join('</mark>');
+        const time = new Date(result.created_at).toLocaleString([], {month: 'short', day: 'numeric', hour: '2-digit', minute: '2-digit'});
+        const room = allRooms ? ` in ${escapeHtml(result.room_name)}` : '';
+        return `
+            <a class="search-result" href="${result.context_url}" data-room="${escapeHtml(result.room_code)}" data-message-id="${result.message_id}">
+                <div class="message-header">${escapeHtml(result.username)}${room} <span class="message-time">${time}</span></div>
+                <div>${snippet}</div>
+            </a>
+        `;
+    }).join('');
+    
+    // Results already on screen are scrolled to rather than reloaded
+    resultsElement.querySelectorAll('.search-result').forEach(link => {
+        link.addEventListener('click', function(e) {
+            if (link.dataset.room === roomCode && focusMessage(link.dataset.messageId)) {
+                e.preventDefault();
+            }
+        });
+    });
+}
+
+function focusMessage(messageId) {
+    if (!messageId || messageId === '0') return false;
+    const element = document.querySelector(`#messages .message[data-message-id="${messageId}"]`);
+    if (!element) return false;
+    
+    document.querySelectorAll('#messages .message.focused').forEach(el => el.classList.remove('focused'));
+    element.classList.add('focused');
+    element.scrollIntoView({block: 'center'});
+    return true;
+}
+
+// Online users
+const roomPresence = new Map(); // user_id -> {username, status}
+
 function updateOnlineUsers(users) {
     const onlineList = document.getElementById('onlineUsers');
     if (onlineList && users) {
         onlineList.innerHTML = users.map(user => 
-            `<div class="online-user">${escapeHtml(user.username)}</div>`
+            `<div class="online-user presence-${escapeHtml(user.status)}">${escapeHtml(user.username)}${user.status === 'away' ? ' (away)' : ''}</div>`
         ).join('');
     }
 }
 
-// Typing indicator functionality (for future enhancement)
+function renderPresence() {
+    const users = Array.from(roomPresence.values())
+        .filter(user => user.status !== 'offline')
+        .sort((a, b) => a.username.localeCompare(b.username));
+    updateOnlineUsers(users);
+}
+
+async function loadPresence() {
+    if (!roomCode) return;
+    
+    try {
+        const response = await fetch(`/api/room/${roomCode}/presence`);
+        const result = await response.json();
+        
+        if (result.presence) {
+            roomPresence.clear();
+            result.presence.forEach(user => {
+                roomPresence.set(user.id, {username: user.username, status: user.status});
+            });
+            renderPresence();
+        }
+    } catch (error) {
+        console.error('Error loading presence:', error);
+    }
+}
+
+function updatePresence(message) {
+    roomPresence.set(message.user_id, {username: message.username, status: message.content});
+    renderPresence();
+}
+
+// Report away while the tab is hidden
+function sendPresence() {
+    if (socket && socket.readyState === WebSocket.OPEN) {
+        socket.send(JSON.stringify({
+            type: 'presence',
+            content: document.visibilityState === 'visible' ? 'online' : 'away'
+        }));
+    }
+}
+
+document.addEventListener('visibilitychange', sendPresence);
+
+// Typing indicators
 let typingTimer;
+let lastTypingSent = 0;
+const typingRefreshInterval = 2000; // the server expires indicators after 6s without a refresh
+const typingIdleTimeout = 3000;
+const typingUsers = new Map(); // user_id -> username
+
 function handleTyping() {
     if (socket && socket.readyState === WebSocket.OPEN) {
         clearTimeout(typingTimer);
         
-        // Send typing start
+        // Refresh typing start at most every couple of seconds
+        const now = Date.now();
+        if (now - lastTypingSent > typingRefreshInterval) {
+            lastTypingSent = now;
+            socket.send(JSON.stringify({
+                type: 'typing_start'
+            }));
+        }
+        
+        // Stop once the user pauses
+        typingTimer = setTimeout(stopTyping, typingIdleTimeout);
+    }
+}
+
+function stopTyping() {
+    clearTimeout(typingTimer);
+    if (lastTypingSent === 0) return;
+    lastTypingSent = 0;
+    
+    if (socket && socket.readyState === WebSocket.OPEN) {
         socket.send(JSON.stringify({
-            type: 'typing_start'
+            type: 'typing_stop'
         }));
+    }
+}
+
+function updateTyping(message) {
+    // Never show our own indicator
+    if (String(message.user_id) === getCurrentUserId()) return;
+    
+    if (message.type === 'typing_start') {
+        typingUsers.set(message.user_id, message.username);
+    } else {
+        typingUsers.delete(message.user_id);
+    }
+    renderTypingIndicator();
+}
+
+function renderTypingIndicator() {
+    const indicator = document.getElementById('typingIndicator');
+    if (!indicator) return;
+    
+    const names = Array.from(typingUsers.values());
+    if (names.length === 0) {
+        indicator.textContent = '';
+    } else if (names.length === 1) {
+        indicator.textContent = `${names[0]} is typing...`;
+    } else if (names.length <= 3) {
+        indicator.textContent = `${names.join(', ')} are typing...`;
+    } else {
+        indicator.textContent = 'Several people are typing...';
+    }
+}
+
+// Sequence numbers
+function noteSeq(seq) {
+    if (seq && seq > lastSeq) {
+        lastSeq = seq;
+    }
+}
+
+// checkSeq fetches any messages skipped between the last one we showed and
+// a newly arrived one
+function checkSeq(seq) {
+    if (lastSeq > 0 && seq > lastSeq + 1 && !fetchingGap) {
+        fetchMissed(lastSeq);
+    }
+}
+
+async function fetchMissed(afterSeq) {
+    fetchingGap = true;
+    try {
+        const response = await fetch(`/api/messages/${roomCode}?after_seq=${afterSeq}&limit=100`);
+        if (!response.ok) return;
         
-        // Clear typing after 2 seconds
-        typingTimer = setTimeout(() => {
-            socket.send(JSON.stringify({
-                type: 'typing_stop'
-            }));
-        }, 2000);
+        const data = await response.json();
+        data.messages.forEach(m => displayMessage(frameFromStored(m)));
+    } catch (error) {
+        console.error('Error fetching missed messages:', error);
+    } finally {
+        fetchingGap = false;
     }
-}
\ No newline at end of file
+}
+
+// frameFromStored converts a message from the history API to the shape of
+// a WebSocket frame
+function frameFromStored(m) {
+    return {
+        type: m.type,
+        content: m.content,
+        user_id: m.user_id,
+        username: m.User.username,
+        chatroom_id: m.chatroom_id,
+        timestamp: m.created_at,
+        message_id: m.id,
+        seq: m.seq,
+        is_bot: m.User.is_bot,
+        attachments: (m.attachments || []).map(a => Object.assign({
+            url: `/api/attachments/${a.id}`,
+            thumbnail_url: a.thumbnail_width ? `/api/attachments/${a.id}/thumbnail` : ''
+        }, a)),
+        previews: m.previews,
+        html: m.html
+    };
+}
+
+// History: load older pages when the user scrolls to the top
+async function loadOlderMessages() {
+    if (loadingHistory || !hasMoreHistory || !oldestSeq) return;
+    loadingHistory = true;
+    
+    const messagesContainer = document.getElementById('messages');
+    try {
+        const response = await fetch(`/api/messages/${roomCode}?before_seq=${oldestSeq}&limit=50`);
+        if (!response.ok) return;
+        
+        const data = await response.json();
+        hasMoreHistory = data.has_more;
+        
+        // Keep the messages the user was looking at in place
+        const previousHeight = messagesContainer.scrollHeight;
+        data.messages.forEach(m => {
+            displayMessage(frameFromStored(m));
+            oldestSeq = Math.min(oldestSeq, m.seq);
+        });
+        messagesContainer.scrollTop += messagesContainer.scrollHeight - previousHeight;
+    } catch (error) {
+        console.error('Error loading older messages:', error);
+    } finally {
+        loadingHistory = false;
+    }
+}
+
+function findMessageAfterSeq(container, seq) {
+    const elements = container.querySelectorAll('.message[data-seq]');
+    for (const element of elements) {
+        if (Number(element.dataset.seq) > seq) {
+            return element;
+        }
+    }
+    return null;
+}
+
+function getCurrentUserId() {
+    const messagesContainer = document.getElementById('messages');
+    return messagesContainer ? messagesContainer.dataset.userId : null;
+}
+
+// Read receipts
+let latestMessageId = 0;
+let lastReadSent = 0;
+let markReadTimer;
+
+function noteMessageId(messageId) {
+    if (messageId > latestMessageId) {
+        latestMessageId = messageId;
+        scheduleMarkRead();
+    }
+}
+
+// Tell the server what we've seen, but only while the tab is visible
+function scheduleMarkRead() {
+    clearTimeout(markReadTimer);
+    markReadTimer = setTimeout(function() {
+        if (document.visibilityState !== 'visible') return;
+        if (latestMessageId <= lastReadSent) return;
+        if (!socket || socket.readyState !== WebSocket.OPEN) return;
+        
+        lastReadSent = latestMessageId;
+        socket.send(JSON.stringify({
+            type: 'read',
+            message_id: latestMessageId
+        }));
+    }, 500);
+}
+
+document.addEventListener('visibilitychange', scheduleMarkRead);
+
+function showReadReceipt(receipt) {
+    const messagesContainer = document.getElementById('messages');
+    if (!messagesContainer) return;
+    
+    const messageElement = messagesContainer.querySelector(`.message[data-message-id="${receipt.message_id}"]`);
+    if (!messageElement) return;
+    
+    // Only the newest read message shows who has seen it
+    const currentUser = document.querySelector('.user-info .username')?.textContent;
+    const readers = (receipt.read_by || []).filter(name => name !== currentUser);
+    const newest = messagesContainer.querySelector('.read-by');
+    if (newest && Number(newest.parentNode.dataset.messageId) > receipt.message_id) {
+        return;
+    }
+    messagesContainer.querySelectorAll('.read-by').forEach(el => el.remove());
+    
+    if (readers.length === 0) return;
+    const readByElement = document.createElement('div');
+    readByElement.className = 'read-by';
+    readByElement.textContent = `seen by ${readers.join(', ')}`;
+    messageElement.appendChild(readByElement);
+}
@@ -0,0 +1,89 @@
+package storage_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/jeffasante/chatroom.go/internal/s3test"
+	"github.com/jeffasante/chatroom.go/storage"
+)
+
+func newS3(t *testing.T, server *s3test.Server, secret string) *storage.S3 {
+	t.Helper()
+	store, err := storage.NewS3(storage.S3Config{
+		Endpoint:  server.URL,
+		Region:    s3test.Region,
+		Bucket:    s3test.Bucket,
+		AccessKey: s3test.AccessKey,
+		SecretKey: secret,
+		PathStyle: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestS3PutGetDelete(t *testing.T) {
+	server := s3test.New(t)
+	store := newS3(t, server, s3test.SecretKey)
+	ctx := context.Background()
+
+	key, err := storage.NewKey(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(ctx, key, strings.NewReader("hello"), 5, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if object, ok := server.Object(key); !ok || string(object.Data) != "hello" || object.ContentType != "text/plain" {
+		t.Fatalf("stored %+v", object)
+	}
+
+	blob, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(blob)
+	blob.Close()
+	if string(data) != "hello" {
+		t.Errorf("read %q", data)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, key); err != storage.ErrNotFound {
+		t.Errorf("get after delete: %v", err)
+	}
+}
+
+func TestS3EscapesKeys(t *testing.T) {
+	server := s3test.New(t)
+	store := newS3(t, server, s3test.SecretKey)
+
+	// Keys from NewKey are plain, but the signature must still match a path
+	// that needs escaping
+	key := "rooms/1/a b+c~d"
+	if err := store.Put(context.Background(), key, strings.NewReader("x"), 1, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.Object(key); !ok {
+		t.Errorf("stored keys %v", server.Keys())
+	}
+}
+
+func TestS3WrongSecret(t *testing.T) {
+	server := s3test.New(t)
+	store := newS3(t, server, "not-the-secret")
+
+	err := store.Put(context.Background(), "rooms/1/x", strings.NewReader("x"), 1, "text/plain")
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("put with the wrong secret: %v", err)
+	}
+	if keys := server.Keys(); len(keys) != 0 {
+		t.Errorf("stored %v", keys)
+	}
+}
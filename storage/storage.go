@@ -0,0 +1,32 @@
+// Package storage keeps uploaded files outside the database, on local disk
+// or in an S3-compatible object store.
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNotFound is returned by Get when no blob has the key
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore stores opaque blobs under string keys. Keys are generated by
+// NewKey and only contain characters that are safe in paths and URLs.
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// NewKey returns a random key grouped under the given room
+func NewKey(chatroomID uint) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("rooms/%d/%s", chatroomID, hex.EncodeToString(b)), nil
+}
@@ -0,0 +1,117 @@
+package unfurl
+
+import (
+	"io"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+const (
+	maxTitleLength       = 200
+	maxDescriptionLength = 500
+)
+
+// parseHTML reads metadata from the head of a page, preferring OpenGraph
+// tags and falling back to <title> and the description meta tag
+func parseHTML(r io.Reader, contentType string, base *url.URL) (*models.LinkPreview, error) {
+	r, err := charset.NewReader(r, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	var title, ogTitle, description, ogDescription, siteName, image string
+	inTitle := false
+	tokenizer := html.NewTokenizer(r)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			// End of input, or of what MaxBodySize let us read
+			return buildPreview(base, firstNonEmpty(ogTitle, title), firstNonEmpty(ogDescription, description), siteName, image), nil
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "title":
+				inTitle = title == ""
+			case "meta":
+				var key, content string
+				for _, attr := range token.Attr {
+					switch attr.Key {
+					case "property", "name":
+						key = strings.ToLower(attr.Val)
+					case "content":
+						content = attr.Val
+					}
+				}
+				switch key {
+				case "og:title":
+					ogTitle = content
+				case "og:description":
+					ogDescription = content
+				case "description":
+					description = content
+				case "og:site_name":
+					siteName = content
+				case "og:image":
+					image = content
+				}
+			case "body":
+				// Metadata belongs in the head, so stop before the page content
+				return buildPreview(base, firstNonEmpty(ogTitle, title), firstNonEmpty(ogDescription, description), siteName, image), nil
+			}
+
+		case html.TextToken:
+			if inTitle {
+				title += string(tokenizer.Text())
+			}
+
+		case html.EndTagToken:
+			if name, _ := tokenizer.TagName(); string(name) == "title" {
+				inTitle = false
+			}
+		}
+	}
+}
+
+func buildPreview(base *url.URL, title, description, siteName, image string) *models.LinkPreview {
+	preview := &models.LinkPreview{
+		URL:         base.String(),
+		Title:       clean(title, maxTitleLength),
+		Description: clean(description, maxDescriptionLength),
+		SiteName:    clean(siteName, maxTitleLength),
+	}
+	if preview.SiteName == "" {
+		preview.SiteName = base.Hostname()
+	}
+	if image != "" {
+		if ref, err := base.Parse(strings.TrimSpace(image)); err == nil && checkURL(ref) == nil {
+			preview.ImageURL = ref.String()
+		}
+	}
+	return preview
+}
+
+// clean collapses whitespace and shortens text to limit characters
+func clean(s string, limit int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) <= limit {
+		return s
+	}
+	runes := []rune(s)
+	return strings.TrimSpace(string(runes[:limit-1])) + "…"
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,182 @@
+// Package unfurl fetches link previews (OpenGraph or plain HTML title and
+// description) for URLs posted in chat.
+//
+// Fetching arbitrary URLs from the server is dangerous, so requests only go
+// to public IP addresses (see netguard), are time limited, and read at most
+// MaxBodySize bytes of HTML.
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/jeffasante/chatroom.go/models"
+	"github.com/jeffasante/chatroom.go/netguard"
+)
+
+const (
+	// MaxURLsPerMessage caps how many links in one message are unfurled
+	MaxURLsPerMessage = 3
+
+	// MaxBodySize is how much of a page is read looking for metadata
+	MaxBodySize = 512 << 10
+
+	fetchTimeout = 5 * time.Second
+	maxRedirects = 3
+
+	// How long results are cached. Failures are retried sooner.
+	cacheTTL        = 24 * time.Hour
+	failureCacheTTL = time.Hour
+)
+
+// AllowPrivate lets previews be fetched from private and loopback addresses.
+// Only for local development and tests.
+var AllowPrivate = false
+
+// Unfurler fetches previews, caching them in the link_previews table
+type Unfurler struct {
+	db     *gorm.DB
+	client *http.Client
+}
+
+// New creates an Unfurler whose HTTP client refuses non-public addresses
+// unless AllowPrivate is set
+func New(db *gorm.DB) *Unfurler {
+	return &Unfurler{
+		db: db,
+		client: netguard.NewClient(fetchTimeout, AllowPrivate, func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.New("too many redirects")
+			}
+			return checkURL(req.URL)
+		}),
+	}
+}
+
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"']+`)
+
+// ExtractURLs returns the distinct http(s) links in a message, at most
+// MaxURLsPerMessage of them
+func ExtractURLs(content string) []string {
+	var urls []string
+	seen := map[string]bool{}
+	for _, match := range urlPattern.FindAllString(content, -1) {
+		match = strings.TrimRight(match, ".,;:!?)]}")
+		if seen[match] {
+			continue
+		}
+		if u, err := url.Parse(match); err != nil || checkURL(u) != nil {
+			continue
+		}
+		seen[match] = true
+		urls = append(urls, match)
+		if len(urls) == MaxURLsPerMessage {
+			break
+		}
+	}
+	return urls
+}
+
+func checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("only http and https links are unfurled")
+	}
+	if u.Hostname() == "" || u.User != nil {
+		return errors.New("invalid link")
+	}
+	return nil
+}
+
+// Unfurl returns the preview for a link, from the cache when fresh. It
+// returns nil if the page has no usable metadata or can't be fetched.
+func (u *Unfurler) Unfurl(ctx context.Context, link string) *models.LinkPreview {
+	var cached models.LinkPreview
+	if err := u.db.Where("url = ?", link).First(&cached).Error; err == nil {
+		ttl := cacheTTL
+		if !cached.OK {
+			ttl = failureCacheTTL
+		}
+		if time.Since(cached.FetchedAt) < ttl {
+			return okOrNil(&cached)
+		}
+	}
+
+	preview, err := u.fetch(ctx, link)
+	if err != nil || preview.Title == "" {
+		preview = &models.LinkPreview{}
+	} else {
+		preview.OK = true
+	}
+	// Cache under the link as posted, not wherever redirects ended up
+	preview.URL = link
+	preview.FetchedAt = time.Now()
+	if err := u.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "url"}},
+		DoUpdates: clause.AssignmentColumns([]string{"ok", "title", "description", "site_name", "image_url", "fetched_at"}),
+	}).Create(preview).Error; err != nil {
+		log.Printf("Failed to cache preview for %s: %v", link, err)
+	}
+
+	return okOrNil(preview)
+}
+
+// Cached looks up stored previews without fetching anything, for showing
+// previews on messages loaded from history. Results are keyed by URL.
+func Cached(db *gorm.DB, links []string) map[string]models.LinkPreview {
+	previews := map[string]models.LinkPreview{}
+	if len(links) == 0 {
+		return previews
+	}
+
+	var records []models.LinkPreview
+	db.Where("url IN ? AND ok = ?", links, true).Find(&records)
+	for _, record := range records {
+		previews[record.URL] = record
+	}
+	return previews
+}
+
+func okOrNil(preview *models.LinkPreview) *models.LinkPreview {
+	if !preview.OK {
+		return nil
+	}
+	return preview
+}
+
+func (u *Unfurler) fetch(ctx context.Context, link string) (*models.LinkPreview, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "chatroom-unfurler/1.0")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unfurl %s: %s", link, resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, fmt.Errorf("unfurl %s: not html", link)
+	}
+
+	body := io.LimitReader(resp.Body, MaxBodySize)
+	return parseHTML(body, resp.Header.Get("Content-Type"), resp.Request.URL)
+}
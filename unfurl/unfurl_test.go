@@ -0,0 +1,175 @@
+package unfurl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.LinkPreview{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newLocalUnfurler returns an Unfurler that may fetch from httptest servers
+func newLocalUnfurler(t *testing.T, db *gorm.DB) *Unfurler {
+	t.Helper()
+	AllowPrivate = true
+	defer func() { AllowPrivate = false }()
+	return New(db)
+}
+
+const articlePage = `<!doctype html>
+<html><head>
+<title>Plain   title</title>
+<meta name="description" content="Plain description">
+<meta property="og:title" content="The OpenGraph title">
+<meta property="og:description" content="  An
+   OpenGraph description ">
+<meta property="og:site_name" content="Example News">
+<meta property="og:image" content="/images/cover.png">
+</head><body><meta property="og:title" content="Not in the head"></body></html>`
+
+func TestParsesOpenGraph(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, articlePage)
+	}))
+	defer server.Close()
+
+	db := newTestDB(t)
+	link := server.URL + "/article"
+	preview := newLocalUnfurler(t, db).Unfurl(context.Background(), link)
+	if preview == nil {
+		t.Fatal("no preview")
+	}
+	want := models.LinkPreview{
+		URL:         link,
+		Title:       "The OpenGraph title",
+		Description: "An OpenGraph description",
+		SiteName:    "Example News",
+		ImageURL:    server.URL + "/images/cover.png",
+	}
+	if preview.URL != want.URL || preview.Title != want.Title || preview.Description != want.Description ||
+		preview.SiteName != want.SiteName || preview.ImageURL != want.ImageURL {
+		t.Errorf("preview = %+v, want %+v", preview, want)
+	}
+	if cached := Cached(db, []string{link}); cached[link].Title != want.Title {
+		t.Errorf("cached = %+v", cached)
+	}
+}
+
+func TestFallsBackToTitle(t *testing.T) {
+	preview, err := parseHTML(strings.NewReader(`<html><head><title>Only a
+		title</title><meta name="description" content="Described"></head>`), "text/html", mustParse(t, "https://example.com/page"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.Title != "Only a title" || preview.Description != "Described" || preview.SiteName != "example.com" {
+		t.Errorf("preview = %+v", preview)
+	}
+}
+
+func TestFollowsRedirects(t *testing.T) {
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, articlePage)
+	}))
+	defer page.Close()
+
+	var hops atomic.Int32
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/moved":
+			http.Redirect(w, r, page.URL+"/article", http.StatusFound)
+		case "/loop":
+			hops.Add(1)
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/ftp":
+			http.Redirect(w, r, "ftp://example.com/file", http.StatusFound)
+		}
+	}))
+	defer redirector.Close()
+
+	u := newLocalUnfurler(t, newTestDB(t))
+	link := redirector.URL + "/moved"
+	preview := u.Unfurl(context.Background(), link)
+	if preview == nil || preview.Title != "The OpenGraph title" {
+		t.Fatalf("preview = %+v", preview)
+	}
+	if preview.URL != link || preview.ImageURL != page.URL+"/images/cover.png" {
+		t.Errorf("cached under %s with image %s", preview.URL, preview.ImageURL)
+	}
+
+	if preview := u.Unfurl(context.Background(), redirector.URL+"/loop"); preview != nil {
+		t.Errorf("redirect loop gave %+v", preview)
+	}
+	if n := hops.Load(); n != maxRedirects {
+		t.Errorf("followed %d redirects, want %d", n, maxRedirects)
+	}
+	if preview := u.Unfurl(context.Background(), redirector.URL+"/ftp"); preview != nil {
+		t.Errorf("redirect to ftp gave %+v", preview)
+	}
+}
+
+func TestRefusesPrivateAddresses(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, articlePage)
+	}))
+	defer server.Close()
+
+	db := newTestDB(t)
+	link := server.URL + "/internal"
+	if preview := New(db).Unfurl(context.Background(), link); preview != nil {
+		t.Errorf("unfurled a loopback address: %+v", preview)
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("server on a loopback address got %d requests", n)
+	}
+
+	// The failure is cached, so the address isn't tried again right away
+	var cached models.LinkPreview
+	if err := db.Where("url = ?", link).First(&cached).Error; err != nil || cached.OK {
+		t.Errorf("cached = %+v, %v", cached, err)
+	}
+}
+
+func TestExtractURLs(t *testing.T) {
+	got := ExtractURLs("see https://a.example/x, http://b.example/y). and https://a.example/x again, " +
+		"ftp://c.example https://user:pw@d.example/ https://e.example https://f.example")
+	want := []string{"https://a.example/x", "http://b.example/y", "https://e.example"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("ExtractURLs = %v, want %v", got, want)
+	}
+}
+
+func mustParse(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
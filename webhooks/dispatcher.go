@@ -0,0 +1,272 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/jeffasante/chatroom.go/models"
+	"github.com/jeffasante/chatroom.go/netguard"
+)
+
+// Payload is the JSON body POSTed to a webhook
+type Payload struct {
+	Event      string      `json:"event"`
+	ChatroomID uint        `json:"chatroom_id"`
+	Timestamp  time.Time   `json:"timestamp"`
+	Data       interface{} `json:"data"`
+}
+
+// MessageEvent is implemented by event data about a stored message, so
+// deliveries can record which message they carry
+type MessageEvent interface {
+	EventMessageID() uint
+}
+
+// redactedFields are the parts of an event's data that copy a message's
+// text or files
+var redactedFields = []string{"content", "html", "previews", "attachments"}
+
+// RedactMessages removes the text of messages from every delivery that
+// carries them, queued or sent. Pass a transaction to redact them only if
+// the messages are deleted.
+func RedactMessages(db *gorm.DB, messageIDs []uint) error {
+	var deliveries []models.WebhookDelivery
+	if err := db.Select("id", "payload").Where("message_id IN ?", messageIDs).Find(&deliveries).Error; err != nil {
+		return err
+	}
+	for _, delivery := range deliveries {
+		var payload map[string]json.RawMessage
+		var data map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(delivery.Payload), &payload); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(payload["data"], &data); err != nil {
+			return err
+		}
+		for _, field := range redactedFields {
+			delete(data, field)
+		}
+		redacted, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		payload["data"] = redacted
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		if err := db.Model(&delivery).Update("payload", string(body)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Dispatcher queues room events in the outbox and delivers them
+type Dispatcher struct {
+	db     *gorm.DB
+	client *http.Client
+
+	MaxAttempts  int           // attempts before a delivery is marked failed
+	BaseBackoff  time.Duration // delay before the first retry, doubled each attempt
+	MaxBackoff   time.Duration
+	PollInterval time.Duration // how often the outbox is checked for due deliveries
+	Workers      int           // concurrent deliveries
+}
+
+// NewDispatcher creates a Dispatcher whose HTTP client only reaches public
+// addresses unless AllowPrivate is set. Redirects are not followed.
+func NewDispatcher(db *gorm.DB) *Dispatcher {
+	return &Dispatcher{
+		db: db,
+		client: netguard.NewClient(10*time.Second, AllowPrivate, func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}),
+		MaxAttempts:  8,
+		BaseBackoff:  5 * time.Second,
+		MaxBackoff:   time.Hour,
+		PollInterval: time.Second,
+		Workers:      4,
+	}
+}
+
+// Publish writes a pending delivery for every webhook subscribed to the
+// event, so the event survives a restart as soon as Publish returns.
+// Delivery happens later, from Run.
+func (d *Dispatcher) Publish(chatroomID uint, name string, data interface{}) {
+	var hooks []models.Webhook
+	if err := d.db.Where("chatroom_id = ? AND active = ?", chatroomID, true).Find(&hooks).Error; err != nil {
+		log.Printf("Failed to load webhooks for chatroom %d: %v", chatroomID, err)
+		return
+	}
+
+	var deliveries []models.WebhookDelivery
+	var body []byte
+	var messageID uint
+	if event, ok := data.(MessageEvent); ok {
+		messageID = event.EventMessageID()
+	}
+	now := time.Now()
+	for _, hook := range hooks {
+		if !Subscribed(hook.Events, name) {
+			continue
+		}
+
+		if body == nil {
+			var err error
+			body, err = json.Marshal(Payload{
+				Event:      name,
+				ChatroomID: chatroomID,
+				Timestamp:  now,
+				Data:       data,
+			})
+			if err != nil {
+				log.Printf("Failed to encode %s webhook payload: %v", name, err)
+				return
+			}
+		}
+
+		deliveries = append(deliveries, models.WebhookDelivery{
+			WebhookID:     hook.ID,
+			Event:         name,
+			MessageID:     messageID,
+			Payload:       string(body),
+			Status:        models.DeliveryPending,
+			NextAttemptAt: now,
+		})
+	}
+	if len(deliveries) == 0 {
+		return
+	}
+	if err := d.db.Create(&deliveries).Error; err != nil {
+		log.Printf("Failed to queue %s webhook deliveries for chatroom %d: %v", name, chatroomID, err)
+	}
+}
+
+// Run delivers due deliveries until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.deliverDue(ctx)
+		}
+	}
+}
+
+// deliverDue sends every pending delivery whose retry time has passed
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	var deliveries []models.WebhookDelivery
+	if err := d.db.Where("status = ? AND next_attempt_at <= ?", models.DeliveryPending, time.Now()).
+		Order("next_attempt_at ASC").
+		Limit(100).
+		Find(&deliveries).Error; err != nil {
+		log.Printf("Failed to load webhook deliveries: %v", err)
+		return
+	}
+
+	sem := make(chan struct{}, d.Workers)
+	var wg sync.WaitGroup
+	for i := range deliveries {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(delivery *models.WebhookDelivery) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			d.attempt(ctx, delivery)
+		}(&deliveries[i])
+	}
+	wg.Wait()
+}
+
+// attempt makes one delivery attempt and records the outcome
+func (d *Dispatcher) attempt(ctx context.Context, delivery *models.WebhookDelivery) {
+	var hook models.Webhook
+	if err := d.db.First(&hook, delivery.WebhookID).Error; err != nil || !hook.Active {
+		d.db.Model(delivery).Updates(map[string]interface{}{
+			"status":     models.DeliveryFailed,
+			"last_error": "webhook removed or disabled",
+		})
+		return
+	}
+
+	err := d.send(ctx, &hook, delivery)
+	attempts := delivery.Attempts + 1
+
+	if err == nil {
+		now := time.Now()
+		d.db.Model(delivery).Updates(map[string]interface{}{
+			"status":       models.DeliveryDelivered,
+			"attempts":     attempts,
+			"last_error":   "",
+			"delivered_at": &now,
+		})
+		return
+	}
+
+	updates := map[string]interface{}{
+		"attempts":   attempts,
+		"last_error": err.Error(),
+	}
+	if attempts >= d.MaxAttempts {
+		updates["status"] = models.DeliveryFailed
+		log.Printf("Webhook delivery %d to %s failed permanently: %v", delivery.ID, hook.URL, err)
+	} else {
+		updates["next_attempt_at"] = time.Now().Add(d.backoff(attempts))
+	}
+	d.db.Model(delivery).Updates(updates)
+}
+
+// backoff returns the delay before retrying after the given number of attempts
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.BaseBackoff
+	for i := 1; i < attempts && delay < d.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.MaxBackoff {
+		delay = d.MaxBackoff
+	}
+	return delay
+}
+
+func (d *Dispatcher) send(ctx context.Context, hook *models.Webhook, delivery *models.WebhookDelivery) error {
+	body := []byte(delivery.Payload)
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "chatroom-webhooks/1.0")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(hook.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("receiver returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,318 @@
+package webhooks
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/jeffasante/chatroom.go/models"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Webhook{}, &models.WebhookDelivery{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newTestDispatcher returns a dispatcher that may reach local receivers
+func newTestDispatcher(t *testing.T, db *gorm.DB) *Dispatcher {
+	t.Helper()
+	AllowPrivate = true
+	t.Cleanup(func() { AllowPrivate = false })
+	d := NewDispatcher(db)
+	d.Workers = 1
+	return d
+}
+
+func createHook(t *testing.T, db *gorm.DB, url, events string) *models.Webhook {
+	t.Helper()
+	hook := &models.Webhook{ChatroomID: 1, URL: url, Secret: "s3cret", Events: events, Active: true}
+	if err := db.Create(hook).Error; err != nil {
+		t.Fatal(err)
+	}
+	return hook
+}
+
+func loadDelivery(t *testing.T, db *gorm.DB) models.WebhookDelivery {
+	t.Helper()
+	var delivery models.WebhookDelivery
+	if err := db.First(&delivery).Error; err != nil {
+		t.Fatal(err)
+	}
+	return delivery
+}
+
+// makeDue moves every pending delivery's retry time into the past
+func makeDue(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	if err := db.Model(&models.WebhookDelivery{}).Where("status = ?", models.DeliveryPending).
+		Update("next_attempt_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPublishWritesOutboxBeforeReturning(t *testing.T) {
+	db := newTestDB(t)
+	d := newTestDispatcher(t, db)
+	createHook(t, db, "https://example.com/a", "message,join")
+	createHook(t, db, "https://example.com/b", "leave")
+
+	d.Publish(1, EventMessage, map[string]string{"content": "hi"})
+	d.Publish(2, EventMessage, map[string]string{"content": "other room"})
+
+	var deliveries []models.WebhookDelivery
+	db.Find(&deliveries)
+	if len(deliveries) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(deliveries))
+	}
+	if deliveries[0].Status != models.DeliveryPending || deliveries[0].Event != EventMessage {
+		t.Errorf("delivery = %+v", deliveries[0])
+	}
+	if !strings.Contains(deliveries[0].Payload, `"content":"hi"`) {
+		t.Errorf("payload = %s", deliveries[0].Payload)
+	}
+}
+
+func TestDeliverySignature(t *testing.T) {
+	db := newTestDB(t)
+	d := newTestDispatcher(t, db)
+
+	received := make(chan *http.Request, 1)
+	var body []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		received <- r
+	}))
+	defer receiver.Close()
+
+	createHook(t, db, receiver.URL, "message")
+	d.Publish(1, EventMessage, map[string]string{"content": "signed"})
+	d.deliverDue(context.Background())
+
+	r := <-received
+	if got := r.Header.Get(HeaderEvent); got != EventMessage {
+		t.Errorf("%s = %q", HeaderEvent, got)
+	}
+	if got := r.Header.Get(HeaderDelivery); got != strconv.FormatUint(uint64(loadDelivery(t, db).ID), 10) {
+		t.Errorf("%s = %q", HeaderDelivery, got)
+	}
+	timestamp, err := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		t.Fatalf("bad %s: %v", HeaderTimestamp, err)
+	}
+	signature := r.Header.Get(HeaderSignature)
+	if !Verify("s3cret", timestamp, body, signature) {
+		t.Errorf("signature %q does not verify", signature)
+	}
+	if Verify("wrong", timestamp, body, signature) || Verify("s3cret", timestamp+1, body, signature) {
+		t.Error("signature verifies with the wrong secret or timestamp")
+	}
+
+	if delivery := loadDelivery(t, db); delivery.Status != models.DeliveryDelivered || delivery.Attempts != 1 || delivery.DeliveredAt == nil {
+		t.Errorf("delivery = %+v", delivery)
+	}
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	db := newTestDB(t)
+	d := newTestDispatcher(t, db)
+	d.BaseBackoff = time.Minute
+
+	var requests atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= 2 {
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+		}
+	}))
+	defer receiver.Close()
+
+	createHook(t, db, receiver.URL, "message")
+	d.Publish(1, EventMessage, "retry me")
+
+	d.deliverDue(context.Background())
+	delivery := loadDelivery(t, db)
+	if delivery.Status != models.DeliveryPending || delivery.Attempts != 1 || !strings.Contains(delivery.LastError, "503") {
+		t.Fatalf("after first attempt: %+v", delivery)
+	}
+	if wait := time.Until(delivery.NextAttemptAt); wait < 50*time.Second || wait > time.Minute {
+		t.Errorf("first retry in %v, want about 1m", wait)
+	}
+
+	// Not due yet, so nothing is sent
+	d.deliverDue(context.Background())
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("sent %d requests before the retry was due", n)
+	}
+
+	makeDue(t, db)
+	d.deliverDue(context.Background())
+	delivery = loadDelivery(t, db)
+	if wait := time.Until(delivery.NextAttemptAt); delivery.Attempts != 2 || wait < 110*time.Second || wait > 2*time.Minute {
+		t.Errorf("second retry after %d attempts in %v, want about 2m", delivery.Attempts, wait)
+	}
+
+	makeDue(t, db)
+	d.deliverDue(context.Background())
+	delivery = loadDelivery(t, db)
+	if delivery.Status != models.DeliveryDelivered || delivery.Attempts != 3 || delivery.LastError != "" {
+		t.Errorf("after success: %+v", delivery)
+	}
+}
+
+func TestFailsAfterMaxAttempts(t *testing.T) {
+	db := newTestDB(t)
+	d := newTestDispatcher(t, db)
+	d.MaxAttempts = 3
+
+	var requests atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "broken", http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	createHook(t, db, receiver.URL, "message")
+	d.Publish(1, EventMessage, "never arrives")
+	for i := 0; i < 5; i++ {
+		makeDue(t, db)
+		d.deliverDue(context.Background())
+	}
+
+	delivery := loadDelivery(t, db)
+	if delivery.Status != models.DeliveryFailed || delivery.Attempts != 3 || !strings.Contains(delivery.LastError, "500") {
+		t.Errorf("delivery = %+v", delivery)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("sent %d requests, want 3", n)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{BaseBackoff: 5 * time.Second, MaxBackoff: time.Hour}
+	for attempts, want := range map[int]time.Duration{
+		1:  5 * time.Second,
+		2:  10 * time.Second,
+		3:  20 * time.Second,
+		12: time.Hour,
+	} {
+		if got := d.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestRefusesPrivateAddresses(t *testing.T) {
+	db := newTestDB(t)
+	d := NewDispatcher(db)
+
+	var requests atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	defer receiver.Close()
+
+	createHook(t, db, receiver.URL, "message")
+	d.Publish(1, EventMessage, "to localhost")
+	d.deliverDue(context.Background())
+
+	if n := requests.Load(); n != 0 {
+		t.Errorf("receiver on a loopback address got %d requests", n)
+	}
+	if delivery := loadDelivery(t, db); !strings.Contains(delivery.LastError, "address not allowed") {
+		t.Errorf("last error = %q", delivery.LastError)
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	for raw, ok := range map[string]bool{
+		"https://example.com/hook":    true,
+		"http://example.com/hook":     false,
+		"https://127.0.0.1/hook":      false,
+		"https://10.1.2.3/hook":       false,
+		"https://[::1]/hook":          false,
+		"https://169.254.169.254/":    false,
+		"https://localhost:8080/":     false,
+		"https://user:pw@example.com": false,
+		"ftp://example.com/":          false,
+		"/relative":                   false,
+	} {
+		if err := ValidateURL(raw); (err == nil) != ok {
+			t.Errorf("ValidateURL(%q) = %v", raw, err)
+		}
+	}
+}
+
+func TestParseEvents(t *testing.T) {
+	for raw, want := range map[string]string{
+		"message":                "message",
+		" Message , JOIN,leave ": "message,join,leave",
+		"edit,delete,edit":       "edit,delete",
+		"message,,join":          "message,join",
+	} {
+		if got, err := ParseEvents(raw); err != nil || got != want {
+			t.Errorf("ParseEvents(%q) = %q, %v, want %q", raw, got, err, want)
+		}
+	}
+	for _, raw := range []string{"", " , ", "message,typing"} {
+		if got, err := ParseEvents(raw); err == nil {
+			t.Errorf("ParseEvents(%q) = %q, want an error", raw, got)
+		}
+	}
+}
+
+// testMessage is event data about a stored message
+type testMessage struct {
+	ID      uint   `json:"message_id"`
+	Content string `json:"content"`
+	HTML    string `json:"html"`
+}
+
+func (m testMessage) EventMessageID() uint { return m.ID }
+
+func TestRedactMessages(t *testing.T) {
+	db := newTestDB(t)
+	d := newTestDispatcher(t, db)
+	createHook(t, db, "https://example.com/hook", "message,join,delete")
+	createHook(t, db, "https://example.org/hook", "message")
+
+	d.Publish(1, EventMessage, testMessage{ID: 7, Content: "secret plans", HTML: "<p>secret plans</p>"})
+	d.Publish(1, EventDelete, testMessage{ID: 7, Content: "secret plans"})
+	d.Publish(1, EventMessage, testMessage{ID: 8, Content: "lunch"})
+	d.Publish(1, EventJoin, map[string]string{"content": "bob joined"})
+
+	if err := RedactMessages(db, []uint{7}); err != nil {
+		t.Fatal(err)
+	}
+
+	var deliveries []models.WebhookDelivery
+	db.Order("id").Find(&deliveries)
+	if len(deliveries) != 6 {
+		t.Fatalf("%d deliveries, want 6", len(deliveries))
+	}
+	for _, delivery := range deliveries {
+		redacted := !strings.Contains(delivery.Payload, `"content"`)
+		if redacted != (delivery.MessageID == 7) {
+			t.Errorf("delivery %d of message %d: %s", delivery.ID, delivery.MessageID, delivery.Payload)
+		}
+		if redacted && !strings.Contains(delivery.Payload, `"data":{"message_id":7}`) {
+			t.Errorf("redacted payload %s", delivery.Payload)
+		}
+	}
+}
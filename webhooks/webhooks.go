@@ -0,0 +1,126 @@
+// Package webhooks delivers room events to URLs registered by room owners.
+//
+// Events published by the hub are written to the webhook_deliveries table
+// and sent from there, so a slow or unreachable receiver never blocks the
+// chat and queued events survive a restart. Failed deliveries are retried
+// with exponential backoff.
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jeffasante/chatroom.go/netguard"
+)
+
+// Event names a webhook can subscribe to
+const (
+	EventMessage = "message"
+	EventJoin    = "join"
+	EventLeave   = "leave"
+	EventEdit    = "edit"
+	EventDelete  = "delete"
+)
+
+// Events lists every event a webhook can subscribe to
+var Events = []string{EventMessage, EventJoin, EventLeave, EventEdit, EventDelete}
+
+// Headers sent with every delivery
+const (
+	HeaderEvent     = "X-Chatroom-Event"
+	HeaderDelivery  = "X-Chatroom-Delivery"
+	HeaderTimestamp = "X-Chatroom-Timestamp"
+	HeaderSignature = "X-Chatroom-Signature"
+)
+
+// AllowHTTP permits plain http:// webhook URLs. It is meant for local
+// development and tests; production webhooks must use HTTPS.
+var AllowHTTP = false
+
+// AllowPrivate permits webhooks to private and loopback addresses, for
+// local development and tests only
+var AllowPrivate = false
+
+// ValidateURL checks that a webhook URL is absolute, uses HTTPS and doesn't
+// name a private address. Hostnames are checked again when delivering.
+func ValidateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" || u.User != nil {
+		return errors.New("invalid webhook url")
+	}
+
+	if u.Scheme != "https" && !(u.Scheme == "http" && AllowHTTP) {
+		return errors.New("webhook url must use https")
+	}
+	if !AllowPrivate {
+		host := u.Hostname()
+		if ip := net.ParseIP(host); (ip != nil && !netguard.AllowedIP(ip)) || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+			return errors.New("webhook url must not point to a private address")
+		}
+	}
+	return nil
+}
+
+// ParseEvents normalizes a comma separated event list, rejecting unknown events
+func ParseEvents(raw string) (string, error) {
+	var events []string
+	seen := make(map[string]bool)
+	for _, event := range strings.Split(raw, ",") {
+		event = strings.ToLower(strings.TrimSpace(event))
+		if event == "" || seen[event] {
+			continue
+		}
+		if !isEvent(event) {
+			return "", errors.New("unknown event: " + event)
+		}
+		seen[event] = true
+		events = append(events, event)
+	}
+
+	if len(events) == 0 {
+		return "", errors.New("at least one event is required")
+	}
+	return strings.Join(events, ","), nil
+}
+
+// Subscribed reports whether a webhook's event list includes event
+func Subscribed(events, event string) bool {
+	for _, e := range strings.Split(events, ",") {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func isEvent(event string) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign returns the X-Chatroom-Signature value for a delivery body.
+// The HMAC-SHA256 covers the timestamp header and the body joined by a dot,
+// so a captured delivery cannot be replayed with a new timestamp.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature produced by Sign. Receivers written in Go can
+// use it directly.
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}